@@ -105,8 +105,21 @@ func main() {
 	auditService := services.NewAuditService()
 	log.Println("✅ Audit Service initialized")
 
+	// Initialize route optimizers, the external strategy needs a solver service
+	optimizerRegistry := services.NewOptimizerRegistry(mapsProxyService)
+	if solverURL := os.Getenv("VRP_EXTERNAL_SOLVER_URL"); solverURL != "" {
+		optimizerRegistry.SetExternalSolver(services.NewExternalSolverClient(solverURL, 0))
+		log.Println("✅ External VRP solver configured")
+	}
+
+	// Initialize optimization jobs, runs cut off by a restart are marked failed
+	optimizationJobs := services.NewOptimizationJobService()
+	if err := optimizationJobs.FailInterrupted(); err != nil {
+		log.Printf("⚠️  Failed to close interrupted optimization jobs: %v", err)
+	}
+
 	// Inject services into handlers
-	handlers.InitializeServices(notificationService, auditService)
+	handlers.InitializeServices(notificationService, auditService, mapsProxyService, optimizerRegistry, optimizationJobs)
 
 	// Setup Gin router
 	if os.Getenv("BACKEND_ENV") == "production" {
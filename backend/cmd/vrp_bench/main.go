@@ -0,0 +1,305 @@
+// vrp_bench runs the route optimization strategies on Solomon and Gehring-Homberger
+// VRPTW instance files and prints cost, vehicle count and runtime for each.
+//
+// Usage:
+//
+//	go run ./cmd/vrp_bench -strategies greedy,savings,local_search -time-limit 5s testdata/solomon/*.txt
+//
+// Directories are searched for *.txt files. Distances are Euclidean and one distance
+// unit is driven in one minute, so cost equals total distance as in the literature.
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/ai-tms/backend/internal/models"
+	"github.com/ai-tms/backend/internal/services"
+	"github.com/google/uuid"
+)
+
+// instance is a parsed Solomon/Gehring-Homberger problem
+type instance struct {
+	name      string
+	vehicles  int
+	capacity  int
+	customers []customer // index 0 is the depot
+}
+
+// customer is one row of the CUSTOMER section
+type customer struct {
+	number  int
+	x, y    float64
+	demand  float64
+	ready   float64
+	due     float64
+	service float64
+}
+
+// benchStart anchors instance times, which are minutes since the start of the horizon
+var benchStart = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func main() {
+	strategies := flag.String("strategies", "greedy,savings,local_search", "comma separated strategies to run")
+	timeLimit := flag.Duration("time-limit", 5*time.Second, "local search / external solver budget per run")
+	seed := flag.Int64("seed", 0, "local search seed, 0 scans routes in input order")
+	externalURL := flag.String("external-url", os.Getenv("VRP_EXTERNAL_SOLVER_URL"), "external solver service URL")
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		fmt.Fprintln(os.Stderr, "usage: vrp_bench [flags] <instance files or directories>")
+		flag.PrintDefaults()
+		os.Exit(2)
+	}
+
+	files, err := instanceFiles(flag.Args())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	registry := services.NewOptimizerRegistry(nil)
+	if *externalURL != "" {
+		registry.SetExternalSolver(services.NewExternalSolverClient(*externalURL, *timeLimit+30*time.Second))
+	}
+
+	optimizers := make([]services.RouteOptimizer, 0)
+	for _, name := range strings.Split(*strategies, ",") {
+		optimizer, err := registry.Get(strings.TrimSpace(name))
+		if err != nil {
+			log.Fatal(err)
+		}
+		optimizers = append(optimizers, optimizer)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "INSTANCE\tSTRATEGY\tCOST\tVEHICLES\tUNASSIGNED\tRUNTIME\t")
+
+	for _, file := range files {
+		inst, err := parseInstance(file)
+		if err != nil {
+			log.Printf("⚠️ Skipping %s: %v", file, err)
+			continue
+		}
+
+		for _, optimizer := range optimizers {
+			problem := inst.problem(*timeLimit, *seed)
+
+			started := time.Now()
+			result, err := optimizer.Optimize(context.Background(), problem)
+			runtime := time.Since(started)
+			if err != nil && !errors.Is(err, services.ErrUnassignedOrders) {
+				fmt.Fprintf(w, "%s\t%s\terror: %v\t\t\t\t\n", inst.name, optimizer.Name(), err)
+				continue
+			}
+
+			cost := 0.0
+			for _, route := range result.Routes {
+				cost += route.TotalDistance
+			}
+			fmt.Fprintf(w, "%s\t%s\t%.2f\t%d\t%d\t%s\t\n",
+				inst.name, optimizer.Name(), cost, len(result.Routes), len(result.Unassigned), runtime.Round(time.Millisecond))
+		}
+		w.Flush()
+	}
+	w.Flush()
+}
+
+// instanceFiles expands directories into the *.txt files they contain
+func instanceFiles(args []string) ([]string, error) {
+	files := make([]string, 0)
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, arg)
+			continue
+		}
+
+		entries, err := os.ReadDir(arg)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if !entry.IsDir() && strings.EqualFold(filepath.Ext(entry.Name()), ".txt") {
+				files = append(files, filepath.Join(arg, entry.Name()))
+			}
+		}
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+// parseInstance reads a Solomon/Gehring-Homberger instance file
+func parseInstance(path string) (*instance, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	inst := &instance{}
+	section := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		if inst.name == "" {
+			inst.name = line
+			continue
+		}
+
+		upper := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(upper, "VEHICLE"):
+			section = "vehicle"
+			continue
+		case strings.HasPrefix(upper, "CUSTOMER"):
+			section = "customer"
+			continue
+		case strings.HasPrefix(upper, "NUMBER"), strings.HasPrefix(upper, "CUST"):
+			continue // column headers
+		}
+
+		fields, err := parseFloats(strings.Fields(line))
+		if err != nil {
+			return nil, fmt.Errorf("line %q: %w", line, err)
+		}
+
+		switch section {
+		case "vehicle":
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("vehicle line %q: want 2 fields", line)
+			}
+			inst.vehicles, inst.capacity = int(fields[0]), int(fields[1])
+		case "customer":
+			if len(fields) != 7 {
+				return nil, fmt.Errorf("customer line %q: want 7 fields", line)
+			}
+			inst.customers = append(inst.customers, customer{
+				number:  int(fields[0]),
+				x:       fields[1],
+				y:       fields[2],
+				demand:  fields[3],
+				ready:   fields[4],
+				due:     fields[5],
+				service: fields[6],
+			})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if inst.vehicles == 0 || len(inst.customers) < 2 {
+		return nil, fmt.Errorf("no vehicle or customer section found")
+	}
+	return inst, nil
+}
+
+// parseFloats converts whitespace separated numbers
+func parseFloats(fields []string) ([]float64, error) {
+	values := make([]float64, len(fields))
+	for i, field := range fields {
+		value, err := strconv.ParseFloat(field, 64)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+// problem converts the instance into solver input with a Euclidean matrix and hard windows
+func (inst *instance) problem(timeLimit time.Duration, seed int64) services.OptimizationProblem {
+	depot := inst.customers[0]
+	horizon := minutes(depot.due)
+
+	orders := make([]models.Order, 0, len(inst.customers)-1)
+	windows := make(map[string]services.TimeWindow, len(inst.customers)-1)
+	for _, c := range inst.customers[1:] {
+		order := models.Order{
+			ID:          uuid.New(),
+			OrderNumber: fmt.Sprintf("C%d", c.number),
+			WeightKg:    c.demand,
+			Customer:    &models.Customer{AvgServiceTimeMinutes: int(c.service)},
+		}
+		orders = append(orders, order)
+		windows[order.ID.String()] = services.TimeWindow{
+			Start: benchStart.Add(minutes(c.ready)),
+			End:   benchStart.Add(minutes(c.due)),
+		}
+	}
+
+	vehicles := make([]models.Vehicle, 0, inst.vehicles)
+	shifts := make(map[string]services.Shift, inst.vehicles)
+	for i := 0; i < inst.vehicles; i++ {
+		vehicle := models.Vehicle{
+			ID:           uuid.New(),
+			LicensePlate: fmt.Sprintf("V%d", i+1),
+			CapacityKg:   inst.capacity,
+			CostPerKm:    1,
+		}
+		vehicles = append(vehicles, vehicle)
+		shifts[vehicle.ID.String()] = services.Shift{Start: benchStart, End: benchStart.Add(horizon)}
+	}
+
+	return services.OptimizationProblem{
+		Orders:    orders,
+		Vehicles:  vehicles,
+		Depot:     models.Depot{ID: uuid.New(), Name: inst.name},
+		Matrix:    inst.matrix(),
+		PlanStart: benchStart,
+		Constraints: services.VRPConstraints{
+			TimeWindows:          windows,
+			DriverShifts:         shifts,
+			HardTimeWindows:      true,
+			MaxOvertime:          -1,
+			WaitingCostPerHour:   math.SmallestNonzeroFloat64, // waiting is free in the benchmark objective
+			BreakAfterDriving:    1000 * time.Hour,            // no rest breaks
+			ImprovementTimeLimit: timeLimit,
+			Seed:                 seed,
+		},
+	}
+}
+
+// matrix builds Euclidean distances with one distance unit driven per minute
+func (inst *instance) matrix() *services.DistanceMatrix {
+	n := len(inst.customers)
+	m := &services.DistanceMatrix{
+		Distances: make([][]float64, n),
+		Durations: make([][]time.Duration, n),
+		Source:    "euclidean",
+	}
+	for i, a := range inst.customers {
+		m.Distances[i] = make([]float64, n)
+		m.Durations[i] = make([]time.Duration, n)
+		for j, b := range inst.customers {
+			d := math.Hypot(a.x-b.x, a.y-b.y)
+			m.Distances[i][j] = d
+			m.Durations[i][j] = minutes(d)
+		}
+	}
+	return m
+}
+
+// minutes converts instance time units to a duration
+func minutes(units float64) time.Duration {
+	return time.Duration(units * float64(time.Minute))
+}
@@ -102,6 +102,13 @@ func Migrate() error {
 		return fmt.Errorf("failed to create enums: %w", err)
 	}
 
+	// Order statuses added after the enum was first created
+	for _, status := range []string{"returning", "returned"} {
+		if err := DB.Exec("ALTER TYPE order_status ADD VALUE IF NOT EXISTS '" + status + "'").Error; err != nil {
+			return fmt.Errorf("failed to extend order_status enum: %w", err)
+		}
+	}
+
 	// Manual Migration Fix: Convert text[] to jsonb if needed
 	// This unblocks AutoMigrate when changing types
 	fixColumnSQL := `
@@ -135,6 +142,7 @@ func Migrate() error {
 		&models.RouteStop{},
 		&models.GPSTracking{},
 		&models.ProofOfDelivery{},
+		&models.DeliveryAttempt{},
 		&models.Alert{},
 		&models.SLARule{},
 		// Security & Governance models
@@ -143,6 +151,11 @@ func Migrate() error {
 		&models.APIKey{},
 		&models.PlanVersion{},
 		&models.ReassignmentLog{},
+		&models.OrderStatusHistory{},
+		&models.OptimizationJob{},
+		&models.ImportJob{},
+		&models.ReplanProposal{},
+		&models.ScoringProfile{},
 		// AI Infrastructure models
 		&models.ModelMetric{},
 		&models.InferenceLog{},
@@ -108,7 +108,7 @@ func GetDashboard(c *gin.Context) {
 	totalOrdersChange := calculateChange(float64(newOrdersToday), float64(newOrdersYesterday))
 
 	// 2. Active Routes & Trends
-	database.DB.Model(&models.Route{}).Where("status IN ?", []string{"assigned", "in_progress"}).Count(&activeRoutes)
+	database.DB.Model(&models.Route{}).Where("status IN ?", []string{"assigned", "dispatched", "in_progress"}).Count(&activeRoutes)
 	// Prev active routes is hard to capture without snapshots. We'll compare "Created Routes Today vs Yesterday"
 	var routesToday, routesYesterday int64
 	database.DB.Model(&models.Route{}).Where("created_at >= ?", startOfToday).Count(&routesToday)
@@ -201,7 +201,7 @@ func GetDashboard(c *gin.Context) {
 
 	// 8. Fleet Distribution
 	var onRouteCount, maintenanceCount int64
-	database.DB.Model(&models.Route{}).Where("status IN ?", []string{"in_progress", "assigned"}).Distinct("vehicle_id").Count(&onRouteCount)
+	database.DB.Model(&models.Route{}).Where("status IN ?", []string{"in_progress", "dispatched", "assigned"}).Distinct("vehicle_id").Count(&onRouteCount)
 	database.DB.Model(&models.Vehicle{}).Where("status = ?", "maintenance").Count(&maintenanceCount)
 
 	loadingCount := totalVehicles - (onRouteCount + maintenanceCount)
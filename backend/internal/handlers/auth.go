@@ -211,3 +211,16 @@ func GetMe(c *gin.Context) {
 		Role:  user.Role,
 	})
 }
+
+// currentUserID returns the ID of the authenticated user, or nil
+func currentUserID(c *gin.Context) *uuid.UUID {
+	value, ok := c.Get("user_id")
+	if !ok {
+		return nil
+	}
+	userID, ok := value.(uuid.UUID)
+	if !ok {
+		return nil
+	}
+	return &userID
+}
@@ -103,6 +103,9 @@ func UpdateCustomer(c *gin.Context) {
 	customer.Latitude = input.Latitude
 	customer.Longitude = input.Longitude
 	customer.Location = fmt.Sprintf("POINT(%f %f)", input.Longitude, input.Latitude)
+	if input.Tier != "" {
+		customer.Tier = input.Tier
+	}
 
 	if err := database.DB.Save(&customer).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update customer: " + err.Error()})
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ai-tms/backend/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// ReassignRouteRequest hands a route to another driver, vehicle or both
+type ReassignRouteRequest struct {
+	DriverID  string `json:"driver_id"`
+	VehicleID string `json:"vehicle_id"`
+	Reason    string `json:"reason" binding:"required"` // vehicle_breakdown, driver_unavailable, customer_request, ...
+	Notes     string `json:"notes"`
+}
+
+// ReassignStopRequest moves a stop to another route, or to another place on its own route
+type ReassignStopRequest struct {
+	RouteID  string `json:"route_id" binding:"required"`
+	Sequence int    `json:"sequence" binding:"required,min=1"`
+	Reason   string `json:"reason" binding:"required"`
+	Notes    string `json:"notes"`
+}
+
+// ReassignRoute hands a route to another driver or vehicle, logging the reassignment
+func ReassignRoute(c *gin.Context) {
+	routeID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid route ID"})
+		return
+	}
+
+	var req ReassignRouteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.DriverID == "" && req.VehicleID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "driver_id or vehicle_id is required"})
+		return
+	}
+
+	userID := currentUserID(c)
+	if userID == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	reassignment := &services.ReassignRouteRequest{
+		RouteID:      routeID,
+		Reason:       services.ReassignmentReason(req.Reason),
+		Notes:        req.Notes,
+		ReassignedBy: *userID,
+	}
+	if req.DriverID != "" {
+		driverID, err := uuid.Parse(req.DriverID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid driver ID"})
+			return
+		}
+		reassignment.ToDriverID = &driverID
+	}
+	if req.VehicleID != "" {
+		vehicleID, err := uuid.Parse(req.VehicleID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid vehicle ID"})
+			return
+		}
+		reassignment.ToVehicleID = &vehicleID
+	}
+
+	err = services.NewDispatchService().SetLockOverride(lockOverride(c)).ReassignRoute(reassignment)
+	if err != nil {
+		respondReassignment(c, err, "Failed to reassign route")
+		return
+	}
+
+	// Broadcast event for real-time dashboard
+	services.GetEventService().Broadcast(services.EventStatusUpdate, gin.H{
+		"route_id":   routeID,
+		"driver_id":  reassignment.ToDriverID,
+		"vehicle_id": reassignment.ToVehicleID,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Route reassigned successfully"})
+}
+
+// ReassignStop moves a stop to a place on another route, or on its own, and retimes the routes
+func ReassignStop(c *gin.Context) {
+	stopID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid stop ID"})
+		return
+	}
+
+	var req ReassignStopRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	toRouteID, err := uuid.Parse(req.RouteID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid route ID"})
+		return
+	}
+
+	userID := currentUserID(c)
+	if userID == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	err = services.NewDispatchService().SetMapsProxy(mapsProxySvc).SetLockOverride(lockOverride(c)).
+		ReassignStop(&services.ReassignStopRequest{
+			StopID:       stopID,
+			ToRouteID:    toRouteID,
+			NewSequence:  req.Sequence,
+			Reason:       services.ReassignmentReason(req.Reason),
+			Notes:        req.Notes,
+			ReassignedBy: *userID,
+		})
+	if err != nil {
+		respondReassignment(c, err, "Failed to reassign stop")
+		return
+	}
+
+	// Broadcast event for real-time dashboard
+	services.GetEventService().Broadcast(services.EventStatusUpdate, gin.H{
+		"stop_id":  stopID,
+		"route_id": toRouteID,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Stop reassigned successfully"})
+}
+
+// respondReassignment answers a failed reassignment
+func respondReassignment(c *gin.Context, err error, failure string) {
+	switch {
+	case errors.Is(err, services.ErrRouteNotFound), errors.Is(err, services.ErrStopNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrRouteClosed):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrRouteLocked):
+		respondRouteLocked(c, err)
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": failure})
+	}
+}
@@ -16,6 +16,9 @@ type VehicleDTO struct {
 	Type              string  `json:"type"`
 	Capacity          float64 `json:"capacity"`
 	CostPerKm         float64 `json:"cost_per_km"`
+	FixedCostPerDay   float64 `json:"fixed_cost_per_day"`
+	CostPerHour       float64 `json:"cost_per_hour"`
+	OvertimeCost      float64 `json:"overtime_cost_per_hour"`
 	Status            string  `json:"status"`
 	CurrentDriverID   *string `json:"current_driver_id,omitempty"`
 	CurrentDriverName *string `json:"current_driver_name,omitempty"`
@@ -46,12 +49,15 @@ func ListVehicles(c *gin.Context) {
 	vehicleDTOs := make([]VehicleDTO, 0, len(vehicles))
 	for _, v := range vehicles {
 		dto := VehicleDTO{
-			ID:           v.ID.String(),
-			LicensePlate: v.LicensePlate,
-			Type:         v.VehicleType,
-			Capacity:     float64(v.CapacityKg),
-			CostPerKm:    v.CostPerKm,
-			Status:       v.Status,
+			ID:              v.ID.String(),
+			LicensePlate:    v.LicensePlate,
+			Type:            v.VehicleType,
+			Capacity:        float64(v.CapacityKg),
+			CostPerKm:       v.CostPerKm,
+			FixedCostPerDay: v.FixedCostPerDay,
+			CostPerHour:     v.CostPerHour,
+			OvertimeCost:    v.OvertimeCostPerHour,
+			Status:          v.Status,
 		}
 		if v.CurrentDriverID != nil {
 			idStr := v.CurrentDriverID.String()
@@ -74,6 +80,10 @@ func CreateVehicle(c *gin.Context) {
 		Type         string  `json:"type" binding:"required"`
 		Capacity     float64 `json:"capacity" binding:"required"`
 		CostPerKm    float64 `json:"cost_per_km" binding:"required"`
+
+		FixedCostPerDay     float64 `json:"fixed_cost_per_day"`
+		CostPerHour         float64 `json:"cost_per_hour"`
+		OvertimeCostPerHour float64 `json:"overtime_cost_per_hour"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -88,6 +98,10 @@ func CreateVehicle(c *gin.Context) {
 		CapacityKg:   int(req.Capacity),
 		CostPerKm:    req.CostPerKm,
 		Status:       "available",
+
+		FixedCostPerDay:     req.FixedCostPerDay,
+		CostPerHour:         req.CostPerHour,
+		OvertimeCostPerHour: req.OvertimeCostPerHour,
 	}
 
 	if err := database.DB.Create(&vehicle).Error; err != nil {
@@ -96,12 +110,15 @@ func CreateVehicle(c *gin.Context) {
 	}
 
 	c.JSON(http.StatusCreated, VehicleDTO{
-		ID:           vehicle.ID.String(),
-		LicensePlate: vehicle.LicensePlate,
-		Type:         vehicle.VehicleType,
-		Capacity:     float64(vehicle.CapacityKg),
-		CostPerKm:    vehicle.CostPerKm,
-		Status:       vehicle.Status,
+		ID:              vehicle.ID.String(),
+		LicensePlate:    vehicle.LicensePlate,
+		Type:            vehicle.VehicleType,
+		Capacity:        float64(vehicle.CapacityKg),
+		CostPerKm:       vehicle.CostPerKm,
+		FixedCostPerDay: vehicle.FixedCostPerDay,
+		CostPerHour:     vehicle.CostPerHour,
+		OvertimeCost:    vehicle.OvertimeCostPerHour,
+		Status:          vehicle.Status,
 	})
 }
 
@@ -113,6 +130,10 @@ func UpdateVehicle(c *gin.Context) {
 		Status    string  `json:"status"`
 		Capacity  float64 `json:"capacity"`
 		CostPerKm float64 `json:"cost_per_km"`
+
+		FixedCostPerDay     *float64 `json:"fixed_cost_per_day"`
+		CostPerHour         *float64 `json:"cost_per_hour"`
+		OvertimeCostPerHour *float64 `json:"overtime_cost_per_hour"`
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -130,6 +151,15 @@ func UpdateVehicle(c *gin.Context) {
 	if req.CostPerKm > 0 {
 		updates["cost_per_km"] = req.CostPerKm
 	}
+	if req.FixedCostPerDay != nil {
+		updates["fixed_cost_per_day"] = *req.FixedCostPerDay
+	}
+	if req.CostPerHour != nil {
+		updates["cost_per_hour"] = *req.CostPerHour
+	}
+	if req.OvertimeCostPerHour != nil {
+		updates["overtime_cost_per_hour"] = *req.OvertimeCostPerHour
+	}
 
 	if err := database.DB.Model(&models.Vehicle{}).Where("id = ?", vehicleID).Updates(updates).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update vehicle"})
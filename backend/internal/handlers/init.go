@@ -1,16 +1,32 @@
 package handlers
 
 import (
+	"github.com/ai-tms/backend/internal/maps"
 	"github.com/ai-tms/backend/internal/services"
 )
 
 var (
-	notificationSvc *services.NotificationService
-	auditSvc        *services.AuditService
+	notificationSvc  *services.NotificationService
+	auditSvc         *services.AuditService
+	mapsProxySvc     *maps.MapsProxyService
+	optimizers       *services.OptimizerRegistry
+	optimizationJobs *services.OptimizationJobService
 )
 
 // InitializeServices sets the service dependencies for all handlers
-func InitializeServices(notif *services.NotificationService, audit *services.AuditService) {
+func InitializeServices(notif *services.NotificationService, audit *services.AuditService, mapsProxy *maps.MapsProxyService, optimizerRegistry *services.OptimizerRegistry, jobs *services.OptimizationJobService) {
 	notificationSvc = notif
 	auditSvc = audit
+	mapsProxySvc = mapsProxy
+	optimizers = optimizerRegistry
+	optimizationJobs = jobs
+}
+
+// routeOptimizer returns the optimizer for a requested strategy ("" for the default)
+func routeOptimizer(strategy string) (services.RouteOptimizer, error) {
+	registry := optimizers
+	if registry == nil {
+		registry = services.NewOptimizerRegistry(mapsProxySvc)
+	}
+	return registry.Get(strategy)
 }
@@ -0,0 +1,139 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ai-tms/backend/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// InsertionRequest asks where an order fits best into a day's active routes
+type InsertionRequest struct {
+	OrderID string `json:"order_id" binding:"required"`
+	Date    string `json:"date"`   // 2006-01-02, today when omitted
+	Commit  bool   `json:"commit"` // add the order to the quoted route when the insertion is feasible
+}
+
+// InsertionDTO represents the cheapest insertion of an order
+type InsertionDTO struct {
+	RouteID        string     `json:"route_id"`
+	RouteNumber    string     `json:"route_number"`
+	VehicleID      string     `json:"vehicle_id"`
+	Position       int        `json:"position"`                  // 1-based position of the delivery among the open stops
+	PickupPosition int        `json:"pickup_position,omitempty"` // 1-based position of the pickup, if the order has one
+	ExtraCost      float64    `json:"extra_cost"`
+	ExtraMinutes   float64    `json:"extra_minutes"`
+	Feasible       bool       `json:"feasible"`
+	Violations     []string   `json:"violations"`
+	PlannedArrival *time.Time `json:"planned_arrival,omitempty"`
+	Committed      bool       `json:"committed"`
+}
+
+// QuoteInsertion finds the active route and position where an order adds the least cost,
+// reporting the added cost and time and any constraint it breaks; with commit set the order
+// is added to that route and assigned
+func QuoteInsertion(c *gin.Context) {
+	var req InsertionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	orderID, err := uuid.Parse(req.OrderID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+	day := time.Now()
+	if req.Date != "" {
+		if day, err = time.ParseInLocation("2006-01-02", req.Date, time.Local); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date, expected YYYY-MM-DD"})
+			return
+		}
+	}
+
+	insertions := services.NewInsertionService(mapsProxySvc).SetLockOverride(lockOverride(c))
+	insertion, err := insertions.Quote(c.Request.Context(), orderID, day)
+	switch {
+	case errors.Is(err, services.ErrOrderNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	case errors.Is(err, services.ErrOrderNotPlannable):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrNoActiveRoutes):
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to quote insertion"})
+		return
+	}
+
+	dto := newInsertionDTO(insertion)
+	if !req.Commit {
+		c.JSON(http.StatusOK, gin.H{"data": dto})
+		return
+	}
+
+	_, err = insertions.Commit(insertion, currentUserID(c))
+	switch {
+	case errors.Is(err, services.ErrInsertionInfeasible):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error(), "data": dto})
+		return
+	case errors.Is(err, services.ErrRouteLocked):
+		respondRouteLocked(c, err)
+		return
+	case errors.Is(err, services.ErrRouteChanged), errors.Is(err, services.ErrOrderNotPlannable):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert order"})
+		return
+	}
+	dto.Committed = true
+
+	if userID := currentUserID(c); userID != nil && auditSvc != nil {
+		auditSvc.LogAction(*userID, "insert_order", "route", insertion.RouteID, gin.H{
+			"order_id":   orderID,
+			"position":   dto.Position,
+			"extra_cost": dto.ExtraCost,
+		}, c.ClientIP(), c.Request.UserAgent())
+	}
+
+	// Broadcast event for real-time dashboard
+	services.GetEventService().Broadcast(services.EventStatusUpdate, gin.H{
+		"route_id": insertion.RouteID,
+		"order_id": orderID,
+		"status":   "assigned",
+	})
+
+	c.JSON(http.StatusOK, gin.H{"data": dto})
+}
+
+// newInsertionDTO converts an insertion quote
+func newInsertionDTO(insertion *services.Insertion) InsertionDTO {
+	dto := InsertionDTO{
+		RouteID:      insertion.RouteID.String(),
+		RouteNumber:  insertion.RouteNumber,
+		VehicleID:    insertion.VehicleID,
+		Position:     insertion.Position + 1,
+		ExtraCost:    insertion.ExtraCost,
+		ExtraMinutes: insertion.ExtraTime.Minutes(),
+		Feasible:     insertion.Feasible,
+		Violations:   insertion.Violations,
+	}
+	if insertion.PickupPosition >= 0 {
+		dto.PickupPosition = insertion.PickupPosition + 1
+	}
+	if dto.Violations == nil {
+		dto.Violations = []string{}
+	}
+	if insertion.Position >= 0 && insertion.Position < len(insertion.Route.Stops) {
+		arrival := insertion.Route.Stops[insertion.Position].ArrivalTime
+		dto.PlannedArrival = &arrival
+	}
+	return dto
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/ai-tms/backend/internal/models"
+	"github.com/ai-tms/backend/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// OptimizationJobDTO represents an asynchronous route optimization
+type OptimizationJobDTO struct {
+	ID         string                 `json:"id"`
+	Status     string                 `json:"status"` // queued, running, completed, failed, cancelled
+	Strategy   string                 `json:"strategy"`
+	Iteration  int                    `json:"iteration"`
+	BestCost   float64                `json:"best_cost"`
+	Error      string                 `json:"error,omitempty"`
+	Result     *GenerateRouteResponse `json:"result,omitempty"` // Draft plan once completed
+	StartedAt  *time.Time             `json:"started_at,omitempty"`
+	FinishedAt *time.Time             `json:"finished_at,omitempty"`
+	CreatedAt  time.Time              `json:"created_at"`
+}
+
+// newOptimizationJobDTO converts a job, decoding its stored plan summary
+func newOptimizationJobDTO(job *models.OptimizationJob) OptimizationJobDTO {
+	dto := OptimizationJobDTO{
+		ID:         job.ID.String(),
+		Status:     job.Status,
+		Strategy:   job.Strategy,
+		Iteration:  job.Iteration,
+		BestCost:   job.BestCost,
+		Error:      job.Error,
+		StartedAt:  job.StartedAt,
+		FinishedAt: job.FinishedAt,
+		CreatedAt:  job.CreatedAt,
+	}
+	if job.Status == services.JobStatusCompleted {
+		var result GenerateRouteResponse
+		if err := json.Unmarshal([]byte(job.Result), &result); err == nil {
+			dto.Result = &result
+		}
+	}
+	return dto
+}
+
+// SubmitOptimizationJob starts route generation in the background and returns the job to poll
+// The request is the same as for GenerateRoute; progress is broadcast as OPTIMIZATION_PROGRESS
+// events on the event stream and the plan is saved as draft routes
+func SubmitOptimizationJob(c *gin.Context) {
+	if optimizationJobs == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Optimization jobs are not available"})
+		return
+	}
+
+	var req GenerateRouteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	problem, planErr := planningProblem(req, lockOverride(c))
+	if planErr != nil {
+		c.JSON(planErr.status, gin.H{"error": planErr.message})
+		return
+	}
+
+	optimizer, err := routeOptimizer(req.Strategy)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	request, _ := json.Marshal(req)
+	job := &models.OptimizationJob{
+		ID:        uuid.New(),
+		Strategy:  optimizer.Name(),
+		Request:   string(request),
+		CreatedBy: currentUserID(c),
+	}
+
+	timeout := time.Duration(req.MaxRuntimeMs) * time.Millisecond
+	err = optimizationJobs.Submit(job, timeout, func(ctx context.Context, progress services.ProgressFunc) (interface{}, error) {
+		problem.Progress = progress
+		result, err := optimizer.Optimize(ctx, problem)
+		if err != nil && !errors.Is(err, services.ErrUnassignedOrders) {
+			return nil, err
+		}
+		return savePlan(result, problem.PlanStart, req.Seed, services.RouteStatusDraft, job.CreatedBy)
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create optimization job"})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, newOptimizationJobDTO(job))
+}
+
+// GetOptimizationJob returns the status, progress and, once completed, the plan of a job
+func GetOptimizationJob(c *gin.Context) {
+	if optimizationJobs == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Optimization jobs are not available"})
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return
+	}
+
+	job, err := optimizationJobs.Get(id)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Optimization job not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, newOptimizationJobDTO(job))
+}
+
+// CancelOptimizationJob stops a queued or running job
+func CancelOptimizationJob(c *gin.Context) {
+	if optimizationJobs == nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Optimization jobs are not available"})
+		return
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid job ID"})
+		return
+	}
+
+	job, err := optimizationJobs.Cancel(id)
+	switch {
+	case errors.Is(err, services.ErrJobNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Optimization job not found"})
+		return
+	case errors.Is(err, services.ErrJobFinished):
+		c.JSON(http.StatusConflict, gin.H{"error": "Optimization job already finished", "status": job.Status})
+		return
+	}
+
+	c.JSON(http.StatusAccepted, newOptimizationJobDTO(job))
+}
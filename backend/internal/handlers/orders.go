@@ -1,22 +1,28 @@
 package handlers
 
 import (
-	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/ai-tms/backend/internal/database"
 	"github.com/ai-tms/backend/internal/models"
+	"github.com/ai-tms/backend/internal/services"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 // CreateOrderRequest represents order creation request
 type CreateOrderRequest struct {
 	CustomerID      string    `json:"customer_id" binding:"required"`
 	PickupAddress   string    `json:"pickup_address"`
+	PickupLatitude  float64   `json:"pickup_latitude"`
+	PickupLongitude float64   `json:"pickup_longitude"`
 	DeliveryAddress string    `json:"delivery_address" binding:"required"`
 	PickupTime      time.Time `json:"pickup_time"`
 	DeliveryTime    time.Time `json:"delivery_time"`
@@ -26,18 +32,45 @@ type CreateOrderRequest struct {
 
 // OrderDTO represents order data transfer object
 type OrderDTO struct {
-	ID              string    `json:"id"`
-	OrderNumber     string    `json:"order_number"`
-	CustomerID      string    `json:"customer_id"`
-	CustomerName    string    `json:"customer_name,omitempty"`
-	PickupAddress   string    `json:"pickup_address"`
-	DeliveryAddress string    `json:"delivery_address"`
-	PickupTime      time.Time `json:"pickup_time"`
-	DeliveryTime    time.Time `json:"delivery_time"`
-	Status          string    `json:"status"`
-	Priority        string    `json:"priority"`
-	Notes           string    `json:"notes"`
-	CreatedAt       time.Time `json:"created_at"`
+	ID              string     `json:"id"`
+	OrderNumber     string     `json:"order_number"`
+	CustomerID      string     `json:"customer_id"`
+	CustomerName    string     `json:"customer_name,omitempty"`
+	PickupAddress   string     `json:"pickup_address"`
+	DeliveryAddress string     `json:"delivery_address"`
+	PickupTime      time.Time  `json:"pickup_time"`
+	DeliveryTime    time.Time  `json:"delivery_time"`
+	Status          string     `json:"status"`
+	Priority        string     `json:"priority"`
+	Notes           string     `json:"notes"`
+	RetryOn         *time.Time `json:"retry_on,omitempty"` // held back from planning until this day after a failed delivery
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+// newOrderDTO converts an order
+func newOrderDTO(order models.Order) OrderDTO {
+	dto := OrderDTO{
+		ID:              order.ID.String(),
+		OrderNumber:     order.OrderNumber,
+		CustomerID:      order.CustomerID.String(),
+		PickupAddress:   order.PickupAddress,
+		DeliveryAddress: order.DeliveryAddress,
+		Status:          order.Status,
+		Priority:        order.Priority,
+		Notes:           order.Notes,
+		RetryOn:         order.RetryOn,
+		CreatedAt:       order.CreatedAt,
+	}
+	if order.PickupTime != nil {
+		dto.PickupTime = *order.PickupTime
+	}
+	if order.DeliveryTime != nil {
+		dto.DeliveryTime = *order.DeliveryTime
+	}
+	if order.Customer != nil {
+		dto.CustomerName = order.Customer.Name
+	}
+	return dto
 }
 
 // CreateOrder creates a new order
@@ -56,6 +89,8 @@ func CreateOrder(c *gin.Context) {
 		OrderNumber:     orderNumber,
 		CustomerID:      uuid.MustParse(req.CustomerID),
 		PickupAddress:   req.PickupAddress,
+		PickupLatitude:  req.PickupLatitude,
+		PickupLongitude: req.PickupLongitude,
 		DeliveryAddress: req.DeliveryAddress,
 		PickupTime:      &req.PickupTime,
 		DeliveryTime:    &req.DeliveryTime,
@@ -200,7 +235,10 @@ func UpdateOrder(c *gin.Context) {
 
 	var req struct {
 		Status          string    `json:"status"`
+		Reason          string    `json:"reason"` // why the status changed, kept in the status history
 		PickupAddress   string    `json:"pickup_address"`
+		PickupLatitude  *float64  `json:"pickup_latitude"`
+		PickupLongitude *float64  `json:"pickup_longitude"`
 		DeliveryAddress string    `json:"delivery_address"`
 		PickupTime      time.Time `json:"pickup_time"`
 		DeliveryTime    time.Time `json:"delivery_time"`
@@ -214,13 +252,22 @@ func UpdateOrder(c *gin.Context) {
 		return
 	}
 
-	updates := make(map[string]interface{})
-	if req.Status != "" {
-		updates["status"] = req.Status
+	id, err := uuid.Parse(orderID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
 	}
+
+	updates := make(map[string]interface{})
 	if req.PickupAddress != "" {
 		updates["pickup_address"] = req.PickupAddress
 	}
+	if req.PickupLatitude != nil {
+		updates["pickup_latitude"] = *req.PickupLatitude
+	}
+	if req.PickupLongitude != nil {
+		updates["pickup_longitude"] = *req.PickupLongitude
+	}
 	if req.DeliveryAddress != "" {
 		updates["delivery_address"] = req.DeliveryAddress
 	}
@@ -240,27 +287,105 @@ func UpdateOrder(c *gin.Context) {
 		updates["customer_id"] = req.CustomerID
 	}
 
-	if err := database.DB.Model(&models.Order{}).Where("id = ?", orderID).Updates(updates).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order"})
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		// Status changes follow the order lifecycle
+		if req.Status != "" {
+			var order models.Order
+			if err := tx.First(&order, "id = ?", id).Error; err != nil {
+				return services.ErrOrderNotFound
+			}
+			if order.Status != req.Status {
+				if err := services.NewOrderStatusService().Transition(tx, &order, services.OrderStatus(req.Status), orderStatusChange(c, req.Reason)); err != nil {
+					return err
+				}
+			}
+		}
+		if len(updates) == 0 {
+			return nil
+		}
+		return tx.Model(&models.Order{}).Where("id = ?", id).Updates(updates).Error
+	})
+	if err != nil {
+		respondOrderTransition(c, err, "Failed to update order")
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Order updated successfully"})
 }
 
-// DeleteOrder cancels an order
+// DeleteOrder cancels an order; delivered orders cannot be cancelled
 func DeleteOrder(c *gin.Context) {
-	orderID := c.Param("id")
+	orderID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
 
-	if err := database.DB.Model(&models.Order{}).Where("id = ?", orderID).Update("status", "cancelled").Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel order"})
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		_, err := services.NewOrderStatusService().TransitionByID(tx, orderID, services.OrderStatusCancelled, orderStatusChange(c, c.Query("reason")))
+		return err
+	})
+	if err != nil {
+		respondOrderTransition(c, err, "Failed to cancel order")
 		return
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Order cancelled successfully"})
 }
 
-// ImportOrders imports orders from CSV
+// orderStatusChange describes an order status change made through the API by the current user
+func orderStatusChange(c *gin.Context, reason string) services.OrderStatusChange {
+	return services.OrderStatusChange{
+		ChangedBy: currentUserID(c),
+		Reason:    reason,
+		Source:    services.OrderStatusSourceAPI,
+	}
+}
+
+// respondOrderTransition answers a failed order status change, listing the allowed next
+// statuses when the lifecycle forbids it
+func respondOrderTransition(c *gin.Context, err error, failure string) {
+	var transition *services.OrderTransitionError
+	switch {
+	case errors.As(err, &transition):
+		c.JSON(http.StatusConflict, gin.H{
+			"error":          transition.Error(),
+			"status":         transition.From,
+			"allowed_status": transition.Allowed,
+		})
+	case errors.Is(err, services.ErrOrderNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": failure})
+	}
+}
+
+// ImportJobDTO represents an import job with its column mapping and row errors
+type ImportJobDTO struct {
+	ID           string                    `json:"id"`
+	Status       string                    `json:"status"`
+	Format       string                    `json:"format"`
+	FileName     string                    `json:"file_name"`
+	DryRun       bool                      `json:"dry_run"`
+	SkipInvalid  bool                      `json:"skip_invalid"`
+	Columns      map[string]*string        `json:"columns"` // field each header was read into, null when ignored
+	TotalRows    int                       `json:"total_rows"`
+	ValidRows    int                       `json:"valid_rows"`
+	ImportedRows int                       `json:"imported_rows"`
+	FailedRows   int                       `json:"failed_rows"`
+	Errors       []services.ImportRowError `json:"errors"`
+	Error        string                    `json:"error,omitempty"`
+	Orders       []OrderDTO                `json:"orders,omitempty"` // created, or to be created on a dry run
+	CreatedAt    time.Time                 `json:"created_at"`
+	FinishedAt   *time.Time                `json:"finished_at"`
+}
+
+// ImportOrders imports orders in bulk from an uploaded CSV, XLSX, JSON or NDJSON file
+// The format is taken from the "format" form field, or else the file extension; workbooks are
+// read from the "sheet" form field's sheet, or their first one. Columns are matched to order
+// fields by header, with an optional JSON "mapping" form field for headers of other names.
+// With dry_run=true rows are only validated; otherwise nothing is imported while any row
+// fails validation, unless skip_invalid=true
 func ImportOrders(c *gin.Context) {
 	file, err := c.FormFile("file")
 	if err != nil {
@@ -268,6 +393,25 @@ func ImportOrders(c *gin.Context) {
 		return
 	}
 
+	format := c.PostForm("format")
+	if format == "" {
+		format = services.ImportFormatFromFileName(file.Filename)
+	}
+
+	options := services.ImportOptions{
+		Format:      format,
+		FileName:    file.Filename,
+		DryRun:      formFlag(c, "dry_run"),
+		SkipInvalid: formFlag(c, "skip_invalid"),
+		CreatedBy:   currentUserID(c),
+	}
+	if mapping := c.PostForm("mapping"); mapping != "" {
+		if err := json.Unmarshal([]byte(mapping), &options.Mapping); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "mapping must be a JSON object of header to field"})
+			return
+		}
+	}
+
 	// Open file
 	src, err := file.Open()
 	if err != nil {
@@ -276,67 +420,102 @@ func ImportOrders(c *gin.Context) {
 	}
 	defer src.Close()
 
-	// Parse CSV
-	reader := csv.NewReader(src)
-	records, err := reader.ReadAll()
+	sheet, err := services.ReadImportSheet(src, format, c.PostForm("sheet"))
+	if errors.Is(err, services.ErrImportFormat) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "formats": services.ImportFormats})
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid CSV format"})
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
-	if len(records) < 2 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "CSV file is empty"})
+	result, err := services.NewOrderImportService().Import(sheet, options)
+	switch {
+	case errors.Is(err, services.ErrImportEmpty), errors.Is(err, services.ErrImportColumns):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "fields": services.OrderImportFields, "data": newImportJobDTO(result.Job, nil)})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to import orders"})
 		return
 	}
 
-	// Skip header row
-	imported := 0
-	failed := 0
+	status := http.StatusOK
+	switch result.Job.Status {
+	case services.ImportStatusCompleted:
+		status = http.StatusCreated
+	case services.ImportStatusFailed:
+		status = http.StatusUnprocessableEntity
+	}
+	c.JSON(status, gin.H{"data": newImportJobDTO(result.Job, result.Orders)})
+}
 
-	for i, record := range records[1:] {
-		if len(record) < 4 {
-			failed++
-			continue
-		}
+// GetImportTemplate downloads an import file of the requested format, csv by default, with
+// every order field as a column and an example row
+func GetImportTemplate(c *gin.Context) {
+	data, contentType, fileName, err := services.ImportTemplate(c.DefaultQuery("format", services.ImportFormatCSV))
+	if errors.Is(err, services.ErrImportFormat) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "formats": services.ImportFormats})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build import template"})
+		return
+	}
 
-		// Parse customer ID
-		customerID, err := uuid.Parse(record[0])
-		if err != nil {
-			failed++
-			continue
-		}
+	c.Header("Content-Disposition", "attachment; filename="+fileName)
+	c.Data(http.StatusOK, contentType, data)
+}
 
-		// Parse delivery time
-		deliveryTime, err := time.Parse("2006-01-02 15:04:05", record[3])
-		if err != nil {
-			deliveryTime = time.Now().Add(24 * time.Hour)
-		}
+// GetImportJob returns an import job with its row errors
+func GetImportJob(c *gin.Context) {
+	var job models.ImportJob
+	if err := database.DB.First(&job, "id = ?", c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Import job not found"})
+		return
+	}
 
-		orderNumber := "ORD-" + time.Now().Format("20060102") + "-" + strconv.Itoa(i+1)
-
-		order := models.Order{
-			ID:              uuid.New(),
-			OrderNumber:     orderNumber,
-			CustomerID:      customerID,
-			PickupAddress:   record[1],
-			DeliveryAddress: record[2],
-			DeliveryTime:    &deliveryTime,
-			Status:          "pending",
-			Priority:        "normal",
-		}
+	c.JSON(http.StatusOK, gin.H{"data": newImportJobDTO(job, nil)})
+}
 
-		if err := database.DB.Create(&order).Error; err != nil {
-			failed++
-		} else {
-			imported++
-		}
+// newImportJobDTO converts an import job, decoding its stored mapping and errors
+func newImportJobDTO(job models.ImportJob, orders []models.Order) ImportJobDTO {
+	dto := ImportJobDTO{
+		ID:           job.ID.String(),
+		Status:       job.Status,
+		Format:       job.Format,
+		FileName:     job.FileName,
+		DryRun:       job.DryRun,
+		SkipInvalid:  job.SkipInvalid,
+		TotalRows:    job.TotalRows,
+		ValidRows:    job.ValidRows,
+		ImportedRows: job.ImportedRows,
+		FailedRows:   job.FailedRows,
+		Errors:       []services.ImportRowError{},
+		Error:        job.Error,
+		CreatedAt:    job.CreatedAt,
+		FinishedAt:   job.FinishedAt,
+	}
+	if job.Mapping != "" {
+		json.Unmarshal([]byte(job.Mapping), &dto.Columns)
+	}
+	if job.Errors != "" {
+		json.Unmarshal([]byte(job.Errors), &dto.Errors)
 	}
+	for _, order := range orders {
+		dto.Orders = append(dto.Orders, newOrderDTO(order))
+	}
+	return dto
+}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message":  "Import completed",
-		"imported": imported,
-		"failed":   failed,
-	})
+// formFlag reads a boolean from a form field or query parameter
+func formFlag(c *gin.Context, name string) bool {
+	value := c.PostForm(name)
+	if value == "" {
+		value = c.Query(name)
+	}
+	flag, _ := strconv.ParseBool(value)
+	return flag
 }
 
 // TrackOrder allows customers to track their order
@@ -349,12 +528,11 @@ func TrackOrder(c *gin.Context) {
 		return
 	}
 
-	// Get route stop if assigned
-	var routeStop models.RouteStop
+	// Get the current delivery stop if assigned
 	var eta *time.Time
 	var driverLocation *string
 
-	if err := database.DB.Preload("Route.Driver").First(&routeStop, "order_id = ?", order.ID).Error; err == nil {
+	if routeStop, err := services.FindCurrentDeliveryStop(database.DB.Preload("Route.Driver"), order.ID); err == nil {
 		eta = &routeStop.PlannedArrival
 		// Get real-time driver location
 		var gps models.GPSTracking
@@ -383,3 +561,101 @@ func TrackOrder(c *gin.Context) {
 
 	c.JSON(http.StatusOK, response)
 }
+
+// GetOrderTimeline returns every recorded step of an order for support staff, oldest first
+func GetOrderTimeline(c *gin.Context) {
+	orderID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	events, err := services.NewOrderTimelineService().Timeline(orderID)
+	if errors.Is(err, services.ErrOrderNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build order timeline"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": events})
+}
+
+// TrackOrderTimeline returns the customer-facing steps of an order, by order number
+func TrackOrderTimeline(c *gin.Context) {
+	var order models.Order
+	if err := database.DB.First(&order, "order_number = ?", c.Param("number")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Order not found"})
+		return
+	}
+
+	events, err := services.NewOrderTimelineService().Timeline(order.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to build order timeline"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"order_number": order.OrderNumber,
+		"status":       order.Status,
+		"data":         services.PublicTimeline(events),
+	})
+}
+
+// ListFailureReasons returns the failed delivery reason codes with their retry policies
+func ListFailureReasons(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"data": services.FailureReasonCatalog()})
+}
+
+// ReceiveReturnedOrder records a returning order as received back at the depot
+func ReceiveReturnedOrder(c *gin.Context) {
+	orderID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+	var req struct {
+		Notes string `json:"notes"` // condition of the goods and the like, kept in the status history
+	}
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var order *models.Order
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		order, err = services.NewFailedDeliveryService().ReceiveReturn(tx, orderID, orderStatusChange(c, req.Notes))
+		return err
+	})
+	if err != nil {
+		respondOrderTransition(c, err, "Failed to record return")
+		return
+	}
+
+	if userID := currentUserID(c); userID != nil && auditSvc != nil {
+		auditSvc.LogOrderAction(*userID, "receive_return", order.ID, gin.H{
+			"notes": req.Notes,
+		}, c.ClientIP(), c.Request.UserAgent())
+	}
+
+	services.GetEventService().Broadcast(services.EventStatusUpdate, gin.H{
+		"order_id": order.ID,
+		"status":   order.Status,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"data": newOrderDTO(*order)})
+}
+
+// ListDeliveryAttempts returns an order's failed delivery attempts, oldest first
+func ListDeliveryAttempts(c *gin.Context) {
+	var attempts []models.DeliveryAttempt
+	if err := database.DB.Where("order_id = ?", c.Param("id")).Order("attempt").Find(&attempts).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch delivery attempts"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": attempts})
+}
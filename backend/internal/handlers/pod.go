@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -32,12 +33,16 @@ func SubmitPOD(c *gin.Context) {
 		return
 	}
 
-	// 1. Find the RouteStop associated with this Order
-	var routeStop models.RouteStop
-	if err := database.DB.Where("order_id = ?", req.OrderID).First(&routeStop).Error; err != nil {
+	// 1. Find the open delivery stop of this Order; earlier failed attempts leave closed ones
+	routeStop, err := services.FindCurrentDeliveryStop(database.DB, req.OrderID)
+	if errors.Is(err, services.ErrNoOpenDeliveryStop) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Associated route stop not found"})
 		return
 	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch route stop"})
+		return
+	}
 
 	// 2. Create POD record with all required fields
 	pod := models.ProofOfDelivery{
@@ -51,28 +56,40 @@ func SubmitPOD(c *gin.Context) {
 		PhotoURLs:     "[]", // Initialize as empty JSON array
 	}
 
-	if err := database.DB.Create(&pod).Error; err != nil {
+	// 3. Save the POD and complete the stop in one go; the order is delivered with its stop
+	// and the route completed with its last stop
+	var routeCompleted bool
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&pod).Error; err != nil {
+			return err
+		}
+		change := services.OrderStatusChange{
+			ChangedBy: currentUserID(c),
+			Reason:    "proof of delivery signed by " + req.RecipientName,
+			Source:    services.OrderStatusSourcePOD,
+		}
+		// A signed POD shows the driver reached a stop they never reported arriving at
+		stop := routeStop
+		if routeStop.Status == string(services.StopStatusPending) {
+			if _, err := services.RecordStopStatus(tx, &stop, services.StopStatusInProgress, map[string]interface{}{
+				"actual_arrival": pod.Timestamp,
+			}, change); err != nil {
+				return err
+			}
+		}
+		var err error
+		routeCompleted, err = services.RecordStopStatus(tx, &stop, services.StopStatusCompleted, map[string]interface{}{
+			"actual_departure": pod.Timestamp,
+		}, change)
+		return err
+	})
+	if err != nil {
 		log.Printf("❌ Failed to save POD: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save POD"})
-		return
-	}
-
-	// 3. Update order status
-	if err := database.DB.Model(&models.Order{}).
-		Where("id = ?", req.OrderID).
-		Update("status", "delivered").Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update order status"})
+		respondStopTransition(c, routeStop, err, "Failed to save POD")
 		return
 	}
 
-	// 4. Update the RouteStop status
-	if err := database.DB.Model(&models.RouteStop{}).
-		Where("id = ?", routeStop.ID).
-		Update("status", "delivered").Error; err != nil {
-		log.Printf("⚠️ Failed to update route stop status: %v", err)
-	}
-
-	// 5. Broadcast event for real-time dashboard
+	// 4. Broadcast event for real-time dashboard
 	log.Printf("📢 Broadcasting POD submission for order %s", req.OrderID)
 	services.GetEventService().Broadcast(services.EventStatusUpdate, gin.H{
 		"stop_id":  routeStop.ID.String(),
@@ -80,6 +97,12 @@ func SubmitPOD(c *gin.Context) {
 		"route_id": routeStop.RouteID,
 		"order_id": routeStop.OrderID,
 	})
+	if routeCompleted {
+		services.GetEventService().Broadcast(services.EventStatusUpdate, gin.H{
+			"route_id": routeStop.RouteID,
+			"status":   services.RouteStatusCompleted,
+		})
+	}
 
 	log.Printf("✅ POD created successfully for order %s", req.OrderID)
 	c.JSON(http.StatusCreated, gin.H{
@@ -1,42 +1,54 @@
 package handlers
 
 import (
+	"errors"
+	"log"
 	"net/http"
+	"time"
 
 	"github.com/ai-tms/backend/internal/database"
 	"github.com/ai-tms/backend/internal/models"
 	"github.com/ai-tms/backend/internal/services"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 // ReplanRequest represents re-planning request
 type ReplanRequest struct {
-	EventType   string `json:"event_type" binding:"required"`
-	VehicleID   string `json:"vehicle_id"`
-	RouteID     string `json:"route_id"`
-	Location    string `json:"location"`
-	Severity    string `json:"severity"`
-	Description string `json:"description"`
+	EventType   string  `json:"event_type" binding:"required"`
+	VehicleID   string  `json:"vehicle_id"`
+	RouteID     string  `json:"route_id"`
+	OrderID     string  `json:"order_id"` // new_order: the order to insert
+	Location    string  `json:"location"`
+	Latitude    float64 `json:"latitude"` // breakdown or jam position, geocoded from location when omitted
+	Longitude   float64 `json:"longitude"`
+	Severity    string  `json:"severity"`
+	Description string  `json:"description"`
+	Strategy    string  `json:"strategy"` // route optimizer used for the alternatives
 }
 
 // ReplanResponse represents re-planning response
 type ReplanResponse struct {
+	ReplanID     string           `json:"replan_id"`  // apply one alternative through /routes/replan/:id/apply
+	ExpiresAt    time.Time        `json:"expires_at"` // alternatives cannot be applied afterwards
 	Alternatives []AlternativeDTO `json:"alternatives"`
 	Recommended  string           `json:"recommended"`
 }
 
 // AlternativeDTO represents an alternative plan
 type AlternativeDTO struct {
-	ID             string   `json:"id"`
-	Name           string   `json:"name"`
-	Description    string   `json:"description"`
-	TotalCost      float64  `json:"total_cost"`
-	TotalDistance  float64  `json:"total_distance"`
-	LateDeliveries int      `json:"late_deliveries"`
-	ChangedStops   int      `json:"changed_stops"`
-	Score          float64  `json:"score"`
-	Pros           []string `json:"pros"`
-	Cons           []string `json:"cons"`
+	ID             string                  `json:"id"`
+	Name           string                  `json:"name"`
+	Description    string                  `json:"description"`
+	TotalCost      float64                 `json:"total_cost"`
+	TotalDistance  float64                 `json:"total_distance"`
+	LateDeliveries int                     `json:"late_deliveries"`
+	ChangedStops   int                     `json:"changed_stops"`
+	Score          float64                 `json:"score"`
+	ScoreBreakdown services.ScoreBreakdown `json:"score_breakdown"` // why the alternative scores as it does
+	Pros           []string                `json:"pros"`
+	Cons           []string                `json:"cons"`
 }
 
 // GenerateReplan handles dynamic re-planning
@@ -47,40 +59,99 @@ func GenerateReplan(c *gin.Context) {
 		return
 	}
 
-	// Get current routes
+	// Get current routes with their stops, to tell which stops an alternative moves
 	var currentRoutes []models.Route
-	database.DB.Where("status IN ?", []string{"assigned", "in_progress"}).Find(&currentRoutes)
+	database.DB.Preload("Vehicle.CurrentDriver").Preload("Stops", func(db *gorm.DB) *gorm.DB {
+		return db.Order("sequence")
+	}).Where("status IN ?", services.ReplanRouteStatuses).Find(&currentRoutes)
+
+	// Locked routes are left as they are, and their vehicles take no other work
+	override := lockOverride(c)
+	lockedVehicles := make(map[uuid.UUID]bool)
+	unlocked := currentRoutes[:0]
+	for _, route := range currentRoutes {
+		if err := services.CheckRouteUnlocked(route, override); err != nil {
+			if route.ID.String() == req.RouteID || route.VehicleID.String() == req.VehicleID {
+				respondRouteLocked(c, err)
+				return
+			}
+			lockedVehicles[route.VehicleID] = true
+			continue
+		}
+		unlocked = append(unlocked, route)
+	}
+	currentRoutes = unlocked
+
+	// Vehicles on the road continue from their last GPS fix
+	vehicleIDs := make([]uuid.UUID, 0, len(currentRoutes))
+	for _, route := range currentRoutes {
+		vehicleIDs = append(vehicleIDs, route.VehicleID)
+	}
+	positions := services.LastPositions(vehicleIDs)
 
 	// Get undelivered orders
 	var orders []models.Order
-	database.DB.Where("status NOT IN ?", []string{"delivered", "cancelled"}).Find(&orders)
+	database.DB.Preload("Customer").Where("status NOT IN ?", []string{"delivered", "cancelled", "returning", "returned"}).Find(&orders)
 
 	// Get available vehicles
-	var vehicles []models.Vehicle
-	database.DB.Where("status = ?", "available").Find(&vehicles)
+	var available []models.Vehicle
+	database.DB.Where("status = ?", "available").Find(&available)
+	vehicles := make([]models.Vehicle, 0, len(available))
+	for _, vehicle := range available {
+		if !lockedVehicles[vehicle.ID] {
+			vehicles = append(vehicles, vehicle)
+		}
+	}
 
 	// Get depot
 	var depot models.Depot
 	database.DB.First(&depot)
 
+	// Get scoring profiles, the one for the depot and affected customer tier ranks the alternatives
+	var profiles []models.ScoringProfile
+	database.DB.Find(&profiles)
+
 	// Create event
 	event := services.ReplanEvent{
 		Type:        req.EventType,
 		VehicleID:   req.VehicleID,
 		RouteID:     req.RouteID,
+		OrderID:     req.OrderID,
 		Location:    req.Location,
+		Latitude:    req.Latitude,
+		Longitude:   req.Longitude,
 		Severity:    req.Severity,
 		Description: req.Description,
 	}
 
 	// Generate alternatives
-	replanner := services.NewReplanner()
+	optimizer, err := routeOptimizer(req.Strategy)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	replanner := services.NewReplanner().
+		SetOptimizer(optimizer).
+		SetMapsProxy(mapsProxySvc).
+		SetPositions(positions).
+		SetScoringProfiles(profiles)
 	alternatives, err := replanner.GenerateAlternatives(event, currentRoutes, orders, vehicles, depot)
+	if errors.Is(err, services.ErrInvalidReplanEvent) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to generate alternatives"})
 		return
 	}
 
+	// Keep the alternatives so that the chosen one can be applied as generated
+	proposal, err := services.NewReplanService().Propose(event, alternatives, currentUserID(c))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store alternatives"})
+		return
+	}
+
 	// Convert to DTOs
 	altDTOs := make([]AlternativeDTO, 0, len(alternatives))
 	for _, alt := range alternatives {
@@ -93,6 +164,7 @@ func GenerateReplan(c *gin.Context) {
 			LateDeliveries: alt.LateDeliveries,
 			ChangedStops:   alt.ChangedStops,
 			Score:          alt.Score,
+			ScoreBreakdown: alt.Breakdown,
 			Pros:           alt.Pros,
 			Cons:           alt.Cons,
 		})
@@ -109,6 +181,8 @@ func GenerateReplan(c *gin.Context) {
 	}
 
 	response := ReplanResponse{
+		ReplanID:     proposal.ID.String(),
+		ExpiresAt:    proposal.ExpiresAt,
 		Alternatives: altDTOs,
 		Recommended:  recommended,
 	}
@@ -116,8 +190,14 @@ func GenerateReplan(c *gin.Context) {
 	c.JSON(http.StatusOK, response)
 }
 
-// ApplyReplan applies a selected alternative
+// ApplyReplan applies a selected alternative of a stored replan
 func ApplyReplan(c *gin.Context) {
+	proposalID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid replan ID"})
+		return
+	}
+
 	var req struct {
 		AlternativeID string `json:"alternative_id" binding:"required"`
 	}
@@ -127,11 +207,74 @@ func ApplyReplan(c *gin.Context) {
 		return
 	}
 
-	// In production, implement actual plan application
-	// This would update routes, notify drivers, etc.
+	userID := currentUserID(c)
+	if userID == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	applied, err := services.NewReplanService().SetLockOverride(lockOverride(c)).Apply(proposalID, req.AlternativeID, *userID)
+	switch {
+	case errors.Is(err, services.ErrRouteLocked):
+		respondRouteLocked(c, err)
+		return
+	case errors.Is(err, services.ErrReplanNotFound), errors.Is(err, services.ErrAlternativeNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrReplanExpired):
+		c.JSON(http.StatusGone, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrReplanApplied), errors.Is(err, services.ErrReplanStale),
+		errors.Is(err, services.ErrOrderNotPlannable):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to apply re-plan"})
+		return
+	}
+
+	routeIDs := make([]string, 0, len(applied.Routes))
+	for _, changed := range applied.Routes {
+		routeIDs = append(routeIDs, changed.Route.ID.String())
+		notifyRouteChange(changed)
+	}
+
+	if auditSvc != nil {
+		auditSvc.LogAction(*userID, "apply_replan", "replan", proposalID, gin.H{
+			"alternative_id": req.AlternativeID,
+			"event_type":     applied.Event.Type,
+			"route_ids":      routeIDs,
+		}, c.ClientIP(), c.Request.UserAgent())
+	}
+
+	// Broadcast event for real-time dashboard
+	services.GetEventService().Broadcast(services.EventStatusUpdate, gin.H{
+		"replan_id":      proposalID,
+		"alternative_id": req.AlternativeID,
+		"status":         "replanned",
+		"route_ids":      routeIDs,
+	})
 
 	c.JSON(http.StatusOK, gin.H{
 		"message":        "Re-plan applied successfully",
+		"replan_id":      proposalID,
 		"alternative_id": req.AlternativeID,
+		"route_ids":      routeIDs,
+		"unrouted":       applied.Unrouted,
 	})
 }
+
+// notifyRouteChange tells the driver of a replanned route about the change
+func notifyRouteChange(changed services.ChangedRoute) {
+	if notificationSvc == nil || changed.Route.DriverID == nil {
+		return
+	}
+
+	var driver models.Driver
+	if err := database.DB.Preload("User").First(&driver, "id = ?", changed.Route.DriverID).Error; err != nil || driver.User == nil {
+		return
+	}
+	if err := notificationSvc.NotifyRouteChange(driver.User.Name, changed.Route.RouteNumber, changed.OpenStops); err != nil {
+		log.Printf("⚠️  Failed to notify driver of route %s: %v", changed.Route.RouteNumber, err)
+	}
+}
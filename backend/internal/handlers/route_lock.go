@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ai-tms/backend/internal/middleware"
+	"github.com/ai-tms/backend/internal/models"
+	"github.com/ai-tms/backend/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// RouteLockRequest locks or unlocks a route
+type RouteLockRequest struct {
+	Reason string `json:"reason" binding:"required"`
+}
+
+// LockRoute freezes a route against planning, replanning and dispatch changes
+func LockRoute(c *gin.Context) {
+	setRouteLock(c, true)
+}
+
+// UnlockRoute lets a locked route be changed again
+func UnlockRoute(c *gin.Context) {
+	setRouteLock(c, false)
+}
+
+// setRouteLock handles locking and unlocking, logging both to the audit trail
+func setRouteLock(c *gin.Context, locked bool) {
+	routeID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid route ID"})
+		return
+	}
+
+	var req RouteLockRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	userID := currentUserID(c)
+	if userID == nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "User not authenticated"})
+		return
+	}
+
+	locks := services.NewRouteLockService()
+	action := locks.Lock
+	if !locked {
+		action = locks.Unlock
+	}
+	route, err := action(routeID, *userID, req.Reason)
+	switch {
+	case errors.Is(err, services.ErrLockReasonRequired):
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	case errors.Is(err, services.ErrRouteNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Route not found"})
+		return
+	case errors.Is(err, services.ErrRouteLocked), errors.Is(err, services.ErrRouteNotLocked):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	case err != nil:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update route lock"})
+		return
+	}
+
+	auditAction := "lock_route"
+	if !locked {
+		auditAction = "unlock_route"
+	}
+	if auditSvc != nil {
+		auditSvc.LogRouteAction(*userID, auditAction, routeID, gin.H{
+			"is_locked": locked,
+			"reason":    req.Reason,
+		}, c.ClientIP(), c.Request.UserAgent())
+	}
+
+	// Broadcast event for real-time dashboard
+	services.GetEventService().Broadcast(services.EventStatusUpdate, gin.H{
+		"route_id":  routeID,
+		"is_locked": locked,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"data": route})
+}
+
+// lockOverride reports whether a request may change locked routes: it must ask for it with
+// override_lock=true and the user's role must hold the lock override permission
+func lockOverride(c *gin.Context) bool {
+	if c.Query("override_lock") != "true" {
+		return false
+	}
+	role, _ := c.Get("user_role")
+	name, _ := role.(string)
+	return middleware.RoleHasPermission(name, middleware.PermissionRouteOverride)
+}
+
+// respondRouteLocked answers a change refused because of a route lock
+func respondRouteLocked(c *gin.Context, err error) {
+	c.JSON(http.StatusLocked, gin.H{
+		"error": err.Error(),
+		"hint":  "unlock the route, or retry with override_lock=true if your role may override locks",
+	})
+}
+
+// ensureRouteChangeable loads a route for update within db and checks that the request may
+// change it: the route must be open, and unlocked unless the request overrides locks. It
+// answers the request and returns false otherwise
+func ensureRouteChangeable(c *gin.Context, db *gorm.DB, routeID uuid.UUID) (models.Route, bool) {
+	route, err := services.LockRouteForChange(db, routeID, lockOverride(c))
+	switch {
+	case err == nil:
+		if route.IsLocked {
+			logLockOverride(c, routeID)
+		}
+		return route, true
+	case errors.Is(err, services.ErrRouteNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": "Route not found"})
+	case errors.Is(err, services.ErrRouteClosed):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	case errors.Is(err, services.ErrRouteLocked):
+		respondRouteLocked(c, err)
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load route"})
+	}
+	return route, false
+}
+
+// logLockOverride records a change made to a locked route under the lock override
+func logLockOverride(c *gin.Context, routeID uuid.UUID) {
+	userID := currentUserID(c)
+	if auditSvc == nil || userID == nil {
+		return
+	}
+	auditSvc.LogRouteAction(*userID, "override_route_lock", routeID, gin.H{
+		"method": c.Request.Method,
+		"path":   c.FullPath(),
+	}, c.ClientIP(), c.Request.UserAgent())
+}
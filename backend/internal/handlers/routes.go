@@ -1,7 +1,10 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"time"
 
@@ -10,27 +13,101 @@ import (
 	"github.com/ai-tms/backend/internal/services"
 	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 // GenerateRouteRequest represents the request to generate routes
 type GenerateRouteRequest struct {
 	OrderIDs   []string `json:"order_ids" binding:"required"`
 	VehicleIDs []string `json:"vehicle_ids"`
-	DepotID    string   `json:"depot_id" binding:"required"`
+	DepotID    string   `json:"depot_id"`  // single depot, kept for existing clients
+	DepotIDs   []string `json:"depot_ids"` // several depots, vehicles start from their own depot
+	Strategy   string   `json:"strategy"`  // greedy, savings, local_search (default) or external
+
+	// Re-running with the same plan date, start time and seed reproduces the plan
+	PlanDate     string `json:"plan_date"`      // YYYY-MM-DD, today when empty
+	StartTime    string `json:"start_time"`     // HH:MM, 08:00 when empty
+	Seed         int64  `json:"seed"`           // local search scan order, 0 for input order
+	MaxRuntimeMs int    `json:"max_runtime_ms"` // wall-clock limit for the whole run
+
+	Constraints *RouteConstraintsDTO `json:"constraints"`
+}
+
+// depotIDs returns every requested depot ID, depot_id first
+func (r GenerateRouteRequest) depotIDs() []string {
+	ids := make([]string, 0, len(r.DepotIDs)+1)
+	if r.DepotID != "" {
+		ids = append(ids, r.DepotID)
+	}
+	for _, id := range r.DepotIDs {
+		if id != "" && id != r.DepotID {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// RouteConstraintsDTO represents optional solver constraints for route generation
+type RouteConstraintsDTO struct {
+	MaxStopsPerRoute       int     `json:"max_stops_per_route"`
+	MaxDistancePerRoute    float64 `json:"max_distance_per_route_km"`
+	HardTimeWindows        bool    `json:"hard_time_windows"`
+	WaitingCostPerHour     float64 `json:"waiting_cost_per_hour"`
+	LatenessPenaltyPerHour float64 `json:"lateness_penalty_per_hour"`
+	MaxOvertimeMinutes     int     `json:"max_overtime_minutes"` // negative forbids overtime
+	OvertimeCostPerHour    float64 `json:"overtime_cost_per_hour"`
+	BreakAfterDriving      int     `json:"break_after_driving_minutes"`
+	BreakMinutes           int     `json:"break_minutes"`
+	TimeLimitMs            int     `json:"time_limit_ms"` // local search budget, negative skips it
+
+	// VehicleTypes maps an order or customer ID to the allowed vehicle types, comma separated
+	VehicleTypes map[string]string `json:"vehicle_types"`
+
+	// EndDepots maps a vehicle ID to the depot its route must end at
+	EndDepots         map[string]string `json:"end_depots"`
+	EndAtNearestDepot bool              `json:"end_at_nearest_depot"`
 }
 
 // GenerateRouteResponse represents the response from route generation
 type GenerateRouteResponse struct {
-	Routes           []RouteDTO `json:"routes"`
-	TotalDistance    float64    `json:"total_distance"`
-	TotalCost        float64    `json:"total_cost"`
-	UnassignedOrders int        `json:"unassigned_orders"`
+	Strategy         string               `json:"strategy"`
+	PlanStart        time.Time            `json:"plan_start"`
+	Seed             int64                `json:"seed"`
+	Routes           []RouteDTO           `json:"routes"`
+	TotalDistance    float64              `json:"total_distance"`
+	TotalCost        float64              `json:"total_cost"`
+	UnassignedOrders int                  `json:"unassigned_orders"`
+	Unassigned       []UnassignedOrderDTO `json:"unassigned"`
+	Report           SolverReportDTO      `json:"solver_report"`
+}
+
+// SolverReportDTO summarizes the local search improvement over the greedy plan
+type SolverReportDTO struct {
+	InitialCost     float64        `json:"initial_cost"`
+	FinalCost       float64        `json:"final_cost"`
+	ImprovementPct  float64        `json:"improvement_pct"`
+	InitialDistance float64        `json:"initial_distance_km"`
+	FinalDistance   float64        `json:"final_distance_km"`
+	Iterations      int            `json:"iterations"`
+	Moves           map[string]int `json:"moves"`
+	DurationMs      int64          `json:"duration_ms"`
+	TimedOut        bool           `json:"timed_out"`
+}
+
+// UnassignedOrderDTO explains why an order was left out of the plan
+type UnassignedOrderDTO struct {
+	OrderID     string `json:"order_id"`
+	OrderNumber string `json:"order_number"`
+	Reason      string `json:"reason"`
 }
 
 // RouteDTO represents a route data transfer object
 type RouteDTO struct {
 	ID            string    `json:"id"`
+	RouteNumber   string    `json:"route_number,omitempty"`
 	VehicleID     string    `json:"vehicle_id"`
+	StartDepotID  string    `json:"start_depot_id,omitempty"`
+	EndDepotID    string    `json:"end_depot_id,omitempty"`
 	VehicleNumber string    `json:"vehicle_number,omitempty"` // Added
 	VehicleType   string    `json:"vehicle_type,omitempty"`   // Added
 	DriverID      string    `json:"driver_id,omitempty"`
@@ -40,154 +117,319 @@ type RouteDTO struct {
 	TotalDuration int       `json:"total_duration_minutes"`
 	TotalCost     float64   `json:"total_cost"`
 	Utilization   float64   `json:"utilization"`
+	Breaks        int       `json:"breaks,omitempty"`
+	Overtime      int       `json:"overtime_minutes,omitempty"`
+	CostBreakdown *CostDTO  `json:"cost_breakdown,omitempty"`
 	Status        string    `json:"status"`
 }
 
+// CostDTO breaks a route's total cost down by component
+type CostDTO struct {
+	Fixed    float64 `json:"fixed"`
+	Distance float64 `json:"distance"`
+	Time     float64 `json:"time"`
+	Overtime float64 `json:"overtime"`
+}
+
 // StopDTO represents a route stop
 type StopDTO struct {
 	ID            string    `json:"id"`
 	Sequence      int       `json:"sequence"`
 	OrderID       string    `json:"order_id"`
+	StopType      string    `json:"stop_type"` // pickup or delivery
 	CustomerID    string    `json:"customer_id"`
 	Address       string    `json:"address"`
 	ArrivalTime   time.Time `json:"arrival_time"`
 	DepartureTime time.Time `json:"departure_time"`
 	ServiceTime   int       `json:"service_time_minutes"`
+	WaitTime      int       `json:"wait_minutes,omitempty"`
+	LateBy        int       `json:"late_minutes,omitempty"`
+	BreakBefore   int       `json:"break_minutes,omitempty"`
 	Distance      float64   `json:"distance_km"`
 	CustomerName  string    `json:"customer_name"`
 	Status        string    `json:"status"`
 }
 
-// GenerateRoute handles route generation using VRP solver
-func GenerateRoute(c *gin.Context) {
-	var req GenerateRouteRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-		return
+// requestPlanStart resolves the plan date and start time of a planning request
+func requestPlanStart(date, clock string) (time.Time, error) {
+	day := time.Now()
+	if date != "" {
+		parsed, err := time.ParseInLocation("2006-01-02", date, time.Local)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid plan_date %q, want YYYY-MM-DD", date)
+		}
+		day = parsed
 	}
 
-	// Fetch orders
+	start, err := services.PlanStart(day, clock)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid start_time %q, want HH:MM", clock)
+	}
+	return start, nil
+}
+
+// stopAddress returns where a stop takes place, the pickup address for pickup stops
+func stopAddress(stop models.RouteStop) string {
+	if stop.StopType == services.StopTypePickup {
+		return stop.Order.PickupAddress
+	}
+	return stop.Order.DeliveryAddress
+}
+
+// planningError is a planning request that cannot be run, with the HTTP status to answer
+type planningError struct {
+	status  int
+	message string
+}
+
+func (e *planningError) Error() string {
+	return e.message
+}
+
+// planningProblem loads the orders, depots and vehicles of a planning request
+// Vehicles whose route on the plan date is locked are left out unless locks are overridden,
+// and so are orders held back until a later retry day after a failed delivery
+func planningProblem(req GenerateRouteRequest, overrideLock bool) (services.OptimizationProblem, *planningError) {
+	planStart, err := requestPlanStart(req.PlanDate, req.StartTime)
+	if err != nil {
+		return services.OptimizationProblem{}, &planningError{http.StatusBadRequest, err.Error()}
+	}
+
+	// Fetch orders with customers (coordinates come from the customer record)
 	var orders []models.Order
-	if err := database.DB.Where("id IN ?", req.OrderIDs).Find(&orders).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch orders"})
-		return
+	if err := database.DB.Preload("Customer").Scopes(services.PlannableOrders(planStart)).
+		Where("id IN ?", req.OrderIDs).Find(&orders).Error; err != nil {
+		return services.OptimizationProblem{}, &planningError{http.StatusInternalServerError, "Failed to fetch orders"}
 	}
 
 	if len(orders) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No valid orders found"})
-		return
+		return services.OptimizationProblem{}, &planningError{http.StatusBadRequest, "No valid orders found"}
+	}
+
+	depotIDs := req.depotIDs()
+	if len(depotIDs) == 0 {
+		return services.OptimizationProblem{}, &planningError{http.StatusBadRequest, "depot_id or depot_ids is required"}
 	}
 
-	// Fetch vehicles
+	// Fetch depots, keeping the requested order so the first depot stays the default
+	var found []models.Depot
+	if err := database.DB.Where("id IN ?", depotIDs).Find(&found).Error; err != nil {
+		return services.OptimizationProblem{}, &planningError{http.StatusInternalServerError, "Failed to fetch depots"}
+	}
+	depots := make([]models.Depot, 0, len(depotIDs))
+	for _, id := range depotIDs {
+		for _, depot := range found {
+			if depot.ID.String() == id {
+				depots = append(depots, depot)
+			}
+		}
+	}
+	if len(depots) != len(depotIDs) {
+		return services.OptimizationProblem{}, &planningError{http.StatusNotFound, "Depot not found"}
+	}
+
+	// Fetch vehicles, with several depots only those based at one of them
 	var vehicles []models.Vehicle
 	query := database.DB
 	if len(req.VehicleIDs) > 0 {
 		query = query.Where("id IN ?", req.VehicleIDs)
+	} else if len(depots) > 1 {
+		query = query.Where("depot_id IN ?", depotIDs)
 	}
-	if err := query.Where("status = ?", "available").Find(&vehicles).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch vehicles"})
-		return
+	if !overrideLock {
+		day := time.Date(planStart.Year(), planStart.Month(), planStart.Day(), 0, 0, 0, 0, planStart.Location())
+		query = query.Where("id NOT IN (?)", database.DB.Model(&models.Route{}).Select("vehicle_id").
+			Where("is_locked = ? AND date >= ? AND date < ?", true, day, day.AddDate(0, 0, 1)))
+	}
+	if err := query.Preload("CurrentDriver").Where("status = ?", "available").Find(&vehicles).Error; err != nil {
+		return services.OptimizationProblem{}, &planningError{http.StatusInternalServerError, "Failed to fetch vehicles"}
 	}
 
 	if len(vehicles) == 0 {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "No available vehicles found"})
-		return
-	}
+		return services.OptimizationProblem{}, &planningError{http.StatusBadRequest, "No available vehicles found"}
+	}
+
+	constraints := services.VRPConstraints{}
+	if req.Constraints != nil {
+		constraints.MaxStopsPerRoute = req.Constraints.MaxStopsPerRoute
+		constraints.MaxDistancePerRoute = req.Constraints.MaxDistancePerRoute
+		constraints.HardTimeWindows = req.Constraints.HardTimeWindows
+		constraints.WaitingCostPerHour = req.Constraints.WaitingCostPerHour
+		constraints.LatenessPenaltyPerHour = req.Constraints.LatenessPenaltyPerHour
+		constraints.MaxOvertime = time.Duration(req.Constraints.MaxOvertimeMinutes) * time.Minute
+		constraints.OvertimeCostPerHour = req.Constraints.OvertimeCostPerHour
+		constraints.BreakAfterDriving = time.Duration(req.Constraints.BreakAfterDriving) * time.Minute
+		constraints.BreakDuration = time.Duration(req.Constraints.BreakMinutes) * time.Minute
+		constraints.ImprovementTimeLimit = time.Duration(req.Constraints.TimeLimitMs) * time.Millisecond
+		constraints.VehicleTypes = req.Constraints.VehicleTypes
+		constraints.EndDepots = req.Constraints.EndDepots
+		constraints.EndAtNearestDepot = req.Constraints.EndAtNearestDepot
+	}
+	constraints.Seed = req.Seed
+
+	return services.OptimizationProblem{
+		Orders:      orders,
+		Vehicles:    vehicles,
+		Depot:       depots[0],
+		Depots:      depots,
+		Constraints: constraints,
+		PlanStart:   planStart,
+	}, nil
+}
 
-	// Fetch depot
-	var depot models.Depot
-	if err := database.DB.First(&depot, "id = ?", req.DepotID).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Depot not found"})
-		return
+// optimizationFailure maps an optimizer error to an HTTP status and message
+func optimizationFailure(err error) (int, string) {
+	switch {
+	case errors.Is(err, services.ErrMissingCoordinates):
+		return http.StatusBadRequest, err.Error()
+	case errors.Is(err, services.ErrExternalSolver):
+		return http.StatusBadGateway, err.Error()
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout, "Route optimization did not finish within max_runtime_ms"
+	case errors.Is(err, context.Canceled):
+		return http.StatusServiceUnavailable, "Route optimization was cancelled"
+	default:
+		return http.StatusInternalServerError, err.Error()
 	}
+}
 
-	// Run VRP solver
-	solver := services.NewVRPSolver(orders, vehicles, depot)
-	results, err := solver.Solve()
+// savePlan stores the optimized routes with the given status and summarizes the plan
+func savePlan(result *services.OptimizationResult, planStart time.Time, seed int64, status services.RouteStatus, changedBy *uuid.UUID) (*GenerateRouteResponse, error) {
+	saved, err := services.NewPlanService().SavePlan(result, planStart, status, changedBy)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		return nil, err
 	}
 
-	// Save routes to database
-	routeDTOs := make([]RouteDTO, 0, len(results))
+	routeDTOs := make([]RouteDTO, 0, len(result.Routes))
 	totalDistance := 0.0
 	totalCost := 0.0
 
-	for _, result := range results {
-		// Create route record
-		route := models.Route{
-			ID:            uuid.New(),
-			VehicleID:     uuid.MustParse(result.VehicleID),
-			DepotID:       depot.ID,
-			Status:        "planned",
-			TotalDistance: result.TotalDistance,
-			TotalCost:     result.TotalCost,
-			PlannedDate:   time.Now(),
-		}
-
-		if err := database.DB.Create(&route).Error; err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save route"})
-			return
-		}
+	for i, result := range result.Routes {
+		route := saved[i].Route
 
-		// Create route stops
 		stops := make([]StopDTO, 0, len(result.Stops))
-		for i, stop := range result.Stops {
-			routeStop := models.RouteStop{
-				ID:             uuid.New(),
-				RouteID:        route.ID,
-				OrderID:        uuid.MustParse(stop.OrderID),
-				Sequence:       i + 1,
-				PlannedArrival: stop.ArrivalTime,
-				Status:         "pending",
-			}
-
-			if err := database.DB.Create(&routeStop).Error; err != nil {
-				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save route stop"})
-				return
-			}
-
+		for j, stop := range result.Stops {
 			stops = append(stops, StopDTO{
-				ID:            routeStop.ID.String(),
-				Sequence:      i + 1,
+				ID:            saved[i].Stops[j].ID.String(),
+				Sequence:      j + 1,
 				OrderID:       stop.OrderID,
+				StopType:      stop.StopType,
 				CustomerID:    stop.CustomerID,
 				Address:       stop.Location,
 				ArrivalTime:   stop.ArrivalTime,
 				DepartureTime: stop.DepartureTime,
 				ServiceTime:   int(stop.ServiceTime.Minutes()),
+				WaitTime:      int(stop.WaitTime.Minutes()),
+				LateBy:        int(stop.LateBy.Minutes()),
+				BreakBefore:   int(stop.BreakBefore.Minutes()),
 				Distance:      stop.Distance,
+				Status:        saved[i].Stops[j].Status,
 			})
 		}
 
 		routeDTOs = append(routeDTOs, RouteDTO{
 			ID:            route.ID.String(),
+			RouteNumber:   route.RouteNumber,
 			VehicleID:     result.VehicleID,
+			StartDepotID:  result.StartDepotID,
+			EndDepotID:    result.EndDepotID,
 			Stops:         stops,
 			TotalDistance: result.TotalDistance,
 			TotalDuration: int(result.TotalDuration.Minutes()),
 			TotalCost:     result.TotalCost,
 			Utilization:   result.Utilization,
-			Status:        "planned",
+			Breaks:        result.Breaks,
+			Overtime:      int(result.Overtime.Minutes()),
+			CostBreakdown: &CostDTO{
+				Fixed:    result.FixedCost,
+				Distance: result.DistanceCost,
+				Time:     result.TimeCost,
+				Overtime: result.OvertimeCost,
+			},
+			Status: route.Status,
 		})
 
 		totalDistance += result.TotalDistance
 		totalCost += result.TotalCost
 	}
 
-	// Calculate unassigned orders
-	assignedOrders := 0
-	for _, route := range results {
-		assignedOrders += len(route.Stops)
+	// Report unassigned orders with their reasons
+	unassigned := make([]UnassignedOrderDTO, 0)
+	for _, u := range result.Unassigned {
+		unassigned = append(unassigned, UnassignedOrderDTO{
+			OrderID:     u.OrderID,
+			OrderNumber: u.OrderNumber,
+			Reason:      u.Reason,
+		})
 	}
-	unassignedOrders := len(orders) - assignedOrders
 
-	response := GenerateRouteResponse{
+	report := result.Report
+	return &GenerateRouteResponse{
+		Strategy:         result.Strategy,
+		PlanStart:        planStart,
+		Seed:             seed,
 		Routes:           routeDTOs,
 		TotalDistance:    totalDistance,
 		TotalCost:        totalCost,
-		UnassignedOrders: unassignedOrders,
+		UnassignedOrders: len(unassigned),
+		Unassigned:       unassigned,
+		Report: SolverReportDTO{
+			InitialCost:     report.InitialCost,
+			FinalCost:       report.FinalCost,
+			ImprovementPct:  report.Improvement(),
+			InitialDistance: report.InitialDistance,
+			FinalDistance:   report.FinalDistance,
+			Iterations:      report.Iterations,
+			Moves:           report.Moves,
+			DurationMs:      report.Duration.Milliseconds(),
+			TimedOut:        report.TimedOut,
+		},
+	}, nil
+}
+
+// GenerateRoute handles route generation using VRP solver
+func GenerateRoute(c *gin.Context) {
+	var req GenerateRouteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	problem, planErr := planningProblem(req, lockOverride(c))
+	if planErr != nil {
+		c.JSON(planErr.status, gin.H{"error": planErr.message})
+		return
+	}
+
+	ctx := c.Request.Context()
+	if req.MaxRuntimeMs > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, time.Duration(req.MaxRuntimeMs)*time.Millisecond)
+		defer cancel()
+	}
+
+	optimizer, err := routeOptimizer(req.Strategy)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Run VRP solver, a partial plan is kept and its unassigned orders reported
+	result, err := optimizer.Optimize(ctx, problem)
+	if err != nil && !errors.Is(err, services.ErrUnassignedOrders) {
+		status, message := optimizationFailure(err)
+		c.JSON(status, gin.H{"error": message})
+		return
+	}
+
+	response, err := savePlan(result, problem.PlanStart, req.Seed, services.RouteStatusPlanned, currentUserID(c))
+	if errors.Is(err, services.ErrOrderNotPlannable) {
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save plan"})
+		return
 	}
 
 	c.JSON(http.StatusOK, response)
@@ -225,6 +467,7 @@ func GetRoute(c *gin.Context) {
 			ID:           stop.ID.String(),
 			Sequence:     stop.Sequence,
 			OrderID:      stop.OrderID.String(),
+			StopType:     stop.StopType,
 			CustomerName: customerName,
 			Address:      "Unknown Location", // You might want to grab this from Order.DeliveryAddress if Stop address is empty?
 			// Actually RouteStop doesn't store address directly in model, it's computed or from Order.
@@ -238,7 +481,7 @@ func GetRoute(c *gin.Context) {
 		})
 		// Fix Address:
 		if stop.Order != nil {
-			stopDTOs[len(stopDTOs)-1].Address = stop.Order.DeliveryAddress
+			stopDTOs[len(stopDTOs)-1].Address = stopAddress(stop)
 		}
 	}
 
@@ -278,16 +521,37 @@ func AssignRoute(c *gin.Context) {
 		return
 	}
 
-	// Update route
-	driverUUID := uuid.MustParse(req.DriverID)
-	if err := database.DB.Model(&models.Route{}).Where("id = ?", routeID).Updates(map[string]interface{}{
-		"driver_id": driverUUID,
-		"status":    "assigned",
-	}).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to assign route"})
+	routeUUID, err := uuid.Parse(routeID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid route ID"})
+		return
+	}
+	driverUUID, err := uuid.Parse(req.DriverID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid driver ID"})
+		return
+	}
+	tx := database.DB.Begin()
+	route, ok := ensureRouteChangeable(c, tx, routeUUID)
+	if !ok {
+		tx.Rollback()
 		return
 	}
 
+	// Update route, a route that is already assigned only changes driver
+	updates := map[string]interface{}{"driver_id": driverUUID}
+	if route.Status == string(services.RouteStatusAssigned) {
+		err = tx.Model(&route).Updates(updates).Error
+	} else {
+		err = services.TransitionRoute(tx, &route, services.RouteStatusAssigned, updates)
+	}
+	if err != nil {
+		tx.Rollback()
+		respondRouteTransition(c, route, err, "Failed to assign route")
+		return
+	}
+	tx.Commit()
+
 	// 1. Fetch Driver/User name for notification
 	var user models.User
 	database.DB.First(&user, "id = ?", driverUUID)
@@ -296,7 +560,7 @@ func AssignRoute(c *gin.Context) {
 	if auditSvc != nil {
 		senderID, _ := c.Get("userID") // Assume auth middleware sets this
 		if sID, ok := senderID.(uuid.UUID); ok {
-			auditSvc.LogRouteAction(sID, "assign_driver", uuid.MustParse(routeID), req.DriverID, c.ClientIP(), c.Request.UserAgent())
+			auditSvc.LogRouteAction(sID, "assign_driver", routeUUID, req.DriverID, c.ClientIP(), c.Request.UserAgent())
 		}
 	}
 
@@ -311,12 +575,14 @@ func AssignRoute(c *gin.Context) {
 }
 
 // UpdateRoute updates route details
+// Moving a draft route to planned commits it, assigning its orders like a saved plan; aborting
+// a route sends the orders of its open stops back to pending
 func UpdateRoute(c *gin.Context) {
 	routeID := c.Param("id")
 
 	var req struct {
 		Status    string `json:"status"`
-		Locked    *bool  `json:"locked"`
+		Locked    *bool  `json:"locked"` // rejected, locks go through /routes/:id/lock and /unlock
 		VehicleID string `json:"vehicle_id"`
 		DriverID  string `json:"driver_id"`
 		Date      string `json:"date"`
@@ -327,13 +593,24 @@ func UpdateRoute(c *gin.Context) {
 		return
 	}
 
-	updates := make(map[string]interface{})
-	if req.Status != "" {
-		updates["status"] = req.Status
-	}
 	if req.Locked != nil {
-		updates["locked"] = *req.Locked
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Lock or unlock routes with a reason through /routes/:id/lock and /routes/:id/unlock"})
+		return
+	}
+	routeUUID, err := uuid.Parse(routeID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid route ID"})
+		return
+	}
+	tx := database.DB.Begin()
+	route, ok := ensureRouteChangeable(c, tx, routeUUID)
+	if !ok {
+		tx.Rollback()
+		return
 	}
+
+	updates := make(map[string]interface{})
+	status := services.RouteStatus(req.Status)
 	if req.VehicleID != "" {
 		if uid, err := uuid.Parse(req.VehicleID); err == nil {
 			updates["vehicle_id"] = uid
@@ -342,7 +619,9 @@ func UpdateRoute(c *gin.Context) {
 	if req.DriverID != "" {
 		if uid, err := uuid.Parse(req.DriverID); err == nil {
 			updates["driver_id"] = uid
-			updates["status"] = "assigned" // Auto assign status
+			if status == "" && route.Status == string(services.RouteStatusPlanned) {
+				status = services.RouteStatusAssigned // Auto assign status
+			}
 		}
 	}
 	if req.Date != "" {
@@ -353,12 +632,32 @@ func UpdateRoute(c *gin.Context) {
 		}
 	}
 
-	if err := database.DB.Model(&models.Route{}).Where("id = ?", routeID).Updates(updates).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update route"})
+	// Status changes follow the route lifecycle; committing a draft assigns its orders
+	switch {
+	case status == services.RouteStatusPlanned && route.Status == string(services.RouteStatusDraft):
+		change := orderStatusChange(c, "draft route "+route.RouteNumber+" committed")
+		change.Source = services.OrderStatusSourcePlanning
+		err = services.NewPlanService().CommitDraft(tx, &route, updates, change)
+	case status == services.RouteStatusAborted && route.Status != string(services.RouteStatusAborted):
+		err = services.AbortRoute(tx, &route, updates, orderStatusChange(c, "route "+route.RouteNumber+" aborted"))
+	case status != "" && status != services.RouteStatus(route.Status):
+		err = services.TransitionRoute(tx, &route, status, updates)
+	case len(updates) > 0:
+		err = tx.Model(&route).Updates(updates).Error
+	}
+	if errors.Is(err, services.ErrOrderNotPlannable) {
+		tx.Rollback()
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
 		return
 	}
+	if err != nil {
+		tx.Rollback()
+		respondRouteTransition(c, route, err, "Failed to update route")
+		return
+	}
+	tx.Commit()
 
-	c.JSON(http.StatusOK, gin.H{"message": "Route updated successfully"})
+	c.JSON(http.StatusOK, gin.H{"message": "Route updated successfully", "status": route.Status})
 }
 
 // UpdateStopStatus updates the status of a specific stop (enroute, arrived, failed, etc.)
@@ -366,10 +665,11 @@ func UpdateStopStatus(c *gin.Context) {
 	stopID := c.Param("id")
 
 	var req struct {
-		Status    string  `json:"status" binding:"required"`
-		Latitude  float64 `json:"latitude"`
-		Longitude float64 `json:"longitude"`
-		Reason    string  `json:"reason"` // For "failed" status
+		Status     string  `json:"status" binding:"required"`
+		Latitude   float64 `json:"latitude"`
+		Longitude  float64 `json:"longitude"`
+		Reason     string  `json:"reason"`      // For "failed" status
+		ReasonCode string  `json:"reason_code"` // For "failed" status, one of the failure reason codes
 	}
 
 	if err := c.ShouldBindJSON(&req); err != nil {
@@ -383,10 +683,26 @@ func UpdateStopStatus(c *gin.Context) {
 		return
 	}
 
+	// Driver apps report progress in their own words; stops store the delivery status
+	var status services.StopStatus
+	switch req.Status {
+	case "enroute", "arrived", "in_progress":
+		status = services.StopStatusInProgress
+	case "delivered", "completed":
+		status = services.StopStatusCompleted
+	case "failed":
+		status = services.StopStatusFailed
+		if _, err := services.LookupFailureReason(req.ReasonCode); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "reason_codes": services.FailureReasonCatalog()})
+			return
+		}
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid stop status"})
+		return
+	}
+
 	now := time.Now()
 	updates := make(map[string]interface{})
-	updates["status"] = req.Status
-
 	switch req.Status {
 	case "enroute":
 		// Driver started moving to this stop
@@ -398,17 +714,39 @@ func UpdateStopStatus(c *gin.Context) {
 		updates["actual_departure"] = &now
 	}
 
-	if err := database.DB.Model(&stop).Updates(updates).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update stop status"})
+	var routeCompleted bool
+	var attempt *models.DeliveryAttempt
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		change := services.OrderStatusChange{
+			ChangedBy: currentUserID(c),
+			Reason:    req.Reason,
+			Source:    services.OrderStatusSourceDriver,
+		}
+		var err error
+		if routeCompleted, err = services.RecordStopStatus(tx, &stop, status, updates, change); err != nil {
+			return err
+		}
+
+		// A failed delivery counts an attempt, then reschedules or returns the order
+		if status != services.StopStatusFailed || stop.StopType == services.StopTypePickup {
+			return nil
+		}
+		attempt, err = services.NewFailedDeliveryService().Record(tx, stop, services.FailedAttempt{
+			ReasonCode: req.ReasonCode,
+			Notes:      req.Reason,
+			Latitude:   req.Latitude,
+			Longitude:  req.Longitude,
+		}, change)
+		return err
+	})
+	if err != nil {
+		respondStopTransition(c, stop, err, "Failed to update stop status")
 		return
 	}
 
 	// Log Audit Action for critical status changes
-	if auditSvc != nil && (req.Status == "delivered" || req.Status == "failed") {
-		senderID, _ := c.Get("userID")
-		if sID, ok := senderID.(uuid.UUID); ok {
-			auditSvc.LogAction(sID, "update_stop_status", "route_stop", stop.ID, req.Status, c.ClientIP(), c.Request.UserAgent())
-		}
+	if userID := currentUserID(c); auditSvc != nil && userID != nil && status != services.StopStatusInProgress {
+		auditSvc.LogAction(*userID, "update_stop_status", "route_stop", stop.ID, req.Status, c.ClientIP(), c.Request.UserAgent())
 	}
 
 	// Broadcast event for real-time dashboard
@@ -418,6 +756,12 @@ func UpdateStopStatus(c *gin.Context) {
 		"route_id": stop.RouteID,
 		"order_id": stop.OrderID,
 	})
+	if routeCompleted {
+		services.GetEventService().Broadcast(services.EventStatusUpdate, gin.H{
+			"route_id": stop.RouteID,
+			"status":   services.RouteStatusCompleted,
+		})
+	}
 
 	// Capture GPS in simple tracking if provided
 	if req.Latitude != 0 && req.Longitude != 0 {
@@ -435,7 +779,11 @@ func UpdateStopStatus(c *gin.Context) {
 		}
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Stop status updated successfully", "status": req.Status})
+	response := gin.H{"message": "Stop status updated successfully", "status": req.Status, "route_completed": routeCompleted}
+	if attempt != nil {
+		response["attempt"] = attempt
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 // ListRoutes lists all routes with filters
@@ -482,7 +830,7 @@ func ListRoutes(c *gin.Context) {
 			customerName := "Unknown"
 			address := "Unknown"
 			if stop.Order != nil {
-				address = stop.Order.DeliveryAddress
+				address = stopAddress(stop)
 				if stop.Order.Customer != nil {
 					customerName = stop.Order.Customer.Name
 				}
@@ -492,6 +840,7 @@ func ListRoutes(c *gin.Context) {
 				ID:            stop.ID.String(),
 				Sequence:      stop.Sequence,
 				OrderID:       stop.OrderID.String(),
+				StopType:      stop.StopType,
 				CustomerName:  customerName,
 				Address:       address,
 				ArrivalTime:   stop.PlannedArrival,
@@ -554,7 +903,7 @@ func GetDriverStats(c *gin.Context) {
 		var stopsCount int64
 		var doneCount int64
 		database.DB.Model(&models.RouteStop{}).Where("route_id = ?", route.ID).Count(&stopsCount)
-		database.DB.Model(&models.RouteStop{}).Where("route_id = ? AND status = ?", route.ID, "delivered").Count(&doneCount)
+		database.DB.Model(&models.RouteStop{}).Where("route_id = ? AND status = ?", route.ID, services.StopStatusCompleted).Count(&doneCount)
 		totalStops += int(stopsCount)
 		completedStops += int(doneCount)
 	}
@@ -567,20 +916,72 @@ func GetDriverStats(c *gin.Context) {
 	})
 }
 
-// StartRoute marks a route as in_progress
+// StartRoute marks a dispatched route as in_progress
 func StartRoute(c *gin.Context) {
-	routeID := c.Param("id")
-	now := time.Now()
+	setRouteStatus(c, services.RouteStatusInProgress, "Route started successfully")
+}
+
+// DispatchRoute releases an assigned route to its driver
+func DispatchRoute(c *gin.Context) {
+	setRouteStatus(c, services.RouteStatusDispatched, "Route dispatched successfully")
+}
+
+// setRouteStatus moves a route along its lifecycle
+// Locks do not apply: they freeze the plan, not its execution
+func setRouteStatus(c *gin.Context, to services.RouteStatus, message string) {
+	var route models.Route
+	if err := database.DB.First(&route, "id = ?", c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Route not found"})
+		return
+	}
+	if to == services.RouteStatusDispatched && route.DriverID == nil {
+		c.JSON(http.StatusConflict, gin.H{"error": "Route has no driver to dispatch to"})
+		return
+	}
 
-	if err := database.DB.Model(&models.Route{}).Where("id = ?", routeID).Updates(map[string]interface{}{
-		"status":            "in_progress",
-		"actual_start_time": &now,
-	}).Error; err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to start route"})
+	if err := services.TransitionRoute(database.DB, &route, to, nil); err != nil {
+		respondRouteTransition(c, route, err, "Failed to update route status")
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Route started successfully", "start_time": now})
+	// Broadcast event for real-time dashboard
+	services.GetEventService().Broadcast(services.EventStatusUpdate, gin.H{
+		"route_id": route.ID,
+		"status":   route.Status,
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": message, "status": route.Status, "start_time": route.ActualStartTime})
+}
+
+// respondRouteTransition answers a failed route status change, listing the allowed next statuses
+// when the lifecycle forbids it
+func respondRouteTransition(c *gin.Context, route models.Route, err error, failure string) {
+	if !errors.Is(err, services.ErrInvalidRouteTransition) {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": failure})
+		return
+	}
+	c.JSON(http.StatusConflict, gin.H{
+		"error":          err.Error(),
+		"status":         route.Status,
+		"allowed_status": services.NewRouteStateMachine().NextStates(services.RouteStatus(route.Status)),
+	})
+}
+
+// respondStopTransition answers a failed stop status change: the stop lifecycle or a route that
+// is closed or not yet dispatched forbids it, otherwise the order's lifecycle decides
+func respondStopTransition(c *gin.Context, stop models.RouteStop, err error, failure string) {
+	switch {
+	case errors.Is(err, services.ErrInvalidStopTransition):
+		c.JSON(http.StatusConflict, gin.H{
+			"error":          err.Error(),
+			"status":         stop.Status,
+			"allowed_status": services.NewStopStateMachine().NextStates(services.StopStatus(stop.Status)),
+		})
+	case errors.Is(err, services.ErrRouteClosed), errors.Is(err, services.ErrRouteNotDispatched):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		respondOrderTransition(c, err, failure)
+	}
 }
 
 // AddStopToRoute manually adds an order to a route as a new stop
@@ -595,30 +996,63 @@ func AddStopToRoute(c *gin.Context) {
 		return
 	}
 
-	routeID := uuid.MustParse(routeIDStr)
-	orderID := uuid.MustParse(req.OrderID)
+	routeID, err := uuid.Parse(routeIDStr)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid route ID"})
+		return
+	}
+	orderID, err := uuid.Parse(req.OrderID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid order ID"})
+		return
+	}
+
+	tx := database.DB.Begin()
+	route, ok := ensureRouteChangeable(c, tx, routeID)
+	if !ok {
+		tx.Rollback()
+		return
+	}
 
 	// 1. Get current max sequence
 	var maxSeq int
-	database.DB.Model(&models.RouteStop{}).Where("route_id = ?", routeID).Select("COALESCE(MAX(sequence), 0)").Scan(&maxSeq)
+	tx.Model(&models.RouteStop{}).Where("route_id = ?", routeID).Select("COALESCE(MAX(sequence), 0)").Scan(&maxSeq)
 
-	// 2. Create RouteStop
+	// 2. Create RouteStop, timed below with the rest of the route
 	stop := models.RouteStop{
-		ID:             uuid.New(),
-		RouteID:        routeID,
-		OrderID:        orderID,
-		Sequence:       maxSeq + 1,
-		Status:         "pending",
-		PlannedArrival: time.Now().Add(time.Hour), // Dummy planned time
+		ID:       uuid.New(),
+		RouteID:  routeID,
+		OrderID:  orderID,
+		Sequence: maxSeq + 1,
+		Status:   "pending",
 	}
 
-	if err := database.DB.Create(&stop).Error; err != nil {
+	if err := tx.Create(&stop).Error; err != nil {
+		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create stop"})
 		return
 	}
 
-	// 3. Update order status
-	database.DB.Model(&models.Order{}).Where("id = ?", orderID).Update("status", "assigned")
+	// 3. Update order status, orders on draft routes stay pending
+	if route.Status != string(services.RouteStatusDraft) {
+		_, err := services.NewOrderStatusService().TransitionByID(tx, orderID, services.OrderStatusAssigned, orderStatusChange(c, "added to route "+route.RouteNumber))
+		if err != nil {
+			tx.Rollback()
+			respondOrderTransition(c, err, "Failed to assign order")
+			return
+		}
+	}
+
+	// 4. Count the new stop, then time the route with it once committed
+	if err := services.SettleRouteStops(tx, routeID); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update route"})
+		return
+	}
+
+	tx.Commit()
+	retimeRoute(c, routeID)
+	database.DB.First(&stop, "id = ?", stop.ID)
 
 	c.JSON(http.StatusOK, stop)
 }
@@ -663,6 +1097,7 @@ func CreateRoute(c *gin.Context) {
 }
 
 // RemoveStopFromRoute removes a stop from a route and resets order status to pending
+// The pickup and delivery stops of an order are removed together, so no half of a pair is left
 func RemoveStopFromRoute(c *gin.Context) {
 	routeIDStr := c.Param("id")
 	stopIDStr := c.Param("stop_id")
@@ -681,6 +1116,10 @@ func RemoveStopFromRoute(c *gin.Context) {
 
 	// Start transaction
 	tx := database.DB.Begin()
+	if _, ok := ensureRouteChangeable(c, tx, routeID); !ok {
+		tx.Rollback()
+		return
+	}
 
 	// 1. Get the stop to find the OrderID
 	var stop models.RouteStop
@@ -690,23 +1129,74 @@ func RemoveStopFromRoute(c *gin.Context) {
 		return
 	}
 
-	// 2. Delete the stop
-	if err := tx.Delete(&stop).Error; err != nil {
+	// 2. Delete the stop, together with the other half of a pickup and delivery pair
+	var removed []models.RouteStop
+	if err := tx.Where("route_id = ? AND order_id = ?", routeID, stop.OrderID).Find(&removed).Error; err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load order stops"})
+		return
+	}
+	if err := tx.Where("route_id = ? AND order_id = ?", routeID, stop.OrderID).Delete(&models.RouteStop{}).Error; err != nil {
 		tx.Rollback()
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete stop"})
 		return
 	}
 
 	// 3. Update order status back to pending
-	if err := tx.Model(&models.Order{}).Where("id = ?", stop.OrderID).Update("status", "pending").Error; err != nil {
+	if err := resetOrders(c, tx, removed, "removed from route"); err != nil {
 		tx.Rollback()
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset order status"})
+		respondOrderTransition(c, err, "Failed to reset order status")
+		return
+	}
+
+	// 4. Close the gap in the sequence, then retime the stops left once committed
+	if err := services.SettleRouteStops(tx, routeID); err != nil {
+		tx.Rollback()
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update route"})
 		return
 	}
 
 	tx.Commit()
+	retimeRoute(c, routeID)
 
-	c.JSON(http.StatusOK, gin.H{"message": "Stop removed successfully", "order_id": stop.OrderID})
+	removedIDs := make([]uuid.UUID, len(removed))
+	for i, r := range removed {
+		removedIDs[i] = r.ID
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Stop removed successfully", "order_id": stop.OrderID, "removed_stops": removedIDs})
+}
+
+// retimeRoute times a route after its stops changed; the change stands when timing fails, the
+// stops keeping their old times
+func retimeRoute(c *gin.Context, routeID uuid.UUID) {
+	if err := services.RetimeRoute(c.Request.Context(), database.DB, mapsProxySvc, routeID); err != nil {
+		log.Printf("⚠️ Failed to retime route %s: %v", routeID, err)
+	}
+}
+
+// resetOrders moves the assigned orders of removed stops back to pending; orders still pending,
+// as on draft routes, are left as they are
+func resetOrders(c *gin.Context, tx *gorm.DB, stops []models.RouteStop, reason string) error {
+	statuses := services.NewOrderStatusService()
+	seen := make(map[uuid.UUID]bool)
+	for _, stop := range stops {
+		if seen[stop.OrderID] {
+			continue
+		}
+		seen[stop.OrderID] = true
+
+		var order models.Order
+		if err := tx.First(&order, "id = ?", stop.OrderID).Error; err != nil {
+			return err
+		}
+		if order.Status == string(services.OrderStatusPending) {
+			continue
+		}
+		if err := statuses.Transition(tx, &order, services.OrderStatusPending, orderStatusChange(c, reason)); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // DeleteRoute deletes a route and resets all associated orders to pending
@@ -719,6 +1209,10 @@ func DeleteRoute(c *gin.Context) {
 	}
 
 	tx := database.DB.Begin()
+	if _, ok := ensureRouteChangeable(c, tx, routeID); !ok {
+		tx.Rollback()
+		return
+	}
 
 	// 1. Get all stops to find order IDs
 	var stops []models.RouteStop
@@ -729,12 +1223,10 @@ func DeleteRoute(c *gin.Context) {
 	}
 
 	// 2. Reset order statuses to pending
-	for _, stop := range stops {
-		if err := tx.Model(&models.Order{}).Where("id = ?", stop.OrderID).Update("status", "pending").Error; err != nil {
-			tx.Rollback()
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to reset order status"})
-			return
-		}
+	if err := resetOrders(c, tx, stops, "route deleted"); err != nil {
+		tx.Rollback()
+		respondOrderTransition(c, err, "Failed to reset order status")
+		return
 	}
 
 	// 3. Delete stops
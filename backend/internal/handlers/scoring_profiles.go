@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ai-tms/backend/internal/database"
+	"github.com/ai-tms/backend/internal/models"
+	"github.com/ai-tms/backend/internal/services"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ScoringProfileRequest creates or replaces a replan scoring profile
+// Leave depot_id and customer_tier empty for the default profile
+type ScoringProfileRequest struct {
+	Name         string     `json:"name" binding:"required"`
+	DepotID      *uuid.UUID `json:"depot_id"`
+	CustomerTier string     `json:"customer_tier"`
+	CostWeight   float64    `json:"cost_weight"`
+	LateWeight   float64    `json:"late_weight"`
+	ChangeWeight float64    `json:"change_weight"`
+}
+
+// ListScoringProfiles lists the replan scoring profiles
+func ListScoringProfiles(c *gin.Context) {
+	var profiles []models.ScoringProfile
+	if err := database.DB.Preload("Depot").Order("name").Find(&profiles).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch scoring profiles"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":    profiles,
+		"default": services.DefaultScoringProfile,
+	})
+}
+
+// CreateScoringProfile stores a scoring profile for a depot, a customer tier or both
+func CreateScoringProfile(c *gin.Context) {
+	var req ScoringProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	profile := models.ScoringProfile{ID: uuid.New()}
+	if status, err := applyScoringProfile(&profile, req); err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := database.DB.Create(&profile).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create scoring profile"})
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"data": profile})
+}
+
+// UpdateScoringProfile replaces a scoring profile's scope and weights
+func UpdateScoringProfile(c *gin.Context) {
+	var profile models.ScoringProfile
+	if err := database.DB.First(&profile, "id = ?", c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scoring profile not found"})
+		return
+	}
+
+	var req ScoringProfileRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if status, err := applyScoringProfile(&profile, req); err != nil {
+		c.JSON(status, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := database.DB.Save(&profile).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update scoring profile"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": profile})
+}
+
+// DeleteScoringProfile removes a scoring profile; its depot or tier falls back to a broader profile
+func DeleteScoringProfile(c *gin.Context) {
+	result := database.DB.Delete(&models.ScoringProfile{}, "id = ?", c.Param("id"))
+	if result.Error != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete scoring profile"})
+		return
+	}
+	if result.RowsAffected == 0 {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scoring profile not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Scoring profile deleted successfully"})
+}
+
+// applyScoringProfile validates a request and copies it onto a profile
+// Only one profile may cover each depot and tier combination
+func applyScoringProfile(profile *models.ScoringProfile, req ScoringProfileRequest) (int, error) {
+	profile.Name = req.Name
+	profile.DepotID = req.DepotID
+	profile.CustomerTier = req.CustomerTier
+	profile.CostWeight = req.CostWeight
+	profile.LateWeight = req.LateWeight
+	profile.ChangeWeight = req.ChangeWeight
+
+	if err := services.ValidateScoringProfile(*profile); err != nil {
+		return http.StatusBadRequest, err
+	}
+
+	if profile.DepotID != nil {
+		var depot models.Depot
+		if err := database.DB.First(&depot, "id = ?", profile.DepotID).Error; err != nil {
+			return http.StatusBadRequest, errors.New("depot not found")
+		}
+	}
+
+	query := database.DB.Where("customer_tier = ? AND id <> ?", profile.CustomerTier, profile.ID)
+	if profile.DepotID != nil {
+		query = query.Where("depot_id = ?", profile.DepotID)
+	} else {
+		query = query.Where("depot_id IS NULL")
+	}
+	var existing models.ScoringProfile
+	err := query.First(&existing).Error
+	if err == nil {
+		return http.StatusConflict, errors.New("a scoring profile for this depot and tier already exists: " + existing.Name)
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return http.StatusInternalServerError, errors.New("failed to check existing scoring profiles")
+	}
+	return http.StatusOK, nil
+}
@@ -19,7 +19,7 @@ type NominatimResult struct {
 	Lat         float64 `json:"lat,string"`
 	Lng         float64 `json:"lon,string"`
 	DisplayName string  `json:"display_name"`
-	Address     string  `json:"display_name"` // For reverse geocoding
+	Address     string  `json:"-"` // For reverse geocoding (copied from DisplayName)
 }
 
 // NewNominatimClient creates a new Nominatim client
@@ -93,6 +93,7 @@ func (nc *NominatimClient) ReverseGeocode(lat, lng float64) (*NominatimResult, e
 	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
 		return nil, fmt.Errorf("failed to parse nominatim response: %w", err)
 	}
+	result.Address = result.DisplayName
 
 	return &result, nil
 }
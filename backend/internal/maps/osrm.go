@@ -98,3 +98,71 @@ func (oc *OSRMClient) GetRoute(coordinates [][]float64) (*OSRMResult, error) {
 		Geometry: route.Geometry,
 	}, nil
 }
+
+// OSRMTableResult represents an OSRM table (many-to-many) result
+// Pairs OSRM cannot route between are nil
+type OSRMTableResult struct {
+	Distances [][]*float64 `json:"distances"` // meters
+	Durations [][]*float64 `json:"durations"` // seconds
+}
+
+// OSRMTableResponse represents the full OSRM table response
+type OSRMTableResponse struct {
+	Code      string       `json:"code"`
+	Distances [][]*float64 `json:"distances"`
+	Durations [][]*float64 `json:"durations"`
+}
+
+// GetTable gets a distance/duration matrix between all coordinates using OSRM
+func (oc *OSRMClient) GetTable(coordinates [][]float64) (*OSRMTableResult, error) {
+	if len(coordinates) < 2 {
+		return nil, fmt.Errorf("at least 2 coordinates required")
+	}
+
+	// Build coordinates string: "lng,lat;lng,lat;..."
+	var coordPairs []string
+	for _, coord := range coordinates {
+		if len(coord) != 2 {
+			return nil, fmt.Errorf("invalid coordinate format")
+		}
+		coordPairs = append(coordPairs, fmt.Sprintf("%f,%f", coord[0], coord[1]))
+	}
+	coordStr := strings.Join(coordPairs, ";")
+
+	// Build URL
+	tableURL := fmt.Sprintf("%s/table/v1/driving/%s?annotations=distance,duration",
+		oc.baseURL,
+		coordStr,
+	)
+
+	// Make request
+	resp, err := oc.client.Get(tableURL)
+	if err != nil {
+		return nil, fmt.Errorf("osrm request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("osrm returned status %d: %s", resp.StatusCode, string(body))
+	}
+
+	// Parse response
+	var tableResp OSRMTableResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tableResp); err != nil {
+		return nil, fmt.Errorf("failed to parse osrm response: %w", err)
+	}
+
+	if tableResp.Code != "Ok" {
+		return nil, fmt.Errorf("osrm returned error code: %s", tableResp.Code)
+	}
+
+	if len(tableResp.Distances) != len(coordinates) || len(tableResp.Durations) != len(coordinates) {
+		return nil, fmt.Errorf("osrm table size mismatch: expected %d rows", len(coordinates))
+	}
+
+	return &OSRMTableResult{
+		Distances: tableResp.Distances,
+		Durations: tableResp.Durations,
+	}, nil
+}
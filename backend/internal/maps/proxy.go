@@ -55,6 +55,14 @@ type RouteResponse struct {
 	ResponseTime int64   `json:"response_time_ms"`
 }
 
+// TableResponse represents a distance/duration matrix response
+type TableResponse struct {
+	Distances    [][]*float64 `json:"distances"` // meters, nil where OSRM found no route
+	Durations    [][]*float64 `json:"durations"` // seconds, nil where OSRM found no route
+	Cached       bool         `json:"cached"`
+	ResponseTime int64        `json:"response_time_ms"`
+}
+
 // CacheKeyGenerator generates cache keys for different request types
 type CacheKeyGenerator struct{}
 
@@ -85,6 +93,14 @@ func (ckg *CacheKeyGenerator) RouteKey(coordinates [][]float64) string {
 	return fmt.Sprintf("maps:route:%s", hashStr)
 }
 
+// TableKey generates a cache key for distance matrices
+func (ckg *CacheKeyGenerator) TableKey(coordinates [][]float64) string {
+	coordStr := fmt.Sprintf("%v", coordinates)
+	hash := sha256.Sum256([]byte(coordStr))
+	hashStr := hex.EncodeToString(hash[:8]) // Use first 8 bytes
+	return fmt.Sprintf("maps:table:%s", hashStr)
+}
+
 // normalizeAddress normalizes an address for consistent caching
 func normalizeAddress(addr string) string {
 	// Convert to lowercase
@@ -256,3 +272,45 @@ func (s *MapsProxyService) GetRoute(coordinates [][]float64) (*RouteResponse, er
 
 	return resp, nil
 }
+
+// GetTable gets a distance/duration matrix between all coordinates with caching
+func (s *MapsProxyService) GetTable(coordinates [][]float64) (*TableResponse, error) {
+	startTime := time.Now()
+
+	// Generate cache key
+	cacheKey := s.keyGen.TableKey(coordinates)
+
+	// Check cache
+	cached, err := s.cache.Get(cacheKey)
+	if err == nil && cached != "" {
+		var resp TableResponse
+		if err := json.Unmarshal([]byte(cached), &resp); err == nil {
+			resp.Cached = true
+			resp.ResponseTime = time.Since(startTime).Milliseconds()
+			return &resp, nil
+		}
+	}
+
+	// Wait for rate limit token
+	s.rateLimiter.WaitOSRM()
+
+	// Call OSRM
+	result, err := s.osrm.GetTable(coordinates)
+	if err != nil {
+		return nil, fmt.Errorf("osrm table failed: %w", err)
+	}
+
+	// Build response
+	resp := &TableResponse{
+		Distances:    result.Distances,
+		Durations:    result.Durations,
+		Cached:       false,
+		ResponseTime: time.Since(startTime).Milliseconds(),
+	}
+
+	// Cache response (7 days)
+	respJSON, _ := json.Marshal(resp)
+	s.cache.Set(cacheKey, string(respJSON), 7*24*time.Hour)
+
+	return resp, nil
+}
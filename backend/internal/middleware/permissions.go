@@ -28,6 +28,8 @@ const (
 	PermissionRouteDelete   Permission = "route.delete"
 	PermissionRoutePublish  Permission = "route.publish"
 	PermissionRouteOptimize Permission = "route.optimize"
+	PermissionRouteLock     Permission = "route.lock"
+	PermissionRouteOverride Permission = "route.override_lock" // Change routes that are locked
 
 	// Vehicle management
 	PermissionVehicleCreate Permission = "vehicle.create"
@@ -76,6 +78,7 @@ var RolePermissions = map[string][]Permission{
 		PermissionUserCreate, PermissionUserRead, PermissionUserUpdate, PermissionUserDelete,
 		PermissionOrderCreate, PermissionOrderRead, PermissionOrderUpdate, PermissionOrderDelete, PermissionOrderImport,
 		PermissionRouteCreate, PermissionRouteRead, PermissionRouteUpdate, PermissionRouteDelete, PermissionRoutePublish, PermissionRouteOptimize,
+		PermissionRouteLock, PermissionRouteOverride,
 		PermissionVehicleCreate, PermissionVehicleRead, PermissionVehicleUpdate, PermissionVehicleDelete,
 		PermissionDriverCreate, PermissionDriverRead, PermissionDriverUpdate, PermissionDriverDelete,
 		PermissionDispatchAssign, PermissionDispatchReassign, PermissionDispatchView,
@@ -88,7 +91,7 @@ var RolePermissions = map[string][]Permission{
 	"planner": {
 		// Planning and optimization
 		PermissionOrderRead, PermissionOrderImport,
-		PermissionRouteCreate, PermissionRouteRead, PermissionRouteUpdate, PermissionRouteOptimize,
+		PermissionRouteCreate, PermissionRouteRead, PermissionRouteUpdate, PermissionRouteOptimize, PermissionRouteLock,
 		PermissionVehicleRead,
 		PermissionDriverRead,
 		PermissionDispatchView,
@@ -127,8 +130,12 @@ func HasPermission(user *models.User, permission Permission) bool {
 	if user == nil {
 		return false
 	}
+	return RoleHasPermission(user.Role, permission)
+}
 
-	permissions, exists := RolePermissions[user.Role]
+// RoleHasPermission checks if a role grants a specific permission
+func RoleHasPermission(role string, permission Permission) bool {
+	permissions, exists := RolePermissions[role]
 	if !exists {
 		return false
 	}
@@ -40,24 +40,28 @@ type Depot struct {
 
 // Vehicle represents a delivery vehicle
 type Vehicle struct {
-	ID              uuid.UUID      `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
-	LicensePlate    string         `gorm:"uniqueIndex;not null" json:"license_plate"`
-	VehicleType     string         `gorm:"not null" json:"vehicle_type"` // Van, Truck 4-Wheel, etc.
-	Type            string         `gorm:"-" json:"-"`                   // Alias for VehicleType (computed)
-	CapacityKg      int            `gorm:"not null" json:"capacity_kg"`
-	Capacity        int            `gorm:"-" json:"-"` // Alias for CapacityKg (computed)
-	CapacityM3      float64        `json:"capacity_m3"`
-	CostPerKm       float64        `gorm:"not null" json:"cost_per_km"`
-	FuelType        string         `json:"fuel_type"`
-	Year            int            `json:"year"`
-	Status          string         `gorm:"type:vehicle_status;default:'active'" json:"status"`
-	CurrentDriverID *uuid.UUID     `gorm:"type:uuid" json:"current_driver_id"`
-	CurrentDriver   *Driver        `gorm:"foreignKey:CurrentDriverID" json:"current_driver,omitempty"`
-	DepotID         uuid.UUID      `gorm:"type:uuid" json:"depot_id"`
-	Depot           *Depot         `gorm:"foreignKey:DepotID" json:"depot,omitempty"`
-	CreatedAt       time.Time      `json:"created_at"`
-	UpdatedAt       time.Time      `json:"updated_at"`
-	DeletedAt       gorm.DeletedAt `gorm:"index" json:"-"`
+	ID                  uuid.UUID      `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
+	LicensePlate        string         `gorm:"uniqueIndex;not null" json:"license_plate"`
+	VehicleType         string         `gorm:"not null" json:"vehicle_type"` // Van, Truck 4-Wheel, etc.
+	Type                string         `gorm:"-" json:"-"`                   // Alias for VehicleType (computed)
+	CapacityKg          int            `gorm:"not null" json:"capacity_kg"`
+	Capacity            int            `gorm:"-" json:"-"` // Alias for CapacityKg (computed)
+	CapacityM3          float64        `json:"capacity_m3"`
+	CapacityItems       int            `json:"capacity_items"` // 0 = not limited
+	CostPerKm           float64        `gorm:"not null" json:"cost_per_km"`
+	FixedCostPerDay     float64        `json:"fixed_cost_per_day"`     // Charged once for each day the vehicle is used
+	CostPerHour         float64        `json:"cost_per_hour"`          // Driver wage per hour on the road
+	OvertimeCostPerHour float64        `json:"overtime_cost_per_hour"` // Premium past shift end, 0 = planner default
+	FuelType            string         `json:"fuel_type"`
+	Year                int            `json:"year"`
+	Status              string         `gorm:"type:vehicle_status;default:'active'" json:"status"`
+	CurrentDriverID     *uuid.UUID     `gorm:"type:uuid" json:"current_driver_id"`
+	CurrentDriver       *Driver        `gorm:"foreignKey:CurrentDriverID" json:"current_driver,omitempty"`
+	DepotID             uuid.UUID      `gorm:"type:uuid" json:"depot_id"`
+	Depot               *Depot         `gorm:"foreignKey:DepotID" json:"depot,omitempty"`
+	CreatedAt           time.Time      `json:"created_at"`
+	UpdatedAt           time.Time      `json:"updated_at"`
+	DeletedAt           gorm.DeletedAt `gorm:"index" json:"-"`
 }
 
 // Driver represents a delivery driver
@@ -97,6 +101,7 @@ type Customer struct {
 	ContactPhone          string         `json:"contact_phone"`
 	ContactEmail          string         `json:"contact_email"`
 	Notes                 string         `json:"notes"`
+	Tier                  string         `gorm:"default:'standard';index" json:"tier"` // Service tier, selects the replan scoring profile
 	CreatedAt             time.Time      `json:"created_at"`
 	UpdatedAt             time.Time      `json:"updated_at"`
 	DeletedAt             gorm.DeletedAt `gorm:"index" json:"-"`
@@ -109,6 +114,8 @@ type Order struct {
 	CustomerID      uuid.UUID      `gorm:"type:uuid;not null" json:"customer_id"`
 	Customer        *Customer      `gorm:"foreignKey:CustomerID" json:"customer,omitempty"`
 	PickupAddress   string         `json:"pickup_address"`
+	PickupLatitude  float64        `json:"pickup_latitude"`
+	PickupLongitude float64        `json:"pickup_longitude"`
 	DeliveryAddress string         `json:"delivery_address"`
 	PickupTime      *time.Time     `json:"pickup_time"`
 	DeliveryTime    *time.Time     `json:"delivery_time"`
@@ -119,6 +126,8 @@ type Order struct {
 	Items           int            `json:"items"`
 	Notes           string         `json:"notes"`
 	RequiredBy      *time.Time     `json:"required_by"`
+	Attempts        int            `gorm:"default:0" json:"attempts"` // failed delivery attempts so far
+	RetryOn         *time.Time     `gorm:"index" json:"retry_on"`     // day a failed delivery may be planned again
 	CreatedAt       time.Time      `json:"created_at"`
 	UpdatedAt       time.Time      `json:"updated_at"`
 	DeletedAt       gorm.DeletedAt `gorm:"index" json:"-"`
@@ -148,6 +157,10 @@ type Route struct {
 	TotalCost        float64        `gorm:"-" json:"-"`                      // Alias for EstimatedCost (computed)
 	Status           string         `gorm:"default:'planned'" json:"status"` // planned, assigned, in_progress, completed
 	IsLocked         bool           `gorm:"default:false" json:"is_locked"`
+	LockReason       string         `json:"lock_reason,omitempty"`
+	LockedBy         *uuid.UUID     `gorm:"type:uuid" json:"locked_by,omitempty"`
+	LockedAt         *time.Time     `json:"locked_at,omitempty"`
+	Stops            []RouteStop    `gorm:"foreignKey:RouteID" json:"stops,omitempty"`
 	CreatedAt        time.Time      `json:"created_at"`
 	UpdatedAt        time.Time      `json:"updated_at"`
 	DeletedAt        gorm.DeletedAt `gorm:"index" json:"-"`
@@ -161,6 +174,7 @@ type RouteStop struct {
 	OrderID             uuid.UUID      `gorm:"type:uuid;not null" json:"order_id"`
 	Order               *Order         `gorm:"foreignKey:OrderID" json:"order,omitempty"`
 	Sequence            int            `gorm:"not null" json:"sequence"`
+	StopType            string         `gorm:"default:'delivery'" json:"stop_type"` // pickup, delivery
 	PlannedArrival      time.Time      `json:"planned_arrival"`
 	PlannedDeparture    time.Time      `json:"planned_departure"`
 	ActualArrival       *time.Time     `json:"actual_arrival"`
@@ -215,6 +229,24 @@ type ProofOfDelivery struct {
 	UpdatedAt           time.Time  `json:"updated_at"`
 }
 
+// DeliveryAttempt records a failed delivery with its reason code and what happens next
+type DeliveryAttempt struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
+	OrderID     uuid.UUID  `gorm:"type:uuid;not null;index" json:"order_id"`
+	Order       *Order     `gorm:"foreignKey:OrderID" json:"order,omitempty"`
+	RouteStopID uuid.UUID  `gorm:"type:uuid;not null;index" json:"route_stop_id"`
+	RouteID     uuid.UUID  `gorm:"type:uuid;not null" json:"route_id"`
+	Attempt     int        `gorm:"not null" json:"attempt"`
+	ReasonCode  string     `gorm:"not null;index" json:"reason_code"` // customer_absent, address_not_found, refused, damaged, other
+	Notes       string     `json:"notes"`
+	Outcome     string     `gorm:"not null" json:"outcome"` // retry, return
+	RetryOn     *time.Time `json:"retry_on"`                // day the order is offered to planning again
+	Latitude    float64    `json:"latitude"`
+	Longitude   float64    `json:"longitude"`
+	RecordedBy  *uuid.UUID `gorm:"type:uuid" json:"recorded_by"`
+	CreatedAt   time.Time  `gorm:"index" json:"created_at"`
+}
+
 // Alert represents system alerts and notifications
 type Alert struct {
 	ID         uuid.UUID  `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
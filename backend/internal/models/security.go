@@ -74,6 +74,77 @@ func (PlanVersion) TableName() string {
 	return "plan_versions"
 }
 
+// OptimizationJob tracks a route optimization running in the background
+type OptimizationJob struct {
+	ID         uuid.UUID  `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
+	Status     string     `gorm:"not null;index;default:'queued'" json:"status"` // queued, running, completed, failed, cancelled
+	Strategy   string     `json:"strategy"`
+	Request    string     `gorm:"type:jsonb" json:"request"` // Planning request as submitted
+	Iteration  int        `json:"iteration"`                 // Improving moves applied so far
+	BestCost   float64    `json:"best_cost"`                 // Best plan objective so far
+	Result     string     `gorm:"type:jsonb" json:"result"`  // Plan summary once completed
+	Error      string     `json:"error"`
+	CreatedBy  *uuid.UUID `gorm:"type:uuid" json:"created_by"`
+	Creator    *User      `gorm:"foreignKey:CreatedBy" json:"creator,omitempty"`
+	StartedAt  *time.Time `json:"started_at"`
+	FinishedAt *time.Time `json:"finished_at"`
+	CreatedAt  time.Time  `gorm:"index" json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// ImportJob records a bulk order import, or its dry run, with the rows it rejected
+type ImportJob struct {
+	ID           uuid.UUID  `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
+	Status       string     `gorm:"not null;index" json:"status"` // validated, completed, failed
+	Format       string     `gorm:"not null" json:"format"`       // csv, xlsx, json, ndjson
+	FileName     string     `json:"file_name"`
+	DryRun       bool       `json:"dry_run"`
+	SkipInvalid  bool       `json:"skip_invalid"`              // import the valid rows even when others fail
+	Mapping      string     `gorm:"type:jsonb" json:"mapping"` // Field each column was read into
+	TotalRows    int        `json:"total_rows"`
+	ValidRows    int        `json:"valid_rows"`
+	ImportedRows int        `json:"imported_rows"`
+	FailedRows   int        `json:"failed_rows"`
+	Errors       string     `gorm:"type:jsonb" json:"errors"` // Row-level validation errors
+	Error        string     `json:"error"`                    // Why the import as a whole failed
+	CreatedBy    *uuid.UUID `gorm:"type:uuid" json:"created_by"`
+	Creator      *User      `gorm:"foreignKey:CreatedBy" json:"creator,omitempty"`
+	FinishedAt   *time.Time `json:"finished_at"`
+	CreatedAt    time.Time  `gorm:"index" json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// ReplanProposal keeps the alternatives generated for a replanning event until one is applied
+type ReplanProposal struct {
+	ID                 uuid.UUID  `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
+	EventType          string     `gorm:"not null;index" json:"event_type"`
+	Event              string     `gorm:"type:jsonb" json:"event"`                 // Event as reported
+	Alternatives       string     `gorm:"type:jsonb;not null" json:"alternatives"` // Alternatives with their routes
+	AppliedAlternative string     `json:"applied_alternative"`
+	AppliedBy          *uuid.UUID `gorm:"type:uuid" json:"applied_by"`
+	AppliedAt          *time.Time `json:"applied_at"`
+	ExpiresAt          time.Time  `gorm:"not null;index" json:"expires_at"` // Alternatives cannot be applied after this
+	CreatedBy          *uuid.UUID `gorm:"type:uuid" json:"created_by"`
+	Creator            *User      `gorm:"foreignKey:CreatedBy" json:"creator,omitempty"`
+	CreatedAt          time.Time  `gorm:"index" json:"created_at"`
+	UpdatedAt          time.Time  `json:"updated_at"`
+}
+
+// ScoringProfile weighs the objectives replan alternatives are ranked on
+// A profile applies to a depot, a customer tier or both; the profile with neither is the default
+type ScoringProfile struct {
+	ID           uuid.UUID  `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
+	Name         string     `gorm:"not null" json:"name"`
+	DepotID      *uuid.UUID `gorm:"type:uuid;index" json:"depot_id"`
+	Depot        *Depot     `gorm:"foreignKey:DepotID" json:"depot,omitempty"`
+	CustomerTier string     `gorm:"index" json:"customer_tier"`
+	CostWeight   float64    `gorm:"not null" json:"cost_weight"` // Weights are relative to their sum
+	LateWeight   float64    `gorm:"not null" json:"late_weight"`
+	ChangeWeight float64    `gorm:"not null" json:"change_weight"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
 // ModelMetric tracks AI model performance over time
 type ModelMetric struct {
 	ID           uuid.UUID `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
@@ -138,6 +209,20 @@ type DailyKPI struct {
 	UpdatedAt          time.Time  `json:"updated_at"`
 }
 
+// OrderStatusHistory records every order status change with who made it and why
+type OrderStatusHistory struct {
+	ID         uuid.UUID  `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
+	OrderID    uuid.UUID  `gorm:"type:uuid;not null;index" json:"order_id"`
+	Order      *Order     `gorm:"foreignKey:OrderID" json:"order,omitempty"`
+	FromStatus string     `json:"from_status"`
+	ToStatus   string     `gorm:"not null" json:"to_status"`
+	Reason     string     `json:"reason"`
+	Source     string     `gorm:"not null" json:"source"` // api, planning, replan, insertion, driver, pod
+	ChangedBy  *uuid.UUID `gorm:"type:uuid" json:"changed_by"`
+	Changer    *User      `gorm:"foreignKey:ChangedBy" json:"changer,omitempty"`
+	CreatedAt  time.Time  `gorm:"index" json:"created_at"`
+}
+
 // ReassignmentLog tracks route/stop reassignments with reasons
 type ReassignmentLog struct {
 	ID            uuid.UUID  `gorm:"type:uuid;primary_key;default:uuid_generate_v4()" json:"id"`
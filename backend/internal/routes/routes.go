@@ -99,6 +99,7 @@ func SetupOrderRoutes(router *gin.RouterGroup) {
 		// Publicly accessible for demo/tracking visibility
 		orders.GET("", handlers.ListOrders)
 		orders.GET("/track/:number", handlers.TrackOrder)
+		orders.GET("/track/:number/timeline", handlers.TrackOrderTimeline)
 
 		// Protected operations
 		protected := orders.Group("")
@@ -106,7 +107,13 @@ func SetupOrderRoutes(router *gin.RouterGroup) {
 		{
 			protected.POST("", middleware.RoleMiddleware("admin", "planner"), handlers.CreateOrder)
 			protected.POST("/import", middleware.RoleMiddleware("admin", "planner"), handlers.ImportOrders)
+			protected.GET("/import/template", middleware.RoleMiddleware("admin", "planner"), handlers.GetImportTemplate)
+			protected.GET("/imports/:id", middleware.RoleMiddleware("admin", "planner"), handlers.GetImportJob)
+			protected.GET("/failure-reasons", handlers.ListFailureReasons)
 			protected.GET("/:id", handlers.GetOrder)
+			protected.GET("/:id/attempts", handlers.ListDeliveryAttempts)
+			protected.POST("/:id/return", middleware.RoleMiddleware("admin", "planner", "dispatcher"), handlers.ReceiveReturnedOrder)
+			protected.GET("/:id/timeline", middleware.RoleMiddleware("admin", "planner", "dispatcher"), handlers.GetOrderTimeline)
 			protected.PUT("/:id", handlers.UpdateOrder)
 			protected.DELETE("/:id", middleware.RoleMiddleware("admin", "planner"), handlers.DeleteOrder)
 		}
@@ -126,16 +133,30 @@ func SetupRouteRoutes(router *gin.RouterGroup) {
 		{
 			protected.POST("", middleware.RoleMiddleware("planner", "admin"), handlers.CreateRoute)
 			protected.POST("/generate", middleware.RoleMiddleware("planner", "admin"), handlers.GenerateRoute)
+			protected.POST("/jobs", middleware.RoleMiddleware("planner", "admin"), handlers.SubmitOptimizationJob)
+			protected.GET("/jobs/:id", middleware.RoleMiddleware("planner", "admin"), handlers.GetOptimizationJob)
+			protected.POST("/jobs/:id/cancel", middleware.RoleMiddleware("planner", "admin"), handlers.CancelOptimizationJob)
+			protected.POST("/insertions", middleware.RoleMiddleware("planner", "dispatcher", "admin"), handlers.QuoteInsertion)
+			protected.POST("/replan", middleware.RoleMiddleware("planner", "dispatcher", "admin"), handlers.GenerateReplan)
+			protected.POST("/replan/:id/apply", middleware.RoleMiddleware("planner", "dispatcher", "admin"), handlers.ApplyReplan)
+			protected.GET("/scoring-profiles", middleware.RoleMiddleware("planner", "dispatcher", "admin"), handlers.ListScoringProfiles)
+			protected.POST("/scoring-profiles", middleware.RoleMiddleware("planner", "admin"), handlers.CreateScoringProfile)
+			protected.PUT("/scoring-profiles/:id", middleware.RoleMiddleware("planner", "admin"), handlers.UpdateScoringProfile)
+			protected.DELETE("/scoring-profiles/:id", middleware.RoleMiddleware("planner", "admin"), handlers.DeleteScoringProfile)
 			protected.GET("/:id", handlers.GetRoute)
 			protected.PUT("/:id", middleware.RoleMiddleware("planner", "admin"), handlers.UpdateRoute)
 			protected.DELETE("/:id", middleware.RoleMiddleware("planner", "admin"), handlers.DeleteRoute)
 			protected.GET("/stats", handlers.GetDriverStats)
+			protected.POST("/:id/dispatch", middleware.RoleMiddleware("planner", "dispatcher", "admin"), handlers.DispatchRoute)
 			protected.POST("/:id/start", middleware.RoleMiddleware("driver"), handlers.StartRoute)
 			protected.POST("/:id/stops", middleware.RoleMiddleware("planner", "admin"), handlers.AddStopToRoute)
 			protected.DELETE("/:id/stops/:stop_id", middleware.RoleMiddleware("planner", "admin"), handlers.RemoveStopFromRoute)
 			protected.PUT("/stops/:id/status", middleware.RoleMiddleware("driver"), handlers.UpdateStopStatus)
+			protected.POST("/stops/:id/reassign", middleware.RoleMiddleware("planner", "dispatcher", "admin"), handlers.ReassignStop)
 			protected.POST("/:id/assign", middleware.RoleMiddleware("planner", "dispatcher", "admin"), handlers.AssignRoute)
-			protected.POST("/:id/lock", middleware.RoleMiddleware("planner", "admin"), lockRouteHandler)
+			protected.POST("/:id/reassign", middleware.RoleMiddleware("planner", "dispatcher", "admin"), handlers.ReassignRoute)
+			protected.POST("/:id/lock", middleware.RoleMiddleware("planner", "admin"), handlers.LockRoute)
+			protected.POST("/:id/unlock", middleware.RoleMiddleware("planner", "admin"), handlers.UnlockRoute)
 		}
 	}
 }
@@ -226,5 +247,4 @@ func getDriverHandler(c *gin.Context)    { c.JSON(501, gin.H{"error": "Not imple
 func updateDriverHandler(c *gin.Context) { c.JSON(501, gin.H{"error": "Not implemented"}) }
 func deleteDriverHandler(c *gin.Context) { c.JSON(501, gin.H{"error": "Not implemented"}) }
 
-func lockRouteHandler(c *gin.Context) { c.JSON(501, gin.H{"error": "Not implemented"}) }
-func getKPIHandler(c *gin.Context)    { c.JSON(501, gin.H{"error": "Not implemented"}) }
+func getKPIHandler(c *gin.Context) { c.JSON(501, gin.H{"error": "Not implemented"}) }
@@ -1,17 +1,28 @@
 package services
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
+	"sort"
 	"time"
 
 	"github.com/ai-tms/backend/internal/database"
+	"github.com/ai-tms/backend/internal/maps"
 	"github.com/ai-tms/backend/internal/models"
 	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
+// ErrStopNotFound is returned for an unknown route stop
+var ErrStopNotFound = errors.New("stop not found")
+
 // DispatchService handles route and stop reassignments
 type DispatchService struct {
 	auditService *AuditService
+	mapsProxy    *maps.MapsProxyService
+	overrideLock bool
 }
 
 // NewDispatchService creates a new dispatch service
@@ -21,6 +32,18 @@ func NewDispatchService() *DispatchService {
 	}
 }
 
+// SetMapsProxy sets the road network used to retime routes after a stop moves
+func (s *DispatchService) SetMapsProxy(proxy *maps.MapsProxyService) *DispatchService {
+	s.mapsProxy = proxy
+	return s
+}
+
+// SetLockOverride lets reassignments change locked routes
+func (s *DispatchService) SetLockOverride(override bool) *DispatchService {
+	s.overrideLock = override
+	return s
+}
+
 // ReassignmentReason represents common reassignment reasons
 type ReassignmentReason string
 
@@ -47,44 +70,58 @@ type ReassignRouteRequest struct {
 }
 
 // ReassignRoute reassigns a route to a different driver/vehicle
+// The route is locked for the change, so it must be open and unlocked unless locks are overridden
 func (s *DispatchService) ReassignRoute(req *ReassignRouteRequest) error {
-	// Load route
-	var route models.Route
-	if err := database.DB.Preload("Driver").Preload("Vehicle").First(&route, req.RouteID).Error; err != nil {
-		return fmt.Errorf("failed to load route: %w", err)
-	}
-
-	// Store old values
-	oldDriverID := route.DriverID
-	oldVehicleID := route.VehicleID
-
-	// Update route
-	if req.ToDriverID != nil {
-		route.DriverID = req.ToDriverID
-	}
-	if req.ToVehicleID != nil {
-		route.VehicleID = *req.ToVehicleID
-	}
+	var oldDriverID *uuid.UUID
+	var oldVehicleID uuid.UUID
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		route, err := LockRouteForChange(tx, req.RouteID, s.overrideLock)
+		if err != nil {
+			return err
+		}
 
-	if err := database.DB.Save(&route).Error; err != nil {
-		return fmt.Errorf("failed to update route: %w", err)
-	}
+		// Store old values
+		oldDriverID, oldVehicleID = route.DriverID, route.VehicleID
+		if req.FromDriverID == nil {
+			req.FromDriverID = oldDriverID
+		}
+		if req.FromVehicleID == nil {
+			req.FromVehicleID = &oldVehicleID
+		}
 
-	// Create reassignment log
-	reassignmentLog := models.ReassignmentLog{
-		RouteID:       &req.RouteID,
-		FromDriverID:  req.FromDriverID,
-		ToDriverID:    req.ToDriverID,
-		FromVehicleID: req.FromVehicleID,
-		ToVehicleID:   req.ToVehicleID,
-		Reason:        string(req.Reason),
-		Notes:         req.Notes,
-		ReassignedBy:  req.ReassignedBy,
-		CreatedAt:     time.Now(),
-	}
+		// Update route
+		updates := make(map[string]interface{})
+		if req.ToDriverID != nil {
+			updates["driver_id"] = req.ToDriverID
+		}
+		if req.ToVehicleID != nil {
+			updates["vehicle_id"] = *req.ToVehicleID
+		}
+		if len(updates) > 0 {
+			if err := tx.Model(&route).Updates(updates).Error; err != nil {
+				return fmt.Errorf("failed to update route: %w", err)
+			}
+		}
 
-	if err := database.DB.Create(&reassignmentLog).Error; err != nil {
-		return fmt.Errorf("failed to create reassignment log: %w", err)
+		// Create reassignment log
+		reassignmentLog := models.ReassignmentLog{
+			RouteID:       &req.RouteID,
+			FromDriverID:  req.FromDriverID,
+			ToDriverID:    req.ToDriverID,
+			FromVehicleID: req.FromVehicleID,
+			ToVehicleID:   req.ToVehicleID,
+			Reason:        string(req.Reason),
+			Notes:         req.Notes,
+			ReassignedBy:  req.ReassignedBy,
+			CreatedAt:     time.Now(),
+		}
+		if err := tx.Create(&reassignmentLog).Error; err != nil {
+			return fmt.Errorf("failed to create reassignment log: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	// Log to audit
@@ -121,47 +158,78 @@ type ReassignStopRequest struct {
 }
 
 // ReassignStop moves a stop from one route to another
+// Both routes are locked for the move, so they must be open and unlocked unless locks are
+// overridden
 func (s *DispatchService) ReassignStop(req *ReassignStopRequest) error {
-	// Load stop
-	var stop models.RouteStop
-	if err := database.DB.Preload("Route").First(&stop, req.StopID).Error; err != nil {
-		return fmt.Errorf("failed to load stop: %w", err)
-	}
-
-	// Validate target route exists
-	var toRoute models.Route
-	if err := database.DB.First(&toRoute, req.ToRouteID).Error; err != nil {
-		return fmt.Errorf("target route not found: %w", err)
-	}
+	var oldRouteID uuid.UUID
+	var oldSequence int
+	var routeIDs []uuid.UUID
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		// Load stop
+		var stop models.RouteStop
+		if err := tx.First(&stop, "id = ?", req.StopID).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrStopNotFound
+			}
+			return fmt.Errorf("failed to load stop: %w", err)
+		}
 
-	// Store old values
-	oldRouteID := stop.RouteID
-	oldSequence := stop.Sequence
+		// Store old values
+		oldRouteID, oldSequence = stop.RouteID, stop.Sequence
+		routeIDs = []uuid.UUID{req.ToRouteID}
+		if oldRouteID != req.ToRouteID {
+			routeIDs = append(routeIDs, oldRouteID)
+		}
 
-	// Update stop
-	stop.RouteID = req.ToRouteID
-	stop.Sequence = req.NewSequence
+		// Lock both routes in a fixed order, so concurrent moves between them cannot deadlock
+		locking := append([]uuid.UUID{}, routeIDs...)
+		sort.Slice(locking, func(i, j int) bool { return locking[i].String() < locking[j].String() })
+		for _, routeID := range locking {
+			if _, err := LockRouteForChange(tx, routeID, s.overrideLock); err != nil {
+				return err
+			}
+		}
 
-	if err := database.DB.Save(&stop).Error; err != nil {
-		return fmt.Errorf("failed to update stop: %w", err)
-	}
+		// Update stop
+		stop.RouteID = req.ToRouteID
+		stop.Sequence = req.NewSequence
+		if err := tx.Omit("Route").Save(&stop).Error; err != nil {
+			return fmt.Errorf("failed to update stop: %w", err)
+		}
 
-	// Resequence remaining stops in old route
-	if err := s.resequenceRoute(oldRouteID); err != nil {
-		return fmt.Errorf("failed to resequence old route: %w", err)
-	}
+		// Resequence both routes, the moved stop taking its new place
+		movedDown := oldRouteID == req.ToRouteID && req.NewSequence > oldSequence
+		for _, routeID := range routeIDs {
+			if err := s.resequenceRoute(tx, routeID, stop.ID, movedDown); err != nil {
+				return fmt.Errorf("failed to resequence route: %w", err)
+			}
+			if err := SettleRouteStops(tx, routeID); err != nil {
+				return err
+			}
+		}
 
-	// Create reassignment log
-	reassignmentLog := models.ReassignmentLog{
-		RouteStopID:  &req.StopID,
-		Reason:       string(req.Reason),
-		Notes:        req.Notes,
-		ReassignedBy: req.ReassignedBy,
-		CreatedAt:    time.Now(),
+		// Create reassignment log
+		reassignmentLog := models.ReassignmentLog{
+			RouteStopID:  &req.StopID,
+			Reason:       string(req.Reason),
+			Notes:        req.Notes,
+			ReassignedBy: req.ReassignedBy,
+			CreatedAt:    time.Now(),
+		}
+		if err := tx.Create(&reassignmentLog).Error; err != nil {
+			return fmt.Errorf("failed to create reassignment log: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	if err := database.DB.Create(&reassignmentLog).Error; err != nil {
-		return fmt.Errorf("failed to create reassignment log: %w", err)
+	// Retime both routes; the move stands when timing fails, the stops keeping their old times
+	for _, routeID := range routeIDs {
+		if err := RetimeRoute(context.Background(), database.DB, s.mapsProxy, routeID); err != nil {
+			log.Printf("⚠️ Failed to retime route %s: %v", routeID, err)
+		}
 	}
 
 	// Log to audit
@@ -186,19 +254,24 @@ func (s *DispatchService) ReassignStop(req *ReassignStopRequest) error {
 	return nil
 }
 
-// resequenceRoute reorders stops in a route after a stop is removed
-func (s *DispatchService) resequenceRoute(routeID uuid.UUID) error {
+// resequenceRoute numbers the stops of a route from 1 after a stop is moved in or out
+// The moved stop goes before a stop already holding its sequence, or after it when the
+// stop moved down its own route
+func (s *DispatchService) resequenceRoute(tx *gorm.DB, routeID, movedStopID uuid.UUID, movedDown bool) error {
+	ties := "id = ? DESC"
+	if movedDown {
+		ties = "id = ? ASC"
+	}
 	var stops []models.RouteStop
-	if err := database.DB.Where("route_id = ?", routeID).
-		Order("sequence ASC").
+	if err := tx.Where("route_id = ?", routeID).
+		Order(gorm.Expr("sequence ASC, "+ties, movedStopID)).
 		Find(&stops).Error; err != nil {
 		return err
 	}
 
 	// Update sequences
 	for i, stop := range stops {
-		stop.Sequence = i + 1
-		if err := database.DB.Save(&stop).Error; err != nil {
+		if err := tx.Model(&stop).Update("sequence", i+1).Error; err != nil {
 			return err
 		}
 	}
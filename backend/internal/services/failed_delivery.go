@@ -0,0 +1,197 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ai-tms/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ErrUnknownFailureReason is returned for a failed delivery reported with a reason code not in the catalog
+var ErrUnknownFailureReason = errors.New("unknown failure reason code")
+
+// Outcomes of a failed delivery attempt
+const (
+	AttemptOutcomeRetry  = "retry"
+	AttemptOutcomeReturn = "return"
+)
+
+// FailureReason is a failed delivery reason code with its retry policy
+// An order failed for a reason is offered to planning again RetryAfterDays later, until it has
+// failed MaxAttempts times; it then goes back to the depot. A MaxAttempts of 1 never retries
+type FailureReason struct {
+	Code           string `json:"code"`
+	Label          string `json:"label"`
+	MaxAttempts    int    `json:"max_attempts"`
+	RetryAfterDays int    `json:"retry_after_days"`
+}
+
+// FailureReasonOther is the reason code used when a driver gives none
+const FailureReasonOther = "other"
+
+// FailureReasons is the catalog of failed delivery reason codes
+var FailureReasons = map[string]FailureReason{
+	"customer_absent":   {Code: "customer_absent", Label: "Customer absent", MaxAttempts: 3, RetryAfterDays: 1},
+	"address_not_found": {Code: "address_not_found", Label: "Address not found", MaxAttempts: 2, RetryAfterDays: 1},
+	"refused":           {Code: "refused", Label: "Refused by customer", MaxAttempts: 1},
+	"damaged":           {Code: "damaged", Label: "Goods damaged", MaxAttempts: 1},
+	FailureReasonOther:  {Code: FailureReasonOther, Label: "Other", MaxAttempts: 3, RetryAfterDays: 1},
+}
+
+// FailureReasonCatalog lists the failure reasons by code
+func FailureReasonCatalog() []FailureReason {
+	reasons := make([]FailureReason, 0, len(FailureReasons))
+	for _, reason := range FailureReasons {
+		reasons = append(reasons, reason)
+	}
+	sort.Slice(reasons, func(i, j int) bool { return reasons[i].Code < reasons[j].Code })
+	return reasons
+}
+
+// LookupFailureReason returns the catalog entry of a reason code, the other reason when empty
+func LookupFailureReason(code string) (FailureReason, error) {
+	if code == "" {
+		code = FailureReasonOther
+	}
+	reason, ok := FailureReasons[code]
+	if !ok {
+		return FailureReason{}, fmt.Errorf("%w: %s", ErrUnknownFailureReason, code)
+	}
+	return reason, nil
+}
+
+// NextStep decides what follows the given failed attempt: another try on the returned day,
+// or the return to the depot
+func (r FailureReason) NextStep(attempt int, now time.Time) (string, *time.Time) {
+	if attempt >= r.MaxAttempts {
+		return AttemptOutcomeReturn, nil
+	}
+	days := r.RetryAfterDays
+	if days < 1 {
+		days = 1
+	}
+	retryOn := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).AddDate(0, 0, days)
+	return AttemptOutcomeRetry, &retryOn
+}
+
+// FailedAttempt is a failed delivery as reported by the driver
+type FailedAttempt struct {
+	ReasonCode string
+	Notes      string
+	Latitude   float64
+	Longitude  float64
+}
+
+// FailedDeliveryService records failed delivery attempts and reschedules or returns their orders
+type FailedDeliveryService struct {
+	orderStatuses *OrderStatusService
+}
+
+// NewFailedDeliveryService creates a new failed delivery service
+func NewFailedDeliveryService() *FailedDeliveryService {
+	return &FailedDeliveryService{
+		orderStatuses: NewOrderStatusService(),
+	}
+}
+
+// Record stores a failed attempt at a delivery stop within a transaction, once its order has
+// failed. The order counts the attempt, then either goes back to pending, held back from
+// planning until the retry day, or, out of attempts, starts its return to the depot
+func (s *FailedDeliveryService) Record(tx *gorm.DB, stop models.RouteStop, failed FailedAttempt, change OrderStatusChange) (*models.DeliveryAttempt, error) {
+	reason, err := LookupFailureReason(failed.ReasonCode)
+	if err != nil {
+		return nil, err
+	}
+
+	var order models.Order
+	if err := tx.First(&order, "id = ?", stop.OrderID).Error; err != nil {
+		return nil, ErrOrderNotFound
+	}
+
+	now := time.Now()
+	attempt := order.Attempts + 1
+	outcome, retryOn := reason.NextStep(attempt, now)
+	record := models.DeliveryAttempt{
+		ID:          uuid.New(),
+		OrderID:     order.ID,
+		RouteStopID: stop.ID,
+		RouteID:     stop.RouteID,
+		Attempt:     attempt,
+		ReasonCode:  reason.Code,
+		Notes:       failed.Notes,
+		Outcome:     outcome,
+		RetryOn:     retryOn,
+		Latitude:    failed.Latitude,
+		Longitude:   failed.Longitude,
+		RecordedBy:  change.ChangedBy,
+	}
+	if err := tx.Create(&record).Error; err != nil {
+		return nil, fmt.Errorf("failed to record delivery attempt: %w", err)
+	}
+
+	updates := map[string]interface{}{"attempts": attempt, "retry_on": retryOn}
+	if retryOn != nil {
+		if order.DeliveryTime != nil {
+			updates["delivery_time"] = onDay(*order.DeliveryTime, *retryOn)
+		}
+		if order.RequiredBy != nil && order.RequiredBy.Before(retryOn.AddDate(0, 0, 1)) {
+			updates["required_by"] = onDay(*order.RequiredBy, *retryOn)
+		}
+	}
+	if err := tx.Model(&order).Updates(updates).Error; err != nil {
+		return nil, fmt.Errorf("failed to reschedule order %s: %w", order.OrderNumber, err)
+	}
+
+	next := OrderStatusPending
+	change.Reason = fmt.Sprintf("attempt %d of %d failed: %s", attempt, reason.MaxAttempts, reason.Label)
+	if outcome == AttemptOutcomeReturn {
+		next = OrderStatusReturning
+	}
+	if err := s.orderStatuses.Transition(tx, &order, next, change); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// ReceiveReturn records a returning order as received back at the depot
+func (s *FailedDeliveryService) ReceiveReturn(tx *gorm.DB, orderID uuid.UUID, change OrderStatusChange) (*models.Order, error) {
+	if change.Reason == "" {
+		change.Reason = "received back at depot"
+	}
+	order, err := s.orderStatuses.TransitionByID(tx, orderID, OrderStatusReturned, change)
+	if err != nil {
+		return order, err
+	}
+	if err := tx.Model(order).Update("retry_on", nil).Error; err != nil {
+		return order, fmt.Errorf("failed to update order %s: %w", order.OrderNumber, err)
+	}
+	order.RetryOn = nil
+	return order, nil
+}
+
+// PlannableOn reports whether an order may be planned for a day: after a failed delivery it
+// is held back until its retry day
+func PlannableOn(order models.Order, day time.Time) bool {
+	return order.RetryOn == nil || order.RetryOn.Before(nextDay(day))
+}
+
+// PlannableOrders limits an order query to the orders that may be planned for a day
+func PlannableOrders(day time.Time) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("retry_on IS NULL OR retry_on < ?", nextDay(day))
+	}
+}
+
+// nextDay returns the start of the day after a time
+func nextDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()).AddDate(0, 0, 1)
+}
+
+// onDay moves a time to another day, keeping its clock time
+func onDay(t, day time.Time) time.Time {
+	return time.Date(day.Year(), day.Month(), day.Day(), t.Hour(), t.Minute(), t.Second(), 0, t.Location())
+}
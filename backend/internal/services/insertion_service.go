@@ -0,0 +1,221 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ai-tms/backend/internal/database"
+	"github.com/ai-tms/backend/internal/maps"
+	"github.com/ai-tms/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ActiveRouteStatuses are the statuses of routes that make up a day's live plan
+var ActiveRouteStatuses = []string{
+	string(RouteStatusPlanned), string(RouteStatusAssigned), string(RouteStatusDispatched), string(RouteStatusInProgress),
+}
+
+var (
+	// ErrOrderNotFound is returned for an unknown order
+	ErrOrderNotFound = errors.New("order not found")
+
+	// ErrNoActiveRoutes is returned when a day has no route an order could join
+	ErrNoActiveRoutes = errors.New("no active routes on the day")
+
+	// ErrInsertionInfeasible is returned when committing an insertion that breaks a hard constraint
+	ErrInsertionInfeasible = errors.New("insertion breaks a route constraint")
+
+	// ErrRouteChanged is returned when a route's open stops changed since an insertion was quoted
+	ErrRouteChanged = errors.New("route changed since the insertion was quoted")
+)
+
+// InsertionService quotes and commits adding an order to a day's active routes
+type InsertionService struct {
+	mapsProxy    *maps.MapsProxyService
+	orderStates  *OrderStateMachine
+	plans        *PlanService
+	overrideLock bool
+}
+
+// NewInsertionService creates a new insertion service
+func NewInsertionService(mapsProxy *maps.MapsProxyService) *InsertionService {
+	return &InsertionService{
+		mapsProxy:   mapsProxy,
+		orderStates: NewOrderStateMachine(),
+		plans:       NewPlanService(),
+	}
+}
+
+// SetLockOverride lets insertions consider and change locked routes
+func (s *InsertionService) SetLockOverride(override bool) *InsertionService {
+	s.overrideLock = override
+	return s
+}
+
+// Insertion is a quote for adding an order to a stored route
+type Insertion struct {
+	InsertionQuote
+	OrderID     uuid.UUID
+	RouteID     uuid.UUID
+	RouteNumber string
+	openStops   []uuid.UUID // Pending stops of the route the quote was made on, in sequence
+}
+
+// Quote finds the active route and position of the day where an order adds the least cost
+// Routes keep their open stops in order; vehicles on the road continue from their last position
+func (s *InsertionService) Quote(ctx context.Context, orderID uuid.UUID, day time.Time) (*Insertion, error) {
+	var order models.Order
+	if err := database.DB.Preload("Customer").First(&order, "id = ?", orderID).Error; err != nil {
+		return nil, ErrOrderNotFound
+	}
+	if err := s.orderStates.ValidateTransition(OrderStatus(order.Status), OrderStatusAssigned); err != nil {
+		return nil, fmt.Errorf("%w: %s: %v", ErrOrderNotPlannable, order.OrderNumber, err)
+	}
+	if !PlannableOn(order, day) {
+		return nil, fmt.Errorf("%w: %s is held back until %s", ErrOrderNotPlannable, order.OrderNumber, order.RetryOn.Format("2006-01-02"))
+	}
+
+	start := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+	query := database.DB.Preload("Vehicle.CurrentDriver").Preload("Stops", func(db *gorm.DB) *gorm.DB {
+		return db.Order("sequence")
+	}).Where("status IN ? AND date >= ? AND date < ?", ActiveRouteStatuses, start, start.AddDate(0, 0, 1))
+	if !s.overrideLock {
+		query = query.Where("is_locked = ?", false)
+	}
+	var routes []models.Route
+	if err := query.Find(&routes).Error; err != nil {
+		return nil, fmt.Errorf("failed to load routes: %w", err)
+	}
+	if len(routes) == 0 {
+		return nil, ErrNoActiveRoutes
+	}
+
+	planned, depots, err := s.routeData(routes)
+	if err != nil {
+		return nil, err
+	}
+	state := newReplanState(routes, append(planned, order), nil)
+
+	fixed := make([]FixedRoute, 0, len(state.routes))
+	onRoad := make([]uuid.UUID, 0)
+	for _, route := range state.routes {
+		fixed = append(fixed, route.fixed())
+		if route.route.Status == string(RouteStatusInProgress) {
+			onRoad = append(onRoad, route.vehicle.ID)
+		}
+	}
+	vehicles := make([]models.Vehicle, 0, len(fixed))
+	for _, route := range fixed {
+		vehicles = append(vehicles, route.Vehicle)
+	}
+
+	planStart, err := PlanStart(start, DefaultPlanStartClock)
+	if err != nil {
+		return nil, err
+	}
+	solver := NewVRPSolver(append(state.openOrders(state.routes), order), vehicles, depots[0]).
+		SetDepots(depots).
+		SetMapsProxy(s.mapsProxy).
+		SetPlanStart(planStart)
+	solver.constraints = VRPConstraints{StartPositions: LastPositions(onRoad)}
+	if now := time.Now(); now.After(start) && now.Before(start.AddDate(0, 0, 1)) {
+		solver.constraints.NotBefore = now
+	}
+
+	quote, err := solver.QuoteInsertion(ctx, fixed, orderID.String())
+	if err != nil {
+		return nil, err
+	}
+
+	route := state.routes[quote.RouteIndex]
+	insertion := &Insertion{
+		InsertionQuote: *quote,
+		OrderID:        orderID,
+		RouteID:        route.route.ID,
+		RouteNumber:    route.route.RouteNumber,
+	}
+	for _, stop := range route.open {
+		insertion.openStops = append(insertion.openStops, stop.ID)
+	}
+	return insertion, nil
+}
+
+// Commit stores a quoted insertion: the route's open stops take their new positions and times,
+// the order's stops are added and the order is assigned
+func (s *InsertionService) Commit(insertion *Insertion, changedBy *uuid.UUID) ([]models.RouteStop, error) {
+	if !insertion.Feasible {
+		return nil, fmt.Errorf("%w: %s", ErrInsertionInfeasible, insertion.Route.Violation)
+	}
+
+	var stops []models.RouteStop
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		route, err := LockRouteForChange(tx, insertion.RouteID, s.overrideLock)
+		if err != nil {
+			return err
+		}
+
+		var open []models.RouteStop
+		if err := tx.Where("route_id = ? AND status = ?", route.ID, string(StopStatusPending)).
+			Order("sequence").Find(&open).Error; err != nil {
+			return fmt.Errorf("failed to load route stops: %w", err)
+		}
+		if len(open) != len(insertion.openStops) {
+			return ErrRouteChanged
+		}
+		for i, stop := range open {
+			if stop.ID != insertion.openStops[i] {
+				return ErrRouteChanged
+			}
+		}
+
+		if stops, err = writeRouteSequence(tx, route, open, insertion.Route); err != nil {
+			return err
+		}
+		change := OrderStatusChange{ChangedBy: changedBy, Source: OrderStatusSourceInsertion}
+		if err := s.plans.assignOrders(tx, []uuid.UUID{insertion.OrderID}, change); err != nil {
+			return err
+		}
+		_, err = refreshRouteTotals(tx, route)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stops, nil
+}
+
+// routeData loads the orders on the routes' open stops and the depots the routes leave from
+func (s *InsertionService) routeData(routes []models.Route) ([]models.Order, []models.Depot, error) {
+	orderIDs := make([]uuid.UUID, 0)
+	depotIDs := make([]uuid.UUID, 0)
+	for _, route := range routes {
+		depotIDs = append(depotIDs, route.DepotID)
+		if route.Vehicle != nil {
+			depotIDs = append(depotIDs, route.Vehicle.DepotID)
+		}
+		for _, stop := range route.Stops {
+			if stop.Status == string(StopStatusPending) {
+				orderIDs = append(orderIDs, stop.OrderID)
+			}
+		}
+	}
+
+	var orders []models.Order
+	if len(orderIDs) > 0 {
+		if err := database.DB.Preload("Customer").Where("id IN ?", orderIDs).Find(&orders).Error; err != nil {
+			return nil, nil, fmt.Errorf("failed to load orders: %w", err)
+		}
+	}
+
+	var depots []models.Depot
+	if err := database.DB.Where("id IN ?", depotIDs).Find(&depots).Error; err != nil {
+		return nil, nil, fmt.Errorf("failed to load depots: %w", err)
+	}
+	if len(depots) == 0 {
+		return nil, nil, fmt.Errorf("routes have no known depot")
+	}
+	return orders, depots, nil
+}
@@ -57,3 +57,10 @@ func (s *NotificationService) NotifyDriverAssignment(driverName, routeID string,
 		driverName, routeID, stopCount)
 	return s.SendLineNotification(msg)
 }
+
+// NotifyRouteChange notifies a driver that replanning changed their route
+func (s *NotificationService) NotifyRouteChange(driverName, routeNumber string, openStops int) error {
+	msg := fmt.Sprintf("\n[AI-TMS Alert] 🔄\nHelloคุณ %s,\nแผนเส้นทางของคุณมีการเปลี่ยนแปลง!\nRoute: %s\nจุดส่งที่เหลือ: %d จุด\nตรวจสอบลำดับจุดส่งใหม่ใน App เลยครับ!",
+		driverName, routeNumber, openStops)
+	return s.SendLineNotification(msg)
+}
@@ -0,0 +1,227 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/ai-tms/backend/internal/database"
+	"github.com/ai-tms/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+// Optimization job statuses
+const (
+	JobStatusQueued    = "queued"
+	JobStatusRunning   = "running"
+	JobStatusCompleted = "completed"
+	JobStatusFailed    = "failed"
+	JobStatusCancelled = "cancelled"
+)
+
+// EventOptimizationProgress reports a job's progress and status changes on the event stream
+const EventOptimizationProgress EventType = "OPTIMIZATION_PROGRESS"
+
+// progressInterval limits how often a running job stores and broadcasts its progress
+const progressInterval = 500 * time.Millisecond
+
+var (
+	// ErrJobNotFound is returned for an unknown optimization job
+	ErrJobNotFound = errors.New("optimization job not found")
+
+	// ErrJobFinished is returned when cancelling a job that already ended
+	ErrJobFinished = errors.New("optimization job already finished")
+)
+
+// OptimizationJobFunc runs a job's optimization and persists its plan
+// It must report progress through the given callback and stop when the context is done;
+// the returned summary is stored as the job result
+type OptimizationJobFunc func(ctx context.Context, progress ProgressFunc) (interface{}, error)
+
+// OptimizationProgressEvent is the payload of EventOptimizationProgress
+type OptimizationProgressEvent struct {
+	JobID     uuid.UUID `json:"job_id"`
+	Status    string    `json:"status"`
+	Iteration int       `json:"iteration"`
+	BestCost  float64   `json:"best_cost"`
+	ElapsedMs int64     `json:"elapsed_ms"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// OptimizationJobService runs route optimizations in the background
+type OptimizationJobService struct {
+	mu      sync.Mutex
+	running map[uuid.UUID]context.CancelFunc
+}
+
+// NewOptimizationJobService creates a new optimization job service
+func NewOptimizationJobService() *OptimizationJobService {
+	return &OptimizationJobService{
+		running: make(map[uuid.UUID]context.CancelFunc),
+	}
+}
+
+// Submit stores a queued job and starts running it, bounded by timeout when positive
+func (s *OptimizationJobService) Submit(job *models.OptimizationJob, timeout time.Duration, run OptimizationJobFunc) error {
+	job.Status = JobStatusQueued
+	if job.Request == "" {
+		job.Request = "{}"
+	}
+	if job.Result == "" {
+		job.Result = "{}"
+	}
+	if err := database.DB.Create(job).Error; err != nil {
+		return fmt.Errorf("failed to create optimization job: %w", err)
+	}
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+
+	s.mu.Lock()
+	s.running[job.ID] = cancel
+	s.mu.Unlock()
+
+	go s.run(ctx, job.ID, run)
+	return nil
+}
+
+// Get loads a job
+func (s *OptimizationJobService) Get(id uuid.UUID) (*models.OptimizationJob, error) {
+	var job models.OptimizationJob
+	if err := database.DB.First(&job, "id = ?", id).Error; err != nil {
+		return nil, ErrJobNotFound
+	}
+	return &job, nil
+}
+
+// Cancel stops a queued or running job
+// A job running in this process is marked cancelled once its optimizer returns; a job left
+// over from before a restart is marked cancelled right away
+func (s *OptimizationJobService) Cancel(id uuid.UUID) (*models.OptimizationJob, error) {
+	job, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if job.Status != JobStatusQueued && job.Status != JobStatusRunning {
+		return job, ErrJobFinished
+	}
+
+	s.mu.Lock()
+	cancel, ok := s.running[id]
+	s.mu.Unlock()
+	if ok {
+		cancel()
+		return job, nil
+	}
+
+	s.finish(job, JobStatusCancelled, nil, context.Canceled)
+	return job, nil
+}
+
+// FailInterrupted marks jobs that were queued or running when the server stopped as failed
+func (s *OptimizationJobService) FailInterrupted() error {
+	return database.DB.Model(&models.OptimizationJob{}).
+		Where("status IN ?", []string{JobStatusQueued, JobStatusRunning}).
+		Updates(map[string]interface{}{
+			"status":      JobStatusFailed,
+			"error":       "interrupted by server restart",
+			"finished_at": time.Now(),
+		}).Error
+}
+
+// run executes a job and records its outcome
+func (s *OptimizationJobService) run(ctx context.Context, id uuid.UUID, run OptimizationJobFunc) {
+	defer func() {
+		s.mu.Lock()
+		cancel := s.running[id]
+		delete(s.running, id)
+		s.mu.Unlock()
+		cancel()
+	}()
+
+	job, err := s.Get(id)
+	if err != nil {
+		log.Printf("⚠️  Optimization job %s disappeared: %v", id, err)
+		return
+	}
+
+	started := time.Now()
+	job.Status = JobStatusRunning
+	job.StartedAt = &started
+	database.DB.Model(job).Updates(map[string]interface{}{"status": job.Status, "started_at": started})
+	s.broadcast(job, 0)
+
+	var last time.Time
+	result, err := run(ctx, func(progress SolverProgress) {
+		job.Iteration = progress.Iteration
+		job.BestCost = progress.BestCost
+		if time.Since(last) < progressInterval {
+			return
+		}
+		last = time.Now()
+		database.DB.Model(job).Updates(map[string]interface{}{"iteration": job.Iteration, "best_cost": job.BestCost})
+		s.broadcast(job, time.Since(started))
+	})
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		s.finish(job, JobStatusCancelled, nil, err)
+	case err != nil:
+		s.finish(job, JobStatusFailed, nil, err)
+	default:
+		s.finish(job, JobStatusCompleted, result, nil)
+	}
+}
+
+// finish stores a job's final status, result and error and announces it
+func (s *OptimizationJobService) finish(job *models.OptimizationJob, status string, result interface{}, err error) {
+	finished := time.Now()
+	job.Status = status
+	job.FinishedAt = &finished
+	if err != nil {
+		job.Error = err.Error()
+	}
+	if result != nil {
+		if data, marshalErr := json.Marshal(result); marshalErr == nil {
+			job.Result = string(data)
+		}
+	}
+
+	if saveErr := database.DB.Model(job).Updates(map[string]interface{}{
+		"status":      job.Status,
+		"iteration":   job.Iteration,
+		"best_cost":   job.BestCost,
+		"result":      job.Result,
+		"error":       job.Error,
+		"finished_at": finished,
+	}).Error; saveErr != nil {
+		log.Printf("⚠️  Failed to save optimization job %s: %v", job.ID, saveErr)
+	}
+
+	var elapsed time.Duration
+	if job.StartedAt != nil {
+		elapsed = finished.Sub(*job.StartedAt)
+	}
+	s.broadcast(job, elapsed)
+}
+
+// broadcast pushes a job's progress to the event stream
+func (s *OptimizationJobService) broadcast(job *models.OptimizationJob, elapsed time.Duration) {
+	GetEventService().Broadcast(EventOptimizationProgress, OptimizationProgressEvent{
+		JobID:     job.ID,
+		Status:    job.Status,
+		Iteration: job.Iteration,
+		BestCost:  job.BestCost,
+		ElapsedMs: elapsed.Milliseconds(),
+		Error:     job.Error,
+	})
+}
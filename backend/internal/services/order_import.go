@@ -0,0 +1,511 @@
+package services
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ai-tms/backend/internal/database"
+	"github.com/ai-tms/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+var (
+	// ErrImportEmpty is returned for an import file without data rows
+	ErrImportEmpty = errors.New("import file has no data rows")
+
+	// ErrImportColumns is returned when the file's columns cannot be mapped to order fields
+	ErrImportColumns = errors.New("import columns cannot be mapped")
+)
+
+// Import job statuses
+const (
+	ImportStatusValidated = "validated" // dry run, nothing imported
+	ImportStatusCompleted = "completed"
+	ImportStatusFailed    = "failed"
+)
+
+// OrderImportFields are the order fields import columns can be mapped to
+var OrderImportFields = []string{
+	"order_number", "customer_code", "customer_id",
+	"pickup_address", "pickup_latitude", "pickup_longitude", "pickup_time",
+	"delivery_address", "delivery_time", "required_by",
+	"weight_kg", "volume_m3", "items", "priority", "notes",
+}
+
+// importHeaderAliases maps common header spellings to import fields
+var importHeaderAliases = map[string]string{
+	"order":         "order_number",
+	"order_no":      "order_number",
+	"customer":      "customer_code",
+	"customer_no":   "customer_code",
+	"address":       "delivery_address",
+	"delivery":      "delivery_address",
+	"pickup":        "pickup_address",
+	"weight":        "weight_kg",
+	"volume":        "volume_m3",
+	"quantity":      "items",
+	"qty":           "items",
+	"deadline":      "required_by",
+	"due_by":        "required_by",
+	"delivery_date": "delivery_time",
+}
+
+// orderPriorities are the accepted order priorities
+var orderPriorities = map[string]bool{"low": true, "normal": true, "high": true, "critical": true}
+
+// importTimeLayouts are the accepted date and time formats, tried in order
+var importTimeLayouts = []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02T15:04", "2006-01-02"}
+
+// ImportSheet is an import file read into its header and data rows
+type ImportSheet struct {
+	Headers []string
+	Rows    []ImportRow
+}
+
+// ImportRow is a data row with its line in the file: the row of a workbook, or the object of a JSON file
+type ImportRow struct {
+	Line   int
+	Values []string
+}
+
+// ImportRowError is a validation error on one row, and column when it concerns one
+type ImportRowError struct {
+	Row     int    `json:"row"`
+	Column  string `json:"column,omitempty"`
+	Message string `json:"message"`
+}
+
+// ImportOptions control how an import runs
+type ImportOptions struct {
+	Format      string
+	FileName    string
+	Mapping     map[string]string // header to order field, for headers the aliases do not cover
+	DryRun      bool              // validate only
+	SkipInvalid bool              // import the valid rows even when others fail
+	CreatedBy   *uuid.UUID
+}
+
+// ImportResult is the outcome of an import with the orders it created, or would create on a dry run
+type ImportResult struct {
+	Job    models.ImportJob
+	Orders []models.Order
+}
+
+// ReadCSVSheet reads a CSV import file; its first row holds the headers
+func ReadCSVSheet(r io.Reader) (ImportSheet, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+
+	var sheet ImportSheet
+	for line := 1; ; line++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return sheet, fmt.Errorf("invalid CSV: %w", err)
+		}
+		if sheet.Headers == nil {
+			if len(record) > 0 {
+				record[0] = strings.TrimPrefix(record[0], "\ufeff")
+			}
+			sheet.Headers = record
+			continue
+		}
+		if blankRow(record) {
+			continue
+		}
+		sheet.Rows = append(sheet.Rows, ImportRow{Line: line, Values: record})
+	}
+	return sheet, nil
+}
+
+// blankRow reports whether every value of a row is empty
+func blankRow(values []string) bool {
+	for _, value := range values {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// MapColumns maps the file's headers to order fields: explicit mappings first, then headers
+// named after a field or one of its aliases. It returns the column of each mapped field and the
+// headers left unmapped; the customer must be mapped by code or ID
+func MapColumns(headers []string, mapping map[string]string) (map[string]int, []string, error) {
+	known := make(map[string]bool, len(OrderImportFields))
+	for _, field := range OrderImportFields {
+		known[field] = true
+	}
+	explicit := make(map[string]string, len(mapping))
+	for header, field := range mapping {
+		field = normalizeHeader(field)
+		if !known[field] {
+			return nil, nil, fmt.Errorf("%w: %q is not an order field", ErrImportColumns, field)
+		}
+		explicit[normalizeHeader(header)] = field
+	}
+
+	columns := make(map[string]int)
+	var ignored []string
+	for i, header := range headers {
+		name := normalizeHeader(header)
+		field, ok := explicit[name]
+		if !ok && known[name] {
+			field, ok = name, true
+		}
+		if !ok {
+			field, ok = importHeaderAliases[name]
+		}
+		if !ok {
+			ignored = append(ignored, header)
+			continue
+		}
+		if _, taken := columns[field]; taken {
+			return nil, nil, fmt.Errorf("%w: several columns map to %s", ErrImportColumns, field)
+		}
+		columns[field] = i
+	}
+
+	_, byCode := columns["customer_code"]
+	_, byID := columns["customer_id"]
+	if !byCode && !byID {
+		return nil, nil, fmt.Errorf("%w: a customer_code or customer_id column is required", ErrImportColumns)
+	}
+	return columns, ignored, nil
+}
+
+// normalizeHeader lowercases a header and joins its words with underscores
+func normalizeHeader(header string) string {
+	header = strings.ToLower(strings.TrimSpace(header))
+	return strings.Join(strings.FieldsFunc(header, func(r rune) bool {
+		return r == ' ' || r == '_' || r == '-' || r == '.' || r == '(' || r == ')'
+	}), "_")
+}
+
+// ImportCustomers are the customers rows may refer to, by code and by ID
+type ImportCustomers struct {
+	ByCode map[string]models.Customer
+	ByID   map[uuid.UUID]models.Customer
+}
+
+// ParseImportRow reads a row into a pending order, collecting every validation error
+// The delivery address defaults to the customer's address; the order number is left empty
+// when the row has none
+func ParseImportRow(row ImportRow, columns map[string]int, customers ImportCustomers) (models.Order, []ImportRowError) {
+	var errs []ImportRowError
+	fail := func(field, format string, args ...interface{}) {
+		errs = append(errs, ImportRowError{Row: row.Line, Column: field, Message: fmt.Sprintf(format, args...)})
+	}
+	value := func(field string) string {
+		i, ok := columns[field]
+		if !ok || i >= len(row.Values) {
+			return ""
+		}
+		return strings.TrimSpace(row.Values[i])
+	}
+	number := func(field string) float64 {
+		raw := value(field)
+		if raw == "" {
+			return 0
+		}
+		// Commas only group thousands; a decimal comma such as 1,5 is rejected, not read as 15
+		if thousandsNumber.MatchString(raw) {
+			raw = strings.ReplaceAll(raw, ",", "")
+		}
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			fail(field, "%q is not a number, use a decimal point", raw)
+			return 0
+		}
+		return n
+	}
+	moment := func(field string) *time.Time {
+		raw := value(field)
+		if raw == "" {
+			return nil
+		}
+		t, err := parseImportTime(raw)
+		if err != nil {
+			fail(field, "%q is not a date or time", raw)
+			return nil
+		}
+		return &t
+	}
+
+	order := models.Order{
+		OrderNumber:     value("order_number"),
+		PickupAddress:   value("pickup_address"),
+		PickupLatitude:  number("pickup_latitude"),
+		PickupLongitude: number("pickup_longitude"),
+		PickupTime:      moment("pickup_time"),
+		DeliveryAddress: value("delivery_address"),
+		DeliveryTime:    moment("delivery_time"),
+		RequiredBy:      moment("required_by"),
+		WeightKg:        number("weight_kg"),
+		VolumeM3:        number("volume_m3"),
+		Notes:           value("notes"),
+		Status:          string(OrderStatusPending),
+		Priority:        "normal",
+	}
+
+	var customer models.Customer
+	var found bool
+	if code := value("customer_code"); code != "" {
+		if customer, found = customers.ByCode[code]; !found {
+			fail("customer_code", "no customer with code %q", code)
+		}
+	} else if raw := value("customer_id"); raw != "" {
+		id, err := uuid.Parse(raw)
+		if err == nil {
+			customer, found = customers.ByID[id]
+		}
+		if !found {
+			fail("customer_id", "no customer with ID %q", raw)
+		}
+	} else {
+		fail("customer_code", "customer is required")
+	}
+	if found {
+		order.CustomerID = customer.ID
+		if order.DeliveryAddress == "" {
+			order.DeliveryAddress = customer.Address
+		}
+	}
+
+	if order.WeightKg < 0 {
+		fail("weight_kg", "weight cannot be negative")
+	}
+	if order.VolumeM3 < 0 {
+		fail("volume_m3", "volume cannot be negative")
+	}
+	if raw := value("items"); raw != "" {
+		items, err := strconv.Atoi(raw)
+		if err != nil || items < 0 {
+			fail("items", "%q is not a number of items", raw)
+		}
+		order.Items = items
+	}
+	if raw := strings.ToLower(value("priority")); raw != "" {
+		if !orderPriorities[raw] {
+			fail("priority", "%q is not a priority (low, normal, high, critical)", raw)
+		}
+		order.Priority = raw
+	}
+	if err := NewOrderValidationService().ValidatePriority(&order); err != nil {
+		fail("required_by", "%s", err.Error())
+	}
+	return order, errs
+}
+
+// thousandsNumber matches a number whose commas group thousands, such as 1,200.5
+var thousandsNumber = regexp.MustCompile(`^[-+]?\d{1,3}(,\d{3})+(\.\d+)?$`)
+
+// parseImportTime reads a date or time in one of the accepted layouts, in local time
+func parseImportTime(raw string) (time.Time, error) {
+	var err error
+	for _, layout := range importTimeLayouts {
+		var t time.Time
+		if t, err = time.ParseInLocation(layout, raw, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, err
+}
+
+// OrderImportService imports orders in bulk from import files
+type OrderImportService struct {
+	validation *OrderValidationService
+}
+
+// NewOrderImportService creates a new order import service
+func NewOrderImportService() *OrderImportService {
+	return &OrderImportService{
+		validation: NewOrderValidationService(),
+	}
+}
+
+// Import validates every row of a sheet and, unless it is a dry run, creates the orders in a
+// single transaction. Rows that fail validation or duplicate an order, in the file or already
+// stored, are reported by row; with any of them nothing is imported unless invalid rows are
+// skipped. Every run is kept as an import job
+func (s *OrderImportService) Import(sheet ImportSheet, options ImportOptions) (*ImportResult, error) {
+	result := &ImportResult{Job: models.ImportJob{
+		ID:          uuid.New(),
+		Format:      options.Format,
+		FileName:    options.FileName,
+		DryRun:      options.DryRun,
+		SkipInvalid: options.SkipInvalid,
+		TotalRows:   len(sheet.Rows),
+		CreatedBy:   options.CreatedBy,
+	}}
+	job := &result.Job
+
+	if len(sheet.Rows) == 0 {
+		return result, s.fail(job, ErrImportEmpty)
+	}
+	columns, ignored, err := MapColumns(sheet.Headers, options.Mapping)
+	if err != nil {
+		return result, s.fail(job, err)
+	}
+	job.Mapping = importMapping(sheet.Headers, columns, ignored)
+
+	customers, err := s.customers(sheet, columns)
+	if err != nil {
+		return nil, err
+	}
+
+	var rowErrors []ImportRowError
+	seen := make(map[string]int)
+	for _, row := range sheet.Rows {
+		order, errs := ParseImportRow(row, columns, customers)
+		if len(errs) == 0 {
+			errs = s.checkDuplicate(row, &order, seen)
+		}
+		if len(errs) > 0 {
+			rowErrors = append(rowErrors, errs...)
+			job.FailedRows++
+			continue
+		}
+		if order.OrderNumber == "" {
+			order.OrderNumber = "ORD-" + time.Now().Format("20060102") + "-" + uuid.New().String()[:8]
+		}
+		order.ID = uuid.New()
+		result.Orders = append(result.Orders, order)
+	}
+	job.ValidRows = len(result.Orders)
+	if encoded, err := json.Marshal(rowErrors); err == nil && len(rowErrors) > 0 {
+		job.Errors = string(encoded)
+	}
+
+	switch {
+	case options.DryRun:
+		job.Status = ImportStatusValidated
+	case job.FailedRows > 0 && !options.SkipInvalid:
+		job.Status = ImportStatusFailed
+		job.Error = fmt.Sprintf("%d of %d rows failed validation, nothing was imported", job.FailedRows, job.TotalRows)
+	case len(result.Orders) == 0:
+		job.Status = ImportStatusFailed
+		job.Error = "no valid rows to import"
+	default:
+		job.Status = ImportStatusCompleted
+		job.ImportedRows = len(result.Orders)
+	}
+
+	now := time.Now()
+	job.FinishedAt = &now
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		if job.Status == ImportStatusCompleted {
+			if err := tx.CreateInBatches(&result.Orders, 100).Error; err != nil {
+				return fmt.Errorf("failed to create orders: %w", err)
+			}
+		}
+		return tx.Create(job).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	if job.Status == ImportStatusFailed {
+		result.Orders = nil
+	}
+	return result, nil
+}
+
+// fail records an import that could not be read at all
+func (s *OrderImportService) fail(job *models.ImportJob, cause error) error {
+	now := time.Now()
+	job.Status = ImportStatusFailed
+	job.Error = cause.Error()
+	job.FinishedAt = &now
+	if err := database.DB.Create(job).Error; err != nil {
+		return fmt.Errorf("failed to save import job: %w", err)
+	}
+	return cause
+}
+
+// customers loads the customers the sheet's rows refer to
+func (s *OrderImportService) customers(sheet ImportSheet, columns map[string]int) (ImportCustomers, error) {
+	customers := ImportCustomers{ByCode: make(map[string]models.Customer), ByID: make(map[uuid.UUID]models.Customer)}
+	var codes []string
+	var ids []uuid.UUID
+	for _, row := range sheet.Rows {
+		if i, ok := columns["customer_code"]; ok && i < len(row.Values) {
+			if code := strings.TrimSpace(row.Values[i]); code != "" {
+				codes = append(codes, code)
+			}
+		}
+		if i, ok := columns["customer_id"]; ok && i < len(row.Values) {
+			if id, err := uuid.Parse(strings.TrimSpace(row.Values[i])); err == nil {
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	var found []models.Customer
+	if len(codes) > 0 {
+		if err := database.DB.Where("code IN ?", codes).Find(&found).Error; err != nil {
+			return customers, fmt.Errorf("failed to load customers: %w", err)
+		}
+	}
+	if len(ids) > 0 {
+		var byID []models.Customer
+		if err := database.DB.Where("id IN ?", ids).Find(&byID).Error; err != nil {
+			return customers, fmt.Errorf("failed to load customers: %w", err)
+		}
+		found = append(found, byID...)
+	}
+	for _, customer := range found {
+		customers.ByCode[customer.Code] = customer
+		customers.ByID[customer.ID] = customer
+	}
+	return customers, nil
+}
+
+// checkDuplicate rejects a row repeating an earlier row of the file or an order already stored
+func (s *OrderImportService) checkDuplicate(row ImportRow, order *models.Order, seen map[string]int) []ImportRowError {
+	keys := []string{"hash:" + s.validation.generateOrderHash(order)}
+	if order.OrderNumber != "" {
+		keys = append(keys, "number:"+order.OrderNumber)
+	}
+	for _, key := range keys {
+		if line, ok := seen[key]; ok {
+			return []ImportRowError{{Row: row.Line, Message: fmt.Sprintf("duplicates row %d", line)}}
+		}
+	}
+	for _, key := range keys {
+		seen[key] = row.Line
+	}
+
+	duplicate, existing, err := s.validation.CheckDuplicateOrder(order)
+	if err != nil {
+		return []ImportRowError{{Row: row.Line, Message: err.Error()}}
+	}
+	if duplicate {
+		return []ImportRowError{{Row: row.Line, Message: "duplicates order " + existing.OrderNumber}}
+	}
+	return nil
+}
+
+// importMapping describes the column mapping as JSON: the field each header was read into,
+// with null for ignored headers
+func importMapping(headers []string, columns map[string]int, ignored []string) string {
+	mapping := make(map[string]*string, len(headers))
+	for field, i := range columns {
+		mapping[headers[i]] = &field
+	}
+	for _, header := range ignored {
+		mapping[header] = nil
+	}
+	encoded, _ := json.Marshal(mapping)
+	return string(encoded)
+}
@@ -0,0 +1,306 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/xuri/excelize/v2"
+)
+
+// Import file formats
+const (
+	ImportFormatCSV    = "csv"
+	ImportFormatXLSX   = "xlsx"
+	ImportFormatJSON   = "json"   // array of objects
+	ImportFormatNDJSON = "ndjson" // one object per line
+)
+
+// ImportFormats lists the supported import file formats
+var ImportFormats = []string{ImportFormatCSV, ImportFormatXLSX, ImportFormatJSON, ImportFormatNDJSON}
+
+var (
+	// ErrImportFormat is returned for an import file format that is not supported
+	ErrImportFormat = errors.New("unsupported import format")
+
+	// ErrImportSheetNotFound is returned when a workbook has no sheet of the requested name
+	ErrImportSheetNotFound = errors.New("sheet not found")
+)
+
+// ImportFormatFromFileName picks the import format from a file's extension
+func ImportFormatFromFileName(name string) string {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".xlsx"):
+		return ImportFormatXLSX
+	case strings.HasSuffix(name, ".ndjson"), strings.HasSuffix(name, ".jsonl"):
+		return ImportFormatNDJSON
+	case strings.HasSuffix(name, ".json"):
+		return ImportFormatJSON
+	default:
+		return ImportFormatCSV
+	}
+}
+
+// ReadImportSheet reads an import file of the given format into a sheet
+// Workbooks are read from the named sheet, or the first one when no name is given
+func ReadImportSheet(r io.Reader, format, sheetName string) (ImportSheet, error) {
+	switch format {
+	case ImportFormatCSV:
+		return ReadCSVSheet(r)
+	case ImportFormatXLSX:
+		return ReadXLSXSheet(r, sheetName)
+	case ImportFormatJSON, ImportFormatNDJSON:
+		return ReadJSONSheet(r)
+	default:
+		return ImportSheet{}, fmt.Errorf("%w: %s", ErrImportFormat, format)
+	}
+}
+
+// ReadXLSXSheet reads a sheet of an Excel workbook; its first row holds the headers
+// Cells formatted as dates are read as date and time rather than as serial numbers
+func ReadXLSXSheet(r io.Reader, sheetName string) (ImportSheet, error) {
+	var sheet ImportSheet
+	workbook, err := excelize.OpenReader(r)
+	if err != nil {
+		return sheet, fmt.Errorf("invalid workbook: %w", err)
+	}
+	defer workbook.Close()
+
+	sheets := workbook.GetSheetList()
+	if sheetName == "" && len(sheets) > 0 {
+		sheetName = sheets[0]
+	}
+	found := false
+	for _, name := range sheets {
+		found = found || name == sheetName
+	}
+	if !found {
+		return sheet, fmt.Errorf("%w: %q, the workbook has %s", ErrImportSheetNotFound, sheetName, strings.Join(sheets, ", "))
+	}
+
+	rows, err := workbook.GetRows(sheetName, excelize.Options{RawCellValue: true})
+	if err != nil {
+		return sheet, fmt.Errorf("failed to read sheet %s: %w", sheetName, err)
+	}
+	date1904 := false
+	if props, err := workbook.GetWorkbookProps(); err == nil && props.Date1904 != nil {
+		date1904 = *props.Date1904
+	}
+
+	for i, row := range rows {
+		if sheet.Headers == nil {
+			if !blankRow(row) {
+				sheet.Headers = row
+			}
+			continue
+		}
+		if blankRow(row) {
+			continue
+		}
+		for col, value := range row {
+			serial, err := strconv.ParseFloat(value, 64)
+			if err != nil || !xlsxDateCell(workbook, sheetName, col+1, i+1) {
+				continue
+			}
+			if t, err := excelize.ExcelDateToTime(serial, date1904); err == nil {
+				row[col] = t.Format("2006-01-02 15:04:05")
+			}
+		}
+		sheet.Rows = append(sheet.Rows, ImportRow{Line: i + 1, Values: row})
+	}
+	return sheet, nil
+}
+
+// xlsxDateFormat matches the date and time parts of a number format, once xlsxFormatLiterals
+// strips quoted text and bracketed sections such as colors
+var (
+	xlsxFormatLiterals = regexp.MustCompile(`"[^"]*"|\[[^\]]*\]|\\.`)
+	xlsxDateFormat     = regexp.MustCompile(`(?i)[ydhs]|mm?:|:mm?`)
+)
+
+// xlsxDateCell reports whether a cell's number format shows a date or time
+func xlsxDateCell(workbook *excelize.File, sheet string, col, row int) bool {
+	cell, err := excelize.CoordinatesToCellName(col, row)
+	if err != nil {
+		return false
+	}
+	styleID, err := workbook.GetCellStyle(sheet, cell)
+	if err != nil || styleID == 0 {
+		return false
+	}
+	style, err := workbook.GetStyle(styleID)
+	if err != nil {
+		return false
+	}
+	if style.CustomNumFmt != nil {
+		return xlsxDateFormat.MatchString(xlsxFormatLiterals.ReplaceAllString(*style.CustomNumFmt, ""))
+	}
+	// Built-in date and time formats
+	return (style.NumFmt >= 14 && style.NumFmt <= 22) || (style.NumFmt >= 45 && style.NumFmt <= 47)
+}
+
+// ReadJSONSheet reads a JSON array of order objects, or NDJSON with one object per line
+// The headers are the keys found across all objects; rows are numbered by object
+func ReadJSONSheet(r io.Reader) (ImportSheet, error) {
+	var sheet ImportSheet
+	reader := bufio.NewReader(r)
+	first, err := firstNonSpace(reader)
+	if err == io.EOF {
+		return sheet, nil
+	}
+	if err != nil {
+		return sheet, fmt.Errorf("invalid JSON: %w", err)
+	}
+
+	var objects []map[string]interface{}
+	decoder := json.NewDecoder(reader)
+	decoder.UseNumber()
+	if first == '[' {
+		if err := decoder.Decode(&objects); err != nil {
+			return sheet, fmt.Errorf("invalid JSON: %w", err)
+		}
+	} else {
+		for {
+			var object map[string]interface{}
+			if err := decoder.Decode(&object); err == io.EOF {
+				break
+			} else if err != nil {
+				return sheet, fmt.Errorf("invalid NDJSON at object %d: %w", len(objects)+1, err)
+			}
+			objects = append(objects, object)
+		}
+	}
+
+	columns := make(map[string]int)
+	for _, object := range objects {
+		for key := range object {
+			if _, ok := columns[key]; !ok {
+				columns[key] = len(sheet.Headers)
+				sheet.Headers = append(sheet.Headers, key)
+			}
+		}
+	}
+	sort.Strings(sheet.Headers)
+	for i, header := range sheet.Headers {
+		columns[header] = i
+	}
+
+	for i, object := range objects {
+		values := make([]string, len(sheet.Headers))
+		for key, value := range object {
+			values[columns[key]] = jsonCellValue(value)
+		}
+		sheet.Rows = append(sheet.Rows, ImportRow{Line: i + 1, Values: values})
+	}
+	return sheet, nil
+}
+
+// firstNonSpace peeks at the first byte that is not white space
+func firstNonSpace(reader *bufio.Reader) (byte, error) {
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if b != ' ' && b != '\t' && b != '\r' && b != '\n' {
+			return b, reader.UnreadByte()
+		}
+	}
+}
+
+// jsonCellValue turns a JSON value into the text of a cell; nested values keep their JSON
+func jsonCellValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		encoded, _ := json.Marshal(v)
+		return string(encoded)
+	}
+}
+
+// importTemplateExample is the example row of the import templates
+var importTemplateExample = map[string]string{
+	"order_number":     "",
+	"customer_code":    "CUST-001",
+	"customer_id":      "",
+	"pickup_address":   "",
+	"pickup_latitude":  "",
+	"pickup_longitude": "",
+	"pickup_time":      "",
+	"delivery_address": "123 Example Road",
+	"delivery_time":    "2026-01-15 09:00",
+	"required_by":      "2026-01-15 17:00",
+	"weight_kg":        "25.5",
+	"volume_m3":        "0.4",
+	"items":            "3",
+	"priority":         "normal",
+	"notes":            "Leave at reception",
+}
+
+// ImportTemplate builds an import file of the given format with every order field as a
+// column and one example row, returning its content type and file name
+func ImportTemplate(format string) ([]byte, string, string, error) {
+	example := make([]string, len(OrderImportFields))
+	for i, field := range OrderImportFields {
+		example[i] = importTemplateExample[field]
+	}
+
+	var buf bytes.Buffer
+	switch format {
+	case ImportFormatCSV:
+		writer := csv.NewWriter(&buf)
+		writer.Write(OrderImportFields)
+		writer.Write(example)
+		writer.Flush()
+		return buf.Bytes(), "text/csv", "orders_template.csv", writer.Error()
+
+	case ImportFormatXLSX:
+		workbook := excelize.NewFile()
+		defer workbook.Close()
+		sheet := "Orders"
+		if err := workbook.SetSheetName("Sheet1", sheet); err != nil {
+			return nil, "", "", err
+		}
+		if err := workbook.SetSheetRow(sheet, "A1", &OrderImportFields); err != nil {
+			return nil, "", "", err
+		}
+		if err := workbook.SetSheetRow(sheet, "A2", &example); err != nil {
+			return nil, "", "", err
+		}
+		if _, err := workbook.WriteTo(&buf); err != nil {
+			return nil, "", "", err
+		}
+		return buf.Bytes(), "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet", "orders_template.xlsx", nil
+
+	case ImportFormatJSON, ImportFormatNDJSON:
+		object := make(map[string]string, len(OrderImportFields))
+		for i, field := range OrderImportFields {
+			object[field] = example[i]
+		}
+		if format == ImportFormatNDJSON {
+			err := json.NewEncoder(&buf).Encode(object)
+			return buf.Bytes(), "application/x-ndjson", "orders_template.ndjson", err
+		}
+		encoded, err := json.MarshalIndent([]map[string]string{object}, "", "  ")
+		return encoded, "application/json", "orders_template.json", err
+
+	default:
+		return nil, "", "", fmt.Errorf("%w: %s", ErrImportFormat, format)
+	}
+}
@@ -0,0 +1,115 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ai-tms/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrInvalidOrderTransition is returned for an order status change the lifecycle does not allow
+var ErrInvalidOrderTransition = errors.New("invalid order status transition")
+
+// Sources of order status changes, recorded in the status history
+const (
+	OrderStatusSourceAPI       = "api"
+	OrderStatusSourcePlanning  = "planning"
+	OrderStatusSourceReplan    = "replan"
+	OrderStatusSourceInsertion = "insertion"
+	OrderStatusSourceDriver    = "driver"
+	OrderStatusSourcePOD       = "pod"
+)
+
+// OrderTransitionError is an order status change the lifecycle refused, with the statuses the
+// order could move to instead
+type OrderTransitionError struct {
+	OrderNumber string
+	From        OrderStatus
+	To          OrderStatus
+	Allowed     []OrderStatus
+}
+
+func (e *OrderTransitionError) Error() string {
+	return fmt.Sprintf("order %s: %v from %s to %s", e.OrderNumber, ErrInvalidOrderTransition, e.From, e.To)
+}
+
+// Unwrap lets callers match the error with errors.Is(err, ErrInvalidOrderTransition)
+func (e *OrderTransitionError) Unwrap() error {
+	return ErrInvalidOrderTransition
+}
+
+// OrderStatusChange says who changed an order's status, why and through which channel
+type OrderStatusChange struct {
+	ChangedBy *uuid.UUID
+	Reason    string
+	Source    string
+}
+
+// OrderStatusService is the single place order statuses change: it validates each change
+// against the order lifecycle and records it in the order status history
+type OrderStatusService struct {
+	states *OrderStateMachine
+}
+
+// NewOrderStatusService creates a new order status service
+func NewOrderStatusService() *OrderStatusService {
+	return &OrderStatusService{
+		states: NewOrderStateMachine(),
+	}
+}
+
+// NextStates returns the statuses an order can move to from its current status
+func (s *OrderStatusService) NextStates(order models.Order) []OrderStatus {
+	return s.states.NextStates(OrderStatus(order.Status))
+}
+
+// CanTransition reports whether an order may move to a status from its current one
+func (s *OrderStatusService) CanTransition(order models.Order, to OrderStatus) bool {
+	return s.states.CanTransition(OrderStatus(order.Status), to)
+}
+
+// Transition moves an order to a new status within a transaction and records the change
+func (s *OrderStatusService) Transition(tx *gorm.DB, order *models.Order, to OrderStatus, change OrderStatusChange) error {
+	from := OrderStatus(order.Status)
+	if !s.states.CanTransition(from, to) {
+		return &OrderTransitionError{OrderNumber: order.OrderNumber, From: from, To: to, Allowed: s.states.NextStates(from)}
+	}
+
+	if err := tx.Model(order).Update("status", string(to)).Error; err != nil {
+		return fmt.Errorf("failed to update order %s: %w", order.OrderNumber, err)
+	}
+	order.Status = string(to)
+
+	source := change.Source
+	if source == "" {
+		source = OrderStatusSourceAPI
+	}
+	history := models.OrderStatusHistory{
+		ID:         uuid.New(),
+		OrderID:    order.ID,
+		FromStatus: string(from),
+		ToStatus:   string(to),
+		Reason:     change.Reason,
+		Source:     source,
+		ChangedBy:  change.ChangedBy,
+	}
+	if err := tx.Create(&history).Error; err != nil {
+		return fmt.Errorf("failed to record status history for order %s: %w", order.OrderNumber, err)
+	}
+	return nil
+}
+
+// TransitionByID loads an order for update within a transaction and moves it to a new status
+func (s *OrderStatusService) TransitionByID(tx *gorm.DB, orderID uuid.UUID, to OrderStatus, change OrderStatusChange) (*models.Order, error) {
+	var order models.Order
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, "id = ?", orderID).Error; err != nil {
+		return nil, ErrOrderNotFound
+	}
+	if err := s.Transition(tx, &order, to, change); err != nil {
+		return &order, err
+	}
+	return &order, nil
+}
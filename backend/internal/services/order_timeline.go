@@ -0,0 +1,272 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/ai-tms/backend/internal/database"
+	"github.com/ai-tms/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+// Timeline event types
+const (
+	TimelineOrderCreated  = "order_created"
+	TimelineStatusChanged = "status_changed"
+	TimelineStopArrived   = "stop_arrived"
+	TimelineStopDeparted  = "stop_departed"
+	TimelinePODSubmitted  = "pod_submitted"
+	TimelineAttemptFailed = "attempt_failed"
+	TimelineReassigned    = "reassigned"
+	TimelineAlert         = "alert"
+)
+
+// TimelineEvent is one step in an order's history
+// Internal events, and the actors and reasons behind them, are for staff only
+type TimelineEvent struct {
+	Time     time.Time  `json:"time"`
+	Type     string     `json:"type"`
+	Title    string     `json:"title"`
+	Status   string     `json:"status,omitempty"`
+	Detail   string     `json:"detail,omitempty"`
+	Source   string     `json:"source,omitempty"`
+	ActorID  *uuid.UUID `json:"actor_id,omitempty"`
+	RouteID  *uuid.UUID `json:"route_id,omitempty"`
+	StopID   *uuid.UUID `json:"stop_id,omitempty"`
+	Internal bool       `json:"internal"`
+}
+
+// OrderHistory gathers everything recorded about an order
+type OrderHistory struct {
+	Order         models.Order
+	Statuses      []models.OrderStatusHistory
+	Stops         []models.RouteStop // including stops since removed from their route
+	PODs          []models.ProofOfDelivery
+	Attempts      []models.DeliveryAttempt
+	Reassignments []models.ReassignmentLog
+	Alerts        []models.Alert // raised on the order's routes
+}
+
+// OrderTimelineService builds the status timeline of an order
+type OrderTimelineService struct{}
+
+// NewOrderTimelineService creates a new order timeline service
+func NewOrderTimelineService() *OrderTimelineService {
+	return &OrderTimelineService{}
+}
+
+// Timeline loads an order's history and returns its events, oldest first
+func (s *OrderTimelineService) Timeline(orderID uuid.UUID) ([]TimelineEvent, error) {
+	history, err := s.load(orderID)
+	if err != nil {
+		return nil, err
+	}
+	return BuildOrderTimeline(history), nil
+}
+
+// load reads the order's status history, stops, PODs, delivery attempts, reassignments and route alerts
+func (s *OrderTimelineService) load(orderID uuid.UUID) (OrderHistory, error) {
+	var history OrderHistory
+	if err := database.DB.First(&history.Order, "id = ?", orderID).Error; err != nil {
+		return history, ErrOrderNotFound
+	}
+
+	if err := database.DB.Where("order_id = ?", orderID).Order("created_at").
+		Find(&history.Statuses).Error; err != nil {
+		return history, fmt.Errorf("failed to load status history: %w", err)
+	}
+	if err := database.DB.Unscoped().Where("order_id = ?", orderID).
+		Find(&history.Stops).Error; err != nil {
+		return history, fmt.Errorf("failed to load route stops: %w", err)
+	}
+	if len(history.Stops) == 0 {
+		return history, nil
+	}
+
+	stopIDs := make([]uuid.UUID, 0, len(history.Stops))
+	routeIDs := make([]uuid.UUID, 0, len(history.Stops))
+	for _, stop := range history.Stops {
+		stopIDs = append(stopIDs, stop.ID)
+		routeIDs = append(routeIDs, stop.RouteID)
+	}
+	if err := database.DB.Where("route_stop_id IN ?", stopIDs).Find(&history.PODs).Error; err != nil {
+		return history, fmt.Errorf("failed to load proofs of delivery: %w", err)
+	}
+	if err := database.DB.Where("order_id = ?", orderID).Find(&history.Attempts).Error; err != nil {
+		return history, fmt.Errorf("failed to load delivery attempts: %w", err)
+	}
+	if err := database.DB.Preload("Route").Where("route_stop_id IN ?", stopIDs).
+		Find(&history.Reassignments).Error; err != nil {
+		return history, fmt.Errorf("failed to load reassignments: %w", err)
+	}
+	if err := database.DB.Where("route_id IN ?", routeIDs).Find(&history.Alerts).Error; err != nil {
+		return history, fmt.Errorf("failed to load alerts: %w", err)
+	}
+	return history, nil
+}
+
+// BuildOrderTimeline merges an order's history into a single list of events, oldest first
+// Route alerts only count while the order was on the route: from its stop being planned until
+// the stop was left
+func BuildOrderTimeline(history OrderHistory) []TimelineEvent {
+	order := history.Order
+	events := []TimelineEvent{{
+		Time:   order.CreatedAt,
+		Type:   TimelineOrderCreated,
+		Title:  "Order " + order.OrderNumber + " created",
+		Status: string(OrderStatusPending),
+	}}
+
+	for _, change := range history.Statuses {
+		events = append(events, TimelineEvent{
+			Time:    change.CreatedAt,
+			Type:    TimelineStatusChanged,
+			Title:   fmt.Sprintf("Status changed from %s to %s", change.FromStatus, change.ToStatus),
+			Status:  change.ToStatus,
+			Detail:  change.Reason,
+			Source:  change.Source,
+			ActorID: change.ChangedBy,
+		})
+	}
+
+	stops := make(map[uuid.UUID]models.RouteStop, len(history.Stops))
+	for _, stop := range history.Stops {
+		stops[stop.ID] = stop
+		if stop.ActualArrival != nil {
+			events = append(events, TimelineEvent{
+				Time:    *stop.ActualArrival,
+				Type:    TimelineStopArrived,
+				Title:   "Driver arrived at " + stopPlace(stop),
+				RouteID: &stop.RouteID,
+				StopID:  &stop.ID,
+			})
+		}
+		if stop.ActualDeparture != nil {
+			events = append(events, TimelineEvent{
+				Time:    *stop.ActualDeparture,
+				Type:    TimelineStopDeparted,
+				Title:   "Driver left " + stopPlace(stop),
+				Status:  stop.Status,
+				RouteID: &stop.RouteID,
+				StopID:  &stop.ID,
+			})
+		}
+	}
+
+	for _, pod := range history.PODs {
+		stop := stops[pod.RouteStopID]
+		events = append(events, TimelineEvent{
+			Time:    pod.Timestamp,
+			Type:    TimelinePODSubmitted,
+			Title:   "Proof of delivery received from " + pod.RecipientName,
+			Detail:  pod.Notes,
+			RouteID: &stop.RouteID,
+			StopID:  &stop.ID,
+		})
+	}
+
+	for _, attempt := range history.Attempts {
+		title := fmt.Sprintf("Delivery attempt %d failed", attempt.Attempt)
+		if reason, ok := FailureReasons[attempt.ReasonCode]; ok {
+			title += ": " + reason.Label
+		}
+		detail := "Returning to depot"
+		if attempt.RetryOn != nil {
+			detail = "Rescheduled for " + attempt.RetryOn.Format("2006-01-02")
+		}
+		events = append(events, TimelineEvent{
+			Time:    attempt.CreatedAt,
+			Type:    TimelineAttemptFailed,
+			Title:   title,
+			Detail:  detail,
+			ActorID: attempt.RecordedBy,
+			RouteID: &attempt.RouteID,
+			StopID:  &attempt.RouteStopID,
+		})
+	}
+
+	for _, reassignment := range history.Reassignments {
+		title := "Moved to another route"
+		if reassignment.Route != nil {
+			title = "Moved to route " + reassignment.Route.RouteNumber
+		}
+		events = append(events, TimelineEvent{
+			Time:     reassignment.CreatedAt,
+			Type:     TimelineReassigned,
+			Title:    title,
+			Detail:   reassignment.Reason,
+			ActorID:  &reassignment.ReassignedBy,
+			RouteID:  reassignment.RouteID,
+			StopID:   reassignment.RouteStopID,
+			Internal: true,
+		})
+	}
+
+	for _, alert := range history.Alerts {
+		if alert.RouteID == nil || !onRouteAt(history.Stops, *alert.RouteID, alert.CreatedAt) {
+			continue
+		}
+		events = append(events, TimelineEvent{
+			Time:     alert.CreatedAt,
+			Type:     TimelineAlert,
+			Title:    alert.Title,
+			Detail:   alert.Message,
+			Source:   alert.Type,
+			RouteID:  alert.RouteID,
+			Internal: true,
+		})
+	}
+
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time.Before(events[j].Time)
+	})
+	return events
+}
+
+// PublicTimeline keeps the events a customer may see, without staff or recipient details
+func PublicTimeline(events []TimelineEvent) []TimelineEvent {
+	public := make([]TimelineEvent, 0, len(events))
+	for _, event := range events {
+		if event.Internal {
+			continue
+		}
+		event.ActorID, event.RouteID, event.StopID = nil, nil, nil
+		event.Source = ""
+		switch event.Type {
+		case TimelineStatusChanged:
+			event.Detail = ""
+		case TimelinePODSubmitted:
+			// Who signed and the driver's notes are for staff only
+			event.Title = "Proof of delivery received"
+			event.Detail = ""
+		}
+		public = append(public, event)
+	}
+	return public
+}
+
+// onRouteAt reports whether one of the order's stops was on a route at a time
+func onRouteAt(stops []models.RouteStop, routeID uuid.UUID, at time.Time) bool {
+	for _, stop := range stops {
+		if stop.RouteID != routeID || at.Before(stop.CreatedAt) {
+			continue
+		}
+		if stop.ActualDeparture != nil && at.After(*stop.ActualDeparture) {
+			continue
+		}
+		if stop.DeletedAt.Valid && at.After(stop.DeletedAt.Time) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// stopPlace names a stop for the timeline
+func stopPlace(stop models.RouteStop) string {
+	if stop.StopType == StopTypePickup {
+		return "pickup"
+	}
+	return "delivery address"
+}
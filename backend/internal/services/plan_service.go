@@ -0,0 +1,266 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ai-tms/backend/internal/database"
+	"github.com/ai-tms/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ErrOrderNotPlannable is returned when a plan contains an order that cannot be assigned
+var ErrOrderNotPlannable = errors.New("order cannot be assigned")
+
+// PlanService persists optimized plans
+type PlanService struct {
+	orderStatuses *OrderStatusService
+}
+
+// NewPlanService creates a new plan service
+func NewPlanService() *PlanService {
+	return &PlanService{
+		orderStatuses: NewOrderStatusService(),
+	}
+}
+
+// SavedRoute is a stored route with its stops in visiting order
+type SavedRoute struct {
+	Route models.Route
+	Stops []models.RouteStop
+}
+
+// SavePlan stores the routes of an optimization result in a single transaction
+// Planned routes move their orders to assigned; any failure, including an order that is no
+// longer pending, rolls the whole plan back
+func (s *PlanService) SavePlan(result *OptimizationResult, planStart time.Time, status RouteStatus, changedBy *uuid.UUID) ([]SavedRoute, error) {
+	saved := make([]SavedRoute, 0, len(result.Routes))
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		date := time.Date(planStart.Year(), planStart.Month(), planStart.Day(), 0, 0, 0, 0, planStart.Location())
+		orderIDs := make([]uuid.UUID, 0)
+		seen := make(map[uuid.UUID]bool)
+
+		for _, planned := range result.Routes {
+			route, err := newPlannedRoute(planned, date, status)
+			if err != nil {
+				return err
+			}
+			if err := tx.Create(&route).Error; err != nil {
+				return fmt.Errorf("failed to save route: %w", err)
+			}
+
+			stops, err := newPlannedStops(route.ID, planned.Stops, 1)
+			if err != nil {
+				return err
+			}
+			for _, stop := range stops {
+				if !seen[stop.OrderID] {
+					seen[stop.OrderID] = true
+					orderIDs = append(orderIDs, stop.OrderID)
+				}
+			}
+			if len(stops) > 0 {
+				if err := tx.Create(&stops).Error; err != nil {
+					return fmt.Errorf("failed to save route stops: %w", err)
+				}
+			}
+
+			saved = append(saved, SavedRoute{Route: route, Stops: stops})
+		}
+
+		if status == RouteStatusDraft {
+			return nil
+		}
+		return s.assignOrders(tx, orderIDs, OrderStatusChange{ChangedBy: changedBy, Source: OrderStatusSourcePlanning})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return saved, nil
+}
+
+// newPlannedRoute builds the route record of a planned route on the given date
+func newPlannedRoute(planned RouteResult, date time.Time, status RouteStatus) (models.Route, error) {
+	vehicleID, err := uuid.Parse(planned.VehicleID)
+	if err != nil {
+		return models.Route{}, fmt.Errorf("invalid vehicle ID %q: %w", planned.VehicleID, err)
+	}
+	depotID, err := uuid.Parse(planned.StartDepotID)
+	if err != nil {
+		return models.Route{}, fmt.Errorf("invalid depot ID %q: %w", planned.StartDepotID, err)
+	}
+
+	id := uuid.New()
+	return models.Route{
+		ID:               id,
+		RouteNumber:      "R-" + id.String()[:8],
+		Date:             date,
+		VehicleID:        vehicleID,
+		DepotID:          depotID,
+		PlannedStartTime: planned.ShiftStart,
+		PlannedEndTime:   planned.EndTime,
+		TotalDistanceKm:  planned.TotalDistance,
+		TotalDurationMin: int(planned.TotalDuration.Minutes()),
+		TotalStops:       len(planned.Stops),
+		EstimatedCost:    planned.TotalCost,
+		Status:           string(status),
+	}, nil
+}
+
+// newPlannedStops builds the pending stop records of a planned route, numbered from first
+func newPlannedStops(routeID uuid.UUID, planned []RouteStop, first int) ([]models.RouteStop, error) {
+	stops := make([]models.RouteStop, 0, len(planned))
+	for i, stop := range planned {
+		orderID, err := uuid.Parse(stop.OrderID)
+		if err != nil {
+			return nil, fmt.Errorf("invalid order ID %q: %w", stop.OrderID, err)
+		}
+		stops = append(stops, models.RouteStop{
+			ID:                  uuid.New(),
+			RouteID:             routeID,
+			OrderID:             orderID,
+			Sequence:            first + i,
+			StopType:            stop.StopType,
+			PlannedArrival:      stop.ArrivalTime,
+			PlannedDeparture:    stop.DepartureTime,
+			DistanceFromPrevKm:  stop.Distance,
+			DurationFromPrevMin: int(stop.TravelTime.Minutes()),
+			Status:              string(StopStatusPending),
+		})
+	}
+	return stops, nil
+}
+
+// writeRouteSequence stores a timed sequence of a route's open stops after its finished ones
+// Stops already on the route keep their records and get the new position and times; stops for
+// orders new to the route are created. It returns the stops in their new order
+func writeRouteSequence(tx *gorm.DB, route models.Route, open []models.RouteStop, planned RouteResult) ([]models.RouteStop, error) {
+	var finished int
+	if err := tx.Model(&models.RouteStop{}).
+		Where("route_id = ? AND status <> ?", route.ID, string(StopStatusPending)).
+		Select("COALESCE(MAX(sequence), 0)").Scan(&finished).Error; err != nil {
+		return nil, fmt.Errorf("failed to load route stops: %w", err)
+	}
+
+	existing := make(map[string]models.RouteStop, len(open))
+	for _, stop := range open {
+		existing[stopKey(stop.OrderID.String(), stop.StopType)] = stop
+	}
+
+	stops := make([]models.RouteStop, 0, len(planned.Stops))
+	for i, plannedStop := range planned.Stops {
+		sequence := finished + 1 + i
+		stop, ok := existing[stopKey(plannedStop.OrderID, plannedStop.StopType)]
+		if !ok {
+			created, err := newPlannedStops(route.ID, []RouteStop{plannedStop}, sequence)
+			if err != nil {
+				return nil, err
+			}
+			if err := tx.Create(&created).Error; err != nil {
+				return nil, fmt.Errorf("failed to save route stop: %w", err)
+			}
+			stops = append(stops, created[0])
+			continue
+		}
+
+		stop.Sequence = sequence
+		stop.PlannedArrival = plannedStop.ArrivalTime
+		stop.PlannedDeparture = plannedStop.DepartureTime
+		stop.DistanceFromPrevKm = plannedStop.Distance
+		stop.DurationFromPrevMin = int(plannedStop.TravelTime.Minutes())
+		if err := tx.Model(&stop).Updates(map[string]interface{}{
+			"sequence":               stop.Sequence,
+			"planned_arrival":        stop.PlannedArrival,
+			"planned_departure":      stop.PlannedDeparture,
+			"distance_from_prev_km":  stop.DistanceFromPrevKm,
+			"duration_from_prev_min": stop.DurationFromPrevMin,
+		}).Error; err != nil {
+			return nil, fmt.Errorf("failed to update route stop: %w", err)
+		}
+		stops = append(stops, stop)
+	}
+	return stops, nil
+}
+
+// refreshRouteTotals recomputes a route's stop count, distance and planned end from its stops
+// It returns how many stops are left to visit
+func refreshRouteTotals(tx *gorm.DB, route models.Route) (int, error) {
+	var stops []models.RouteStop
+	if err := tx.Where("route_id = ?", route.ID).Order("sequence").Find(&stops).Error; err != nil {
+		return 0, fmt.Errorf("failed to load route stops: %w", err)
+	}
+
+	distance := 0.0
+	open := 0
+	for _, stop := range stops {
+		distance += stop.DistanceFromPrevKm
+		if stop.Status == string(StopStatusPending) {
+			open++
+		}
+	}
+
+	updates := map[string]interface{}{
+		"total_stops":       len(stops),
+		"total_distance_km": distance,
+	}
+	if len(stops) > 0 && !stops[len(stops)-1].PlannedDeparture.IsZero() {
+		end := stops[len(stops)-1].PlannedDeparture
+		updates["planned_end_time"] = end
+		updates["total_duration_min"] = int(end.Sub(route.PlannedStartTime).Minutes())
+	}
+	if err := tx.Model(&route).Updates(updates).Error; err != nil {
+		return 0, fmt.Errorf("failed to update route %s: %w", route.RouteNumber, err)
+	}
+	return open, nil
+}
+
+// CommitDraft promotes a draft route to planned within a transaction, assigning the orders
+// on its stops; like a saved plan it fails with ErrOrderNotPlannable when one of them is no
+// longer pending. Extra column updates are written along with the status
+func (s *PlanService) CommitDraft(tx *gorm.DB, route *models.Route, updates map[string]interface{}, change OrderStatusChange) error {
+	if err := NewRouteStateMachine().ValidateTransition(RouteStatus(route.Status), RouteStatusPlanned); err != nil {
+		return err
+	}
+
+	var orderIDs []uuid.UUID
+	if err := tx.Model(&models.RouteStop{}).Where("route_id = ?", route.ID).
+		Distinct("order_id").Pluck("order_id", &orderIDs).Error; err != nil {
+		return fmt.Errorf("failed to load route stops: %w", err)
+	}
+	if err := s.assignOrders(tx, orderIDs, change); err != nil {
+		return err
+	}
+	return TransitionRoute(tx, route, RouteStatusPlanned, updates)
+}
+
+// assignOrders moves the planned orders to assigned, locking them against concurrent planning
+func (s *PlanService) assignOrders(tx *gorm.DB, orderIDs []uuid.UUID, change OrderStatusChange) error {
+	if len(orderIDs) == 0 {
+		return nil
+	}
+
+	var orders []models.Order
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id IN ?", orderIDs).Find(&orders).Error; err != nil {
+		return fmt.Errorf("failed to load orders: %w", err)
+	}
+	if len(orders) != len(orderIDs) {
+		return fmt.Errorf("%w: %d of %d orders no longer exist", ErrOrderNotPlannable, len(orderIDs)-len(orders), len(orderIDs))
+	}
+
+	for i := range orders {
+		err := s.orderStatuses.Transition(tx, &orders[i], OrderStatusAssigned, change)
+		if errors.Is(err, ErrInvalidOrderTransition) {
+			return fmt.Errorf("%w: %v", ErrOrderNotPlannable, err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
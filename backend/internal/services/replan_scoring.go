@@ -0,0 +1,140 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/ai-tms/backend/internal/models"
+	"github.com/google/uuid"
+)
+
+// DefaultScoringProfile weighs the objectives when no stored profile applies
+var DefaultScoringProfile = models.ScoringProfile{
+	Name:         "default",
+	CostWeight:   0.3,
+	LateWeight:   0.5,
+	ChangeWeight: 0.2,
+}
+
+// ErrInvalidScoringProfile is returned for a profile whose weights cannot rank alternatives
+var ErrInvalidScoringProfile = errors.New("invalid scoring profile")
+
+// ValidateScoringProfile checks that a profile's weights are non-negative and not all zero
+func ValidateScoringProfile(profile models.ScoringProfile) error {
+	if profile.CostWeight < 0 || profile.LateWeight < 0 || profile.ChangeWeight < 0 {
+		return fmt.Errorf("%w: weights cannot be negative", ErrInvalidScoringProfile)
+	}
+	if profile.CostWeight+profile.LateWeight+profile.ChangeWeight == 0 {
+		return fmt.Errorf("%w: at least one weight must be positive", ErrInvalidScoringProfile)
+	}
+	return nil
+}
+
+// ResolveScoringProfile picks the profile for a depot and customer tier, the most specific
+// match winning: depot and tier, then tier, then depot, then the stored or built-in default
+func ResolveScoringProfile(profiles []models.ScoringProfile, depotID uuid.UUID, tier string) models.ScoringProfile {
+	best, bestRank := DefaultScoringProfile, -1
+	for _, profile := range profiles {
+		depotMatch := profile.DepotID != nil && *profile.DepotID == depotID
+		tierMatch := profile.CustomerTier != "" && profile.CustomerTier == tier
+		if (profile.DepotID != nil && !depotMatch) || (profile.CustomerTier != "" && !tierMatch) {
+			continue
+		}
+
+		rank := 0
+		if tierMatch {
+			rank += 2
+		}
+		if depotMatch {
+			rank++
+		}
+		if rank > bestRank {
+			best, bestRank = profile, rank
+		}
+	}
+	return best
+}
+
+// ObjectiveScore is one objective's share of an alternative's score
+type ObjectiveScore struct {
+	Value      float64 `json:"value"`      // Cost, late deliveries or changed stops
+	Baseline   float64 `json:"baseline"`   // The same under the current plan
+	Normalized float64 `json:"normalized"` // Value relative to the baseline
+	Weight     float64 `json:"weight"`     // Profile weight, relative to the sum of weights
+	Score      float64 `json:"score"`      // Weight times the normalized value
+}
+
+// ScoreBreakdown explains an alternative's score per objective, lower being better
+type ScoreBreakdown struct {
+	Profile string         `json:"profile"`
+	Cost    ObjectiveScore `json:"cost"`
+	Late    ObjectiveScore `json:"late"`
+	Changes ObjectiveScore `json:"changes"`
+}
+
+// scoreBaseline is the current plan alternatives are measured against
+type scoreBaseline struct {
+	cost  float64 // Cost of carrying on with the current routes
+	late  int     // Late deliveries if nothing changes, orders left undelivered included
+	stops int     // Stops the event reopens, the most an alternative can change
+}
+
+// objective scores a value against its baseline
+// Values are divided by the baseline, so an alternative matching the current plan scores its
+// weight; a zero baseline counts each unit as a whole baseline
+func objective(value, baseline, weight float64) ObjectiveScore {
+	reference := baseline
+	if reference <= 0 {
+		reference = 1
+	}
+	normalized := value / reference
+	return ObjectiveScore{
+		Value:      value,
+		Baseline:   baseline,
+		Normalized: normalized,
+		Weight:     weight,
+		Score:      weight * normalized,
+	}
+}
+
+// scoreAlternative weighs an alternative's objectives relative to the current plan
+func scoreAlternative(alt Alternative, profile models.ScoringProfile, baseline scoreBaseline) (float64, ScoreBreakdown) {
+	total := profile.CostWeight + profile.LateWeight + profile.ChangeWeight
+	if total <= 0 {
+		profile, total = DefaultScoringProfile, 1
+	}
+
+	breakdown := ScoreBreakdown{
+		Profile: profile.Name,
+		Cost:    objective(alt.TotalCost, baseline.cost, profile.CostWeight/total),
+		Late:    objective(float64(alt.LateDeliveries), float64(baseline.late), profile.LateWeight/total),
+		Changes: objective(float64(alt.ChangedStops), float64(baseline.stops), profile.ChangeWeight/total),
+	}
+	return breakdown.Cost.Score + breakdown.Late.Score + breakdown.Changes.Score, breakdown
+}
+
+// scopeTier returns the most common customer tier among the orders an event reopens
+func scopeTier(orders []models.Order) string {
+	counts := make(map[string]int)
+	for _, order := range orders {
+		if order.Customer != nil && order.Customer.Tier != "" {
+			counts[order.Customer.Tier]++
+		}
+	}
+
+	tiers := make([]string, 0, len(counts))
+	for tier := range counts {
+		tiers = append(tiers, tier)
+	}
+	sort.Slice(tiers, func(i, j int) bool {
+		if counts[tiers[i]] != counts[tiers[j]] {
+			return counts[tiers[i]] > counts[tiers[j]]
+		}
+		return tiers[i] < tiers[j]
+	})
+	if len(tiers) == 0 {
+		return ""
+	}
+	return tiers[0]
+}
@@ -0,0 +1,430 @@
+package services
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ai-tms/backend/internal/database"
+	"github.com/ai-tms/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ReplanRouteStatuses are the statuses of routes that replanning may change
+var ReplanRouteStatuses = []string{string(RouteStatusAssigned), string(RouteStatusDispatched), string(RouteStatusInProgress)}
+
+// replanProposalTTL is how long generated alternatives can be applied
+const replanProposalTTL = 15 * time.Minute
+
+var (
+	// ErrReplanNotFound is returned for an unknown replan proposal
+	ErrReplanNotFound = errors.New("replan proposal not found")
+
+	// ErrReplanExpired is returned when applying alternatives that are too old
+	ErrReplanExpired = errors.New("replan proposal expired")
+
+	// ErrReplanApplied is returned when a proposal already had an alternative applied
+	ErrReplanApplied = errors.New("replan proposal already applied")
+
+	// ErrAlternativeNotFound is returned for an alternative the proposal does not have
+	ErrAlternativeNotFound = errors.New("alternative not found")
+
+	// ErrReplanStale is returned when stops an alternative replaces changed since it was generated
+	ErrReplanStale = errors.New("plan changed since the alternatives were generated")
+)
+
+// ReplanService stores replanning alternatives and applies them to the current routes
+type ReplanService struct {
+	orderStatuses *OrderStatusService
+	plans         *PlanService
+	overrideLock  bool
+}
+
+// NewReplanService creates a new replan service
+func NewReplanService() *ReplanService {
+	return &ReplanService{
+		orderStatuses: NewOrderStatusService(),
+		plans:         NewPlanService(),
+	}
+}
+
+// SetLockOverride lets applied alternatives change locked routes
+func (s *ReplanService) SetLockOverride(override bool) *ReplanService {
+	s.overrideLock = override
+	return s
+}
+
+// ChangedRoute is a route an applied alternative rewrote
+type ChangedRoute struct {
+	Route     models.Route
+	OpenStops int  // Stops left to visit after the change
+	Created   bool // The route was created for the alternative
+}
+
+// AppliedReplan is the outcome of applying an alternative
+type AppliedReplan struct {
+	Proposal    models.ReplanProposal
+	Event       ReplanEvent
+	Alternative Alternative
+	Routes      []ChangedRoute
+	Unrouted    []string // Orders the alternative left without a route, back to pending
+}
+
+// Propose stores generated alternatives so that one of them can be applied before they expire
+func (s *ReplanService) Propose(event ReplanEvent, alternatives []Alternative, createdBy *uuid.UUID) (*models.ReplanProposal, error) {
+	eventJSON, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode replan event: %w", err)
+	}
+	alternativesJSON, err := json.Marshal(alternatives)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode alternatives: %w", err)
+	}
+
+	proposal := models.ReplanProposal{
+		ID:           uuid.New(),
+		EventType:    event.Type,
+		Event:        string(eventJSON),
+		Alternatives: string(alternativesJSON),
+		ExpiresAt:    time.Now().Add(replanProposalTTL),
+		CreatedBy:    createdBy,
+	}
+	if err := database.DB.Create(&proposal).Error; err != nil {
+		return nil, fmt.Errorf("failed to save replan proposal: %w", err)
+	}
+	return &proposal, nil
+}
+
+// Apply rewrites the current routes with one alternative of a proposal in a single transaction
+// The pending stops the alternative replaces are removed and its routes appended to the
+// vehicles' current routes, or stored as new routes for vehicles without one. Every change
+// gets a published plan version and stops moved between routes a reassignment log entry
+func (s *ReplanService) Apply(proposalID uuid.UUID, alternativeID string, userID uuid.UUID) (*AppliedReplan, error) {
+	applied := &AppliedReplan{}
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		proposal, event, alternative, err := s.lockProposal(tx, proposalID, alternativeID)
+		if err != nil {
+			return err
+		}
+		applied.Event = event
+		applied.Alternative = alternative
+
+		previous, err := s.removeReplaced(tx, alternative.ReplacedStops)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		changed := make(map[uuid.UUID]*ChangedRoute)
+		order := make([]uuid.UUID, 0)
+		touch := func(route models.Route, created bool) {
+			if _, ok := changed[route.ID]; !ok {
+				changed[route.ID] = &ChangedRoute{Route: route, Created: created}
+				order = append(order, route.ID)
+			}
+		}
+		for _, stop := range previous {
+			touch(*stop.Route, false)
+		}
+
+		routed := make(map[string]bool)
+		for _, planned := range alternative.Routes {
+			route, created, err := s.vehicleRoute(tx, planned, now)
+			if err != nil {
+				return err
+			}
+			touch(route, created)
+
+			stops, err := s.appendStops(tx, route, planned)
+			if err != nil {
+				return err
+			}
+
+			for _, stop := range stops {
+				routed[stop.OrderID.String()] = true
+				if from, ok := previous[stopKey(stop.OrderID.String(), stop.StopType)]; ok && from.RouteID != route.ID {
+					if err := s.logReassignment(tx, event, from, route, stop, userID); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		// Orders that were on no route join the plan; reopened orders left out go back to pending
+		change := OrderStatusChange{ChangedBy: &userID, Reason: event.Type, Source: OrderStatusSourceReplan}
+		if err := s.assignRouted(tx, routed, change); err != nil {
+			return err
+		}
+		for _, id := range alternative.Orders {
+			if !routed[id] {
+				applied.Unrouted = append(applied.Unrouted, id)
+			}
+		}
+		if err := s.unassignOrders(tx, applied.Unrouted, change); err != nil {
+			return err
+		}
+
+		for _, id := range order {
+			route := changed[id]
+			if err := CheckRouteUnlocked(route.Route, s.overrideLock); err != nil {
+				return err
+			}
+			open, err := refreshRouteTotals(tx, route.Route)
+			if err != nil {
+				return err
+			}
+			route.OpenStops = open
+			if err := s.createVersion(tx, route.Route, event, alternative, userID, now); err != nil {
+				return err
+			}
+			applied.Routes = append(applied.Routes, *route)
+		}
+
+		proposal.AppliedAlternative = alternative.ID
+		proposal.AppliedBy = &userID
+		proposal.AppliedAt = &now
+		if err := tx.Model(&proposal).Updates(map[string]interface{}{
+			"applied_alternative": proposal.AppliedAlternative,
+			"applied_by":          userID,
+			"applied_at":          now,
+		}).Error; err != nil {
+			return fmt.Errorf("failed to mark replan proposal applied: %w", err)
+		}
+		applied.Proposal = proposal
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return applied, nil
+}
+
+// LastPositions returns the latest GPS position of each vehicle that reported one
+func LastPositions(vehicleIDs []uuid.UUID) map[string]LatLng {
+	positions := make(map[string]LatLng)
+	for _, vehicleID := range vehicleIDs {
+		var tracking models.GPSTracking
+		if err := database.DB.Where("vehicle_id = ?", vehicleID).
+			Order("timestamp DESC").
+			First(&tracking).Error; err == nil {
+			positions[vehicleID.String()] = LatLng{Lat: tracking.Latitude, Lng: tracking.Longitude}
+		}
+	}
+	return positions
+}
+
+// lockProposal loads a proposal for update and picks the alternative to apply
+func (s *ReplanService) lockProposal(tx *gorm.DB, proposalID uuid.UUID, alternativeID string) (models.ReplanProposal, ReplanEvent, Alternative, error) {
+	var proposal models.ReplanProposal
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&proposal, "id = ?", proposalID).Error; err != nil {
+		return proposal, ReplanEvent{}, Alternative{}, ErrReplanNotFound
+	}
+	if proposal.AppliedAt != nil {
+		return proposal, ReplanEvent{}, Alternative{}, ErrReplanApplied
+	}
+	if time.Now().After(proposal.ExpiresAt) {
+		return proposal, ReplanEvent{}, Alternative{}, ErrReplanExpired
+	}
+
+	var event ReplanEvent
+	if err := json.Unmarshal([]byte(proposal.Event), &event); err != nil {
+		return proposal, event, Alternative{}, fmt.Errorf("failed to decode replan event: %w", err)
+	}
+	var alternatives []Alternative
+	if err := json.Unmarshal([]byte(proposal.Alternatives), &alternatives); err != nil {
+		return proposal, event, Alternative{}, fmt.Errorf("failed to decode alternatives: %w", err)
+	}
+	for _, alternative := range alternatives {
+		if alternative.ID == alternativeID {
+			return proposal, event, alternative, nil
+		}
+	}
+	return proposal, event, Alternative{}, ErrAlternativeNotFound
+}
+
+// removeReplaced deletes the stops an alternative replaces, which must all still be pending
+// It returns the removed stops with their routes by stop key
+func (s *ReplanService) removeReplaced(tx *gorm.DB, stopIDs []string) (map[string]models.RouteStop, error) {
+	removed := make(map[string]models.RouteStop, len(stopIDs))
+	if len(stopIDs) == 0 {
+		return removed, nil
+	}
+
+	var stops []models.RouteStop
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Route").
+		Where("id IN ?", stopIDs).Find(&stops).Error; err != nil {
+		return nil, fmt.Errorf("failed to load replaced stops: %w", err)
+	}
+	if len(stops) != len(stopIDs) {
+		return nil, fmt.Errorf("%w: %d stops no longer exist", ErrReplanStale, len(stopIDs)-len(stops))
+	}
+	for _, stop := range stops {
+		if stop.Status != string(StopStatusPending) || stop.Route == nil {
+			return nil, fmt.Errorf("%w: stop %s is %s", ErrReplanStale, stop.ID, stop.Status)
+		}
+		removed[stopKey(stop.OrderID.String(), stop.StopType)] = stop
+	}
+
+	if err := tx.Where("id IN ?", stopIDs).Delete(&models.RouteStop{}).Error; err != nil {
+		return nil, fmt.Errorf("failed to remove replaced stops: %w", err)
+	}
+	return removed, nil
+}
+
+// vehicleRoute returns the active route of a planned route's vehicle for the day, creating one
+// when it has none
+func (s *ReplanService) vehicleRoute(tx *gorm.DB, planned RouteResult, now time.Time) (models.Route, bool, error) {
+	date := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	var route models.Route
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("vehicle_id = ? AND status IN ? AND date >= ? AND date < ?",
+			planned.VehicleID, ActiveRouteStatuses, date, date.AddDate(0, 0, 1)).
+		Order("created_at DESC").First(&route).Error
+	if err == nil {
+		return route, false, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return route, false, fmt.Errorf("failed to load route of vehicle %s: %w", planned.VehicleID, err)
+	}
+
+	route, err = newPlannedRoute(planned, date, RouteStatusPlanned)
+	if err != nil {
+		return route, false, err
+	}
+	var vehicle models.Vehicle
+	if err := tx.First(&vehicle, "id = ?", route.VehicleID).Error; err == nil {
+		route.DriverID = vehicle.CurrentDriverID
+	}
+	if err := tx.Create(&route).Error; err != nil {
+		return route, false, fmt.Errorf("failed to create route: %w", err)
+	}
+	return route, true, nil
+}
+
+// appendStops adds a planned route's stops after the stops the route keeps
+func (s *ReplanService) appendStops(tx *gorm.DB, route models.Route, planned RouteResult) ([]models.RouteStop, error) {
+	var last int
+	if err := tx.Model(&models.RouteStop{}).Where("route_id = ?", route.ID).
+		Select("COALESCE(MAX(sequence), 0)").Scan(&last).Error; err != nil {
+		return nil, fmt.Errorf("failed to load route stops: %w", err)
+	}
+
+	stops, err := newPlannedStops(route.ID, planned.Stops, last+1)
+	if err != nil {
+		return nil, err
+	}
+	if len(stops) > 0 {
+		if err := tx.Create(&stops).Error; err != nil {
+			return nil, fmt.Errorf("failed to save route stops: %w", err)
+		}
+	}
+	return stops, nil
+}
+
+// createVersion records a changed route as a new published version of its plan
+func (s *ReplanService) createVersion(tx *gorm.DB, route models.Route, event ReplanEvent, alternative Alternative, userID uuid.UUID, now time.Time) error {
+	var stops []models.RouteStop
+	if err := tx.Where("route_id = ?", route.ID).Order("sequence").Find(&stops).Error; err != nil {
+		return fmt.Errorf("failed to load route stops: %w", err)
+	}
+	route.Stops = stops
+
+	var maxVersion int
+	if err := tx.Model(&models.PlanVersion{}).Where("plan_id = ?", route.ID).
+		Select("COALESCE(MAX(version), 0)").Scan(&maxVersion).Error; err != nil {
+		return fmt.Errorf("failed to load plan versions: %w", err)
+	}
+
+	snapshotJSON, _ := json.Marshal(route)
+	kpisJSON, _ := json.Marshal(map[string]interface{}{
+		"alternative_id":  alternative.ID,
+		"total_cost":      alternative.TotalCost,
+		"total_distance":  alternative.TotalDistance,
+		"late_deliveries": alternative.LateDeliveries,
+		"changed_stops":   alternative.ChangedStops,
+	})
+
+	reason := event.Type
+	if event.Description != "" {
+		reason += ": " + event.Description
+	}
+	version := models.PlanVersion{
+		PlanID:      route.ID,
+		Version:     maxVersion + 1,
+		Status:      "published",
+		CreatedBy:   userID,
+		PublishedBy: &userID,
+		PublishedAt: &now,
+		Reason:      reason,
+		Snapshot:    string(snapshotJSON),
+		KPIs:        string(kpisJSON),
+	}
+	if err := tx.Create(&version).Error; err != nil {
+		return fmt.Errorf("failed to create plan version: %w", err)
+	}
+	return nil
+}
+
+// logReassignment records a stop moving from one route to another
+func (s *ReplanService) logReassignment(tx *gorm.DB, event ReplanEvent, from models.RouteStop, to models.Route, stop models.RouteStop, userID uuid.UUID) error {
+	entry := models.ReassignmentLog{
+		RouteID:       &to.ID,
+		RouteStopID:   &stop.ID,
+		FromDriverID:  from.Route.DriverID,
+		ToDriverID:    to.DriverID,
+		FromVehicleID: &from.Route.VehicleID,
+		ToVehicleID:   &to.VehicleID,
+		Reason:        event.Type,
+		Notes:         event.Description,
+		ReassignedBy:  userID,
+		CreatedAt:     time.Now(),
+	}
+	if err := tx.Create(&entry).Error; err != nil {
+		return fmt.Errorf("failed to create reassignment log: %w", err)
+	}
+	return nil
+}
+
+// assignRouted assigns the routed orders that were still pending
+func (s *ReplanService) assignRouted(tx *gorm.DB, routed map[string]bool, change OrderStatusChange) error {
+	ids := make([]string, 0, len(routed))
+	for id := range routed {
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	var pending []uuid.UUID
+	if err := tx.Model(&models.Order{}).Where("id IN ? AND status = ?", ids, string(OrderStatusPending)).
+		Pluck("id", &pending).Error; err != nil {
+		return fmt.Errorf("failed to load orders: %w", err)
+	}
+	return s.plans.assignOrders(tx, pending, change)
+}
+
+// unassignOrders moves assigned orders an alternative could not route back to pending
+func (s *ReplanService) unassignOrders(tx *gorm.DB, ids []string, change OrderStatusChange) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	var orders []models.Order
+	if err := tx.Where("id IN ?", ids).Find(&orders).Error; err != nil {
+		return fmt.Errorf("failed to load orders: %w", err)
+	}
+	for i := range orders {
+		if !s.orderStatuses.CanTransition(orders[i], OrderStatusPending) {
+			continue
+		}
+		if err := s.orderStatuses.Transition(tx, &orders[i], OrderStatusPending, change); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,14 +1,44 @@
 package services
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
 	"time"
 
+	"github.com/ai-tms/backend/internal/maps"
 	"github.com/ai-tms/backend/internal/models"
+	"github.com/google/uuid"
 )
 
+// Replan event types with dedicated handling; any other type reopens every open order
+const (
+	ReplanVehicleBreakdown = "vehicle_breakdown" // The vehicle's open stops move to other vehicles
+	ReplanTrafficJam       = "traffic_jam"       // Routes through the jam are re-timed around it
+	ReplanNewOrder         = "new_order"         // The order is inserted into the current routes
+)
+
+// ErrInvalidReplanEvent is returned when an event lacks what its type needs
+var ErrInvalidReplanEvent = errors.New("invalid replan event")
+
+// jamSeverity is how far a traffic jam reaches and how much it slows travel, by severity
+var jamSeverity = map[string]Congestion{
+	"low":    {RadiusKm: 1, Factor: 1.5},
+	"medium": {RadiusKm: 3, Factor: 2},
+	"high":   {RadiusKm: 5, Factor: 3},
+}
+
+// urgentLatenessFactor multiplies the lateness penalty for the minimize-late alternative
+const urgentLatenessFactor = 10
+
 // Replanner handles dynamic re-planning
 type Replanner struct {
-	vrpSolver *VRPSolver
+	optimizer RouteOptimizer
+	mapsProxy *maps.MapsProxyService
+	planStart time.Time         // When replanned routes leave, now when zero
+	positions map[string]LatLng // Last known position by vehicle ID
+	profiles  []models.ScoringProfile
 }
 
 // ReplanEvent represents an event that triggers re-planning
@@ -16,8 +46,11 @@ type ReplanEvent struct {
 	Type        string // "vehicle_breakdown", "traffic_jam", "new_order", "time_change"
 	VehicleID   string // Affected vehicle
 	RouteID     string // Affected route
+	OrderID     string // New order, for new_order
 	Location    string // Event location
-	Severity    string // "low", "medium", "high"
+	Latitude    float64
+	Longitude   float64 // Event position, geocoded from Location when not given
+	Severity    string  // "low", "medium", "high"
 	Description string
 	Timestamp   time.Time
 }
@@ -35,52 +68,505 @@ type Alternative struct {
 	Score          float64
 	Pros           []string
 	Cons           []string
+	Breakdown      ScoreBreakdown // How Score is made up, per objective
+	Orders         []string       // Orders the alternative plans, by ID
+	ReplacedStops  []string       // Current pending stops its routes take over, by ID
 }
 
 // NewReplanner creates a new replanner instance
 func NewReplanner() *Replanner {
-	return &Replanner{}
+	return &Replanner{optimizer: &solverOptimizer{strategy: DefaultStrategy}}
+}
+
+// SetOptimizer replaces the route optimizer used to build alternatives
+func (r *Replanner) SetOptimizer(optimizer RouteOptimizer) *Replanner {
+	r.optimizer = optimizer
+	return r
+}
+
+// SetMapsProxy enables road distances for re-timed routes and geocoding of event locations
+func (r *Replanner) SetMapsProxy(proxy *maps.MapsProxyService) *Replanner {
+	r.mapsProxy = proxy
+	return r
+}
+
+// SetPlanStart sets the time replanned routes start from
+func (r *Replanner) SetPlanStart(start time.Time) *Replanner {
+	r.planStart = start
+	return r
+}
+
+// SetPositions sets the last known position of vehicles on the road, keyed by vehicle ID
+// Their replanned routes start there instead of at the depot
+func (r *Replanner) SetPositions(positions map[string]LatLng) *Replanner {
+	r.positions = positions
+	return r
+}
+
+// SetScoringProfiles sets the stored scoring profiles alternatives are ranked with
+// The profile for the depot and the tier of the affected customers applies
+func (r *Replanner) SetScoringProfiles(profiles []models.ScoringProfile) *Replanner {
+	r.profiles = profiles
+	return r
+}
+
+// start returns the time replanned routes start from
+func (r *Replanner) start() time.Time {
+	if r.planStart.IsZero() {
+		return time.Now()
+	}
+	return r.planStart
+}
+
+// liveRoute is a current route with the stops still to visit
+type liveRoute struct {
+	route   models.Route
+	vehicle models.Vehicle
+	open    []models.RouteStop // Pending stops in sequence
+}
+
+// fixed returns the route's open stops as a sequence to keep
+func (l liveRoute) fixed() FixedRoute {
+	stops := make([]FixedStop, 0, len(l.open))
+	for _, stop := range l.open {
+		stops = append(stops, FixedStop{OrderID: stop.OrderID.String(), StopType: stop.StopType})
+	}
+	return FixedRoute{Vehicle: l.vehicle, Stops: stops}
+}
+
+// replanState is the current plan as replanning sees it
+// Completed and in-progress stops are left out: they are not moved by any alternative
+type replanState struct {
+	routes   []liveRoute
+	orders   map[string]models.Order // Orders with open stops, by ID
+	loaded   map[string]bool         // Orders whose cargo is already on their vehicle
+	unrouted []models.Order          // Pending orders on no current route
+	idle     []models.Vehicle        // Vehicles without a current route
+}
+
+// newReplanState splits the current routes into the stops still to visit
+// An order whose pickup is done, or that has no pickup stop, is carried by its vehicle;
+// its copy loses the pickup address so the solver loads it at the start of the route
+func newReplanState(currentRoutes []models.Route, orders []models.Order, vehicles []models.Vehicle) replanState {
+	state := replanState{
+		orders: make(map[string]models.Order),
+		loaded: make(map[string]bool),
+	}
+
+	undelivered := make(map[string]models.Order, len(orders))
+	for _, order := range getUndeliveredOrders(orders) {
+		undelivered[order.ID.String()] = order
+	}
+	available := make(map[string]models.Vehicle, len(vehicles))
+	for _, vehicle := range vehicles {
+		available[vehicle.ID.String()] = vehicle
+	}
+
+	routed := make(map[string]bool)
+	busy := make(map[string]bool)
+	for _, route := range currentRoutes {
+		live := liveRoute{route: route, vehicle: models.Vehicle{ID: route.VehicleID}}
+		if route.Vehicle != nil {
+			live.vehicle = *route.Vehicle
+		} else if vehicle, ok := available[route.VehicleID.String()]; ok {
+			live.vehicle = vehicle
+		}
+		busy[route.VehicleID.String()] = true
+
+		stops := append([]models.RouteStop(nil), route.Stops...)
+		sort.SliceStable(stops, func(i, j int) bool { return stops[i].Sequence < stops[j].Sequence })
+
+		pickups := make(map[string]bool)
+		for _, stop := range stops {
+			id := stop.OrderID.String()
+			routed[id] = true
+			if _, ok := undelivered[id]; !ok || stop.Status != string(StopStatusPending) {
+				continue
+			}
+			live.open = append(live.open, stop)
+			if stop.StopType == StopTypePickup {
+				pickups[id] = true
+			}
+		}
+
+		for _, stop := range live.open {
+			id := stop.OrderID.String()
+			order := undelivered[id]
+			if !pickups[id] {
+				order.PickupAddress = ""
+				order.PickupLatitude, order.PickupLongitude = 0, 0
+				order.PickupTime = nil
+				state.loaded[id] = true
+			}
+			state.orders[id] = order
+		}
+		state.routes = append(state.routes, live)
+	}
+
+	for _, order := range orders {
+		if order.Status == string(OrderStatusPending) && !routed[order.ID.String()] {
+			state.unrouted = append(state.unrouted, order)
+		}
+	}
+	for _, vehicle := range vehicles {
+		if !busy[vehicle.ID.String()] {
+			state.idle = append(state.idle, vehicle)
+		}
+	}
+	return state
+}
+
+// replacedStops returns the IDs of the open stops of the given orders
+func (s replanState) replacedStops(orderIDs []string) []string {
+	planned := make(map[string]bool, len(orderIDs))
+	for _, id := range orderIDs {
+		planned[id] = true
+	}
+
+	stops := make([]string, 0)
+	for _, route := range s.routes {
+		for _, stop := range route.open {
+			if planned[stop.OrderID.String()] {
+				stops = append(stops, stop.ID.String())
+			}
+		}
+	}
+	return stops
+}
+
+// orderIDs returns the IDs of orders
+func orderIDs(orders []models.Order) []string {
+	ids := make([]string, 0, len(orders))
+	for _, order := range orders {
+		ids = append(ids, order.ID.String())
+	}
+	return ids
+}
+
+// openOrders returns the orders with open stops on the given routes, in visiting order
+func (s replanState) openOrders(routes []liveRoute) []models.Order {
+	orders := make([]models.Order, 0)
+	seen := make(map[string]bool)
+	for _, route := range routes {
+		for _, stop := range route.open {
+			id := stop.OrderID.String()
+			if !seen[id] {
+				seen[id] = true
+				orders = append(orders, s.orders[id])
+			}
+		}
+	}
+	return orders
+}
+
+// replanScope is the part of the plan an event reopens
+type replanScope struct {
+	keep        []FixedRoute     // Routes the minimal change keeps in sequence...
+	keepOrders  []models.Order   // ...with the orders on them...
+	insert      []models.Order   // ...inserting these orders where cheapest
+	orders      []models.Order   // Orders the other alternatives route again...
+	vehicles    []models.Vehicle // ...on these vehicles
+	constraints VRPConstraints
+}
+
+// planned returns every order the scope concerns
+func (s replanScope) planned() []models.Order {
+	planned := make([]models.Order, 0, len(s.keepOrders)+len(s.insert)+len(s.orders))
+	seen := make(map[uuid.UUID]bool)
+	for _, orders := range [][]models.Order{s.keepOrders, s.insert, s.orders} {
+		for _, order := range orders {
+			if !seen[order.ID] {
+				seen[order.ID] = true
+				planned = append(planned, order)
+			}
+		}
+	}
+	return planned
+}
+
+// scope works out which stops an event reopens
+func (r *Replanner) scope(event ReplanEvent, state replanState) (replanScope, error) {
+	scope := replanScope{
+		constraints: VRPConstraints{
+			StartPositions: make(map[string]LatLng),
+			NotBefore:      r.start(),
+		},
+	}
+
+	var kept []liveRoute
+	switch event.Type {
+	case ReplanVehicleBreakdown:
+		vehicleID := event.VehicleID
+		for _, route := range state.routes {
+			if route.route.ID.String() == event.RouteID {
+				vehicleID = route.route.VehicleID.String()
+			}
+		}
+		if vehicleID == "" {
+			return scope, fmt.Errorf("%w: vehicle_breakdown needs a vehicle or route", ErrInvalidReplanEvent)
+		}
+
+		var broken []liveRoute
+		for _, route := range state.routes {
+			if route.vehicle.ID.String() == vehicleID {
+				broken = append(broken, route)
+			} else {
+				kept = append(kept, route)
+			}
+		}
+
+		// Cargo on board is collected from the broken vehicle where it stands; without a
+		// known position it is reloaded at the depot
+		position, located := r.eventPosition(event)
+		if !located {
+			position, located = r.positions[vehicleID]
+		}
+		for _, order := range state.openOrders(broken) {
+			if located && state.loaded[order.ID.String()] {
+				order.PickupAddress = event.Location
+				order.PickupLatitude, order.PickupLongitude = position.Lat, position.Lng
+			}
+			scope.insert = append(scope.insert, order)
+		}
+		scope.orders = scope.insert
+		for _, vehicle := range state.idle {
+			if vehicle.ID.String() != vehicleID {
+				scope.keep = append(scope.keep, FixedRoute{Vehicle: vehicle})
+				scope.vehicles = append(scope.vehicles, vehicle)
+			}
+		}
+
+	case ReplanTrafficJam:
+		zone, ok := jamSeverity[event.Severity]
+		if !ok {
+			zone = jamSeverity["medium"]
+		}
+		center, located := r.eventPosition(event)
+		if !located {
+			return scope, fmt.Errorf("%w: traffic_jam needs a location", ErrInvalidReplanEvent)
+		}
+		zone.Center = center
+		scope.constraints.Congestion = []Congestion{zone}
+
+		for _, route := range state.routes {
+			if route.route.ID.String() == event.RouteID || route.vehicle.ID.String() == event.VehicleID ||
+				r.passesThrough(route, state, zone) {
+				kept = append(kept, route)
+			}
+		}
+		scope.orders = state.openOrders(kept)
+
+	case ReplanNewOrder:
+		for _, order := range state.unrouted {
+			if order.ID.String() == event.OrderID {
+				scope.insert = append(scope.insert, order)
+			}
+		}
+		if len(scope.insert) == 0 {
+			return scope, fmt.Errorf("%w: order %q is not an open order outside the current routes", ErrInvalidReplanEvent, event.OrderID)
+		}
+		kept = state.routes
+		scope.orders = append(state.openOrders(kept), scope.insert...)
+		for _, vehicle := range state.idle {
+			scope.keep = append(scope.keep, FixedRoute{Vehicle: vehicle})
+		}
+		scope.vehicles = append(scope.vehicles, state.idle...)
+
+	default:
+		kept = state.routes
+		scope.insert = state.unrouted
+		scope.orders = append(state.openOrders(kept), state.unrouted...)
+		for _, vehicle := range state.idle {
+			scope.keep = append(scope.keep, FixedRoute{Vehicle: vehicle})
+		}
+		scope.vehicles = append(scope.vehicles, state.idle...)
+	}
+
+	// A broken vehicle's orders are only re-solved onto idle vehicles, so that the other
+	// routes keep their stops
+	keep := make([]FixedRoute, 0, len(kept)+len(scope.keep))
+	for _, route := range kept {
+		keep = append(keep, route.fixed())
+		if event.Type != ReplanVehicleBreakdown {
+			scope.vehicles = append(scope.vehicles, route.vehicle)
+		}
+		if position, ok := r.positions[route.vehicle.ID.String()]; ok {
+			scope.constraints.StartPositions[route.vehicle.ID.String()] = position
+		}
+	}
+	scope.keep = append(keep, scope.keep...)
+	scope.keepOrders = state.openOrders(kept)
+	return scope, nil
+}
+
+// passesThrough reports whether a route's vehicle or any of its open stops is inside a jam
+func (r *Replanner) passesThrough(route liveRoute, state replanState, zone Congestion) bool {
+	if position, ok := r.positions[route.vehicle.ID.String()]; ok && HaversineKm(position, zone.Center) <= zone.RadiusKm {
+		return true
+	}
+	for _, stop := range route.open {
+		order := state.orders[stop.OrderID.String()]
+		point := LatLng{}
+		if stop.StopType == StopTypePickup {
+			point = LatLng{Lat: order.PickupLatitude, Lng: order.PickupLongitude}
+		} else if order.Customer != nil {
+			point = LatLng{Lat: order.Customer.Latitude, Lng: order.Customer.Longitude}
+		}
+		if hasCoordinates(point) && HaversineKm(point, zone.Center) <= zone.RadiusKm {
+			return true
+		}
+	}
+	return false
+}
+
+// eventPosition returns where an event happened, geocoding its location when needed
+func (r *Replanner) eventPosition(event ReplanEvent) (LatLng, bool) {
+	point := LatLng{Lat: event.Latitude, Lng: event.Longitude}
+	if hasCoordinates(point) {
+		return point, true
+	}
+	if event.Location == "" || r.mapsProxy == nil {
+		return LatLng{}, false
+	}
+
+	resp, err := r.mapsProxy.Geocode(event.Location)
+	if err != nil {
+		return LatLng{}, false
+	}
+	point = LatLng{Lat: resp.Lat, Lng: resp.Lng}
+	return point, hasCoordinates(point)
+}
+
+// solve routes the scope's orders again and keeps whatever routes the optimizer could build
+func (r *Replanner) solve(scope replanScope, depot models.Depot, latenessPenalty float64) []RouteResult {
+	if len(scope.orders) == 0 || len(scope.vehicles) == 0 {
+		return nil
+	}
+
+	constraints := scope.constraints
+	constraints.LatenessPenaltyPerHour = latenessPenalty
+	result, _ := r.optimizer.Optimize(context.Background(), OptimizationProblem{
+		Orders:      scope.orders,
+		Vehicles:    scope.vehicles,
+		Depot:       depot,
+		Constraints: constraints,
+		PlanStart:   r.start(),
+	})
+	if result == nil {
+		return nil
+	}
+	return result.Routes
+}
+
+// adjust keeps the scope's routes in sequence and inserts its new orders where cheapest
+// Only routes that took on orders are returned, or every re-timed route when nothing is inserted
+func (r *Replanner) adjust(scope replanScope, depot models.Depot) []RouteResult {
+	stops := 0
+	for _, route := range scope.keep {
+		stops += len(route.Stops)
+	}
+	if stops == 0 && len(scope.insert) == 0 {
+		return nil
+	}
+
+	vehicles := make([]models.Vehicle, 0, len(scope.keep))
+	for _, route := range scope.keep {
+		vehicles = append(vehicles, route.Vehicle)
+	}
+	orders := append(append([]models.Order(nil), scope.keepOrders...), scope.insert...)
+	solver := NewVRPSolver(orders, vehicles, depot).
+		SetMapsProxy(r.mapsProxy).
+		SetPlanStart(r.start())
+	solver.constraints = scope.constraints
+
+	results, err := solver.Insert(context.Background(), scope.keep)
+	if err != nil && !errors.Is(err, ErrUnassignedOrders) {
+		return nil
+	}
+
+	routes := make([]RouteResult, 0, len(results))
+	for i, result := range results {
+		if len(result.Stops) == 0 {
+			continue
+		}
+		if len(scope.insert) == 0 || len(result.Stops) > len(scope.keep[i].Stops) {
+			routes = append(routes, result)
+		}
+	}
+	return routes
+}
+
+// baseline measures carrying on with the current routes the event concerns, re-timed as they are
+// Orders the current routes do not deliver, such as those of a broken vehicle, count as late
+func (r *Replanner) baseline(scope replanScope, state replanState, planned []models.Order, depot models.Depot) scoreBaseline {
+	current := scope
+	current.insert = nil
+	routes := r.adjust(current, depot)
+
+	baseline := scoreBaseline{
+		late:  r.estimateLateDeliveries(routes, planned),
+		stops: len(state.replacedStops(orderIDs(planned))),
+	}
+	for _, order := range planned {
+		if _, routed := state.orders[order.ID.String()]; !routed {
+			baseline.stops++
+		}
+	}
+	for _, route := range routes {
+		baseline.cost += route.TotalCost
+	}
+	return baseline
 }
 
 // GenerateAlternatives generates top 3 re-planning alternatives
+// Each alternative only moves the open stops the event concerns; completed and in-progress
+// stops stay where they are, and orders held back until a later retry day stay unrouted
 func (r *Replanner) GenerateAlternatives(event ReplanEvent, currentRoutes []models.Route, orders []models.Order, vehicles []models.Vehicle, depot models.Depot) ([]Alternative, error) {
+	state := newReplanState(currentRoutes, orders, vehicles)
+	plannable := state.unrouted[:0]
+	for _, order := range state.unrouted {
+		if PlannableOn(order, r.start()) {
+			plannable = append(plannable, order)
+		}
+	}
+	state.unrouted = plannable
+	scope, err := r.scope(event, state)
+	if err != nil {
+		return nil, err
+	}
+
 	alternatives := make([]Alternative, 0, 3)
 
 	// Alternative 1: Minimize Late Deliveries
-	alt1 := r.generateMinimizeLateAlternative(event, currentRoutes, orders, vehicles, depot)
+	alt1 := r.generateMinimizeLateAlternative(scope, currentRoutes, depot)
 	alternatives = append(alternatives, alt1)
 
 	// Alternative 2: Minimize Cost
-	alt2 := r.generateMinimizeCostAlternative(event, currentRoutes, orders, vehicles, depot)
+	alt2 := r.generateMinimizeCostAlternative(scope, currentRoutes, depot)
 	alternatives = append(alternatives, alt2)
 
 	// Alternative 3: Minimize Changes
-	alt3 := r.generateMinimizeChangesAlternative(event, currentRoutes, orders, vehicles, depot)
+	alt3 := r.generateMinimizeChangesAlternative(scope, currentRoutes, depot)
 	alternatives = append(alternatives, alt3)
 
-	// Calculate scores
+	// Calculate scores relative to carrying on with the current plan
+	planned := scope.planned()
+	profile := ResolveScoringProfile(r.profiles, depot.ID, scopeTier(planned))
+	baseline := r.baseline(scope, state, planned, depot)
 	for i := range alternatives {
-		alternatives[i].Score = r.calculateScore(alternatives[i])
+		alternatives[i].Score, alternatives[i].Breakdown = scoreAlternative(alternatives[i], profile, baseline)
+		alternatives[i].ReplacedStops = state.replacedStops(alternatives[i].Orders)
 	}
 
 	return alternatives, nil
 }
 
 // generateMinimizeLateAlternative creates plan to minimize late deliveries
-func (r *Replanner) generateMinimizeLateAlternative(event ReplanEvent, currentRoutes []models.Route, orders []models.Order, vehicles []models.Vehicle, depot models.Depot) Alternative {
-	// Strategy: Prioritize time-critical orders, use fastest routes
+func (r *Replanner) generateMinimizeLateAlternative(scope replanScope, currentRoutes []models.Route, depot models.Depot) Alternative {
+	// Strategy: Route the reopened orders again with lateness weighing far more than distance
+	routes := r.solve(scope, depot, defaultLatenessPenaltyPerHour*urgentLatenessFactor)
 
-	// Get undelivered orders
-	undeliveredOrders := r.getUndeliveredOrders(orders)
-
-	// Sort by urgency (earliest delivery time first)
-	sortedOrders := r.sortByUrgency(undeliveredOrders)
-
-	// Re-run VRP with time priority
-	solver := NewVRPSolver(sortedOrders, vehicles, depot)
-	routes, _ := solver.Solve()
-
-	lateCount := r.estimateLateDeliveries(routes)
+	lateCount := r.estimateLateDeliveries(routes, scope.orders)
 	totalCost := 0.0
 	totalDistance := 0.0
 	for _, route := range routes {
@@ -97,6 +583,7 @@ func (r *Replanner) generateMinimizeLateAlternative(event ReplanEvent, currentRo
 		TotalDistance:  totalDistance,
 		LateDeliveries: lateCount,
 		ChangedStops:   r.countChangedStops(currentRoutes, routes),
+		Orders:         orderIDs(scope.orders),
 		Pros: []string{
 			"ลด late delivery ได้มากที่สุด",
 			"รักษา SLA ได้ดี",
@@ -110,16 +597,11 @@ func (r *Replanner) generateMinimizeLateAlternative(event ReplanEvent, currentRo
 }
 
 // generateMinimizeCostAlternative creates plan to minimize cost
-func (r *Replanner) generateMinimizeCostAlternative(event ReplanEvent, currentRoutes []models.Route, orders []models.Order, vehicles []models.Vehicle, depot models.Depot) Alternative {
-	// Strategy: Optimize for shortest total distance
-
-	undeliveredOrders := r.getUndeliveredOrders(orders)
-
-	// Re-run VRP with cost optimization
-	solver := NewVRPSolver(undeliveredOrders, vehicles, depot)
-	routes, _ := solver.Solve()
+func (r *Replanner) generateMinimizeCostAlternative(scope replanScope, currentRoutes []models.Route, depot models.Depot) Alternative {
+	// Strategy: Route the reopened orders again for the lowest total cost
+	routes := r.solve(scope, depot, 0)
 
-	lateCount := r.estimateLateDeliveries(routes)
+	lateCount := r.estimateLateDeliveries(routes, scope.orders)
 	totalCost := 0.0
 	totalDistance := 0.0
 	for _, route := range routes {
@@ -136,6 +618,7 @@ func (r *Replanner) generateMinimizeCostAlternative(event ReplanEvent, currentRo
 		TotalDistance:  totalDistance,
 		LateDeliveries: lateCount,
 		ChangedStops:   r.countChangedStops(currentRoutes, routes),
+		Orders:         orderIDs(scope.orders),
 		Pros: []string{
 			"ค่าใช้จ่ายต่ำที่สุด",
 			"ระยะทางสั้นที่สุด",
@@ -149,21 +632,24 @@ func (r *Replanner) generateMinimizeCostAlternative(event ReplanEvent, currentRo
 }
 
 // generateMinimizeChangesAlternative creates plan with minimal changes
-func (r *Replanner) generateMinimizeChangesAlternative(event ReplanEvent, currentRoutes []models.Route, orders []models.Order, vehicles []models.Vehicle, depot models.Depot) Alternative {
-	// Strategy: Keep as much of current plan as possible, only adjust affected routes
+func (r *Replanner) generateMinimizeChangesAlternative(scope replanScope, currentRoutes []models.Route, depot models.Depot) Alternative {
+	// Strategy: Keep current sequences, re-time them and insert only the orders that must move
+	routes := r.adjust(scope, depot)
 
-	// Clone current routes
-	routes := make([]RouteResult, 0)
-
-	// Only re-plan affected vehicle/route
-	affectedOrders := r.getAffectedOrders(event, orders)
-	if len(affectedOrders) > 0 {
-		solver := NewVRPSolver(affectedOrders, vehicles, depot)
-		newRoutes, _ := solver.Solve()
-		routes = append(routes, newRoutes...)
+	planned := append([]models.Order(nil), scope.insert...)
+	onRoutes := make(map[string]bool)
+	for _, route := range routes {
+		for _, stop := range route.Stops {
+			onRoutes[stop.OrderID] = true
+		}
+	}
+	for _, order := range scope.keepOrders {
+		if onRoutes[order.ID.String()] {
+			planned = append(planned, order)
+		}
 	}
 
-	lateCount := r.estimateLateDeliveries(routes)
+	lateCount := r.estimateLateDeliveries(routes, planned)
 	totalCost := 0.0
 	totalDistance := 0.0
 	for _, route := range routes {
@@ -180,6 +666,7 @@ func (r *Replanner) generateMinimizeChangesAlternative(event ReplanEvent, curren
 		TotalDistance:  totalDistance,
 		LateDeliveries: lateCount,
 		ChangedStops:   r.countChangedStops(currentRoutes, routes),
+		Orders:         orderIDs(planned),
 		Pros: []string{
 			"เปลี่ยนแปลงน้อยที่สุด",
 			"คนขับไม่สับสน",
@@ -194,7 +681,7 @@ func (r *Replanner) generateMinimizeChangesAlternative(event ReplanEvent, curren
 
 // Helper functions
 
-func (r *Replanner) getUndeliveredOrders(orders []models.Order) []models.Order {
+func getUndeliveredOrders(orders []models.Order) []models.Order {
 	undelivered := make([]models.Order, 0)
 	for _, order := range orders {
 		if order.Status != "delivered" && order.Status != "cancelled" {
@@ -204,49 +691,92 @@ func (r *Replanner) getUndeliveredOrders(orders []models.Order) []models.Order {
 	return undelivered
 }
 
-func (r *Replanner) sortByUrgency(orders []models.Order) []models.Order {
-	// Simple sort by delivery time (earliest first)
-	// In production, use proper sorting algorithm
-	return orders
-}
+// estimateLateDeliveries counts the planned orders whose projected arrival misses their deadline
+// Orders the routes leave out cannot arrive in time and count as late too
+func (r *Replanner) estimateLateDeliveries(routes []RouteResult, planned []models.Order) int {
+	arrivals := make(map[string]time.Time)
+	for _, route := range routes {
+		for _, stop := range route.Stops {
+			if stop.StopType != StopTypePickup {
+				arrivals[stop.OrderID] = stop.ArrivalTime
+			}
+		}
+	}
 
-func (r *Replanner) estimateLateDeliveries(routes []RouteResult) int {
-	// Estimate based on ETA vs delivery time
-	// In production, use AI ETA predictor
 	lateCount := 0
-	for _, route := range routes {
-		for range route.Stops {
-			// Simple heuristic: if arrival > delivery time, it's late
-			lateCount++ // Placeholder
+	for _, order := range planned {
+		arrival, ok := arrivals[order.ID.String()]
+		if !ok {
+			lateCount++
+			continue
+		}
+		if deadline := deliveryDeadline(order, arrival); !deadline.IsZero() && arrival.After(deadline) {
+			lateCount++
 		}
 	}
-	return lateCount / 10 // Rough estimate
+	return lateCount
 }
 
-func (r *Replanner) countChangedStops(oldRoutes []models.Route, newRoutes []RouteResult) int {
-	// Count how many stops changed sequence or vehicle
-	// In production, implement proper diff algorithm
-	return len(newRoutes) * 2 // Placeholder
+// deliveryDeadline returns the latest arrival that keeps an order on time on the day of arrival,
+// the earlier of RequiredBy and the end of the customer's window, or zero when neither is set
+func deliveryDeadline(order models.Order, day time.Time) time.Time {
+	var deadline time.Time
+	if order.Customer != nil {
+		if end, err := clockOnDay(day, order.Customer.TimeWindowEnd); err == nil {
+			deadline = end
+		}
+	}
+	if order.RequiredBy != nil && (deadline.IsZero() || order.RequiredBy.Before(deadline)) {
+		deadline = *order.RequiredBy
+	}
+	return deadline
 }
 
-func (r *Replanner) getAffectedOrders(event ReplanEvent, orders []models.Order) []models.Order {
-	// Get orders affected by the event
-	// In production, implement based on event type
-	return r.getUndeliveredOrders(orders)
+// stopKey identifies a stop across plans, pickup and delivery of an order being separate stops
+func stopKey(orderID, stopType string) string {
+	if stopType == "" {
+		stopType = StopTypeDelivery
+	}
+	return orderID + "/" + stopType
 }
 
-func (r *Replanner) calculateScore(alt Alternative) float64 {
-	// Multi-objective scoring
-	// Lower is better
-	costWeight := 0.3
-	lateWeight := 0.5
-	changeWeight := 0.2
+// stopPosition is where a stop sits in a plan
+type stopPosition struct {
+	vehicleID string
+	rank      int // Position among the route's open stops
+}
+
+// countChangedStops counts the stops of the new routes that move to another vehicle or to another
+// position among their route's open stops; stops not in the current plan count as changed
+// Only pending stops are ranked, as the new routes are built from them, so that finished or
+// in-progress work ahead of a stop does not count as a change
+func (r *Replanner) countChangedStops(oldRoutes []models.Route, newRoutes []RouteResult) int {
+	current := make(map[string]stopPosition)
+	for _, route := range oldRoutes {
+		stops := make([]models.RouteStop, 0, len(route.Stops))
+		for _, stop := range route.Stops {
+			if stop.Status == string(StopStatusPending) {
+				stops = append(stops, stop)
+			}
+		}
+		sort.SliceStable(stops, func(i, j int) bool { return stops[i].Sequence < stops[j].Sequence })
 
-	// Normalize values (0-1 scale)
-	costScore := alt.TotalCost / 10000.0            // Assuming max cost ~10000
-	lateScore := float64(alt.LateDeliveries) / 10.0 // Assuming max late ~10
-	changeScore := float64(alt.ChangedStops) / 50.0 // Assuming max changes ~50
+		for rank, stop := range stops {
+			current[stopKey(stop.OrderID.String(), stop.StopType)] = stopPosition{
+				vehicleID: route.VehicleID.String(),
+				rank:      rank,
+			}
+		}
+	}
 
-	score := (costWeight * costScore) + (lateWeight * lateScore) + (changeWeight * changeScore)
-	return score
+	changed := 0
+	for _, route := range newRoutes {
+		for rank, stop := range route.Stops {
+			position, ok := current[stopKey(stop.OrderID, stop.StopType)]
+			if !ok || position.vehicleID != route.VehicleID || position.rank != rank {
+				changed++
+			}
+		}
+	}
+	return changed
 }
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ai-tms/backend/internal/database"
+	"github.com/ai-tms/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrRouteNotFound is returned for an unknown route
+	ErrRouteNotFound = errors.New("route not found")
+
+	// ErrRouteLocked is returned when a change touches a locked route without the lock override
+	ErrRouteLocked = errors.New("route is locked")
+
+	// ErrRouteNotLocked is returned when unlocking a route that is not locked
+	ErrRouteNotLocked = errors.New("route is not locked")
+
+	// ErrLockReasonRequired is returned when locking or unlocking without a reason
+	ErrLockReasonRequired = errors.New("a reason is required")
+)
+
+// RouteLockService locks routes against changes by planning, replanning and dispatch
+type RouteLockService struct{}
+
+// NewRouteLockService creates a new route lock service
+func NewRouteLockService() *RouteLockService {
+	return &RouteLockService{}
+}
+
+// Lock freezes a route's vehicle, driver and stops until it is unlocked
+func (s *RouteLockService) Lock(routeID, userID uuid.UUID, reason string) (*models.Route, error) {
+	return s.setLock(routeID, userID, reason, true)
+}
+
+// Unlock lets a locked route be changed again
+func (s *RouteLockService) Unlock(routeID, userID uuid.UUID, reason string) (*models.Route, error) {
+	return s.setLock(routeID, userID, reason, false)
+}
+
+// setLock locks or unlocks a route, recording who did it and why
+func (s *RouteLockService) setLock(routeID, userID uuid.UUID, reason string, locked bool) (*models.Route, error) {
+	reason = strings.TrimSpace(reason)
+	if reason == "" {
+		return nil, ErrLockReasonRequired
+	}
+
+	var route models.Route
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&route, "id = ?", routeID).Error; err != nil {
+			return routeLoadError(err)
+		}
+		if route.IsLocked == locked {
+			if locked {
+				return fmt.Errorf("%w: %s", ErrRouteLocked, route.LockReason)
+			}
+			return ErrRouteNotLocked
+		}
+
+		now := time.Now()
+		route.IsLocked = locked
+		route.LockReason, route.LockedBy, route.LockedAt = reason, &userID, &now
+		if !locked {
+			// The unlock reason goes to the audit trail, the route only keeps its current lock
+			route.LockReason, route.LockedBy, route.LockedAt = "", nil, nil
+		}
+		return tx.Model(&route).Select("is_locked", "lock_reason", "locked_by", "locked_at").Updates(&route).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &route, nil
+}
+
+// CheckRouteUnlocked returns ErrRouteLocked for a locked route unless the change overrides locks
+func CheckRouteUnlocked(route models.Route, override bool) error {
+	if route.IsLocked && !override {
+		if route.LockReason != "" {
+			return fmt.Errorf("%w: %s: %s", ErrRouteLocked, route.RouteNumber, route.LockReason)
+		}
+		return fmt.Errorf("%w: %s", ErrRouteLocked, route.RouteNumber)
+	}
+	return nil
+}
+
+// LockRouteForChange loads a route for update within a transaction and checks it may be changed:
+// it must be neither closed nor locked
+func LockRouteForChange(tx *gorm.DB, routeID uuid.UUID, override bool) (models.Route, error) {
+	var route models.Route
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&route, "id = ?", routeID).Error; err != nil {
+		return route, routeLoadError(err)
+	}
+	if err := CheckRouteOpen(route); err != nil {
+		return route, err
+	}
+	return route, CheckRouteUnlocked(route, override)
+}
+
+// routeLoadError maps a failed route lookup to ErrRouteNotFound when no route matched
+func routeLoadError(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrRouteNotFound
+	}
+	return fmt.Errorf("failed to load route: %w", err)
+}
@@ -0,0 +1,258 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ai-tms/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	// ErrInvalidRouteTransition is returned for a route status change the lifecycle does not allow
+	ErrInvalidRouteTransition = errors.New("invalid route status transition")
+
+	// ErrRouteClosed is returned when changing a route that is completed or aborted
+	ErrRouteClosed = errors.New("route is closed")
+
+	// ErrRouteNotDispatched is returned when reporting stops of a route not yet released to its driver
+	ErrRouteNotDispatched = errors.New("route has not been dispatched")
+
+	// ErrInvalidStopTransition is returned for a stop status change the lifecycle does not allow
+	ErrInvalidStopTransition = errors.New("invalid stop status transition")
+
+	// ErrNoOpenDeliveryStop is returned for an order without a delivery stop still to be worked
+	ErrNoOpenDeliveryStop = errors.New("order has no open delivery stop")
+)
+
+// TransitionRoute moves a route to a new status within a transaction, validating the change
+// Starting a route records its actual start; completing or aborting it records its actual end.
+// Extra column updates are written along with the status
+func TransitionRoute(tx *gorm.DB, route *models.Route, to RouteStatus, updates map[string]interface{}) error {
+	if err := NewRouteStateMachine().ValidateTransition(RouteStatus(route.Status), to); err != nil {
+		return err
+	}
+
+	if updates == nil {
+		updates = make(map[string]interface{})
+	}
+	updates["status"] = string(to)
+	now := time.Now()
+	switch to {
+	case RouteStatusInProgress:
+		if route.ActualStartTime == nil {
+			updates["actual_start_time"] = now
+			route.ActualStartTime = &now
+		}
+	case RouteStatusCompleted, RouteStatusAborted:
+		updates["actual_end_time"] = now
+		route.ActualEndTime = &now
+	}
+
+	if err := tx.Model(route).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to update route %s: %w", route.RouteNumber, err)
+	}
+	route.Status = string(to)
+	return nil
+}
+
+// AbortRoute aborts a route within a transaction, closing its open stops as failed and
+// sending their orders back to pending so they are planned again. Orders already picked up
+// fail first, so their history shows the delivery did not happen. Extra column updates are
+// written along with the status
+func AbortRoute(tx *gorm.DB, route *models.Route, updates map[string]interface{}, change OrderStatusChange) error {
+	if err := TransitionRoute(tx, route, RouteStatusAborted, updates); err != nil {
+		return err
+	}
+
+	var stops []models.RouteStop
+	if err := tx.Where("route_id = ? AND status IN ?", route.ID,
+		[]string{string(StopStatusPending), string(StopStatusInProgress)}).Find(&stops).Error; err != nil {
+		return fmt.Errorf("failed to load route stops: %w", err)
+	}
+
+	statuses := NewOrderStatusService()
+	seen := make(map[uuid.UUID]bool)
+	for _, stop := range stops {
+		if err := tx.Model(&stop).Update("status", string(StopStatusFailed)).Error; err != nil {
+			return fmt.Errorf("failed to close stop: %w", err)
+		}
+		if seen[stop.OrderID] {
+			continue
+		}
+		seen[stop.OrderID] = true
+
+		var order models.Order
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, "id = ?", stop.OrderID).Error; err != nil {
+			return ErrOrderNotFound
+		}
+		var steps []OrderStatus
+		switch OrderStatus(order.Status) {
+		case OrderStatusAssigned:
+			steps = []OrderStatus{OrderStatusPending}
+		case OrderStatusPickedUp:
+			steps = []OrderStatus{OrderStatusFailed, OrderStatusPending}
+		}
+		for _, to := range steps {
+			if err := statuses.Transition(tx, &order, to, change); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// CheckRouteOpen returns ErrRouteClosed for a completed or aborted route
+func CheckRouteOpen(route models.Route) error {
+	if NewRouteStateMachine().IsClosed(RouteStatus(route.Status)) {
+		return fmt.Errorf("%w: %s is %s", ErrRouteClosed, route.RouteNumber, route.Status)
+	}
+	return nil
+}
+
+// CompleteRouteIfDone completes a route in progress once every stop is completed or failed
+// It reports whether the route was completed
+func CompleteRouteIfDone(tx *gorm.DB, routeID uuid.UUID) (bool, error) {
+	var route models.Route
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&route, "id = ?", routeID).Error; err != nil {
+		return false, fmt.Errorf("failed to load route: %w", err)
+	}
+	if RouteStatus(route.Status) != RouteStatusInProgress {
+		return false, nil
+	}
+
+	var open int64
+	if err := tx.Model(&models.RouteStop{}).
+		Where("route_id = ? AND status NOT IN ?", routeID, []string{string(StopStatusCompleted), string(StopStatusFailed)}).
+		Count(&open).Error; err != nil {
+		return false, fmt.Errorf("failed to count open stops: %w", err)
+	}
+	if open > 0 {
+		return false, nil
+	}
+
+	if err := TransitionRoute(tx, &route, RouteStatusCompleted, nil); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// RecordStopStatus writes a stop's new status within a transaction and keeps its route and
+// order in step: the first stop reported on a dispatched route starts it, the order follows
+// its stop, and the last stop to finish completes the route. A stop in progress may be
+// reported in progress again, as the driver heads to it and then arrives. It reports whether
+// the route was completed
+func RecordStopStatus(tx *gorm.DB, stop *models.RouteStop, status StopStatus, updates map[string]interface{}, change OrderStatusChange) (bool, error) {
+	from := StopStatus(stop.Status)
+	if from != StopStatusInProgress || status != StopStatusInProgress {
+		if err := NewStopStateMachine().ValidateTransition(from, status); err != nil {
+			return false, err
+		}
+	}
+
+	var route models.Route
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&route, "id = ?", stop.RouteID).Error; err != nil {
+		return false, ErrRouteNotFound
+	}
+	if err := CheckRouteOpen(route); err != nil {
+		return false, err
+	}
+	switch RouteStatus(route.Status) {
+	case RouteStatusInProgress:
+	case RouteStatusDispatched:
+		if err := TransitionRoute(tx, &route, RouteStatusInProgress, nil); err != nil {
+			return false, err
+		}
+	default:
+		return false, fmt.Errorf("%w: %s is %s", ErrRouteNotDispatched, route.RouteNumber, route.Status)
+	}
+
+	if updates == nil {
+		updates = make(map[string]interface{})
+	}
+	updates["status"] = string(status)
+	if err := tx.Model(stop).Updates(updates).Error; err != nil {
+		return false, fmt.Errorf("failed to update stop: %w", err)
+	}
+	stop.Status = string(status)
+
+	if err := advanceOrder(tx, *stop, change); err != nil {
+		return false, err
+	}
+	return CompleteRouteIfDone(tx, route.ID)
+}
+
+// advanceOrder moves a stop's order along with the stop: the order is picked up when its
+// pickup stop completes, or, without one, once the driver heads to the delivery; the delivery
+// stop completing or failing delivers or fails it
+func advanceOrder(tx *gorm.DB, stop models.RouteStop, change OrderStatusChange) error {
+	var steps []OrderStatus
+	switch {
+	case stop.StopType == StopTypePickup && stop.Status == string(StopStatusCompleted):
+		steps = []OrderStatus{OrderStatusPickedUp}
+	case stop.StopType == StopTypePickup:
+		return nil
+	case stop.Status == string(StopStatusInProgress):
+		steps = []OrderStatus{OrderStatusPickedUp}
+	case stop.Status == string(StopStatusCompleted):
+		steps = []OrderStatus{OrderStatusPickedUp, OrderStatusDelivered}
+	case stop.Status == string(StopStatusFailed):
+		steps = []OrderStatus{OrderStatusPickedUp, OrderStatusFailed}
+	}
+
+	var order models.Order
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&order, "id = ?", stop.OrderID).Error; err != nil {
+		return ErrOrderNotFound
+	}
+	statuses := NewOrderStatusService()
+	for _, to := range steps {
+		if order.Status == string(to) {
+			continue
+		}
+		// Orders already past pickup skip it
+		if to == OrderStatusPickedUp && order.Status != string(OrderStatusAssigned) {
+			continue
+		}
+		if err := statuses.Transition(tx, &order, to, change); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// IsOpenStop reports whether a stop is still to be worked: pending or in progress
+func IsOpenStop(stop models.RouteStop) bool {
+	return stop.Status == string(StopStatusPending) || stop.Status == string(StopStatusInProgress)
+}
+
+// CurrentDeliveryStop picks an order's current delivery stop from its delivery stops: the
+// latest one still open. A failed delivery leaves its stop behind on the old route once the
+// order is rescheduled, so the order may have several
+func CurrentDeliveryStop(stops []models.RouteStop) (models.RouteStop, bool) {
+	var current models.RouteStop
+	found := false
+	for _, stop := range stops {
+		if IsOpenStop(stop) && (!found || stop.CreatedAt.After(current.CreatedAt)) {
+			current, found = stop, true
+		}
+	}
+	return current, found
+}
+
+// FindCurrentDeliveryStop loads an order's current delivery stop, see CurrentDeliveryStop
+func FindCurrentDeliveryStop(db *gorm.DB, orderID interface{}) (models.RouteStop, error) {
+	var stops []models.RouteStop
+	if err := db.Where("order_id = ? AND stop_type = ? AND status IN ?", orderID, StopTypeDelivery,
+		[]string{string(StopStatusPending), string(StopStatusInProgress)}).
+		Order("created_at DESC").Find(&stops).Error; err != nil {
+		return models.RouteStop{}, fmt.Errorf("failed to load delivery stops: %w", err)
+	}
+	stop, ok := CurrentDeliveryStop(stops)
+	if !ok {
+		return stop, ErrNoOpenDeliveryStop
+	}
+	return stop, nil
+}
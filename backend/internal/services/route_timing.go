@@ -0,0 +1,184 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/ai-tms/backend/internal/maps"
+	"github.com/ai-tms/backend/internal/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// RetimeRoute recomputes the planned arrival, departure and leg of every stop still to visit on
+// a route, in its current sequence, and the route's totals. Call it once stops were added,
+// removed or moved and that change is committed. A route that has not started is timed from
+// its planned start at the depot; once the vehicle is out, open stops are timed from its last
+// position, not before now. Travel times may come from OSRM or the geocoder, so the route is
+// timed without holding locks and the times are written in a short transaction of their own,
+// failing with ErrRouteChanged if the open stops changed meanwhile. On failure the route keeps
+// its old times
+func RetimeRoute(ctx context.Context, db *gorm.DB, mapsProxy *maps.MapsProxyService, routeID uuid.UUID) error {
+	var route models.Route
+	if err := db.Preload("Vehicle.CurrentDriver").First(&route, "id = ?", routeID).Error; err != nil {
+		return fmt.Errorf("failed to load route: %w", err)
+	}
+	if err := db.Preload("Order.Customer").Where("route_id = ?", routeID).
+		Order("sequence").Find(&route.Stops).Error; err != nil {
+		return fmt.Errorf("failed to load route stops: %w", err)
+	}
+
+	orders := make([]models.Order, 0, len(route.Stops))
+	finished := make([]models.RouteStop, 0)
+	for _, stop := range route.Stops {
+		if stop.Order != nil {
+			orders = append(orders, *stop.Order)
+		}
+		if stop.Status != string(StopStatusPending) {
+			finished = append(finished, stop)
+		}
+	}
+
+	state := newReplanState([]models.Route{route}, orders, nil)
+	live := state.routes[0]
+	var timed RouteResult
+	if len(live.open) > 0 {
+		var depot models.Depot
+		if err := db.First(&depot, "id = ?", route.DepotID).Error; err != nil {
+			return fmt.Errorf("failed to load depot: %w", err)
+		}
+
+		start := route.PlannedStartTime
+		if start.IsZero() {
+			start, _ = PlanStart(route.Date, DefaultPlanStartClock)
+		}
+		solver := NewVRPSolver(state.openOrders(state.routes), []models.Vehicle{live.vehicle}, depot).
+			SetMapsProxy(mapsProxy).
+			SetPlanStart(start)
+		if route.Status == string(RouteStatusInProgress) || len(finished) > 0 {
+			// The vehicle is out: it carries on from its last fix, or from the last stop it finished
+			positions := LastPositions([]uuid.UUID{live.vehicle.ID})
+			if _, ok := positions[live.vehicle.ID.String()]; !ok && len(finished) > 0 {
+				if position, ok := stopLocation(finished[len(finished)-1]); ok {
+					positions[live.vehicle.ID.String()] = position
+				}
+			}
+			solver.constraints = VRPConstraints{StartPositions: positions, NotBefore: time.Now()}
+		}
+
+		results, err := solver.Retime(ctx, []FixedRoute{live.fixed()})
+		if err != nil {
+			return fmt.Errorf("failed to retime route %s: %w", route.RouteNumber, err)
+		}
+		timed = results[0]
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var open []models.RouteStop
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&models.Route{}, "id = ?", routeID).Error; err != nil {
+			return fmt.Errorf("failed to load route: %w", err)
+		}
+		if err := tx.Where("route_id = ? AND status = ?", routeID, string(StopStatusPending)).
+			Order("sequence").Find(&open).Error; err != nil {
+			return fmt.Errorf("failed to load route stops: %w", err)
+		}
+		if len(open) != len(live.open) {
+			return fmt.Errorf("%w: %s", ErrRouteChanged, route.RouteNumber)
+		}
+		for i := range open {
+			if open[i].ID != live.open[i].ID {
+				return fmt.Errorf("%w: %s", ErrRouteChanged, route.RouteNumber)
+			}
+		}
+
+		if len(open) > 0 {
+			if _, err := writeRouteSequence(tx, route, live.open, timed); err != nil {
+				return err
+			}
+		}
+		return writeRouteTotals(tx, route, timed)
+	})
+}
+
+// SettleRouteStops numbers a route's stops one after another, keeping their order, and stores
+// the stop count. It runs in the transaction that adds, removes or moves stops, ahead of
+// RetimeRoute, so the sequence holds even when timing the route fails
+func SettleRouteStops(tx *gorm.DB, routeID uuid.UUID) error {
+	var stops []models.RouteStop
+	if err := tx.Where("route_id = ?", routeID).Order("sequence").Find(&stops).Error; err != nil {
+		return fmt.Errorf("failed to load route stops: %w", err)
+	}
+	for i, stop := range stops {
+		if stop.Sequence == i+1 {
+			continue
+		}
+		if err := tx.Model(&stop).Update("sequence", i+1).Error; err != nil {
+			return fmt.Errorf("failed to update stop sequence: %w", err)
+		}
+	}
+	if err := tx.Model(&models.Route{}).Where("id = ?", routeID).Update("total_stops", len(stops)).Error; err != nil {
+		return fmt.Errorf("failed to update route stop count: %w", err)
+	}
+	return nil
+}
+
+// writeRouteTotals stores a route's stop count, distance, duration, cost and planned end
+// Finished stops count as driven; the timed remainder of the route is added on top
+func writeRouteTotals(tx *gorm.DB, route models.Route, remaining RouteResult) error {
+	var stops []models.RouteStop
+	if err := tx.Where("route_id = ?", route.ID).Order("sequence").Find(&stops).Error; err != nil {
+		return fmt.Errorf("failed to load route stops: %w", err)
+	}
+
+	driven := 0.0
+	end := route.PlannedStartTime
+	for _, stop := range stops {
+		if stop.Status == string(StopStatusPending) {
+			continue
+		}
+		driven += stop.DistanceFromPrevKm
+		if stop.ActualDeparture != nil && stop.ActualDeparture.After(end) {
+			end = *stop.ActualDeparture
+		}
+	}
+
+	cost := remaining.TotalCost
+	if route.Vehicle != nil {
+		cost += driven * route.Vehicle.CostPerKm
+	}
+	if !remaining.EndTime.IsZero() {
+		end = remaining.EndTime
+	}
+	start := route.PlannedStartTime
+	if route.ActualStartTime != nil {
+		start = *route.ActualStartTime
+	}
+
+	updates := map[string]interface{}{
+		"total_stops":        len(stops),
+		"total_distance_km":  driven + remaining.TotalDistance,
+		"total_duration_min": max(0, int(end.Sub(start).Minutes())),
+		"estimated_cost":     cost,
+		"planned_end_time":   end,
+	}
+	if err := tx.Model(&route).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to update route %s: %w", route.RouteNumber, err)
+	}
+	return nil
+}
+
+// stopLocation returns where a stop is: the pickup point of a pickup, the customer otherwise
+func stopLocation(stop models.RouteStop) (LatLng, bool) {
+	if stop.Order == nil {
+		return LatLng{}, false
+	}
+	if stop.StopType == StopTypePickup {
+		return LatLng{Lat: stop.Order.PickupLatitude, Lng: stop.Order.PickupLongitude}, stop.Order.PickupLatitude != 0 || stop.Order.PickupLongitude != 0
+	}
+	if stop.Order.Customer == nil {
+		return LatLng{}, false
+	}
+	return LatLng{Lat: stop.Order.Customer.Latitude, Lng: stop.Order.Customer.Longitude}, true
+}
@@ -1,12 +1,28 @@
 package services_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/ai-tms/backend/internal/cache"
+	"github.com/ai-tms/backend/internal/database"
+	"github.com/ai-tms/backend/internal/maps"
 	"github.com/ai-tms/backend/internal/models"
 	"github.com/ai-tms/backend/internal/services"
+	"github.com/glebarez/sqlite"
 	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
+	"github.com/xuri/excelize/v2"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
 )
 
 func TestVRPSolver(t *testing.T) {
@@ -16,10 +32,12 @@ func TestVRPSolver(t *testing.T) {
 			{
 				ID:              uuid.New(),
 				DeliveryAddress: "Bangkok",
+				Customer:        &models.Customer{Latitude: 13.7563, Longitude: 100.5018},
 			},
 			{
 				ID:              uuid.New(),
 				DeliveryAddress: "Nonthaburi",
+				Customer:        &models.Customer{Latitude: 13.8621, Longitude: 100.5144},
 			},
 		}
 
@@ -33,8 +51,10 @@ func TestVRPSolver(t *testing.T) {
 		}
 
 		depot := models.Depot{
-			ID:       uuid.New(),
-			Location: "Depot A",
+			ID:        uuid.New(),
+			Location:  "Depot A",
+			Latitude:  13.7279,
+			Longitude: 100.5241,
 		}
 
 		// Run solver
@@ -58,6 +78,372 @@ func TestVRPSolver(t *testing.T) {
 		assert.Error(t, err)
 	})
 
+	t.Run("Solve rejects orders without coordinates", func(t *testing.T) {
+		orders := []models.Order{{ID: uuid.New(), OrderNumber: "ORD-1", DeliveryAddress: "Somewhere"}}
+		vehicles := []models.Vehicle{{ID: uuid.New(), CapacityKg: 1000}}
+		depot := models.Depot{ID: uuid.New(), Latitude: 13.7279, Longitude: 100.5241}
+
+		solver := services.NewVRPSolver(orders, vehicles, depot)
+		_, err := solver.Solve()
+
+		assert.ErrorIs(t, err, services.ErrMissingCoordinates)
+		assert.Contains(t, err.Error(), "ORD-1")
+	})
+
+	t.Run("Solve reports capacity reasons for unassigned orders", func(t *testing.T) {
+		customer := &models.Customer{Latitude: 13.7563, Longitude: 100.5018}
+		small := models.Order{ID: uuid.New(), OrderNumber: "ORD-SMALL", WeightKg: 100, VolumeM3: 1, Customer: customer}
+		bulky := models.Order{ID: uuid.New(), OrderNumber: "ORD-BULKY", WeightKg: 100, VolumeM3: 20, Customer: customer}
+		vehicles := []models.Vehicle{{ID: uuid.New(), CapacityKg: 1000, CapacityM3: 10}}
+		depot := models.Depot{ID: uuid.New(), Latitude: 13.7279, Longitude: 100.5241}
+
+		solver := services.NewVRPSolver([]models.Order{small, bulky}, vehicles, depot)
+		routes, err := solver.Solve()
+
+		assert.ErrorIs(t, err, services.ErrUnassignedOrders)
+		assert.Len(t, routes, 1)
+		assert.Len(t, solver.Unassigned(), 1)
+		assert.Equal(t, "ORD-BULKY", solver.Unassigned()[0].OrderNumber)
+		assert.Equal(t, "exceeds volume on every vehicle", solver.Unassigned()[0].Reason)
+	})
+
+	t.Run("OptimizeWithConstraints applies time windows and route limits", func(t *testing.T) {
+		customer := &models.Customer{Latitude: 13.7563, Longitude: 100.5018}
+		first := models.Order{ID: uuid.New(), OrderNumber: "ORD-1", Customer: customer}
+		second := models.Order{ID: uuid.New(), OrderNumber: "ORD-2", Customer: customer}
+		vehicles := []models.Vehicle{{ID: uuid.New(), CapacityKg: 1000, CostPerKm: 5}}
+		depot := models.Depot{ID: uuid.New(), Latitude: 13.7279, Longitude: 100.5241}
+
+		// A window that closes when the day starts can only be met softly
+		start := time.Date(2024, 1, 15, 8, 0, 0, 0, time.Local)
+		closed := services.TimeWindow{End: start}
+		windows := map[string]services.TimeWindow{first.ID.String(): closed}
+
+		soft := services.NewVRPSolver([]models.Order{first}, vehicles, depot).SetPlanStart(start)
+		routes, err := soft.OptimizeWithConstraints(services.VRPConstraints{TimeWindows: windows})
+		assert.NoError(t, err)
+		assert.Greater(t, routes[0].Stops[0].LateBy, time.Duration(0))
+		assert.Greater(t, routes[0].Penalty, 0.0)
+
+		hard := services.NewVRPSolver([]models.Order{first}, vehicles, depot).SetPlanStart(start)
+		_, err = hard.OptimizeWithConstraints(services.VRPConstraints{TimeWindows: windows, HardTimeWindows: true})
+		assert.ErrorIs(t, err, services.ErrUnassignedOrders)
+		assert.Contains(t, hard.Unassigned()[0].Reason, "time window")
+
+		limited := services.NewVRPSolver([]models.Order{first, second}, vehicles, depot)
+		routes, err = limited.OptimizeWithConstraints(services.VRPConstraints{MaxStopsPerRoute: 1})
+		assert.ErrorIs(t, err, services.ErrUnassignedOrders)
+		assert.Len(t, routes[0].Stops, 1)
+		assert.Len(t, limited.Unassigned(), 1)
+	})
+
+	t.Run("OptimizeWithConstraints plans within driver shifts", func(t *testing.T) {
+		nearby := models.Order{ID: uuid.New(), OrderNumber: "ORD-NEAR", Customer: &models.Customer{Latitude: 13.7563, Longitude: 100.5018}}
+		remote := models.Order{ID: uuid.New(), OrderNumber: "ORD-FAR", Customer: &models.Customer{Latitude: 18.7883, Longitude: 98.9853}}
+		vehicle := models.Vehicle{ID: uuid.New(), CapacityKg: 1000, CostPerKm: 5}
+		depot := models.Depot{ID: uuid.New(), Latitude: 13.7279, Longitude: 100.5241}
+
+		start := time.Date(2024, 1, 15, 8, 0, 0, 0, time.Local)
+		shifts := map[string]services.Shift{vehicle.ID.String(): {Start: start, End: start.Add(8 * time.Hour)}}
+
+		// The round trip to the remote customer cannot finish before the shift ends
+		short := services.NewVRPSolver([]models.Order{nearby, remote}, []models.Vehicle{vehicle}, depot)
+		routes, err := short.OptimizeWithConstraints(services.VRPConstraints{DriverShifts: shifts, MaxOvertime: -1})
+		assert.ErrorIs(t, err, services.ErrUnassignedOrders)
+		assert.Len(t, routes[0].Stops, 1)
+		assert.Equal(t, start, routes[0].ShiftStart)
+		assert.Equal(t, "ORD-FAR", short.Unassigned()[0].OrderNumber)
+		assert.Contains(t, short.Unassigned()[0].Reason, "driver shift")
+
+		// A tiny driving limit forces a rest break before the return leg
+		rested := services.NewVRPSolver([]models.Order{nearby}, []models.Vehicle{vehicle}, depot)
+		routes, err = rested.OptimizeWithConstraints(services.VRPConstraints{DriverShifts: shifts, BreakAfterDriving: time.Minute})
+		assert.NoError(t, err)
+		assert.Equal(t, 1, routes[0].Breaks)
+		assert.Zero(t, routes[0].Overtime)
+	})
+
+	t.Run("OptimizeWithConstraints prices routes and respects vehicle types", func(t *testing.T) {
+		customer := &models.Customer{Latitude: 13.7563, Longitude: 100.5018}
+		chilled := models.Order{ID: uuid.New(), OrderNumber: "ORD-CHILLED", Customer: customer}
+		dry := models.Order{ID: uuid.New(), OrderNumber: "ORD-DRY", Customer: customer}
+		fuel := models.Order{ID: uuid.New(), OrderNumber: "ORD-FUEL", Customer: customer}
+		van := models.Vehicle{ID: uuid.New(), VehicleType: "Van", CapacityKg: 1000, CostPerKm: 5, FixedCostPerDay: 500, CostPerHour: 100}
+		reefer := models.Vehicle{ID: uuid.New(), VehicleType: "Refrigerated", CapacityKg: 1000, CostPerKm: 8, FixedCostPerDay: 900, CostPerHour: 100}
+		depot := models.Depot{ID: uuid.New(), Latitude: 13.7279, Longitude: 100.5241}
+
+		solver := services.NewVRPSolver([]models.Order{chilled, dry, fuel}, []models.Vehicle{van, reefer}, depot)
+		routes, err := solver.OptimizeWithConstraints(services.VRPConstraints{
+			VehicleTypes: map[string]string{
+				chilled.ID.String(): "refrigerated",
+				fuel.ID.String():    "Tanker",
+			},
+		})
+		assert.ErrorIs(t, err, services.ErrUnassignedOrders)
+		assert.Equal(t, "ORD-FUEL", solver.Unassigned()[0].OrderNumber)
+		assert.Equal(t, "no vehicle of type Tanker available", solver.Unassigned()[0].Reason)
+
+		// The fixed cost of a second vehicle outweighs the detour, so one reefer takes both
+		assert.Len(t, routes, 1)
+		assert.Equal(t, reefer.ID.String(), routes[0].VehicleID)
+		assert.Len(t, routes[0].Stops, 2)
+		assert.Equal(t, 900.0, routes[0].FixedCost)
+		assert.Greater(t, routes[0].TimeCost, 0.0)
+		assert.InDelta(t, routes[0].TotalCost, routes[0].FixedCost+routes[0].DistanceCost+routes[0].TimeCost+routes[0].OvertimeCost, 1e-9)
+	})
+
+	t.Run("Solve improves the greedy plan with local search", func(t *testing.T) {
+		orders := make([]models.Order, 0, 24)
+		for i := 0; i < 24; i++ {
+			customer := &models.Customer{
+				Latitude:  13.70 + 0.02*float64(i%6) + 0.004*float64((i*7)%5),
+				Longitude: 100.45 + 0.03*float64(i/6) + 0.003*float64((i*3)%4),
+			}
+			orders = append(orders, models.Order{ID: uuid.New(), Customer: customer, WeightKg: 10})
+		}
+		vehicles := []models.Vehicle{
+			{ID: uuid.New(), CapacityKg: 150, CostPerKm: 5},
+			{ID: uuid.New(), CapacityKg: 150, CostPerKm: 5},
+		}
+		depot := models.Depot{ID: uuid.New(), Latitude: 13.7279, Longitude: 100.5241}
+
+		progress := make([]services.SolverProgress, 0)
+		solver := services.NewVRPSolver(orders, vehicles, depot).SetProgress(func(p services.SolverProgress) {
+			progress = append(progress, p)
+		})
+		routes, err := solver.OptimizeWithConstraints(services.VRPConstraints{ImprovementTimeLimit: time.Second})
+		assert.NoError(t, err)
+
+		report := solver.Report()
+		assert.Greater(t, report.Iterations, 0)
+		assert.Less(t, report.FinalCost, report.InitialCost)
+		assert.Greater(t, report.Improvement(), 0.0)
+
+		// Progress starts from the constructed plan and follows every improving move
+		assert.Len(t, progress, report.Iterations+1)
+		assert.InDelta(t, report.InitialCost, progress[0].BestCost, 1e-6)
+		assert.InDelta(t, report.FinalCost, progress[len(progress)-1].BestCost, 1e-6)
+		for i := 1; i < len(progress); i++ {
+			assert.Equal(t, i, progress[i].Iteration)
+			assert.Less(t, progress[i].BestCost, progress[i-1].BestCost)
+		}
+
+		stops, finalCost := 0, 0.0
+		for _, route := range routes {
+			stops += len(route.Stops)
+			finalCost += route.TotalCost + route.Penalty
+		}
+		assert.Equal(t, len(orders), stops)
+		assert.InDelta(t, report.FinalCost, finalCost, 1e-6)
+
+		// A negative budget keeps the constructed routes as they are
+		greedy := services.NewVRPSolver(orders, vehicles, depot)
+		_, err = greedy.OptimizeWithConstraints(services.VRPConstraints{ImprovementTimeLimit: -1})
+		assert.NoError(t, err)
+		assert.Zero(t, greedy.Report().Iterations)
+		assert.Equal(t, greedy.Report().InitialCost, greedy.Report().FinalCost)
+	})
+
+	t.Run("SolveContext reproduces plans and honours the context", func(t *testing.T) {
+		orders := make([]models.Order, 0, 20)
+		for i := 0; i < 20; i++ {
+			customer := &models.Customer{
+				Latitude:  13.70 + 0.02*float64(i%5) + 0.004*float64((i*7)%5),
+				Longitude: 100.45 + 0.03*float64(i/5) + 0.003*float64((i*3)%4),
+			}
+			orders = append(orders, models.Order{ID: uuid.New(), Customer: customer, WeightKg: 10})
+		}
+		vehicles := []models.Vehicle{
+			{ID: uuid.New(), CapacityKg: 120, CostPerKm: 5},
+			{ID: uuid.New(), CapacityKg: 120, CostPerKm: 5},
+		}
+		depot := models.Depot{ID: uuid.New(), Latitude: 13.7279, Longitude: 100.5241}
+		start := time.Date(2024, 1, 15, 8, 0, 0, 0, time.Local)
+
+		plan := func(seed int64) []string {
+			solver := services.NewVRPSolver(orders, vehicles, depot).SetPlanStart(start)
+			routes, err := solver.OptimizeWithConstraints(services.VRPConstraints{Seed: seed, ImprovementTimeLimit: 5 * time.Second})
+			assert.NoError(t, err)
+			assert.False(t, solver.Report().TimedOut)
+			assert.Equal(t, seed, solver.Report().Seed)
+
+			stops := make([]string, 0, len(orders))
+			for _, route := range routes {
+				assert.Equal(t, start, route.ShiftStart)
+				for _, stop := range route.Stops {
+					stops = append(stops, route.VehicleID+"/"+stop.OrderID)
+				}
+			}
+			return stops
+		}
+		assert.Equal(t, plan(0), plan(0))
+		assert.Equal(t, plan(42), plan(42))
+
+		cancelled, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := services.NewVRPSolver(orders, vehicles, depot).SolveContext(cancelled)
+		assert.ErrorIs(t, err, context.Canceled)
+
+		// A passed deadline stops local search but keeps the constructed plan
+		expired, cancelExpired := context.WithDeadline(context.Background(), time.Now())
+		defer cancelExpired()
+		solver := services.NewVRPSolver(orders, vehicles, depot)
+		routes, err := solver.SolveContext(expired)
+		assert.NoError(t, err)
+		assert.NotEmpty(t, routes)
+		assert.True(t, solver.Report().TimedOut)
+	})
+
+	t.Run("Solve serves orders from the nearest depot", func(t *testing.T) {
+		north := models.Depot{ID: uuid.New(), Latitude: 13.90, Longitude: 100.50}
+		south := models.Depot{ID: uuid.New(), Latitude: 13.50, Longitude: 100.50}
+		orders := []models.Order{
+			{ID: uuid.New(), OrderNumber: "N1", Customer: &models.Customer{Latitude: 13.91, Longitude: 100.51}},
+			{ID: uuid.New(), OrderNumber: "S1", Customer: &models.Customer{Latitude: 13.49, Longitude: 100.49}},
+			{ID: uuid.New(), OrderNumber: "N2", Customer: &models.Customer{Latitude: 13.89, Longitude: 100.49}},
+			{ID: uuid.New(), OrderNumber: "S2", Customer: &models.Customer{Latitude: 13.51, Longitude: 100.51}},
+		}
+		northVan := models.Vehicle{ID: uuid.New(), DepotID: north.ID, CapacityKg: 1000, CostPerKm: 5}
+		southVan := models.Vehicle{ID: uuid.New(), DepotID: south.ID, CapacityKg: 1000, CostPerKm: 5}
+
+		numbers := make(map[string]string, len(orders))
+		for _, order := range orders {
+			numbers[order.ID.String()] = order.OrderNumber
+		}
+
+		for _, strategy := range []string{services.StrategyGreedy, services.StrategySavings, services.StrategyLocalSearch} {
+			solver := services.NewVRPSolver(orders, []models.Vehicle{northVan, southVan}, north).
+				SetDepots([]models.Depot{north, south}).
+				SetStrategy(strategy)
+			routes, err := solver.Solve()
+			assert.NoError(t, err, strategy)
+			assert.Len(t, routes, 2, strategy)
+
+			for _, route := range routes {
+				prefix := "N"
+				if route.StartDepotID == south.ID.String() {
+					prefix = "S"
+				}
+				assert.Equal(t, route.StartDepotID, route.EndDepotID, strategy)
+				for _, stop := range route.Stops {
+					assert.Equal(t, prefix, numbers[stop.OrderID][:1], strategy)
+				}
+			}
+		}
+
+		// A pinned end depot makes the north van finish its route in the south
+		solver := services.NewVRPSolver(orders[:1], []models.Vehicle{northVan}, north).SetDepots([]models.Depot{north, south})
+		routes, err := solver.OptimizeWithConstraints(services.VRPConstraints{
+			EndDepots: map[string]string{northVan.ID.String(): south.ID.String()},
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, north.ID.String(), routes[0].StartDepotID)
+		assert.Equal(t, south.ID.String(), routes[0].EndDepotID)
+		assert.Greater(t, routes[0].TotalDistance, 40.0)
+	})
+
+	t.Run("Solve picks up paired orders before delivering them", func(t *testing.T) {
+		depot := models.Depot{ID: uuid.New(), Address: "1 Depot Road", Latitude: 13.70, Longitude: 100.50}
+		local := models.Order{
+			ID: uuid.New(), OrderNumber: "ORD-LOCAL", WeightKg: 60, PickupAddress: "1 Depot Road",
+			Customer: &models.Customer{Latitude: 13.72, Longitude: 100.50},
+		}
+		transfer := models.Order{
+			ID: uuid.New(), OrderNumber: "ORD-TRANSFER", WeightKg: 80,
+			PickupAddress: "Supplier", PickupLatitude: 13.80, PickupLongitude: 100.50,
+			Customer: &models.Customer{Latitude: 13.90, Longitude: 100.50},
+		}
+		vehicle := models.Vehicle{ID: uuid.New(), CapacityKg: 100, CostPerKm: 5}
+
+		// 140 kg never fits at once, so the local order must be dropped before the transfer is collected
+		for _, strategy := range []string{services.StrategyGreedy, services.StrategySavings, services.StrategyLocalSearch} {
+			solver := services.NewVRPSolver([]models.Order{local, transfer}, []models.Vehicle{vehicle}, depot).SetStrategy(strategy)
+			routes, err := solver.Solve()
+			assert.NoError(t, err, strategy)
+			assert.Len(t, routes, 1, strategy)
+
+			stops := routes[0].Stops
+			assert.Len(t, stops, 3, strategy)
+			types := make([]string, 0, len(stops))
+			for _, stop := range stops {
+				types = append(types, stop.StopType)
+			}
+			assert.Equal(t, []string{services.StopTypeDelivery, services.StopTypePickup, services.StopTypeDelivery}, types, strategy)
+			assert.Equal(t, local.ID.String(), stops[0].OrderID, strategy)
+			assert.Equal(t, transfer.ID.String(), stops[1].OrderID, strategy)
+			assert.Equal(t, "Supplier", stops[1].Location, strategy)
+			assert.Equal(t, 60.0, routes[0].Load.WeightKg, strategy)
+			assert.InDelta(t, 80.0, routes[0].Utilization, 1e-9, strategy)
+		}
+	})
+
+	t.Run("Retime times stops in the given sequence", func(t *testing.T) {
+		depot := models.Depot{ID: uuid.New(), Latitude: 13.70, Longitude: 100.50}
+		near := models.Order{ID: uuid.New(), OrderNumber: "ORD-NEAR", Customer: &models.Customer{Latitude: 13.75, Longitude: 100.50}}
+		far := models.Order{ID: uuid.New(), OrderNumber: "ORD-FAR", Customer: &models.Customer{Latitude: 13.90, Longitude: 100.50}}
+		vehicle := models.Vehicle{ID: uuid.New(), CapacityKg: 100, CostPerKm: 5}
+		start := time.Date(2024, 1, 15, 8, 0, 0, 0, time.Local)
+
+		// The far stop stays first although visiting the near one on the way is shorter
+		route := services.FixedRoute{Vehicle: vehicle, Stops: []services.FixedStop{
+			{OrderID: far.ID.String()}, {OrderID: near.ID.String()},
+		}}
+		solver := services.NewVRPSolver([]models.Order{near, far}, []models.Vehicle{vehicle}, depot).SetPlanStart(start)
+		results, err := solver.Retime(context.Background(), []services.FixedRoute{route})
+		assert.NoError(t, err)
+		assert.Len(t, results, 1)
+
+		stops := results[0].Stops
+		assert.Len(t, stops, 2)
+		assert.Equal(t, far.ID.String(), stops[0].OrderID)
+		assert.Equal(t, near.ID.String(), stops[1].OrderID)
+		assert.True(t, stops[0].ArrivalTime.After(start))
+		assert.True(t, stops[1].ArrivalTime.After(stops[0].DepartureTime) || stops[1].ArrivalTime.Equal(stops[0].DepartureTime))
+		assert.InDelta(t, 22.2, stops[0].Distance, 2)
+		assert.InDelta(t, 16.7, stops[1].Distance, 2)
+		assert.Greater(t, results[0].TotalDistance, stops[0].Distance+stops[1].Distance)
+		assert.Greater(t, results[0].TotalCost, 0.0)
+	})
+
+	t.Run("QuoteInsertion prices the cheapest position across routes", func(t *testing.T) {
+		depot := models.Depot{ID: uuid.New(), Latitude: 13.70, Longitude: 100.50}
+		north := models.Order{ID: uuid.New(), OrderNumber: "ORD-NORTH", WeightKg: 50, Customer: &models.Customer{Latitude: 13.90, Longitude: 100.50}}
+		south := models.Order{ID: uuid.New(), OrderNumber: "ORD-SOUTH", WeightKg: 50, Customer: &models.Customer{Latitude: 13.50, Longitude: 100.50}}
+		near := models.Order{ID: uuid.New(), OrderNumber: "ORD-NEAR", WeightKg: 40, Customer: &models.Customer{Latitude: 13.85, Longitude: 100.50}}
+		heavy := models.Order{ID: uuid.New(), OrderNumber: "ORD-HEAVY", WeightKg: 500, Customer: &models.Customer{Latitude: 13.85, Longitude: 100.50}}
+		vehicles := []models.Vehicle{
+			{ID: uuid.New(), CapacityKg: 100, CostPerKm: 5},
+			{ID: uuid.New(), CapacityKg: 100, CostPerKm: 5},
+		}
+		routes := []services.FixedRoute{
+			{Vehicle: vehicles[0], Stops: []services.FixedStop{{OrderID: south.ID.String()}}},
+			{Vehicle: vehicles[1], Stops: []services.FixedStop{{OrderID: north.ID.String()}}},
+		}
+
+		// The near order joins the northern route on the way out
+		solver := services.NewVRPSolver([]models.Order{north, south, near, heavy}, vehicles, depot)
+		quote, err := solver.QuoteInsertion(context.Background(), routes, near.ID.String())
+		assert.NoError(t, err)
+		assert.True(t, quote.Feasible)
+		assert.Empty(t, quote.Violations)
+		assert.Equal(t, 1, quote.RouteIndex)
+		assert.Equal(t, vehicles[1].ID.String(), quote.VehicleID)
+		assert.Equal(t, 0, quote.Position)
+		assert.Equal(t, -1, quote.PickupPosition)
+		assert.GreaterOrEqual(t, quote.ExtraCost, 0.0)
+		assert.GreaterOrEqual(t, quote.ExtraTime, time.Duration(0))
+		assert.Len(t, quote.Route.Stops, 2)
+
+		// An order no vehicle can carry is still quoted, with the constraint it breaks
+		quote, err = solver.QuoteInsertion(context.Background(), routes, heavy.ID.String())
+		assert.NoError(t, err)
+		assert.False(t, quote.Feasible)
+		assert.NotEmpty(t, quote.Violations)
+
+		_, err = solver.QuoteInsertion(context.Background(), routes, north.ID.String())
+		assert.Error(t, err)
+	})
+
 	t.Run("Solve with no vehicles", func(t *testing.T) {
 		orders := []models.Order{{ID: uuid.New()}}
 		vehicles := []models.Vehicle{}
@@ -70,6 +456,149 @@ func TestVRPSolver(t *testing.T) {
 	})
 }
 
+func TestOptimizerRegistry(t *testing.T) {
+	orders := make([]models.Order, 0, 12)
+	for i := 0; i < 12; i++ {
+		customer := &models.Customer{Latitude: 13.70 + 0.01*float64(i%4), Longitude: 100.48 + 0.02*float64(i/4)}
+		orders = append(orders, models.Order{ID: uuid.New(), Customer: customer, WeightKg: 10})
+	}
+	vehicles := []models.Vehicle{
+		{ID: uuid.New(), CapacityKg: 80, CostPerKm: 5},
+		{ID: uuid.New(), CapacityKg: 80, CostPerKm: 5},
+	}
+	depot := models.Depot{ID: uuid.New(), Latitude: 13.7279, Longitude: 100.5241}
+	problem := services.OptimizationProblem{Orders: orders, Vehicles: vehicles, Depot: depot}
+
+	t.Run("Get resolves strategies", func(t *testing.T) {
+		registry := services.NewOptimizerRegistry(nil)
+
+		optimizer, err := registry.Get("")
+		assert.NoError(t, err)
+		assert.Equal(t, services.DefaultStrategy, optimizer.Name())
+
+		_, err = registry.Get("simulated_annealing")
+		assert.ErrorIs(t, err, services.ErrUnknownStrategy)
+
+		_, err = registry.Get(services.StrategyExternal)
+		assert.ErrorIs(t, err, services.ErrUnknownStrategy)
+	})
+
+	t.Run("Construction strategies assign every order", func(t *testing.T) {
+		registry := services.NewOptimizerRegistry(nil)
+		numbers := make(map[string]string, len(orders))
+		for _, order := range orders {
+			numbers[order.ID.String()] = order.OrderNumber
+		}
+
+		for _, strategy := range []string{services.StrategyGreedy, services.StrategySavings, services.StrategyLocalSearch} {
+			optimizer, err := registry.Get(strategy)
+			assert.NoError(t, err)
+
+			result, err := optimizer.Optimize(context.Background(), problem)
+			assert.NoError(t, err, strategy)
+			assert.Equal(t, strategy, result.Strategy)
+			assert.Empty(t, result.Unassigned, strategy)
+
+			stops := 0
+			for _, route := range result.Routes {
+				stops += len(route.Stops)
+			}
+			assert.Equal(t, len(orders), stops, strategy)
+			if strategy != services.StrategyLocalSearch {
+				assert.Zero(t, result.Report.Iterations, strategy)
+			}
+		}
+	})
+
+	t.Run("External strategy re-times routes from the solver service", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var req services.ExternalSolveRequest
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			assert.Len(t, req.Nodes, len(orders)+1)
+
+			// Split the orders evenly, in reverse, over the two vehicles
+			routes := []map[string]interface{}{}
+			for v, vehicle := range req.Vehicles {
+				nodes := []int{}
+				for node := len(req.Nodes) - 1 - v; node >= 1; node -= len(req.Vehicles) {
+					nodes = append(nodes, node)
+				}
+				routes = append(routes, map[string]interface{}{"vehicle_id": vehicle.VehicleID, "nodes": nodes})
+			}
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"routes": routes})
+		}))
+		defer server.Close()
+
+		registry := services.NewOptimizerRegistry(nil).SetExternalSolver(services.NewExternalSolverClient(server.URL, time.Second))
+		optimizer, err := registry.Get(services.StrategyExternal)
+		assert.NoError(t, err)
+
+		result, err := optimizer.Optimize(context.Background(), problem)
+		assert.NoError(t, err)
+		assert.Len(t, result.Routes, 2)
+		assert.Equal(t, orders[len(orders)-1].ID.String(), result.Routes[0].Stops[0].OrderID)
+		assert.Greater(t, result.Routes[0].TotalDistance, 0.0)
+	})
+
+	t.Run("External strategy leaves out a negative time limit", func(t *testing.T) {
+		requested := false
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requested = true
+			var req map[string]interface{}
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&req))
+			assert.NotContains(t, req, "time_limit_ms")
+			_ = json.NewEncoder(w).Encode(map[string]interface{}{"routes": []interface{}{}})
+		}))
+		defer server.Close()
+
+		registry := services.NewOptimizerRegistry(nil).SetExternalSolver(services.NewExternalSolverClient(server.URL, time.Second))
+		optimizer, err := registry.Get(services.StrategyExternal)
+		assert.NoError(t, err)
+
+		skipImprovement := problem
+		skipImprovement.Constraints.ImprovementTimeLimit = -time.Second
+		// The solver routes nothing, so only the request matters
+		_, _ = optimizer.Optimize(context.Background(), skipImprovement)
+		assert.True(t, requested)
+	})
+}
+
+func TestHaversineKm(t *testing.T) {
+	bangkok := services.LatLng{Lat: 13.7563, Lng: 100.5018}
+	chiangMai := services.LatLng{Lat: 18.7883, Lng: 98.9853}
+
+	assert.InDelta(t, 0, services.HaversineKm(bangkok, bangkok), 1e-9)
+	assert.InDelta(t, 583, services.HaversineKm(bangkok, chiangMai), 5)
+	assert.InDelta(t, services.HaversineKm(bangkok, chiangMai), services.HaversineKm(chiangMai, bangkok), 1e-9)
+}
+
+func TestOSRMMatrix(t *testing.T) {
+	t.Run("Pairs OSRM cannot route between are estimated as the crow flies", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = w.Write([]byte(`{"code":"Ok","distances":[[0,12000],[null,0]],"durations":[[0,900],[null,0]]}`))
+		}))
+		defer server.Close()
+
+		// No cache is reachable, so the table comes straight from OSRM
+		previous := cache.RedisClient
+		cache.RedisClient = redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1, DialTimeout: 10 * time.Millisecond})
+		defer func() { cache.RedisClient = previous }()
+
+		bangkok := services.LatLng{Lat: 13.7563, Lng: 100.5018}
+		ayutthaya := services.LatLng{Lat: 14.3532, Lng: 100.5689}
+		proxy := maps.NewMapsProxyService("", server.URL, 100, 100)
+		matrix, err := services.NewOSRMMatrix(proxy, []services.LatLng{bangkok, ayutthaya})
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		assert.InDelta(t, 12, matrix.Distance(0, 1), 1e-9)
+		assert.Equal(t, 15*time.Minute, matrix.Duration(0, 1))
+		assert.InDelta(t, services.HaversineKm(ayutthaya, bangkok), matrix.Distance(1, 0), 1e-9)
+		assert.Greater(t, matrix.Duration(1, 0), time.Duration(0))
+	})
+}
+
 func TestReplanner(t *testing.T) {
 	t.Run("Generate alternatives", func(t *testing.T) {
 		event := services.ReplanEvent{
@@ -92,6 +621,575 @@ func TestReplanner(t *testing.T) {
 		assert.NotEmpty(t, alternatives[0].Pros)
 		assert.NotEmpty(t, alternatives[0].Cons)
 	})
+
+	t.Run("Alternatives count late and moved stops", func(t *testing.T) {
+		start := time.Date(2024, 1, 15, 10, 0, 0, 0, time.Local)
+		requiredBy := start.Add(-time.Hour)
+		depot := models.Depot{ID: uuid.New(), Latitude: 13.70, Longitude: 100.50}
+		near := models.Order{ID: uuid.New(), OrderNumber: "NEAR", RequiredBy: &requiredBy,
+			Customer: &models.Customer{Latitude: 13.71, Longitude: 100.50}}
+		far := models.Order{ID: uuid.New(), OrderNumber: "FAR",
+			Customer: &models.Customer{Latitude: 13.80, Longitude: 100.50, TimeWindowEnd: "17:00"}}
+		vehicle := models.Vehicle{ID: uuid.New(), CapacityKg: 1000, CostPerKm: 5}
+
+		// The current plan visits the far stop first, after a stop that was already delivered
+		currentRoute := models.Route{ID: uuid.New(), VehicleID: vehicle.ID, Stops: []models.RouteStop{
+			{OrderID: uuid.New(), Sequence: 1, Status: "completed"},
+			{OrderID: far.ID, Sequence: 2, Status: "pending"},
+			{OrderID: near.ID, Sequence: 3, Status: "pending"},
+		}}
+
+		replanner := services.NewReplanner().SetPlanStart(start)
+		alternatives, err := replanner.GenerateAlternatives(services.ReplanEvent{Type: "time_change"},
+			[]models.Route{currentRoute}, []models.Order{near, far}, []models.Vehicle{vehicle}, depot)
+		assert.NoError(t, err)
+
+		cheapest := alternatives[1]
+		assert.Equal(t, "alt_minimize_cost", cheapest.ID)
+		assert.Len(t, cheapest.Routes, 1)
+		assert.Equal(t, near.ID.String(), cheapest.Routes[0].Stops[0].OrderID)
+		assert.Equal(t, 1, cheapest.LateDeliveries) // NEAR was required an hour before the replan
+		assert.Equal(t, 2, cheapest.ChangedStops)   // both stops swap places
+
+		// The same sequence on the same vehicle is no change
+		currentRoute.Stops[1].OrderID, currentRoute.Stops[2].OrderID = near.ID, far.ID
+		alternatives, err = replanner.GenerateAlternatives(services.ReplanEvent{Type: "time_change"},
+			[]models.Route{currentRoute}, []models.Order{near, far}, []models.Vehicle{vehicle}, depot)
+		assert.NoError(t, err)
+		assert.Zero(t, alternatives[1].ChangedStops)
+	})
+
+	t.Run("Stops behind an in-progress stop keep their place", func(t *testing.T) {
+		start := time.Date(2024, 1, 15, 10, 0, 0, 0, time.Local)
+		depot := models.Depot{ID: uuid.New(), Latitude: 13.70, Longitude: 100.50}
+		at := func(lat float64) models.Order {
+			return models.Order{ID: uuid.New(), Status: "assigned", Customer: &models.Customer{Latitude: lat, Longitude: 100.50}}
+		}
+		current, next, last := at(13.71), at(13.72), at(13.74)
+		vehicle := models.Vehicle{ID: uuid.New(), CapacityKg: 1000, CostPerKm: 5}
+		route := models.Route{ID: uuid.New(), VehicleID: vehicle.ID, Stops: []models.RouteStop{
+			{ID: uuid.New(), OrderID: current.ID, Sequence: 1, Status: "in_progress"},
+			{ID: uuid.New(), OrderID: next.ID, Sequence: 2, Status: "pending"},
+			{ID: uuid.New(), OrderID: last.ID, Sequence: 3, Status: "pending"},
+		}}
+
+		alternatives, err := services.NewReplanner().SetPlanStart(start).GenerateAlternatives(services.ReplanEvent{Type: "time_change"},
+			[]models.Route{route}, []models.Order{current, next, last}, []models.Vehicle{vehicle}, depot)
+		assert.NoError(t, err)
+		for _, alt := range alternatives {
+			if alt.ID == "alt_minimize_changes" {
+				assert.Zero(t, alt.ChangedStops)
+			}
+		}
+	})
+
+	t.Run("Alternatives name the stops they replace", func(t *testing.T) {
+		depot := models.Depot{ID: uuid.New(), Latitude: 13.70, Longitude: 100.50}
+		first := models.Order{ID: uuid.New(), Status: "assigned", Customer: &models.Customer{Latitude: 13.72, Longitude: 100.50}}
+		second := models.Order{ID: uuid.New(), Status: "assigned", Customer: &models.Customer{Latitude: 13.74, Longitude: 100.50}}
+		vehicle := models.Vehicle{ID: uuid.New(), CapacityKg: 1000}
+		route := models.Route{ID: uuid.New(), VehicleID: vehicle.ID, Stops: []models.RouteStop{
+			{ID: uuid.New(), OrderID: first.ID, Sequence: 1, Status: "in_progress"},
+			{ID: uuid.New(), OrderID: second.ID, Sequence: 2, Status: "pending"},
+		}}
+
+		alternatives, err := services.NewReplanner().GenerateAlternatives(services.ReplanEvent{Type: "time_change"},
+			[]models.Route{route}, []models.Order{first, second}, []models.Vehicle{vehicle}, depot)
+		assert.NoError(t, err)
+		for _, alt := range alternatives {
+			assert.Equal(t, []string{second.ID.String()}, alt.Orders, alt.ID)
+			assert.Equal(t, []string{route.Stops[1].ID.String()}, alt.ReplacedStops, alt.ID)
+		}
+	})
+
+	t.Run("Scoring follows the depot and tier profile", func(t *testing.T) {
+		depotID := uuid.New()
+		profiles := []models.ScoringProfile{
+			{Name: "fallback", CostWeight: 1, LateWeight: 1, ChangeWeight: 1},
+			{Name: "depot", DepotID: &depotID, CostWeight: 1},
+			{Name: "premium", CustomerTier: "premium", LateWeight: 1},
+			{Name: "other depot", DepotID: func() *uuid.UUID { id := uuid.New(); return &id }(), ChangeWeight: 1},
+		}
+		assert.Equal(t, "depot", services.ResolveScoringProfile(profiles, depotID, "standard").Name)
+		assert.Equal(t, "premium", services.ResolveScoringProfile(profiles, depotID, "premium").Name)
+		assert.Equal(t, "fallback", services.ResolveScoringProfile(profiles, uuid.New(), "").Name)
+		assert.Equal(t, "default", services.ResolveScoringProfile(nil, depotID, "").Name)
+
+		assert.NoError(t, services.ValidateScoringProfile(profiles[1]))
+		assert.ErrorIs(t, services.ValidateScoringProfile(models.ScoringProfile{}), services.ErrInvalidScoringProfile)
+		assert.ErrorIs(t, services.ValidateScoringProfile(models.ScoringProfile{CostWeight: 2, LateWeight: -1}), services.ErrInvalidScoringProfile)
+
+		// Scores are relative to carrying on with the current plan
+		start := time.Date(2024, 1, 15, 10, 0, 0, 0, time.Local)
+		depot := models.Depot{ID: depotID, Latitude: 13.70, Longitude: 100.50}
+		near := models.Order{ID: uuid.New(), Status: "assigned", Customer: &models.Customer{Latitude: 13.71, Longitude: 100.50}}
+		far := models.Order{ID: uuid.New(), Status: "assigned", Customer: &models.Customer{Latitude: 13.80, Longitude: 100.50}}
+		vehicle := models.Vehicle{ID: uuid.New(), CapacityKg: 1000, CostPerKm: 5}
+		route := models.Route{ID: uuid.New(), VehicleID: vehicle.ID, Stops: []models.RouteStop{
+			{ID: uuid.New(), OrderID: far.ID, Sequence: 1, Status: "pending"},
+			{ID: uuid.New(), OrderID: near.ID, Sequence: 2, Status: "pending"},
+		}}
+
+		alternatives, err := services.NewReplanner().SetPlanStart(start).SetScoringProfiles(profiles).
+			GenerateAlternatives(services.ReplanEvent{Type: "time_change"},
+				[]models.Route{route}, []models.Order{near, far}, []models.Vehicle{vehicle}, depot)
+		assert.NoError(t, err)
+		for _, alt := range alternatives {
+			breakdown := alt.Breakdown
+			assert.Equal(t, "depot", breakdown.Profile)
+			assert.Equal(t, 1.0, breakdown.Cost.Weight)
+			assert.Zero(t, breakdown.Late.Weight)
+			assert.Equal(t, 2.0, breakdown.Changes.Baseline) // both open stops may move
+			assert.Greater(t, breakdown.Cost.Baseline, 0.0)
+			assert.InDelta(t, alt.TotalCost/breakdown.Cost.Baseline, breakdown.Cost.Normalized, 1e-9)
+			assert.InDelta(t, breakdown.Cost.Score, alt.Score, 1e-9)
+		}
+
+		// Keeping the current sequence costs the same as the current plan
+		changes := alternatives[2]
+		assert.Equal(t, "alt_minimize_changes", changes.ID)
+		assert.InDelta(t, 1, changes.Breakdown.Cost.Normalized, 1e-9)
+		assert.Less(t, alternatives[1].Score, changes.Score) // re-sequencing is cheaper
+	})
+
+	t.Run("Events only reopen the stops they concern", func(t *testing.T) {
+		start := time.Date(2024, 1, 15, 9, 0, 0, 0, time.Local)
+		depot := models.Depot{ID: uuid.New(), Latitude: 13.70, Longitude: 100.50}
+		at := func(number string, lat, lng float64) models.Order {
+			return models.Order{ID: uuid.New(), OrderNumber: number, Status: "assigned",
+				Customer: &models.Customer{Latitude: lat, Longitude: lng}}
+		}
+		done, a1, a2, b1 := at("DONE", 13.71, 100.50), at("A1", 13.72, 100.50), at("A2", 13.74, 100.50), at("B1", 13.71, 100.52)
+		fresh := at("NEW", 13.73, 100.51)
+		fresh.Status = "pending"
+		orders := []models.Order{done, a1, a2, b1, fresh}
+
+		truckA := models.Vehicle{ID: uuid.New(), CapacityKg: 1000, CostPerKm: 5}
+		truckB := models.Vehicle{ID: uuid.New(), CapacityKg: 1000, CostPerKm: 5}
+		spare := models.Vehicle{ID: uuid.New(), CapacityKg: 1000, CostPerKm: 5}
+		vehicles := []models.Vehicle{truckA, truckB, spare}
+		routes := []models.Route{
+			{ID: uuid.New(), VehicleID: truckA.ID, Stops: []models.RouteStop{
+				{OrderID: done.ID, Sequence: 1, Status: "completed"},
+				{OrderID: a1.ID, Sequence: 2, Status: "pending"},
+				{OrderID: a2.ID, Sequence: 3, Status: "pending"},
+			}},
+			{ID: uuid.New(), VehicleID: truckB.ID, Stops: []models.RouteStop{
+				{OrderID: b1.ID, Sequence: 1, Status: "in_progress"},
+			}},
+		}
+		replanner := services.NewReplanner().SetPlanStart(start).
+			SetPositions(map[string]services.LatLng{truckA.ID.String(): {Lat: 13.715, Lng: 100.50}})
+
+		byID := func(alternatives []services.Alternative, id string) services.Alternative {
+			for _, alt := range alternatives {
+				if alt.ID == id {
+					return alt
+				}
+			}
+			t.Fatalf("no alternative %s", id)
+			return services.Alternative{}
+		}
+
+		// A breakdown moves the broken truck's open stops, collecting its cargo where it stands
+		breakdown := services.ReplanEvent{Type: "vehicle_breakdown", VehicleID: truckA.ID.String()}
+		alternatives, err := replanner.GenerateAlternatives(breakdown, routes, orders, vehicles, depot)
+		assert.NoError(t, err)
+		for _, alt := range alternatives {
+			delivered := make(map[string]bool)
+			for _, route := range alt.Routes {
+				assert.NotEqual(t, truckA.ID.String(), route.VehicleID)
+				for _, stop := range route.Stops {
+					assert.NotContains(t, []string{done.ID.String(), b1.ID.String(), fresh.ID.String()}, stop.OrderID)
+					if stop.StopType == services.StopTypePickup {
+						assert.InDelta(t, 13.715, stop.Latitude, 1e-9)
+					} else {
+						delivered[stop.OrderID] = true
+					}
+				}
+			}
+			assert.Equal(t, map[string]bool{a1.ID.String(): true, a2.ID.String(): true}, delivered, alt.ID)
+		}
+		for _, route := range byID(alternatives, "alt_minimize_cost").Routes {
+			assert.Equal(t, spare.ID.String(), route.VehicleID) // other routes keep their stops
+		}
+
+		// A new order is inserted into a current route that otherwise keeps its sequence
+		alternatives, err = replanner.GenerateAlternatives(services.ReplanEvent{Type: "new_order", OrderID: fresh.ID.String()},
+			routes, orders, vehicles, depot)
+		assert.NoError(t, err)
+		changes := byID(alternatives, "alt_minimize_changes")
+		if assert.Len(t, changes.Routes, 1) && changes.Routes[0].VehicleID == truckA.ID.String() {
+			sequence := make([]string, 0)
+			for _, stop := range changes.Routes[0].Stops {
+				if stop.OrderID != fresh.ID.String() {
+					sequence = append(sequence, stop.OrderID)
+				}
+			}
+			assert.Equal(t, []string{a1.ID.String(), a2.ID.String()}, sequence)
+		}
+
+		_, err = replanner.GenerateAlternatives(services.ReplanEvent{Type: "new_order", OrderID: a1.ID.String()},
+			routes, orders, vehicles, depot)
+		assert.ErrorIs(t, err, services.ErrInvalidReplanEvent)
+
+		// A jam slows the routes that pass through it and leaves the rest alone
+		planned := []models.Order{done, a1, a2, b1}
+		retimed, err := replanner.GenerateAlternatives(services.ReplanEvent{Type: "time_change"}, routes, planned, vehicles, depot)
+		assert.NoError(t, err)
+		jam := services.ReplanEvent{Type: "traffic_jam", Severity: "low", Latitude: 13.74, Longitude: 100.50}
+		jammed, err := replanner.GenerateAlternatives(jam, routes, planned, vehicles, depot)
+		assert.NoError(t, err)
+
+		before, after := byID(retimed, "alt_minimize_changes"), byID(jammed, "alt_minimize_changes")
+		assert.Len(t, after.Routes, 1)
+		assert.Zero(t, after.ChangedStops)
+		assert.Equal(t, truckA.ID.String(), after.Routes[0].VehicleID)
+		assert.True(t, after.Routes[0].Stops[1].ArrivalTime.After(before.Routes[0].Stops[1].ArrivalTime))
+
+		_, err = replanner.GenerateAlternatives(services.ReplanEvent{Type: "traffic_jam"}, routes, planned, vehicles, depot)
+		assert.ErrorIs(t, err, services.ErrInvalidReplanEvent)
+	})
+}
+
+func TestRouteLocks(t *testing.T) {
+	t.Run("Locked routes refuse changes unless overridden", func(t *testing.T) {
+		open := models.Route{RouteNumber: "R-OPEN"}
+		locked := models.Route{RouteNumber: "R-LOCKED", IsLocked: true, LockReason: "customer confirmed slots"}
+
+		assert.NoError(t, services.CheckRouteUnlocked(open, false))
+		err := services.CheckRouteUnlocked(locked, false)
+		assert.ErrorIs(t, err, services.ErrRouteLocked)
+		assert.Contains(t, err.Error(), "R-LOCKED")
+		assert.Contains(t, err.Error(), "customer confirmed slots")
+		assert.NoError(t, services.CheckRouteUnlocked(locked, true))
+	})
+
+	t.Run("Only a missing route is reported as not found", func(t *testing.T) {
+		db := openTestDB(t)
+		_, err := services.LockRouteForChange(db, uuid.New(), false)
+		assert.ErrorIs(t, err, services.ErrRouteNotFound)
+
+		assert.NoError(t, db.Migrator().DropTable(&models.Route{}))
+		_, err = services.LockRouteForChange(db, uuid.New(), false)
+		assert.Error(t, err)
+		assert.NotErrorIs(t, err, services.ErrRouteNotFound)
+	})
+}
+
+func TestRouteStateMachine(t *testing.T) {
+	sm := services.NewRouteStateMachine()
+
+	t.Run("Routes follow their lifecycle", func(t *testing.T) {
+		assert.True(t, sm.CanTransition(services.RouteStatusPlanned, services.RouteStatusAssigned))
+		assert.True(t, sm.CanTransition(services.RouteStatusAssigned, services.RouteStatusDispatched))
+		assert.True(t, sm.CanTransition(services.RouteStatusDispatched, services.RouteStatusInProgress))
+		assert.True(t, sm.CanTransition(services.RouteStatusInProgress, services.RouteStatusCompleted))
+		assert.True(t, sm.CanTransition(services.RouteStatusInProgress, services.RouteStatusAborted))
+
+		assert.False(t, sm.CanTransition(services.RouteStatusPlanned, services.RouteStatusInProgress))
+		assert.False(t, sm.CanTransition(services.RouteStatusCompleted, services.RouteStatusInProgress))
+		assert.ErrorIs(t, sm.ValidateTransition(services.RouteStatusAssigned, services.RouteStatusCompleted), services.ErrInvalidRouteTransition)
+		assert.ElementsMatch(t, []services.RouteStatus{services.RouteStatusCompleted, services.RouteStatusAborted}, sm.NextStates(services.RouteStatusInProgress))
+	})
+
+	t.Run("Completed and aborted routes are closed", func(t *testing.T) {
+		assert.True(t, sm.IsClosed(services.RouteStatusCompleted))
+		assert.True(t, sm.IsClosed(services.RouteStatusAborted))
+		assert.False(t, sm.IsClosed(services.RouteStatusDispatched))
+		assert.ErrorIs(t, services.CheckRouteOpen(models.Route{RouteNumber: "R-1", Status: "completed"}), services.ErrRouteClosed)
+	})
+}
+
+func TestOrderStatusService(t *testing.T) {
+	statuses := services.NewOrderStatusService()
+
+	t.Run("Invalid changes are refused with the allowed next statuses", func(t *testing.T) {
+		order := &models.Order{OrderNumber: "ORD-1", Status: "assigned"}
+		err := statuses.Transition(nil, order, services.OrderStatusDelivered, services.OrderStatusChange{})
+		assert.ErrorIs(t, err, services.ErrInvalidOrderTransition)
+
+		var transition *services.OrderTransitionError
+		if assert.ErrorAs(t, err, &transition) {
+			assert.Equal(t, services.OrderStatusAssigned, transition.From)
+			assert.ElementsMatch(t, []services.OrderStatus{
+				services.OrderStatusPickedUp, services.OrderStatusCancelled, services.OrderStatusPending,
+			}, transition.Allowed)
+		}
+		assert.Equal(t, "assigned", order.Status)
+	})
+
+	t.Run("Delivered orders cannot be cancelled", func(t *testing.T) {
+		order := models.Order{OrderNumber: "ORD-2", Status: "delivered"}
+		assert.False(t, statuses.CanTransition(order, services.OrderStatusCancelled))
+		assert.Empty(t, statuses.NextStates(order))
+	})
+}
+
+func TestOrderTimeline(t *testing.T) {
+	t.Run("Timeline merges an order's history in time order", func(t *testing.T) {
+		start := time.Date(2026, 3, 2, 8, 0, 0, 0, time.UTC)
+		at := func(minutes int) time.Time { return start.Add(time.Duration(minutes) * time.Minute) }
+		routeID, otherRouteID := uuid.New(), uuid.New()
+		arrived, departed := at(60), at(65)
+		stop := models.RouteStop{ID: uuid.New(), RouteID: routeID, StopType: services.StopTypeDelivery, Status: "completed",
+			ActualArrival: &arrived, ActualDeparture: &departed, CreatedAt: at(5)}
+
+		events := services.BuildOrderTimeline(services.OrderHistory{
+			Order: models.Order{OrderNumber: "ORD-1", CreatedAt: start},
+			Statuses: []models.OrderStatusHistory{
+				{FromStatus: "pending", ToStatus: "assigned", Source: services.OrderStatusSourcePlanning, CreatedAt: at(5)},
+				{FromStatus: "picked_up", ToStatus: "delivered", Reason: "signed", Source: services.OrderStatusSourcePOD, CreatedAt: at(64)},
+			},
+			Stops: []models.RouteStop{stop},
+			PODs:  []models.ProofOfDelivery{{RouteStopID: stop.ID, RecipientName: "J. Doe", Notes: "Gate code 1234", Timestamp: at(64)}},
+			Alerts: []models.Alert{
+				{RouteID: &routeID, Title: "Heavy traffic", CreatedAt: at(30)},
+				{RouteID: &routeID, Title: "After delivery", CreatedAt: at(90)},
+				{RouteID: &otherRouteID, Title: "Other route", CreatedAt: at(30)},
+			},
+		})
+
+		types := make([]string, 0, len(events))
+		for i, event := range events {
+			types = append(types, event.Type)
+			if i > 0 {
+				assert.False(t, event.Time.Before(events[i-1].Time))
+			}
+		}
+		assert.Equal(t, []string{
+			services.TimelineOrderCreated, services.TimelineStatusChanged, services.TimelineAlert, services.TimelineStopArrived,
+			services.TimelineStatusChanged, services.TimelinePODSubmitted, services.TimelineStopDeparted,
+		}, types)
+
+		public := services.PublicTimeline(events)
+		assert.Len(t, public, len(events)-1)
+		for _, event := range public {
+			assert.False(t, event.Internal)
+			assert.Nil(t, event.RouteID)
+			assert.Empty(t, event.Source)
+			assert.NotContains(t, event.Title, "J. Doe")
+			if event.Type == services.TimelinePODSubmitted {
+				assert.Equal(t, "Proof of delivery received", event.Title)
+				assert.Empty(t, event.Detail)
+			}
+		}
+	})
+}
+
+func TestFailedDeliveries(t *testing.T) {
+	t.Run("Retry policies reschedule until the attempts run out", func(t *testing.T) {
+		now := time.Date(2026, 3, 2, 15, 30, 0, 0, time.UTC)
+		absent, err := services.LookupFailureReason("customer_absent")
+		assert.NoError(t, err)
+
+		outcome, retryOn := absent.NextStep(1, now)
+		assert.Equal(t, services.AttemptOutcomeRetry, outcome)
+		if assert.NotNil(t, retryOn) {
+			assert.Equal(t, time.Date(2026, 3, 3, 0, 0, 0, 0, time.UTC), *retryOn)
+		}
+		outcome, retryOn = absent.NextStep(absent.MaxAttempts, now)
+		assert.Equal(t, services.AttemptOutcomeReturn, outcome)
+		assert.Nil(t, retryOn)
+
+		refused, _ := services.LookupFailureReason("refused")
+		outcome, _ = refused.NextStep(1, now)
+		assert.Equal(t, services.AttemptOutcomeReturn, outcome)
+	})
+
+	t.Run("Reason codes come from the catalog", func(t *testing.T) {
+		other, err := services.LookupFailureReason("")
+		assert.NoError(t, err)
+		assert.Equal(t, services.FailureReasonOther, other.Code)
+
+		_, err = services.LookupFailureReason("dog_in_yard")
+		assert.ErrorIs(t, err, services.ErrUnknownFailureReason)
+	})
+
+	t.Run("Failed orders retry or return to the depot", func(t *testing.T) {
+		sm := services.NewOrderStateMachine()
+		assert.True(t, sm.CanTransition(services.OrderStatusFailed, services.OrderStatusPending))
+		assert.True(t, sm.CanTransition(services.OrderStatusFailed, services.OrderStatusReturning))
+		assert.True(t, sm.CanTransition(services.OrderStatusReturning, services.OrderStatusReturned))
+		assert.Empty(t, sm.NextStates(services.OrderStatusReturned))
+	})
+
+	t.Run("Retried orders are held back from planning until their retry day", func(t *testing.T) {
+		now := time.Date(2026, 3, 2, 15, 30, 0, 0, time.Local)
+		absent, _ := services.LookupFailureReason("customer_absent")
+		_, retryOn := absent.NextStep(1, now)
+		held := models.Order{ID: uuid.New(), OrderNumber: "HELD", Status: "pending", RetryOn: retryOn,
+			Customer: &models.Customer{Latitude: 13.72, Longitude: 100.50}}
+
+		assert.False(t, services.PlannableOn(held, now))
+		assert.True(t, services.PlannableOn(held, now.AddDate(0, 0, 1)))
+		assert.True(t, services.PlannableOn(models.Order{}, now))
+
+		depot := models.Depot{ID: uuid.New(), Latitude: 13.70, Longitude: 100.50}
+		vehicle := models.Vehicle{ID: uuid.New(), CapacityKg: 1000}
+		event := services.ReplanEvent{Type: "new_order", OrderID: held.ID.String()}
+		_, err := services.NewReplanner().SetPlanStart(now).
+			GenerateAlternatives(event, nil, []models.Order{held}, []models.Vehicle{vehicle}, depot)
+		assert.ErrorIs(t, err, services.ErrInvalidReplanEvent)
+
+		alternatives, err := services.NewReplanner().SetPlanStart(now.AddDate(0, 0, 1)).
+			GenerateAlternatives(event, nil, []models.Order{held}, []models.Vehicle{vehicle}, depot)
+		assert.NoError(t, err)
+		assert.Contains(t, alternatives[0].Orders, held.ID.String())
+	})
+
+	t.Run("Proof of delivery lands on the stop of the new route after a retry", func(t *testing.T) {
+		orderID := uuid.New()
+		planned := time.Date(2026, 3, 2, 8, 0, 0, 0, time.UTC)
+		firstRoute := models.Route{ID: uuid.New(), RouteNumber: "R-1", Status: string(services.RouteStatusInProgress)}
+		first := models.RouteStop{ID: uuid.New(), RouteID: firstRoute.ID, OrderID: orderID, StopType: services.StopTypeDelivery,
+			Status: string(services.StopStatusPending), CreatedAt: planned}
+
+		// The attempt fails and the order goes back to pending for the next day
+		order := services.NewOrderStateMachine()
+		for _, step := range [][2]services.OrderStatus{
+			{services.OrderStatusAssigned, services.OrderStatusPickedUp},
+			{services.OrderStatusPickedUp, services.OrderStatusFailed},
+			{services.OrderStatusFailed, services.OrderStatusPending},
+		} {
+			assert.NoError(t, order.ValidateTransition(step[0], step[1]))
+		}
+		first.Status = string(services.StopStatusFailed)
+		firstRoute.Status = string(services.RouteStatusCompleted)
+
+		// It is routed again on the retry day
+		retry := models.RouteStop{ID: uuid.New(), RouteID: uuid.New(), OrderID: orderID, StopType: services.StopTypeDelivery,
+			Status: string(services.StopStatusPending), CreatedAt: planned.AddDate(0, 0, 1)}
+		assert.NoError(t, order.ValidateTransition(services.OrderStatusPending, services.OrderStatusAssigned))
+
+		// POD finds the retry's stop, never the failed one on the closed route
+		for _, stops := range [][]models.RouteStop{{first, retry}, {retry, first}} {
+			stop, ok := services.CurrentDeliveryStop(stops)
+			assert.True(t, ok)
+			assert.Equal(t, retry.ID, stop.ID)
+		}
+		assert.ErrorIs(t, services.CheckRouteOpen(firstRoute), services.ErrRouteClosed)
+
+		retry.Status = string(services.StopStatusCompleted)
+		_, ok := services.CurrentDeliveryStop([]models.RouteStop{first, retry})
+		assert.False(t, ok)
+	})
+}
+
+func TestOrderImport(t *testing.T) {
+	t.Run("Columns map by header, alias and explicit mapping", func(t *testing.T) {
+		sheet, err := services.ReadCSVSheet(strings.NewReader(
+			"\ufeffCustomer Code,Address,Weight,Vol (m3),Deadline,Colour\nC-1,1 Main St,12.5,0.4,2026-03-02 17:00,red\n,,,,,\n"))
+		assert.NoError(t, err)
+		assert.Len(t, sheet.Rows, 1)
+		assert.Equal(t, 2, sheet.Rows[0].Line)
+
+		columns, ignored, err := services.MapColumns(sheet.Headers, map[string]string{"Vol (m3)": "volume_m3"})
+		assert.NoError(t, err)
+		assert.Equal(t, map[string]int{"customer_code": 0, "delivery_address": 1, "weight_kg": 2, "volume_m3": 3, "required_by": 4}, columns)
+		assert.Equal(t, []string{"Colour"}, ignored)
+
+		_, _, err = services.MapColumns([]string{"address", "weight"}, nil)
+		assert.ErrorIs(t, err, services.ErrImportColumns)
+		_, _, err = services.MapColumns([]string{"customer", "colour"}, map[string]string{"colour": "paint"})
+		assert.ErrorIs(t, err, services.ErrImportColumns)
+	})
+
+	t.Run("Rows are validated field by field", func(t *testing.T) {
+		customer := models.Customer{ID: uuid.New(), Code: "C-1", Address: "1 Main St"}
+		customers := services.ImportCustomers{
+			ByCode: map[string]models.Customer{customer.Code: customer},
+			ByID:   map[uuid.UUID]models.Customer{customer.ID: customer},
+		}
+		columns := map[string]int{"customer_code": 0, "weight_kg": 1, "priority": 2, "required_by": 3}
+
+		order, errs := services.ParseImportRow(services.ImportRow{Line: 2, Values: []string{"C-1", "1,200", "High", "2026-03-02"}}, columns, customers)
+		assert.Empty(t, errs)
+		assert.Equal(t, customer.ID, order.CustomerID)
+		assert.Equal(t, "1 Main St", order.DeliveryAddress)
+		assert.Equal(t, 1200.0, order.WeightKg)
+		assert.Equal(t, "high", order.Priority)
+		assert.Equal(t, "pending", order.Status)
+
+		_, errs = services.ParseImportRow(services.ImportRow{Line: 3, Values: []string{"C-9", "heavy", "urgent", ""}}, columns, customers)
+		failed := make([]string, 0, len(errs))
+		for _, err := range errs {
+			assert.Equal(t, 3, err.Row)
+			failed = append(failed, err.Column)
+		}
+		assert.ElementsMatch(t, []string{"customer_code", "weight_kg", "priority"}, failed)
+
+		_, errs = services.ParseImportRow(services.ImportRow{Line: 4, Values: []string{"C-1", "5", "critical", ""}}, columns, customers)
+		if assert.Len(t, errs, 1) {
+			assert.Equal(t, "required_by", errs[0].Column)
+		}
+
+		// A decimal comma is not a thousands separator
+		_, errs = services.ParseImportRow(services.ImportRow{Line: 5, Values: []string{"C-1", "1,5", "normal", ""}}, columns, customers)
+		if assert.Len(t, errs, 1) {
+			assert.Equal(t, "weight_kg", errs[0].Column)
+			assert.Contains(t, errs[0].Message, `"1,5"`)
+		}
+	})
+
+	t.Run("JSON arrays and NDJSON read into the same sheet", func(t *testing.T) {
+		array, err := services.ReadJSONSheet(strings.NewReader(
+			`[{"customer_code": "C-1", "weight": 12.5, "fragile": true}, {"customer_code": "C-2", "notes": null, "tags": ["a"]}]`))
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"customer_code", "fragile", "notes", "tags", "weight"}, array.Headers)
+		assert.Equal(t, services.ImportRow{Line: 1, Values: []string{"C-1", "true", "", "", "12.5"}}, array.Rows[0])
+		assert.Equal(t, services.ImportRow{Line: 2, Values: []string{"C-2", "", "", `["a"]`, ""}}, array.Rows[1])
+
+		lines, err := services.ReadJSONSheet(strings.NewReader(
+			"{\"customer_code\": \"C-1\", \"weight\": 12.5, \"fragile\": true}\n{\"customer_code\": \"C-2\", \"notes\": null, \"tags\": [\"a\"]}\n"))
+		assert.NoError(t, err)
+		assert.Equal(t, array, lines)
+
+		_, err = services.ReadJSONSheet(strings.NewReader(`{"customer_code": "C-1"} [`))
+		assert.Error(t, err)
+		assert.Equal(t, services.ImportFormatNDJSON, services.ImportFormatFromFileName("orders.JSONL"))
+		assert.Equal(t, services.ImportFormatXLSX, services.ImportFormatFromFileName("orders.xlsx"))
+	})
+
+	t.Run("Workbooks read from a named sheet with dates", func(t *testing.T) {
+		workbook := excelize.NewFile()
+		workbook.SetSheetRow("Sheet1", "A1", &[]string{"ignored"})
+		workbook.NewSheet("Orders")
+		workbook.SetSheetRow("Orders", "A1", &[]interface{}{"customer_code", "weight_kg", "required_by"})
+		workbook.SetSheetRow("Orders", "A3", &[]interface{}{"C-1", 12.5, time.Date(2026, 3, 2, 17, 0, 0, 0, time.UTC)})
+		dateStyle, _ := workbook.NewStyle(&excelize.Style{NumFmt: 22})
+		workbook.SetCellStyle("Orders", "C3", "C3", dateStyle)
+		var buf bytes.Buffer
+		_, err := workbook.WriteTo(&buf)
+		assert.NoError(t, err)
+
+		sheet, err := services.ReadXLSXSheet(bytes.NewReader(buf.Bytes()), "Orders")
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"customer_code", "weight_kg", "required_by"}, sheet.Headers)
+		assert.Equal(t, []services.ImportRow{{Line: 3, Values: []string{"C-1", "12.5", "2026-03-02 17:00:00"}}}, sheet.Rows)
+
+		_, err = services.ReadXLSXSheet(bytes.NewReader(buf.Bytes()), "Missing")
+		assert.ErrorIs(t, err, services.ErrImportSheetNotFound)
+	})
+
+	t.Run("Templates list every field in each format", func(t *testing.T) {
+		for _, format := range services.ImportFormats {
+			data, _, fileName, err := services.ImportTemplate(format)
+			assert.NoError(t, err)
+			assert.True(t, strings.HasSuffix(fileName, "."+format))
+
+			sheet, err := services.ReadImportSheet(bytes.NewReader(data), format, "")
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, services.OrderImportFields, sheet.Headers, format)
+			assert.Len(t, sheet.Rows, 1, format)
+			columns, _, err := services.MapColumns(sheet.Headers, nil)
+			assert.NoError(t, err)
+			assert.Len(t, columns, len(services.OrderImportFields))
+		}
+
+		_, _, _, err := services.ImportTemplate("pdf")
+		assert.ErrorIs(t, err, services.ErrImportFormat)
+	})
 }
 
 func TestDelayAnalyzer(t *testing.T) {
@@ -120,3 +1218,358 @@ func TestMapsService(t *testing.T) {
 		// Skip in CI/CD, run manually for integration testing
 	})
 }
+
+func TestRouteAbort(t *testing.T) {
+	t.Run("Aborting a route sends the orders of its open stops back to pending", func(t *testing.T) {
+		db := openTestDB(t)
+		route := models.Route{ID: uuid.New(), RouteNumber: "R-1", Date: time.Now(), Status: string(services.RouteStatusInProgress)}
+		assert.NoError(t, db.Create(&route).Error)
+		orders := map[string]*models.Order{}
+		stops := map[string]*models.RouteStop{}
+		for i, status := range [][2]string{{"delivered", "completed"}, {"picked_up", "in_progress"}, {"assigned", "pending"}} {
+			order := &models.Order{ID: uuid.New(), OrderNumber: "ORD-" + status[0], Status: status[0]}
+			stop := &models.RouteStop{ID: uuid.New(), RouteID: route.ID, OrderID: order.ID, Sequence: i + 1,
+				StopType: services.StopTypeDelivery, Status: status[1]}
+			assert.NoError(t, db.Create(order).Error)
+			assert.NoError(t, db.Create(stop).Error)
+			orders[status[0]], stops[status[0]] = order, stop
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			return services.AbortRoute(tx, &route, nil, services.OrderStatusChange{Reason: "breakdown"})
+		})
+		assert.NoError(t, err)
+
+		statusOf := func(model interface{}, id uuid.UUID) string {
+			var status string
+			db.Model(model).Where("id = ?", id).Pluck("status", &status)
+			return status
+		}
+		assert.Equal(t, "aborted", statusOf(&models.Route{}, route.ID))
+		assert.Equal(t, "delivered", statusOf(&models.Order{}, orders["delivered"].ID))
+		assert.Equal(t, "pending", statusOf(&models.Order{}, orders["picked_up"].ID))
+		assert.Equal(t, "pending", statusOf(&models.Order{}, orders["assigned"].ID))
+		assert.Equal(t, "completed", statusOf(&models.RouteStop{}, stops["delivered"].ID))
+		assert.Equal(t, "failed", statusOf(&models.RouteStop{}, stops["picked_up"].ID))
+		assert.Equal(t, "failed", statusOf(&models.RouteStop{}, stops["assigned"].ID))
+
+		var history []models.OrderStatusHistory
+		db.Where("order_id = ?", orders["picked_up"].ID).Order("created_at").Find(&history)
+		if assert.Len(t, history, 2) {
+			assert.Equal(t, "failed", history[0].ToStatus)
+			assert.Equal(t, "pending", history[1].ToStatus)
+		}
+
+		_, err = services.FindCurrentDeliveryStop(db, orders["assigned"].ID)
+		assert.ErrorIs(t, err, services.ErrNoOpenDeliveryStop)
+	})
+}
+
+func TestRecordStopStatus(t *testing.T) {
+	seed := func(t *testing.T, db *gorm.DB, stopStatus string) models.RouteStop {
+		route := models.Route{ID: uuid.New(), RouteNumber: "R-1", Date: time.Now(), Status: string(services.RouteStatusInProgress)}
+		order := models.Order{ID: uuid.New(), OrderNumber: "ORD-1", Status: string(services.OrderStatusPickedUp)}
+		stop := models.RouteStop{ID: uuid.New(), RouteID: route.ID, OrderID: order.ID, Sequence: 1,
+			StopType: services.StopTypeDelivery, Status: stopStatus}
+		assert.NoError(t, db.Create(&route).Error)
+		assert.NoError(t, db.Create(&order).Error)
+		assert.NoError(t, db.Create(&stop).Error)
+		return stop
+	}
+	record := func(db *gorm.DB, stop *models.RouteStop, status services.StopStatus) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			_, err := services.RecordStopStatus(tx, stop, status, nil, services.OrderStatusChange{})
+			return err
+		})
+	}
+
+	t.Run("A completed stop cannot be reported again", func(t *testing.T) {
+		db := openTestDB(t)
+		stop := seed(t, db, string(services.StopStatusCompleted))
+		assert.ErrorIs(t, record(db, &stop, services.StopStatusFailed), services.ErrInvalidStopTransition)
+		assert.Equal(t, string(services.StopStatusCompleted), stop.Status)
+	})
+
+	t.Run("A pending stop cannot be completed without the driver arriving", func(t *testing.T) {
+		db := openTestDB(t)
+		stop := seed(t, db, string(services.StopStatusPending))
+		assert.ErrorIs(t, record(db, &stop, services.StopStatusCompleted), services.ErrInvalidStopTransition)
+	})
+
+	t.Run("A stop in progress can be reported arrived after en route", func(t *testing.T) {
+		db := openTestDB(t)
+		stop := seed(t, db, string(services.StopStatusPending))
+		assert.NoError(t, record(db, &stop, services.StopStatusInProgress))
+		assert.NoError(t, record(db, &stop, services.StopStatusInProgress))
+		assert.NoError(t, record(db, &stop, services.StopStatusCompleted))
+
+		var order models.Order
+		db.First(&order, "id = ?", stop.OrderID)
+		assert.Equal(t, string(services.OrderStatusDelivered), order.Status)
+	})
+}
+
+func TestReplanApply(t *testing.T) {
+	t.Run("Applying onto a vehicle with a planned route today extends that route", func(t *testing.T) {
+		db := openTestDB(t)
+		now := time.Now()
+		today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+		vehicleID, depotID := uuid.New(), uuid.New()
+		yesterday := models.Route{ID: uuid.New(), RouteNumber: "R-OLD", Date: today.AddDate(0, 0, -1),
+			VehicleID: vehicleID, DepotID: depotID, Status: string(services.RouteStatusInProgress)}
+		planned := models.Route{ID: uuid.New(), RouteNumber: "R-1", Date: today,
+			VehicleID: vehicleID, DepotID: depotID, Status: string(services.RouteStatusPlanned)}
+		assert.NoError(t, db.Create(&yesterday).Error)
+		assert.NoError(t, db.Create(&planned).Error)
+		kept := models.Order{ID: uuid.New(), OrderNumber: "ORD-1", Status: string(services.OrderStatusAssigned)}
+		added := models.Order{ID: uuid.New(), OrderNumber: "ORD-2", Status: string(services.OrderStatusPending)}
+		assert.NoError(t, db.Create(&kept).Error)
+		assert.NoError(t, db.Create(&added).Error)
+		assert.NoError(t, db.Create(&models.RouteStop{ID: uuid.New(), RouteID: planned.ID, OrderID: kept.ID, Sequence: 1,
+			StopType: services.StopTypeDelivery, Status: string(services.StopStatusPending)}).Error)
+
+		alternative := services.Alternative{
+			ID: "alt-1",
+			Routes: []services.RouteResult{{
+				VehicleID:    vehicleID.String(),
+				StartDepotID: depotID.String(),
+				Stops:        []services.RouteStop{{OrderID: added.ID.String(), StopType: services.StopTypeDelivery}},
+			}},
+			Orders: []string{added.ID.String()},
+		}
+		replans := services.NewReplanService()
+		proposal, err := replans.Propose(services.ReplanEvent{Type: "new_order", OrderID: added.ID.String()},
+			[]services.Alternative{alternative}, nil)
+		if !assert.NoError(t, err) {
+			return
+		}
+		applied, err := replans.Apply(proposal.ID, alternative.ID, uuid.New())
+		if !assert.NoError(t, err) {
+			return
+		}
+
+		if assert.Len(t, applied.Routes, 1) {
+			assert.Equal(t, planned.ID, applied.Routes[0].Route.ID)
+			assert.False(t, applied.Routes[0].Created)
+		}
+		var routes int64
+		db.Model(&models.Route{}).Count(&routes)
+		assert.Equal(t, int64(2), routes)
+
+		var stop models.RouteStop
+		assert.NoError(t, db.First(&stop, "order_id = ?", added.ID).Error)
+		assert.Equal(t, planned.ID, stop.RouteID)
+		assert.Equal(t, 2, stop.Sequence)
+		db.First(&added, "id = ?", added.ID)
+		assert.Equal(t, string(services.OrderStatusAssigned), added.Status)
+	})
+}
+
+func TestDispatchReassignment(t *testing.T) {
+	seed := func(t *testing.T, db *gorm.DB, locked bool) (models.Route, models.Route, models.RouteStop) {
+		from := models.Route{ID: uuid.New(), RouteNumber: "R-FROM", Date: time.Now(), Status: string(services.RouteStatusPlanned)}
+		to := models.Route{ID: uuid.New(), RouteNumber: "R-TO", Date: time.Now(), Status: string(services.RouteStatusPlanned),
+			IsLocked: locked, LockReason: "customer confirmed slots"}
+		order := models.Order{ID: uuid.New(), OrderNumber: "ORD-1", Status: string(services.OrderStatusAssigned)}
+		stop := models.RouteStop{ID: uuid.New(), RouteID: from.ID, OrderID: order.ID, Sequence: 1,
+			StopType: services.StopTypeDelivery, Status: string(services.StopStatusPending)}
+		assert.NoError(t, db.Create(&from).Error)
+		assert.NoError(t, db.Create(&to).Error)
+		assert.NoError(t, db.Create(&order).Error)
+		assert.NoError(t, db.Create(&stop).Error)
+		return from, to, stop
+	}
+	move := func(stop models.RouteStop, to models.Route, override bool) error {
+		return services.NewDispatchService().SetLockOverride(override).ReassignStop(&services.ReassignStopRequest{
+			StopID: stop.ID, ToRouteID: to.ID, NewSequence: 1, Reason: services.ReasonOther, ReassignedBy: uuid.New(),
+		})
+	}
+
+	t.Run("A stop cannot move onto a locked route", func(t *testing.T) {
+		db := openTestDB(t)
+		from, to, stop := seed(t, db, true)
+		assert.ErrorIs(t, move(stop, to, false), services.ErrRouteLocked)
+
+		db.First(&stop, "id = ?", stop.ID)
+		assert.Equal(t, from.ID, stop.RouteID)
+		var logs int64
+		db.Model(&models.ReassignmentLog{}).Count(&logs)
+		assert.Zero(t, logs)
+	})
+
+	t.Run("A stop moves onto another route and the move is logged", func(t *testing.T) {
+		db := openTestDB(t)
+		_, to, stop := seed(t, db, true)
+		assert.NoError(t, move(stop, to, true))
+
+		db.First(&stop, "id = ?", stop.ID)
+		assert.Equal(t, to.ID, stop.RouteID)
+		var logs int64
+		db.Model(&models.ReassignmentLog{}).Where("route_stop_id = ?", stop.ID).Count(&logs)
+		assert.Equal(t, int64(1), logs)
+	})
+
+	t.Run("An unknown stop is reported as not found", func(t *testing.T) {
+		openTestDB(t)
+		assert.ErrorIs(t, move(models.RouteStop{ID: uuid.New()}, models.Route{ID: uuid.New()}, false), services.ErrStopNotFound)
+	})
+}
+
+func TestPlanService(t *testing.T) {
+	seed := func(t *testing.T, db *gorm.DB, statuses ...services.OrderStatus) (*services.OptimizationResult, []models.Order) {
+		orders := make([]models.Order, len(statuses))
+		planned := services.RouteResult{VehicleID: uuid.NewString(), StartDepotID: uuid.NewString()}
+		for i, status := range statuses {
+			orders[i] = models.Order{ID: uuid.New(), OrderNumber: "ORD-" + string(rune('A'+i)), Status: string(status)}
+			assert.NoError(t, db.Create(&orders[i]).Error)
+			planned.Stops = append(planned.Stops, services.RouteStop{OrderID: orders[i].ID.String(), StopType: services.StopTypeDelivery})
+		}
+		return &services.OptimizationResult{Routes: []services.RouteResult{planned}}, orders
+	}
+	orderStatus := func(db *gorm.DB, order models.Order) string {
+		db.First(&order, "id = ?", order.ID)
+		return order.Status
+	}
+
+	t.Run("A saved plan assigns its orders", func(t *testing.T) {
+		db := openTestDB(t)
+		result, orders := seed(t, db, services.OrderStatusPending, services.OrderStatusPending)
+		saved, err := services.NewPlanService().SavePlan(result, time.Now(), services.RouteStatusPlanned, nil)
+		assert.NoError(t, err)
+		if assert.Len(t, saved, 1) {
+			assert.Len(t, saved[0].Stops, 2)
+		}
+		for _, order := range orders {
+			assert.Equal(t, string(services.OrderStatusAssigned), orderStatus(db, order))
+		}
+	})
+
+	t.Run("A plan with an order no longer pending is not saved", func(t *testing.T) {
+		db := openTestDB(t)
+		result, orders := seed(t, db, services.OrderStatusPending, services.OrderStatusDelivered)
+		_, err := services.NewPlanService().SavePlan(result, time.Now(), services.RouteStatusPlanned, nil)
+		assert.ErrorIs(t, err, services.ErrOrderNotPlannable)
+
+		var routes, stops int64
+		db.Model(&models.Route{}).Count(&routes)
+		db.Model(&models.RouteStop{}).Count(&stops)
+		assert.Zero(t, routes)
+		assert.Zero(t, stops)
+		assert.Equal(t, string(services.OrderStatusPending), orderStatus(db, orders[0]))
+	})
+
+	t.Run("A draft plan leaves its orders pending until committed", func(t *testing.T) {
+		db := openTestDB(t)
+		result, orders := seed(t, db, services.OrderStatusPending, services.OrderStatusPending)
+		plans := services.NewPlanService()
+		saved, err := plans.SavePlan(result, time.Now(), services.RouteStatusDraft, nil)
+		if !assert.NoError(t, err) || !assert.Len(t, saved, 1) {
+			return
+		}
+		assert.Equal(t, string(services.RouteStatusDraft), saved[0].Route.Status)
+		for _, order := range orders {
+			assert.Equal(t, string(services.OrderStatusPending), orderStatus(db, order))
+		}
+
+		route := saved[0].Route
+		err = db.Transaction(func(tx *gorm.DB) error {
+			return plans.CommitDraft(tx, &route, nil, services.OrderStatusChange{Source: services.OrderStatusSourcePlanning})
+		})
+		assert.NoError(t, err)
+		db.First(&route, "id = ?", route.ID)
+		assert.Equal(t, string(services.RouteStatusPlanned), route.Status)
+		for _, order := range orders {
+			assert.Equal(t, string(services.OrderStatusAssigned), orderStatus(db, order))
+		}
+	})
+
+	t.Run("A draft whose order was planned elsewhere cannot be committed", func(t *testing.T) {
+		db := openTestDB(t)
+		result, orders := seed(t, db, services.OrderStatusPending, services.OrderStatusPending)
+		plans := services.NewPlanService()
+		saved, err := plans.SavePlan(result, time.Now(), services.RouteStatusDraft, nil)
+		if !assert.NoError(t, err) || !assert.Len(t, saved, 1) {
+			return
+		}
+		assert.NoError(t, db.Model(&orders[1]).Update("status", string(services.OrderStatusAssigned)).Error)
+
+		route := saved[0].Route
+		err = db.Transaction(func(tx *gorm.DB) error {
+			return plans.CommitDraft(tx, &route, nil, services.OrderStatusChange{})
+		})
+		assert.ErrorIs(t, err, services.ErrOrderNotPlannable)
+		db.First(&route, "id = ?", route.ID)
+		assert.Equal(t, string(services.RouteStatusDraft), route.Status)
+		assert.Equal(t, string(services.OrderStatusPending), orderStatus(db, orders[0]))
+	})
+}
+
+// openTestDB points the database at a fresh in-memory SQLite database for the test
+// PostGIS columns are stored as text and Postgres-only defaults are dropped from the schema,
+// so records get their IDs when created
+func openTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	db, err := gorm.Open(sqlite.Open("file:"+uuid.NewString()+"?mode=memory&cache=shared"), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	sqlDB, _ := db.DB()
+	sqlDB.SetMaxOpenConns(1)
+
+	tables := []interface{}{
+		&models.User{}, &models.Depot{}, &models.Vehicle{}, &models.Driver{}, &models.Customer{}, &models.Order{},
+		&models.Route{}, &models.RouteStop{}, &models.GPSTracking{}, &models.ProofOfDelivery{},
+		&models.DeliveryAttempt{}, &models.Alert{}, &models.AuditLog{}, &models.ReassignmentLog{},
+		&models.OrderStatusHistory{}, &models.PlanVersion{}, &models.OptimizationJob{}, &models.ReplanProposal{},
+	}
+	for _, table := range tables {
+		stmt := &gorm.Statement{DB: db}
+		if !assert.NoError(t, stmt.Parse(table)) {
+			t.FailNow()
+		}
+		for _, field := range stmt.Schema.Fields {
+			if field.DefaultValue == "uuid_generate_v4()" {
+				field.HasDefaultValue, field.DefaultValue, field.DefaultValueInterface = false, "", nil
+			}
+			if strings.HasPrefix(string(field.DataType), "geography") {
+				field.DataType = "text"
+				delete(field.TagSettings, "INDEX")
+			}
+		}
+	}
+	db.Callback().Create().Before("gorm:create").Register("test:uuid", func(tx *gorm.DB) {
+		if tx.Statement.Schema == nil || tx.Statement.Schema.PrioritizedPrimaryField == nil {
+			return
+		}
+		field := tx.Statement.Schema.PrioritizedPrimaryField
+		if field.FieldType != reflect.TypeOf(uuid.UUID{}) {
+			return
+		}
+		setID := func(record reflect.Value) {
+			if _, zero := field.ValueOf(tx.Statement.Context, record); zero {
+				field.Set(tx.Statement.Context, record, uuid.New())
+			}
+		}
+		switch value := tx.Statement.ReflectValue; value.Kind() {
+		case reflect.Slice, reflect.Array:
+			for i := 0; i < value.Len(); i++ {
+				setID(reflect.Indirect(value.Index(i)))
+			}
+		case reflect.Struct:
+			setID(value)
+		}
+	})
+	if !assert.NoError(t, db.AutoMigrate(tables...)) {
+		t.FailNow()
+	}
+
+	previous := database.DB
+	database.DB = db
+	t.Cleanup(func() {
+		database.DB = previous
+		sqlDB.Close()
+	})
+	return db
+}
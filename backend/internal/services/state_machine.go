@@ -17,6 +17,8 @@ const (
 	OrderStatusDelivered OrderStatus = "delivered"
 	OrderStatusCancelled OrderStatus = "cancelled"
 	OrderStatusFailed    OrderStatus = "failed"
+	OrderStatusReturning OrderStatus = "returning" // out of delivery attempts, on its way back to the depot
+	OrderStatusReturned  OrderStatus = "returned"
 )
 
 // StopStatus represents valid stop statuses
@@ -29,6 +31,19 @@ const (
 	StopStatusFailed     StopStatus = "failed"
 )
 
+// RouteStatus represents valid route statuses
+type RouteStatus string
+
+const (
+	RouteStatusDraft      RouteStatus = "draft"   // proposal from an optimization job, orders stay pending
+	RouteStatusPlanned    RouteStatus = "planned" // committed plan, its orders are assigned
+	RouteStatusAssigned   RouteStatus = "assigned"
+	RouteStatusDispatched RouteStatus = "dispatched"
+	RouteStatusInProgress RouteStatus = "in_progress"
+	RouteStatusCompleted  RouteStatus = "completed"
+	RouteStatusAborted    RouteStatus = "aborted"
+)
+
 // OrderStateMachine handles order status transitions
 type OrderStateMachine struct {
 	validTransitions map[OrderStatus][]OrderStatus
@@ -58,7 +73,14 @@ func NewOrderStateMachine() *OrderStateMachine {
 				OrderStatusPending, // Reactivate
 			},
 			OrderStatusFailed: {
-				OrderStatusPending, // Retry
+				OrderStatusPending,   // Retry
+				OrderStatusReturning, // Return to depot
+			},
+			OrderStatusReturning: {
+				OrderStatusReturned,
+			},
+			OrderStatusReturned: {
+				// Terminal state
 			},
 		},
 	}
@@ -83,11 +105,16 @@ func (sm *OrderStateMachine) CanTransition(from, to OrderStatus) bool {
 // ValidateTransition validates and returns error if invalid
 func (sm *OrderStateMachine) ValidateTransition(from, to OrderStatus) error {
 	if !sm.CanTransition(from, to) {
-		return fmt.Errorf("invalid status transition from %s to %s", from, to)
+		return fmt.Errorf("%w from %s to %s", ErrInvalidOrderTransition, from, to)
 	}
 	return nil
 }
 
+// NextStates returns the statuses an order can move to from a status
+func (sm *OrderStateMachine) NextStates(from OrderStatus) []OrderStatus {
+	return append([]OrderStatus{}, sm.validTransitions[from]...)
+}
+
 // StopStateMachine handles stop status transitions
 type StopStateMachine struct {
 	validTransitions map[StopStatus][]StopStatus
@@ -134,11 +161,92 @@ func (sm *StopStateMachine) CanTransition(from, to StopStatus) bool {
 // ValidateTransition validates and returns error if invalid
 func (sm *StopStateMachine) ValidateTransition(from, to StopStatus) error {
 	if !sm.CanTransition(from, to) {
-		return fmt.Errorf("invalid status transition from %s to %s", from, to)
+		return fmt.Errorf("%w from %s to %s", ErrInvalidStopTransition, from, to)
 	}
 	return nil
 }
 
+// NextStates returns the statuses a stop can move to from a status
+func (sm *StopStateMachine) NextStates(from StopStatus) []StopStatus {
+	return append([]StopStatus{}, sm.validTransitions[from]...)
+}
+
+// RouteStateMachine handles route status transitions
+type RouteStateMachine struct {
+	validTransitions map[RouteStatus][]RouteStatus
+}
+
+// NewRouteStateMachine creates a new route state machine
+func NewRouteStateMachine() *RouteStateMachine {
+	return &RouteStateMachine{
+		validTransitions: map[RouteStatus][]RouteStatus{
+			RouteStatusDraft: {
+				RouteStatusPlanned,
+				RouteStatusAborted,
+			},
+			RouteStatusPlanned: {
+				RouteStatusAssigned,
+				RouteStatusAborted,
+			},
+			RouteStatusAssigned: {
+				RouteStatusDispatched,
+				RouteStatusPlanned, // Unassign
+				RouteStatusAborted,
+			},
+			RouteStatusDispatched: {
+				RouteStatusInProgress,
+				RouteStatusAssigned, // Recall before the driver starts
+				RouteStatusAborted,
+			},
+			RouteStatusInProgress: {
+				RouteStatusCompleted,
+				RouteStatusAborted,
+			},
+			RouteStatusCompleted: {
+				// Terminal state
+			},
+			RouteStatusAborted: {
+				// Terminal state
+			},
+		},
+	}
+}
+
+// CanTransition checks if a status transition is valid
+func (sm *RouteStateMachine) CanTransition(from, to RouteStatus) bool {
+	validNextStates, exists := sm.validTransitions[from]
+	if !exists {
+		return false
+	}
+
+	for _, validState := range validNextStates {
+		if validState == to {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ValidateTransition validates and returns error if invalid
+func (sm *RouteStateMachine) ValidateTransition(from, to RouteStatus) error {
+	if !sm.CanTransition(from, to) {
+		return fmt.Errorf("%w from %s to %s", ErrInvalidRouteTransition, from, to)
+	}
+	return nil
+}
+
+// NextStates returns the statuses a route can move to from a status
+func (sm *RouteStateMachine) NextStates(from RouteStatus) []RouteStatus {
+	return append([]RouteStatus{}, sm.validTransitions[from]...)
+}
+
+// IsClosed reports whether a route has reached a terminal status and can no longer change
+func (sm *RouteStateMachine) IsClosed(status RouteStatus) bool {
+	next, exists := sm.validTransitions[status]
+	return exists && len(next) == 0
+}
+
 // ValidateStopSequence ensures stops are completed in order
 func ValidateStopSequence(stops []models.RouteStop, currentStopSequence int) error {
 	// Find any completed stops with higher sequence numbers
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/ai-tms/backend/internal/models"
+)
+
+// palletVolumeM3 is the loaded volume of one standard pallet (1.2m x 1.0m x 1.5m)
+const palletVolumeM3 = 1.8
+
+// Capacity dimension names used in unassigned reasons
+const (
+	DimensionWeight  = "weight"
+	DimensionVolume  = "volume"
+	DimensionItems   = "item count"
+	DimensionPallets = "depot pallets"
+)
+
+// Load is a multi-dimensional quantity carried by a vehicle
+type Load struct {
+	WeightKg float64
+	VolumeM3 float64
+	Items    int
+	Pallets  int
+}
+
+// Add returns the sum of two loads
+func (l Load) Add(other Load) Load {
+	return Load{
+		WeightKg: l.WeightKg + other.WeightKg,
+		VolumeM3: l.VolumeM3 + other.VolumeM3,
+		Items:    l.Items + other.Items,
+		Pallets:  l.Pallets + other.Pallets,
+	}
+}
+
+// Sub returns the load left after removing another load
+func (l Load) Sub(other Load) Load {
+	return Load{
+		WeightKg: l.WeightKg - other.WeightKg,
+		VolumeM3: l.VolumeM3 - other.VolumeM3,
+		Items:    l.Items - other.Items,
+		Pallets:  l.Pallets - other.Pallets,
+	}
+}
+
+// Max returns the larger of two loads in every dimension
+func (l Load) Max(other Load) Load {
+	return Load{
+		WeightKg: math.Max(l.WeightKg, other.WeightKg),
+		VolumeM3: math.Max(l.VolumeM3, other.VolumeM3),
+		Items:    max(l.Items, other.Items),
+		Pallets:  max(l.Pallets, other.Pallets),
+	}
+}
+
+// VehicleCapacity holds the limits of a vehicle, a zero value means the dimension is not limited
+type VehicleCapacity struct {
+	WeightKg float64
+	VolumeM3 float64
+	Items    int
+}
+
+// UnassignedOrder reports an order the solver could not place, with the reason
+type UnassignedOrder struct {
+	OrderID     string
+	OrderNumber string
+	Reason      string
+}
+
+// orderLoad returns the load an order puts on a vehicle
+func orderLoad(order models.Order) Load {
+	pallets := 0
+	if order.VolumeM3 > 0 {
+		pallets = int(math.Ceil(order.VolumeM3 / palletVolumeM3))
+	}
+
+	return Load{
+		WeightKg: order.WeightKg,
+		VolumeM3: order.VolumeM3,
+		Items:    order.Items,
+		Pallets:  pallets,
+	}
+}
+
+// vehicleCapacity returns the capacity limits of a vehicle
+func vehicleCapacity(vehicle models.Vehicle) VehicleCapacity {
+	weight := vehicle.CapacityKg
+	if weight == 0 {
+		weight = vehicle.Capacity // Fall back to the computed alias
+	}
+
+	return VehicleCapacity{
+		WeightKg: float64(weight),
+		VolumeM3: vehicle.CapacityM3,
+		Items:    vehicle.CapacityItems,
+	}
+}
+
+// Fits reports whether a load fits within every limited dimension
+func (c VehicleCapacity) Fits(load Load) bool {
+	return len(c.Exceeded(load)) == 0
+}
+
+// Exceeded returns the dimensions in which a load exceeds the capacity
+func (c VehicleCapacity) Exceeded(load Load) []string {
+	exceeded := make([]string, 0)
+	if c.WeightKg > 0 && load.WeightKg > c.WeightKg {
+		exceeded = append(exceeded, DimensionWeight)
+	}
+	if c.VolumeM3 > 0 && load.VolumeM3 > c.VolumeM3 {
+		exceeded = append(exceeded, DimensionVolume)
+	}
+	if c.Items > 0 && load.Items > c.Items {
+		exceeded = append(exceeded, DimensionItems)
+	}
+	return exceeded
+}
+
+// Utilization returns the usage percentage of the most constrained dimension
+func (c VehicleCapacity) Utilization(load Load) float64 {
+	utilization := 0.0
+	if c.WeightKg > 0 {
+		utilization = math.Max(utilization, load.WeightKg/c.WeightKg*100)
+	}
+	if c.VolumeM3 > 0 {
+		utilization = math.Max(utilization, load.VolumeM3/c.VolumeM3*100)
+	}
+	if c.Items > 0 {
+		utilization = math.Max(utilization, float64(load.Items)/float64(c.Items)*100)
+	}
+	return utilization
+}
+
+// capacityReason explains why an order cannot be placed on any vehicle, or "" if capacity is not the cause
+func capacityReason(order models.Order, vehicles []models.Vehicle, palletsLeft int, palletLimited bool) string {
+	load := orderLoad(order)
+
+	if palletLimited && load.Pallets > palletsLeft {
+		return fmt.Sprintf("exceeds %s (needs %d, %d left)", DimensionPallets, load.Pallets, palletsLeft)
+	}
+
+	// Dimensions that no vehicle can carry, even when empty
+	everyVehicle := map[string]int{}
+	for _, vehicle := range vehicles {
+		for _, dim := range vehicleCapacity(vehicle).Exceeded(load) {
+			everyVehicle[dim]++
+		}
+	}
+
+	blocking := make([]string, 0)
+	for _, dim := range []string{DimensionWeight, DimensionVolume, DimensionItems} {
+		if everyVehicle[dim] == len(vehicles) {
+			blocking = append(blocking, dim)
+		}
+	}
+	if len(blocking) > 0 {
+		return fmt.Sprintf("exceeds %s on every vehicle", strings.Join(blocking, " and "))
+	}
+
+	return ""
+}
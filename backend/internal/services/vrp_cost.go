@@ -0,0 +1,91 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ai-tms/backend/internal/models"
+)
+
+// RouteCost breaks a route's cost down into its components, all in currency
+type RouteCost struct {
+	Fixed    float64 // Daily fixed cost of putting the vehicle on the road
+	Distance float64 // Distance times the vehicle's cost per km
+	Time     float64 // Driver time from shift start to return, at the hourly rate
+	Overtime float64 // Premium on top of the hourly rate for time past shift end
+}
+
+// Total returns the sum of all cost components
+func (c RouteCost) Total() float64 {
+	return c.Fixed + c.Distance + c.Time + c.Overtime
+}
+
+// routeCost prices a simulated route for a vehicle, unused vehicles cost nothing
+func (s *VRPSolver) routeCost(vehicle models.Vehicle, eval routeEval) RouteCost {
+	if len(eval.stops) == 0 {
+		return RouteCost{}
+	}
+
+	overtimeRate := vehicle.OvertimeCostPerHour
+	if overtimeRate == 0 {
+		overtimeRate = s.constraints.OvertimeCostPerHour
+	}
+
+	return RouteCost{
+		Fixed:    vehicle.FixedCostPerDay,
+		Distance: eval.distance * vehicle.CostPerKm,
+		Time:     eval.duration.Hours() * vehicle.CostPerHour,
+		Overtime: eval.overtime.Hours() * overtimeRate,
+	}
+}
+
+// vehicleTypesFor returns the vehicle types allowed to serve an order, nil for any
+// VRPConstraints.VehicleTypes maps an order ID or customer ID to a comma separated list of types
+func (s *VRPSolver) vehicleTypesFor(order models.Order) []string {
+	allowed, ok := s.constraints.VehicleTypes[order.ID.String()]
+	if !ok {
+		allowed, ok = s.constraints.VehicleTypes[order.CustomerID.String()]
+	}
+	if !ok || strings.TrimSpace(allowed) == "" {
+		return nil
+	}
+
+	types := make([]string, 0)
+	for _, t := range strings.Split(allowed, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// vehicleType returns the type of a vehicle, falling back to the computed alias
+func vehicleType(vehicle models.Vehicle) string {
+	if vehicle.VehicleType != "" {
+		return vehicle.VehicleType
+	}
+	return vehicle.Type
+}
+
+// allowsVehicle reports whether a node may be served by a vehicle of the given type
+func (n vrpNode) allowsVehicle(vehicle models.Vehicle) bool {
+	if len(n.vehicleTypes) == 0 {
+		return true
+	}
+	for _, t := range n.vehicleTypes {
+		if strings.EqualFold(t, vehicleType(vehicle)) {
+			return true
+		}
+	}
+	return false
+}
+
+// vehicleTypeReason explains an order that no vehicle in the fleet is allowed to serve, or ""
+func (s *VRPSolver) vehicleTypeReason(node int) string {
+	for _, vehicle := range s.vehicles {
+		if s.nodes[node].allowsVehicle(vehicle) {
+			return ""
+		}
+	}
+	return fmt.Sprintf("no vehicle of type %s available", strings.Join(s.nodes[node].vehicleTypes, " or "))
+}
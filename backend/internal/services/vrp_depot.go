@@ -0,0 +1,179 @@
+package services
+
+import (
+	"github.com/ai-tms/backend/internal/models"
+)
+
+// endAtNearestDepot marks a vehicle whose route ends at the depot closest to its last stop
+const endAtNearestDepot = -1
+
+// SetDepots replaces the depots of the problem, vehicles start from their own depot when it is
+// listed and from the first depot otherwise
+func (s *VRPSolver) SetDepots(depots []models.Depot) *VRPSolver {
+	if len(depots) > 0 {
+		s.depots = depots
+	}
+	return s
+}
+
+// depotCount returns the number of depot nodes, which come before the order nodes
+func (s *VRPSolver) depotCount() int {
+	return len(s.depots)
+}
+
+// orderNode returns the node index of orders[i]
+func (s *VRPSolver) orderNode(i int) int {
+	return len(s.depots) + i
+}
+
+// orderNodes returns the node indices of all orders in input order
+func (s *VRPSolver) orderNodes() []int {
+	nodes := make([]int, 0, len(s.orders))
+	for i := range s.orders {
+		nodes = append(nodes, s.orderNode(i))
+	}
+	return nodes
+}
+
+// depotNode returns the node index of the depot with the given ID, or -1
+func (s *VRPSolver) depotNode(id string) int {
+	for i, depot := range s.depots {
+		if depot.ID.String() == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// startDepot returns the depot node a vehicle leaves from
+func (s *VRPSolver) startDepot(vehicle models.Vehicle) int {
+	if node := s.depotNode(vehicle.DepotID.String()); node >= 0 {
+		return node
+	}
+	return 0
+}
+
+// endDepot returns the depot node a vehicle returns to, or endAtNearestDepot
+// VRPConstraints.EndDepots pins a vehicle's end depot; EndAtNearestDepot lets it end anywhere
+func (s *VRPSolver) endDepot(vehicle models.Vehicle, start int) int {
+	if id, ok := s.constraints.EndDepots[vehicle.ID.String()]; ok {
+		if node := s.depotNode(id); node >= 0 {
+			return node
+		}
+	}
+	if s.constraints.EndAtNearestDepot && len(s.depots) > 1 {
+		return endAtNearestDepot
+	}
+	return start
+}
+
+// routeEnd returns the depot node a vehicle at the given node drives back to
+func (s *VRPSolver) routeEnd(ctx vehicleContext, from int) int {
+	if ctx.end != endAtNearestDepot {
+		return ctx.end
+	}
+	return s.nearestDepot(from, nil)
+}
+
+// nearestDepot returns the depot node closest to a node, limited to candidates when given
+func (s *VRPSolver) nearestDepot(node int, candidates map[int]bool) int {
+	best := -1
+	for depot := 0; depot < len(s.depots); depot++ {
+		if candidates != nil && !candidates[depot] {
+			continue
+		}
+		if best < 0 || s.matrix.Distance(depot, node) < s.matrix.Distance(best, node) {
+			best = depot
+		}
+	}
+	if best < 0 {
+		return 0
+	}
+	return best
+}
+
+// homeDepots assigns each order node to the closest depot that has vehicles
+// Pickups share the home depot of their delivery
+func (s *VRPSolver) homeDepots() map[int]int {
+	staffed := make(map[int]bool)
+	for _, vehicle := range s.vehicles {
+		staffed[s.startDepot(vehicle)] = true
+	}
+
+	home := make(map[int]int, len(s.nodes))
+	for _, node := range s.orderNodes() {
+		home[node] = s.nearestDepot(node, staffed)
+		if s.nodes[node].paired() {
+			home[s.nodes[node].pair] = home[node]
+		}
+	}
+	return home
+}
+
+// palletBudget tracks the pallet space left at each depot, negative for unlimited
+type palletBudget []int
+
+// newPalletBudget returns the pallet capacity of every depot
+func (s *VRPSolver) newPalletBudget() palletBudget {
+	budget := make(palletBudget, len(s.depots))
+	for i, depot := range s.depots {
+		budget[i] = -1
+		if depot.CapacityPallets > 0 {
+			budget[i] = depot.CapacityPallets
+		}
+	}
+	return budget
+}
+
+// fits reports whether the pallets can still leave the depot
+func (b palletBudget) fits(depot, pallets int) bool {
+	return b[depot] < 0 || pallets <= b[depot]
+}
+
+// take reserves pallet space at a depot
+func (b palletBudget) take(depot, pallets int) {
+	if b[depot] >= 0 {
+		b[depot] -= pallets
+	}
+}
+
+// limited reports whether every depot has a pallet limit
+func (b palletBudget) limited() bool {
+	for _, left := range b {
+		if left < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// mostLeft returns the largest pallet space left at any depot
+func (b palletBudget) mostLeft() int {
+	most := 0
+	for _, left := range b {
+		most = max(most, left)
+	}
+	return most
+}
+
+// remaining returns the pallet budget left after the planned routes leave their depots
+func (s *VRPSolver) remaining(plans []routePlan) palletBudget {
+	budget := s.newPalletBudget()
+	for _, plan := range plans {
+		budget.take(s.startDepot(plan.vehicle), plan.eval.load.Pallets)
+	}
+	return budget
+}
+
+// overbooked reports whether the planned routes load more pallets than some depot holds
+func (s *VRPSolver) overbooked(plans []routePlan) bool {
+	budget := s.newPalletBudget()
+	for _, plan := range plans {
+		depot := s.startDepot(plan.vehicle)
+		if !budget.fits(depot, plan.eval.load.Pallets) {
+			return true
+		}
+		budget.take(depot, plan.eval.load.Pallets)
+	}
+	return false
+}
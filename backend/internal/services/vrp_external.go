@@ -0,0 +1,274 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+// ErrExternalSolver is returned when the external solver is missing or answers with an unusable plan
+var ErrExternalSolver = errors.New("external solver failed")
+
+// ExternalSolverClient sends routing problems to an external solver service (e.g. an OR-Tools sidecar)
+// The service receives the full matrix and node data and answers with node sequences per vehicle
+type ExternalSolverClient struct {
+	baseURL string
+	client  *http.Client
+}
+
+// ExternalSolveRequest is the problem sent to POST {baseURL}/solve
+// Times are seconds since the plan start, distances are kilometers
+type ExternalSolveRequest struct {
+	Distances   [][]float64       `json:"distances"`
+	Durations   [][]float64       `json:"durations"`
+	Nodes       []ExternalNode    `json:"nodes"`  // depots first, then order deliveries, then pickups
+	Depots      int               `json:"depots"` // number of depot nodes at the start of Nodes
+	Vehicles    []ExternalVehicle `json:"vehicles"`
+	TimeLimitMs int64             `json:"time_limit_ms,omitempty"` // omitted leaves the budget to the solver
+	Seed        int64             `json:"seed,omitempty"`
+}
+
+// ExternalNode is a depot or order location in an external solve request
+type ExternalNode struct {
+	OrderID        string   `json:"order_id,omitempty"`
+	StopType       string   `json:"stop_type,omitempty"`   // pickup or delivery, empty for depots
+	PairedNode     *int     `json:"paired_node,omitempty"` // other half of a pickup-and-delivery order, which must share a vehicle
+	DepotID        string   `json:"depot_id,omitempty"`
+	MaxPallets     int      `json:"max_pallets,omitempty"` // depot pallet limit shared by routes leaving it
+	WeightKg       float64  `json:"weight_kg"`
+	VolumeM3       float64  `json:"volume_m3"`
+	Items          int      `json:"items"`
+	Pallets        int      `json:"pallets"`
+	ServiceSeconds int64    `json:"service_seconds"`
+	WindowStart    *int64   `json:"window_start,omitempty"`
+	WindowEnd      *int64   `json:"window_end,omitempty"`
+	VehicleTypes   []string `json:"vehicle_types,omitempty"` // allowed vehicle types, empty for any
+}
+
+// ExternalVehicle is a vehicle in an external solve request
+type ExternalVehicle struct {
+	VehicleID   string  `json:"vehicle_id"`
+	Type        string  `json:"vehicle_type"`
+	CapacityKg  float64 `json:"capacity_kg"`
+	CapacityM3  float64 `json:"capacity_m3"`
+	Items       int     `json:"capacity_items"`
+	ShiftStart  int64   `json:"shift_start"`
+	ShiftEnd    int64   `json:"shift_end"`
+	StartNode   int     `json:"start_node"`
+	EndNode     *int    `json:"end_node"` // null when the route may end at any depot
+	CostPerKm   float64 `json:"cost_per_km"`
+	FixedCost   float64 `json:"fixed_cost"`
+	CostPerHour float64 `json:"cost_per_hour"`
+}
+
+// ExternalSolveResponse lists the node sequence driven by each vehicle
+type ExternalSolveResponse struct {
+	Routes []struct {
+		VehicleID string `json:"vehicle_id"`
+		Nodes     []int  `json:"nodes"`
+	} `json:"routes"`
+}
+
+// NewExternalSolverClient creates a client for the solver service at baseURL
+func NewExternalSolverClient(baseURL string, timeout time.Duration) *ExternalSolverClient {
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+
+	return &ExternalSolverClient{
+		baseURL: baseURL,
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+// Solve posts a problem to the solver service and decodes its routes
+func (c *ExternalSolverClient) Solve(ctx context.Context, req ExternalSolveRequest) (*ExternalSolveResponse, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode solve request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/solve", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to build solve request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(httpReq)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("%w: request failed: %v", ErrExternalSolver, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%w: status %d: %s", ErrExternalSolver, resp.StatusCode, string(msg))
+	}
+
+	var result ExternalSolveResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("%w: failed to decode response: %v", ErrExternalSolver, err)
+	}
+
+	return &result, nil
+}
+
+// constructExternal asks the external solver for routes and re-times them with the local evaluator
+func (s *VRPSolver) constructExternal(ctx context.Context) ([]routePlan, error) {
+	if s.external == nil {
+		return nil, fmt.Errorf("%w: no external solver configured", ErrExternalSolver)
+	}
+
+	req := s.externalRequest()
+	if deadline, ok := ctx.Deadline(); ok {
+		remaining := max(time.Until(deadline).Milliseconds(), 0)
+		if req.TimeLimitMs == 0 || remaining < req.TimeLimitMs {
+			req.TimeLimitMs = remaining
+		}
+	}
+
+	resp, err := s.external.Solve(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	vehicleIndex := make(map[string]int, len(s.vehicles))
+	for i, vehicle := range s.vehicles {
+		vehicleIndex[vehicle.ID.String()] = i
+	}
+
+	sequences := make([][]int, len(s.vehicles))
+	seen := make(map[int]bool)
+	for _, route := range resp.Routes {
+		v, ok := vehicleIndex[route.VehicleID]
+		if !ok {
+			return nil, fmt.Errorf("%w: unknown vehicle %s", ErrExternalSolver, route.VehicleID)
+		}
+		if sequences[v] != nil {
+			return nil, fmt.Errorf("%w: vehicle %s has more than one route", ErrExternalSolver, route.VehicleID)
+		}
+		for _, node := range route.Nodes {
+			if node < s.depotCount() || node >= s.stopEnd() || seen[node] {
+				return nil, fmt.Errorf("%w: invalid or repeated node %d", ErrExternalSolver, node)
+			}
+			seen[node] = true
+		}
+		sequences[v] = route.Nodes
+	}
+
+	// Routes that break a local constraint (e.g. rest breaks the service does not model)
+	// are dropped and their stops reinserted wherever they fit
+	plans := make([]routePlan, len(s.vehicles))
+	leftover := make([]int, 0)
+	for v, vehicle := range s.vehicles {
+		plans[v] = s.newRoutePlan(vehicle, []int{})
+		if len(sequences[v]) == 0 {
+			continue
+		}
+		plan := s.newRoutePlan(vehicle, sequences[v])
+		if !plan.eval.feasible {
+			log.Printf("⚠️ External route for vehicle %s rejected: %s", vehicle.ID, plan.eval.reason)
+			leftover = append(leftover, sequences[v]...)
+			continue
+		}
+		plans[v] = plan
+	}
+
+	pallets := s.remaining(plans)
+	for _, node := range leftover {
+		if !s.nodes[node].isPickup() {
+			s.insertCheapest(plans, node, pallets)
+		}
+	}
+
+	return plans, nil
+}
+
+// externalRequest describes the prepared nodes, matrix and vehicles for the external solver
+func (s *VRPSolver) externalRequest() ExternalSolveRequest {
+	seconds := func(t time.Time) int64 {
+		return int64(t.Sub(s.planStart).Seconds())
+	}
+
+	req := ExternalSolveRequest{
+		Distances:   s.matrix.Distances,
+		Durations:   make([][]float64, len(s.matrix.Durations)),
+		Nodes:       make([]ExternalNode, len(s.nodes)),
+		Vehicles:    make([]ExternalVehicle, 0, len(s.vehicles)),
+		Depots:      s.depotCount(),
+		TimeLimitMs: max(s.constraints.ImprovementTimeLimit.Milliseconds(), 0), // a negative budget skips improvement
+		Seed:        s.constraints.Seed,
+	}
+
+	for i, row := range s.matrix.Durations {
+		req.Durations[i] = make([]float64, len(row))
+		for j, d := range row {
+			req.Durations[i][j] = d.Seconds()
+		}
+	}
+
+	for i, node := range s.nodes {
+		ext := ExternalNode{
+			WeightKg:       node.load.WeightKg,
+			VolumeM3:       node.load.VolumeM3,
+			Items:          node.load.Items,
+			Pallets:        node.load.Pallets,
+			ServiceSeconds: int64(node.service.Seconds()),
+			VehicleTypes:   node.vehicleTypes,
+		}
+		if node.order != nil {
+			ext.OrderID = node.order.ID.String()
+			ext.StopType = node.stopType
+			if node.paired() {
+				pair := node.pair
+				ext.PairedNode = &pair
+			}
+		} else if i < s.depotCount() {
+			ext.DepotID = s.depots[i].ID.String()
+			ext.MaxPallets = s.depots[i].CapacityPallets
+		}
+		if !node.window.Start.IsZero() {
+			start := seconds(node.window.Start)
+			ext.WindowStart = &start
+		}
+		if !node.window.End.IsZero() {
+			end := seconds(node.window.End)
+			ext.WindowEnd = &end
+		}
+		req.Nodes[i] = ext
+	}
+
+	for _, vehicle := range s.vehicles {
+		ctx := s.newVehicleContext(vehicle)
+		var endNode *int
+		if ctx.end != endAtNearestDepot {
+			end := ctx.end
+			endNode = &end
+		}
+		req.Vehicles = append(req.Vehicles, ExternalVehicle{
+			VehicleID:   vehicle.ID.String(),
+			Type:        vehicleType(vehicle),
+			CapacityKg:  ctx.capacity.WeightKg,
+			CapacityM3:  ctx.capacity.VolumeM3,
+			Items:       ctx.capacity.Items,
+			ShiftStart:  seconds(ctx.shift.Start),
+			ShiftEnd:    seconds(ctx.shift.End.Add(max(s.constraints.MaxOvertime, 0))),
+			StartNode:   ctx.origin,
+			EndNode:     endNode,
+			CostPerKm:   vehicle.CostPerKm,
+			FixedCost:   vehicle.FixedCostPerDay,
+			CostPerHour: vehicle.CostPerHour,
+		})
+	}
+
+	return req
+}
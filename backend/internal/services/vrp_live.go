@@ -0,0 +1,328 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/ai-tms/backend/internal/models"
+)
+
+// Replanning works on routes that are already on the road: vehicles start from where they are,
+// not before the time of replanning, and keep the stops they were given in order unless
+// the solver is asked to insert new ones
+
+// Congestion slows down travel to and from every node within RadiusKm of Center
+type Congestion struct {
+	Center   LatLng
+	RadiusKm float64
+	Factor   float64 // Travel takes this many times as long, 2 for double
+}
+
+// FixedStop is a stop on a fixed route
+type FixedStop struct {
+	OrderID  string
+	StopType string // StopTypePickup or StopTypeDelivery, delivery when empty
+}
+
+// FixedRoute is a vehicle's visiting sequence that is kept in order
+type FixedRoute struct {
+	Vehicle models.Vehicle
+	Stops   []FixedStop
+}
+
+// Retime simulates fixed routes as they are, with the current constraints, positions and congestion
+// Every order on the routes must be one of the solver's orders; routes keep the input order
+func (s *VRPSolver) Retime(ctx context.Context, routes []FixedRoute) ([]RouteResult, error) {
+	if err := s.prepare(); err != nil {
+		return nil, err
+	}
+	plans, err := s.fixedPlans(routes)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.fixedResults(plans), nil
+}
+
+// Insert adds the solver's orders that are not on the fixed routes where each is cheapest,
+// keeping the sequence of the stops already planned. Orders that fit nowhere are reported
+// by Unassigned and returned alongside ErrUnassignedOrders
+func (s *VRPSolver) Insert(ctx context.Context, routes []FixedRoute) ([]RouteResult, error) {
+	if err := s.prepare(); err != nil {
+		return nil, err
+	}
+	plans, err := s.fixedPlans(routes)
+	if err != nil {
+		return nil, err
+	}
+
+	planned := make(map[int]bool)
+	for _, plan := range plans {
+		for _, node := range plan.nodes {
+			planned[node] = true
+		}
+	}
+
+	pallets := s.remaining(plans)
+	for _, node := range s.orderNodes() {
+		if planned[node] {
+			continue
+		}
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if !s.insertCheapest(plans, node, pallets) {
+			order := s.nodes[node].order
+			s.unassigned = append(s.unassigned, UnassignedOrder{
+				OrderID:     order.ID.String(),
+				OrderNumber: order.OrderNumber,
+				Reason:      s.unassignedReason(node, pallets),
+			})
+		}
+	}
+
+	results := s.fixedResults(plans)
+	if len(s.unassigned) > 0 {
+		return results, fmt.Errorf("%w: %d orders left (no route has room)", ErrUnassignedOrders, len(s.unassigned))
+	}
+	return results, nil
+}
+
+// fixedPlans maps fixed routes onto solver nodes
+func (s *VRPSolver) fixedPlans(routes []FixedRoute) ([]routePlan, error) {
+	deliveries := make(map[string]int, len(s.orders))
+	for _, node := range s.orderNodes() {
+		deliveries[s.nodes[node].order.ID.String()] = node
+	}
+
+	plans := make([]routePlan, 0, len(routes))
+	for _, route := range routes {
+		nodes := make([]int, 0, len(route.Stops))
+		for _, stop := range route.Stops {
+			node, ok := deliveries[stop.OrderID]
+			if !ok {
+				return nil, fmt.Errorf("order %s on route of vehicle %s is not part of the problem", stop.OrderID, route.Vehicle.ID)
+			}
+			if stop.StopType == StopTypePickup {
+				if !s.nodes[node].paired() {
+					return nil, fmt.Errorf("order %s has no pickup stop", stop.OrderID)
+				}
+				node = s.nodes[node].pair
+			}
+			nodes = append(nodes, node)
+		}
+		plans = append(plans, s.newRoutePlan(route.Vehicle, nodes))
+	}
+	return plans, nil
+}
+
+// fixedResults converts fixed route plans into timed routes, including routes left empty
+func (s *VRPSolver) fixedResults(plans []routePlan) []RouteResult {
+	results := make([]RouteResult, 0, len(plans))
+	for _, plan := range plans {
+		result := s.routeResult(plan)
+		if !plan.eval.feasible {
+			result.Violation = plan.eval.reason
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+// originNodes returns the node each vehicle with a start position leaves from
+// Origins come after every stop node, in vehicle order
+func (s *VRPSolver) originNodes(first int) map[string]int {
+	origins := make(map[string]int)
+	for _, vehicle := range s.vehicles {
+		id := vehicle.ID.String()
+		if _, ok := s.constraints.StartPositions[id]; ok {
+			origins[id] = first + len(origins)
+		}
+	}
+	return origins
+}
+
+// originPoints returns the start positions of the origin nodes in node order
+func (s *VRPSolver) originPoints() []LatLng {
+	points := make([]LatLng, 0, len(s.origins))
+	for _, vehicle := range s.vehicles {
+		if point, ok := s.constraints.StartPositions[vehicle.ID.String()]; ok {
+			points = append(points, point)
+		}
+	}
+	return points
+}
+
+// stopEnd returns the node after the last stop node, where origins begin
+func (s *VRPSolver) stopEnd() int {
+	return len(s.nodes) - len(s.origins)
+}
+
+// congested returns the matrix with travel near congestion slowed down
+// The matrix is copied so a preset matrix is left untouched
+func (s *VRPSolver) congested(matrix *DistanceMatrix, points []LatLng) *DistanceMatrix {
+	if len(s.constraints.Congestion) == 0 {
+		return matrix
+	}
+
+	factors := make([]float64, len(points))
+	for i, point := range points {
+		factors[i] = 1
+		for _, zone := range s.constraints.Congestion {
+			if zone.Factor > factors[i] && HaversineKm(point, zone.Center) <= zone.RadiusKm {
+				factors[i] = zone.Factor
+			}
+		}
+	}
+
+	slowed := &DistanceMatrix{
+		Distances: matrix.Distances,
+		Durations: make([][]time.Duration, len(matrix.Durations)),
+		Source:    matrix.Source,
+	}
+	for i, row := range matrix.Durations {
+		slowed.Durations[i] = make([]time.Duration, len(row))
+		for j, d := range row {
+			slowed.Durations[i][j] = time.Duration(float64(d) * max(factors[i], factors[j]))
+		}
+	}
+	return slowed
+}
+
+// notBefore moves a shift start up to the earliest time routes may start
+func (s *VRPSolver) notBefore(shift Shift) Shift {
+	if shift.Start.Before(s.constraints.NotBefore) {
+		shift.Start = s.constraints.NotBefore
+	}
+	return shift
+}
+
+// InsertionQuote is the cheapest place to add an order to fixed routes
+type InsertionQuote struct {
+	RouteIndex     int // Index of the chosen route among the fixed routes
+	VehicleID      string
+	Position       int // Index of the delivery among the route's stops after insertion
+	PickupPosition int // Index of the pickup for pickup-and-delivery orders, -1 otherwise
+	Route          RouteResult
+	ExtraCost      float64       // Route cost added by the order
+	ExtraTime      time.Duration // Route duration added by the order
+	Feasible       bool          // False when no route can take the order within its hard constraints
+	Violations     []string      // Hard constraint broken, and stops the insertion makes late
+}
+
+// QuoteInsertion finds where one of the solver's orders adds the least to the fixed routes,
+// which must not carry it yet. Feasible positions are preferred; when there is none the
+// cheapest infeasible one is quoted with the constraint it breaks
+func (s *VRPSolver) QuoteInsertion(ctx context.Context, routes []FixedRoute, orderID string) (*InsertionQuote, error) {
+	if err := s.prepare(); err != nil {
+		return nil, err
+	}
+	plans, err := s.fixedPlans(routes)
+	if err != nil {
+		return nil, err
+	}
+
+	node := -1
+	for _, n := range s.orderNodes() {
+		if s.nodes[n].order.ID.String() == orderID {
+			node = n
+		}
+	}
+	if node < 0 {
+		return nil, fmt.Errorf("order %s is not part of the problem", orderID)
+	}
+	for _, plan := range plans {
+		for _, n := range plan.nodes {
+			if n == node {
+				return nil, fmt.Errorf("order %s is already on the route of vehicle %s", orderID, plan.vehicle.ID)
+			}
+		}
+	}
+
+	pallets := s.remaining(plans)
+	pallet := s.nodes[node].depotLoad().Pallets
+	best, bestRoute := routePlan{}, -1
+	bestFeasible, bestDelta := false, math.MaxFloat64
+	for r := range plans {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		for _, nodes := range s.insertions(plans[r].nodes, node) {
+			candidate := s.newRoutePlan(plans[r].vehicle, nodes)
+			if !pallets.fits(s.startDepot(plans[r].vehicle), pallet) && candidate.eval.feasible {
+				candidate.eval.feasible = false
+				candidate.eval.reason = "depot pallet budget exceeded"
+			}
+
+			delta := candidate.eval.objective() - plans[r].eval.objective()
+			if (candidate.eval.feasible && !bestFeasible) || (candidate.eval.feasible == bestFeasible && delta < bestDelta) {
+				best, bestRoute, bestFeasible, bestDelta = candidate, r, candidate.eval.feasible, delta
+			}
+		}
+	}
+	if bestRoute < 0 {
+		return nil, fmt.Errorf("no route to insert order %s into", orderID)
+	}
+
+	before, after := s.routeResult(plans[bestRoute]), s.routeResult(best)
+	quote := &InsertionQuote{
+		RouteIndex:     bestRoute,
+		VehicleID:      after.VehicleID,
+		Position:       -1,
+		PickupPosition: -1,
+		Route:          after,
+		ExtraCost:      after.TotalCost - before.TotalCost,
+		ExtraTime:      after.TotalDuration - before.TotalDuration,
+		Feasible:       best.eval.feasible,
+	}
+	for i, n := range best.nodes {
+		switch n {
+		case node:
+			quote.Position = i
+		case s.nodes[node].pair:
+			if s.nodes[node].paired() {
+				quote.PickupPosition = i
+			}
+		}
+	}
+	if !best.eval.feasible {
+		quote.Route.Violation = best.eval.reason
+		quote.Violations = append(quote.Violations, best.eval.reason)
+	}
+	quote.Violations = append(quote.Violations, s.newlyLate(before.Stops, after.Stops)...)
+	return quote, nil
+}
+
+// newlyLate describes the stops that arrive later past their window after a change than before
+func (s *VRPSolver) newlyLate(before, after []RouteStop) []string {
+	labels := make(map[string]string, len(s.orders))
+	for _, order := range s.orders {
+		labels[order.ID.String()] = orderLabel(order)
+	}
+
+	lateBefore := make(map[string]time.Duration, len(before))
+	for _, stop := range before {
+		lateBefore[stopKey(stop.OrderID, stop.StopType)] = stop.LateBy
+	}
+
+	late := make([]string, 0)
+	for _, stop := range after {
+		if stop.LateBy > lateBefore[stopKey(stop.OrderID, stop.StopType)] {
+			late = append(late, fmt.Sprintf("%s of order %s arrives %d min after its time window",
+				stopTypeOrDelivery(stop.StopType), labels[stop.OrderID], int(stop.LateBy.Minutes())))
+		}
+	}
+	return late
+}
+
+// stopTypeOrDelivery names a stop type, stops without one being deliveries
+func stopTypeOrDelivery(stopType string) string {
+	if stopType == "" {
+		return StopTypeDelivery
+	}
+	return stopType
+}
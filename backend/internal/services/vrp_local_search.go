@@ -0,0 +1,410 @@
+package services
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/ai-tms/backend/internal/models"
+)
+
+const (
+	// defaultImprovementTimeLimit bounds the local search phase when no budget is configured
+	defaultImprovementTimeLimit = 2 * time.Second
+
+	// maxSegmentLength is the longest chain of stops moved by or-opt and cross-exchange
+	maxSegmentLength = 3
+
+	// improvementEpsilon ignores floating point noise when comparing objectives
+	improvementEpsilon = 1e-6
+)
+
+// Local search move names used in the solver report
+const (
+	MoveTwoOpt        = "2-opt"
+	MoveOrOpt         = "or-opt"
+	MoveRelocate      = "relocate"
+	MoveSwap          = "swap"
+	MoveCrossExchange = "cross-exchange"
+	MovePairRelocate  = "pair-relocate"
+)
+
+// SolverReport summarizes how a solve improved the constructed plan
+type SolverReport struct {
+	InitialCost     float64 // Objective (cost plus penalties) after construction
+	FinalCost       float64 // Objective after local search
+	InitialDistance float64
+	FinalDistance   float64
+	Iterations      int            // Improving moves applied
+	Moves           map[string]int // Improving moves applied, by move type
+	Duration        time.Duration  // Time spent in local search
+	TimedOut        bool           // Search stopped on the time budget rather than a local optimum
+	Seed            int64          // Seed of the route scan order, 0 for input order
+}
+
+// SolverProgress is a snapshot of a running solve, reported after construction and after
+// every improving move
+type SolverProgress struct {
+	Iteration int           // Improving moves applied so far
+	BestCost  float64       // Objective of the best plan found so far
+	Elapsed   time.Duration // Time spent in local search so far
+}
+
+// ProgressFunc receives solver progress on the solving goroutine and should return quickly
+type ProgressFunc func(SolverProgress)
+
+// Improvement returns the relative cost reduction as a percentage
+func (r SolverReport) Improvement() float64 {
+	if r.InitialCost <= 0 {
+		return 0
+	}
+	return (r.InitialCost - r.FinalCost) / r.InitialCost * 100
+}
+
+// routePlan is a vehicle's visiting sequence together with its evaluation
+type routePlan struct {
+	vehicle models.Vehicle
+	nodes   []int
+	eval    routeEval
+}
+
+// localSearch improves a set of feasible routes with intra- and inter-route moves
+type localSearch struct {
+	ctx      context.Context
+	solver   *VRPSolver
+	plans    []routePlan
+	deadline time.Time
+	report   *SolverReport
+	rng      *rand.Rand // Shuffles the route scan order, nil keeps input order
+	order    []int      // Route indices in scan order
+	started  time.Time
+}
+
+// newRoutePlan evaluates a visiting sequence for a vehicle
+func (s *VRPSolver) newRoutePlan(vehicle models.Vehicle, nodes []int) routePlan {
+	return routePlan{vehicle: vehicle, nodes: nodes, eval: s.evaluateRoute(vehicle, nodes)}
+}
+
+// improve runs local search over the plans until no move helps, the time budget is spent
+// or the context is done
+func (s *VRPSolver) improve(ctx context.Context, plans []routePlan) []routePlan {
+	report := SolverReport{
+		InitialCost:     plansObjective(plans),
+		InitialDistance: plansDistance(plans),
+		Moves:           map[string]int{},
+		Seed:            s.constraints.Seed,
+	}
+
+	started := time.Now()
+	s.notify(SolverProgress{BestCost: report.InitialCost})
+	if s.improves() && s.constraints.ImprovementTimeLimit > 0 {
+		deadline := started.Add(s.constraints.ImprovementTimeLimit)
+		if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+			deadline = ctxDeadline
+		}
+		ls := &localSearch{
+			ctx:      ctx,
+			solver:   s,
+			plans:    plans,
+			deadline: deadline,
+			report:   &report,
+			started:  started,
+		}
+		if s.constraints.Seed != 0 {
+			ls.rng = rand.New(rand.NewSource(s.constraints.Seed))
+		}
+		ls.run()
+		plans = ls.plans
+	}
+
+	report.FinalCost = plansObjective(plans)
+	report.FinalDistance = plansDistance(plans)
+	report.Duration = time.Since(started)
+	s.report = report
+
+	return plans
+}
+
+// improves reports whether the configured strategy runs the local search phase
+func (s *VRPSolver) improves() bool {
+	return s.strategy == "" || s.strategy == StrategyLocalSearch
+}
+
+// run applies improving moves until a local optimum is reached or the deadline passes
+func (ls *localSearch) run() {
+	operators := []func() bool{
+		ls.twoOpt,
+		ls.orOpt,
+		ls.relocate,
+		ls.pairRelocate,
+		ls.swap,
+		ls.crossExchange,
+	}
+
+	for improved := true; improved; {
+		improved = false
+		ls.shuffle()
+		for _, operator := range operators {
+			if ls.expired() {
+				ls.report.TimedOut = true
+				return
+			}
+			if operator() {
+				improved = true
+			}
+		}
+	}
+}
+
+// expired reports whether the time budget is spent or the run was cancelled
+func (ls *localSearch) expired() bool {
+	return ls.ctx.Err() != nil || time.Now().After(ls.deadline)
+}
+
+// shuffle draws the route scan order for the next pass
+func (ls *localSearch) shuffle() {
+	ls.order = make([]int, len(ls.plans))
+	for i := range ls.order {
+		ls.order[i] = i
+	}
+	if ls.rng != nil {
+		ls.rng.Shuffle(len(ls.order), func(i, j int) {
+			ls.order[i], ls.order[j] = ls.order[j], ls.order[i]
+		})
+	}
+}
+
+// tryIntra replaces a route's sequence if the new one is feasible and cheaper
+func (ls *localSearch) tryIntra(move string, r int, nodes []int) bool {
+	candidate := ls.solver.newRoutePlan(ls.plans[r].vehicle, nodes)
+	if !candidate.eval.feasible {
+		return false
+	}
+	if candidate.eval.objective() < ls.plans[r].eval.objective()-improvementEpsilon {
+		ls.plans[r] = candidate
+		ls.accept(move)
+		return true
+	}
+	return false
+}
+
+// tryInter replaces two routes' sequences if both are feasible and the pair is cheaper
+func (ls *localSearch) tryInter(move string, a, b int, nodesA, nodesB []int) bool {
+	current := ls.plans[a].eval.objective() + ls.plans[b].eval.objective()
+
+	candidateA := ls.solver.newRoutePlan(ls.plans[a].vehicle, nodesA)
+	if !candidateA.eval.feasible || candidateA.eval.objective() >= current-improvementEpsilon {
+		return false
+	}
+	candidateB := ls.solver.newRoutePlan(ls.plans[b].vehicle, nodesB)
+	if !candidateB.eval.feasible {
+		return false
+	}
+	if candidateA.eval.objective()+candidateB.eval.objective() >= current-improvementEpsilon {
+		return false
+	}
+
+	previousA, previousB := ls.plans[a], ls.plans[b]
+	ls.plans[a] = candidateA
+	ls.plans[b] = candidateB
+
+	// Moving stops between routes of different depots shifts pallets between depot budgets
+	vehicleA, vehicleB := previousA.vehicle, previousB.vehicle
+	if ls.solver.startDepot(vehicleA) != ls.solver.startDepot(vehicleB) && ls.solver.overbooked(ls.plans) {
+		ls.plans[a], ls.plans[b] = previousA, previousB
+		return false
+	}
+
+	ls.accept(move)
+	return true
+}
+
+// accept records an applied move in the report and reports the new best plan
+func (ls *localSearch) accept(move string) {
+	ls.report.Iterations++
+	ls.report.Moves[move]++
+	ls.solver.notify(SolverProgress{
+		Iteration: ls.report.Iterations,
+		BestCost:  plansObjective(ls.plans),
+		Elapsed:   time.Since(ls.started),
+	})
+}
+
+// twoOpt reverses a section of a route to remove crossing legs
+func (ls *localSearch) twoOpt() bool {
+	for _, r := range ls.order {
+		nodes := ls.plans[r].nodes
+		for i := 0; i < len(nodes)-1; i++ {
+			for j := i + 1; j < len(nodes); j++ {
+				if ls.expired() {
+					return false
+				}
+				if ls.tryIntra(MoveTwoOpt, r, reverseSegment(nodes, i, j)) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// orOpt moves a short chain of consecutive stops to another position in the same route
+func (ls *localSearch) orOpt() bool {
+	for _, r := range ls.order {
+		nodes := ls.plans[r].nodes
+		for length := 1; length <= maxSegmentLength; length++ {
+			for i := 0; i+length <= len(nodes); i++ {
+				segment := nodes[i : i+length]
+				rest := removeSegment(nodes, i, length)
+				for k := 0; k <= len(rest); k++ {
+					if k == i {
+						continue
+					}
+					if ls.expired() {
+						return false
+					}
+					if ls.tryIntra(MoveOrOpt, r, insertSegment(rest, k, segment)) {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+// relocate moves a single stop to any position on another route
+func (ls *localSearch) relocate() bool {
+	for _, a := range ls.order {
+		for i, node := range ls.plans[a].nodes {
+			nodesA := removeSegment(ls.plans[a].nodes, i, 1)
+			for _, b := range ls.order {
+				if a == b {
+					continue
+				}
+				for k := 0; k <= len(ls.plans[b].nodes); k++ {
+					if ls.expired() {
+						return false
+					}
+					nodesB := insertSegment(ls.plans[b].nodes, k, []int{node})
+					if ls.tryInter(MoveRelocate, a, b, nodesA, nodesB) {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+// pairRelocate moves a pickup and its delivery together to the best positions on another route
+// Single stop moves between routes always split such pairs, so they need their own move
+func (ls *localSearch) pairRelocate() bool {
+	for _, a := range ls.order {
+		for _, node := range ls.plans[a].nodes {
+			if !ls.solver.nodes[node].isPickup() {
+				continue
+			}
+			delivery := ls.solver.nodes[node].pair
+			nodesA := removePair(ls.plans[a].nodes, node, delivery)
+			for _, b := range ls.order {
+				if a == b {
+					continue
+				}
+				for _, nodesB := range ls.solver.insertions(ls.plans[b].nodes, delivery) {
+					if ls.expired() {
+						return false
+					}
+					if ls.tryInter(MovePairRelocate, a, b, nodesA, nodesB) {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+// swap exchanges a single stop between two routes
+func (ls *localSearch) swap() bool {
+	return ls.exchange(MoveSwap, 1, 1)
+}
+
+// crossExchange exchanges chains of up to maxSegmentLength stops between two routes
+func (ls *localSearch) crossExchange() bool {
+	for lengthA := 1; lengthA <= maxSegmentLength; lengthA++ {
+		for lengthB := 1; lengthB <= maxSegmentLength; lengthB++ {
+			if lengthA == 1 && lengthB == 1 {
+				continue // covered by swap
+			}
+			if ls.exchange(MoveCrossExchange, lengthA, lengthB) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// exchange swaps a chain of lengthA stops on one route with lengthB stops on another
+func (ls *localSearch) exchange(move string, lengthA, lengthB int) bool {
+	for x, a := range ls.order {
+		for _, b := range ls.order[x+1:] {
+			routeA, routeB := ls.plans[a].nodes, ls.plans[b].nodes
+			for i := 0; i+lengthA <= len(routeA); i++ {
+				for j := 0; j+lengthB <= len(routeB); j++ {
+					if ls.expired() {
+						return false
+					}
+					nodesA := insertSegment(removeSegment(routeA, i, lengthA), i, routeB[j:j+lengthB])
+					nodesB := insertSegment(removeSegment(routeB, j, lengthB), j, routeA[i:i+lengthA])
+					if ls.tryInter(move, a, b, nodesA, nodesB) {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+// reverseSegment returns a copy of nodes with positions i..j reversed
+func reverseSegment(nodes []int, i, j int) []int {
+	out := append([]int(nil), nodes...)
+	for ; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
+
+// removeSegment returns a copy of nodes without the length stops starting at i
+func removeSegment(nodes []int, i, length int) []int {
+	out := make([]int, 0, len(nodes)-length)
+	out = append(out, nodes[:i]...)
+	return append(out, nodes[i+length:]...)
+}
+
+// insertSegment returns a copy of nodes with segment inserted before position k
+func insertSegment(nodes []int, k int, segment []int) []int {
+	out := make([]int, 0, len(nodes)+len(segment))
+	out = append(out, nodes[:k]...)
+	out = append(out, segment...)
+	return append(out, nodes[k:]...)
+}
+
+// plansObjective sums cost and penalties over all routes
+func plansObjective(plans []routePlan) float64 {
+	total := 0.0
+	for _, plan := range plans {
+		total += plan.eval.objective()
+	}
+	return total
+}
+
+// plansDistance sums the driven distance over all routes
+func plansDistance(plans []routePlan) float64 {
+	total := 0.0
+	for _, plan := range plans {
+		total += plan.eval.distance
+	}
+	return total
+}
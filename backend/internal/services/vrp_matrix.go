@@ -0,0 +1,128 @@
+package services
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/ai-tms/backend/internal/maps"
+)
+
+const (
+	// averageSpeedKmh is the travel speed assumed when no road network data is available
+	averageSpeedKmh = 40.0
+
+	// earthRadiusKm is the mean radius of the earth used by the haversine formula
+	earthRadiusKm = 6371.0
+)
+
+// DistanceMatrix holds travel distances and durations between solver nodes
+type DistanceMatrix struct {
+	Distances [][]float64       // in kilometers
+	Durations [][]time.Duration // estimated driving time
+	Source    string            // "osrm" or "haversine"
+}
+
+// Distance returns the distance in kilometers between two nodes
+func (m *DistanceMatrix) Distance(from, to int) float64 {
+	return m.Distances[from][to]
+}
+
+// Duration returns the driving time between two nodes
+func (m *DistanceMatrix) Duration(from, to int) time.Duration {
+	return m.Durations[from][to]
+}
+
+// NewHaversineMatrix builds a matrix from great-circle distances at average speed
+func NewHaversineMatrix(points []LatLng) *DistanceMatrix {
+	n := len(points)
+	matrix := &DistanceMatrix{
+		Distances: make([][]float64, n),
+		Durations: make([][]time.Duration, n),
+		Source:    "haversine",
+	}
+
+	for i := range points {
+		matrix.Distances[i] = make([]float64, n)
+		matrix.Durations[i] = make([]time.Duration, n)
+		for j := range points {
+			if i == j {
+				continue
+			}
+			distance := HaversineKm(points[i], points[j])
+			matrix.Distances[i][j] = distance
+			matrix.Durations[i][j] = travelTime(distance)
+		}
+	}
+
+	return matrix
+}
+
+// NewOSRMMatrix builds a matrix from an OSRM table lookup through the maps proxy
+// Pairs OSRM finds no route between are estimated from their great-circle distance
+func NewOSRMMatrix(proxy *maps.MapsProxyService, points []LatLng) (*DistanceMatrix, error) {
+	coordinates := make([][]float64, len(points))
+	for i, p := range points {
+		coordinates[i] = []float64{p.Lng, p.Lat} // OSRM expects [lng, lat]
+	}
+
+	table, err := proxy.GetTable(coordinates)
+	if err != nil {
+		return nil, err
+	}
+
+	n := len(points)
+	if len(table.Distances) != n || len(table.Durations) != n {
+		return nil, fmt.Errorf("osrm table has %d rows, expected %d", len(table.Distances), n)
+	}
+
+	matrix := &DistanceMatrix{
+		Distances: make([][]float64, n),
+		Durations: make([][]time.Duration, n),
+		Source:    "osrm",
+	}
+
+	for i := 0; i < n; i++ {
+		if len(table.Distances[i]) != n || len(table.Durations[i]) != n {
+			return nil, fmt.Errorf("osrm table row %d has wrong size", i)
+		}
+		matrix.Distances[i] = make([]float64, n)
+		matrix.Durations[i] = make([]time.Duration, n)
+		for j := 0; j < n; j++ {
+			distance, duration := table.Distances[i][j], table.Durations[i][j]
+			if distance == nil || duration == nil {
+				km := HaversineKm(points[i], points[j])
+				matrix.Distances[i][j] = km
+				matrix.Durations[i][j] = travelTime(km)
+				continue
+			}
+			matrix.Distances[i][j] = *distance / 1000.0 // Convert to km
+			matrix.Durations[i][j] = time.Duration(*duration * float64(time.Second))
+		}
+	}
+
+	return matrix, nil
+}
+
+// HaversineKm returns the great-circle distance between two coordinates in kilometers
+func HaversineKm(a, b LatLng) float64 {
+	lat1 := a.Lat * math.Pi / 180
+	lat2 := b.Lat * math.Pi / 180
+	dLat := (b.Lat - a.Lat) * math.Pi / 180
+	dLng := (b.Lng - a.Lng) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+
+	return 2 * earthRadiusKm * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// travelTime estimates driving time for a distance at average speed
+func travelTime(distanceKm float64) time.Duration {
+	return time.Duration(distanceKm / averageSpeedKmh * float64(time.Hour))
+}
+
+// hasCoordinates reports whether a point has been located (0,0 means unknown)
+func hasCoordinates(p LatLng) bool {
+	return p.Lat != 0 || p.Lng != 0
+}
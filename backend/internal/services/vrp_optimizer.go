@@ -0,0 +1,146 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ai-tms/backend/internal/maps"
+	"github.com/ai-tms/backend/internal/models"
+)
+
+// Route optimization strategies selectable per request
+const (
+	StrategyGreedy      = "greedy"       // nearest neighbor construction only
+	StrategySavings     = "savings"      // Clarke-Wright savings construction only
+	StrategyLocalSearch = "local_search" // nearest neighbor followed by local search
+	StrategyExternal    = "external"     // external solver service, re-timed locally
+
+	// DefaultStrategy is used when a request does not name one
+	DefaultStrategy = StrategyLocalSearch
+)
+
+// ErrUnknownStrategy is returned for a strategy name that is not registered
+var ErrUnknownStrategy = errors.New("unknown optimization strategy")
+
+// OptimizationProblem is the input to a route optimizer
+type OptimizationProblem struct {
+	Orders      []models.Order
+	Vehicles    []models.Vehicle
+	Depot       models.Depot
+	Constraints VRPConstraints
+
+	// Optional: further depots vehicles start from; when set, Depot is ignored
+	Depots []models.Depot
+
+	// Optional: a precomputed matrix (depots first, then Orders, then pickups)
+	Matrix *DistanceMatrix
+
+	// PlanStart is the date and time the planning day starts, 08:00 today when zero
+	PlanStart time.Time
+
+	// Optional: follows the best cost while the optimizer runs
+	Progress ProgressFunc
+}
+
+// OptimizationResult is the plan produced by a route optimizer
+type OptimizationResult struct {
+	Strategy   string
+	Routes     []RouteResult
+	Unassigned []UnassignedOrder
+	Report     SolverReport
+}
+
+// RouteOptimizer plans vehicle routes for a set of orders
+// Like VRPSolver.SolveContext, Optimize returns a partial result together with ErrUnassignedOrders
+// and stops searching when the context's deadline passes
+type RouteOptimizer interface {
+	Name() string
+	Optimize(ctx context.Context, problem OptimizationProblem) (*OptimizationResult, error)
+}
+
+// solverOptimizer runs VRPSolver with a fixed strategy
+type solverOptimizer struct {
+	strategy  string
+	mapsProxy *maps.MapsProxyService
+	external  *ExternalSolverClient
+}
+
+// Name returns the strategy name
+func (o *solverOptimizer) Name() string {
+	return o.strategy
+}
+
+// Optimize solves the problem with a fresh VRPSolver
+func (o *solverOptimizer) Optimize(ctx context.Context, problem OptimizationProblem) (*OptimizationResult, error) {
+	solver := NewVRPSolver(problem.Orders, problem.Vehicles, problem.Depot).
+		SetMapsProxy(o.mapsProxy).
+		SetExternalSolver(o.external).
+		SetStrategy(o.strategy).
+		SetDepots(problem.Depots).
+		SetProgress(problem.Progress)
+	if problem.Matrix != nil {
+		solver.SetDistanceMatrix(problem.Matrix)
+	}
+	if !problem.PlanStart.IsZero() {
+		solver.SetPlanStart(problem.PlanStart)
+	}
+
+	solver.constraints = problem.Constraints
+	routes, err := solver.SolveContext(ctx)
+	if err != nil && !errors.Is(err, ErrUnassignedOrders) {
+		return nil, err
+	}
+
+	return &OptimizationResult{
+		Strategy:   o.strategy,
+		Routes:     routes,
+		Unassigned: solver.Unassigned(),
+		Report:     solver.Report(),
+	}, err
+}
+
+// OptimizerRegistry hands out route optimizers by strategy name
+type OptimizerRegistry struct {
+	mapsProxy *maps.MapsProxyService
+	external  *ExternalSolverClient
+}
+
+// NewOptimizerRegistry creates a registry whose optimizers use the given maps proxy
+func NewOptimizerRegistry(mapsProxy *maps.MapsProxyService) *OptimizerRegistry {
+	return &OptimizerRegistry{mapsProxy: mapsProxy}
+}
+
+// SetExternalSolver enables the external strategy
+func (r *OptimizerRegistry) SetExternalSolver(client *ExternalSolverClient) *OptimizerRegistry {
+	r.external = client
+	return r
+}
+
+// Strategies lists the strategies available in this registry
+func (r *OptimizerRegistry) Strategies() []string {
+	strategies := []string{StrategyGreedy, StrategySavings, StrategyLocalSearch}
+	if r.external != nil {
+		strategies = append(strategies, StrategyExternal)
+	}
+	return strategies
+}
+
+// Get returns the optimizer for a strategy, the default strategy for ""
+func (r *OptimizerRegistry) Get(strategy string) (RouteOptimizer, error) {
+	if strategy == "" {
+		strategy = DefaultStrategy
+	}
+
+	for _, known := range r.Strategies() {
+		if known == strategy {
+			return &solverOptimizer{strategy: strategy, mapsProxy: r.mapsProxy, external: r.external}, nil
+		}
+	}
+
+	if strategy == StrategyExternal {
+		return nil, fmt.Errorf("%w: %s (no external solver configured)", ErrUnknownStrategy, strategy)
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownStrategy, strategy)
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"strings"
+
+	"github.com/ai-tms/backend/internal/models"
+)
+
+// Stop types of a planned route stop
+const (
+	StopTypePickup   = "pickup"
+	StopTypeDelivery = "delivery"
+)
+
+// isPickup reports whether the node is the pickup half of a pickup-and-delivery order
+func (n vrpNode) isPickup() bool {
+	return n.stopType == StopTypePickup
+}
+
+// paired reports whether the node belongs to a pickup-and-delivery order
+func (n vrpNode) paired() bool {
+	return n.pair > 0
+}
+
+// depotLoad returns the load the node takes out of the depot, nothing for paired nodes
+func (n vrpNode) depotLoad() Load {
+	if n.paired() {
+		return Load{}
+	}
+	return n.load
+}
+
+// hasPickup reports whether an order is collected somewhere other than a depot
+// Orders whose pickup address is one of the depots are loaded there like any delivery
+func (s *VRPSolver) hasPickup(order models.Order) bool {
+	if hasCoordinates(LatLng{Lat: order.PickupLatitude, Lng: order.PickupLongitude}) {
+		return true
+	}
+
+	address := strings.TrimSpace(order.PickupAddress)
+	if address == "" {
+		return false
+	}
+	for _, depot := range s.depots {
+		if strings.EqualFold(address, strings.TrimSpace(depot.Address)) {
+			return false
+		}
+	}
+	return true
+}
+
+// pickupOrders returns the indices into orders of every pickup-and-delivery order
+func (s *VRPSolver) pickupOrders() []int {
+	indices := make([]int, 0)
+	for i, order := range s.orders {
+		if s.hasPickup(order) {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
+// pickupLocation returns the pickup coordinates of an order, geocoding the address if needed
+func (s *VRPSolver) pickupLocation(order models.Order) (LatLng, error) {
+	point := LatLng{Lat: order.PickupLatitude, Lng: order.PickupLongitude}
+	if hasCoordinates(point) {
+		return point, nil
+	}
+	return s.geocode(order.PickupAddress)
+}
+
+// pickupWindow returns the window in which an order can be collected, PickupTime being the earliest
+func (s *VRPSolver) pickupWindow(order models.Order) TimeWindow {
+	window := TimeWindow{}
+	if order.PickupTime != nil {
+		window.Start = *order.PickupTime
+	}
+	return window
+}
+
+// stopNodes returns every node that is visited on a route, orders first and pickups after
+func (s *VRPSolver) stopNodes() []int {
+	nodes := make([]int, 0, s.stopEnd()-s.depotCount())
+	for node := s.depotCount(); node < s.stopEnd(); node++ {
+		nodes = append(nodes, node)
+	}
+	return nodes
+}
+
+// withPickup returns the nodes to insert for an order node, its pickup first when paired
+func (s *VRPSolver) withPickup(node int) []int {
+	if s.nodes[node].paired() {
+		return []int{s.nodes[node].pair, node}
+	}
+	return []int{node}
+}
+
+// precedenceViolation explains a visit that breaks pickup-before-delivery, or ""
+// picked holds the pickups visited so far on the route
+func (s *VRPSolver) precedenceViolation(to int, picked map[int]bool) string {
+	node := s.nodes[to]
+	if node.paired() && !node.isPickup() && !picked[node.pair] {
+		return "delivery of " + orderLabel(*node.order) + " before its pickup"
+	}
+	return ""
+}
+
+// insertPair inserts a pickup at position i and its delivery at position j of the remaining sequence
+// j counts positions after the pickup has been inserted, so i <= j keeps the pickup first
+func insertPair(nodes []int, i, j, pickup, delivery int) []int {
+	return insertSegment(insertSegment(nodes, i, []int{pickup}), j+1, []int{delivery})
+}
+
+// removePair returns the sequence without a pickup and its delivery
+func removePair(nodes []int, pickup, delivery int) []int {
+	result := make([]int, 0, len(nodes))
+	for _, node := range nodes {
+		if node != pickup && node != delivery {
+			result = append(result, node)
+		}
+	}
+	return result
+}
@@ -0,0 +1,440 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ai-tms/backend/internal/models"
+)
+
+const (
+	// defaultServiceTime is used when a customer has no average service time
+	defaultServiceTime = 15 * time.Minute
+
+	// defaultWaitingCostPerHour is the penalty for idling until a time window opens
+	defaultWaitingCostPerHour = 120.0
+
+	// defaultLatenessPenaltyPerHour is the penalty for arriving after a soft time window closes
+	defaultLatenessPenaltyPerHour = 600.0
+
+	// defaultShiftLength is the working day assumed for vehicles without a known driver shift
+	defaultShiftLength = 9 * time.Hour
+
+	// defaultMaxOvertime is how far past shift end a route may run, at overtime cost
+	defaultMaxOvertime = 2 * time.Hour
+
+	// defaultOvertimeCostPerHour is charged for every hour a route runs past shift end
+	defaultOvertimeCostPerHour = 200.0
+
+	// defaultBreakAfterDriving is the continuous driving limit before a rest break is required
+	defaultBreakAfterDriving = 4 * time.Hour
+
+	// defaultBreakDuration is the minimum length of a required rest break
+	defaultBreakDuration = 30 * time.Minute
+)
+
+// vrpNode is a location the solver visits, depots come first, then order deliveries, then pickups
+type vrpNode struct {
+	order    *models.Order
+	point    LatLng
+	load     Load
+	service  time.Duration
+	window   TimeWindow
+	stopType string // StopTypeDelivery or StopTypePickup, empty for depots
+	pair     int    // Other half of a pickup-and-delivery order, 0 when loaded at the depot
+
+	vehicleTypes []string // Allowed vehicle types, empty for any
+}
+
+// vehicleContext holds the per-vehicle data used while simulating a route
+type vehicleContext struct {
+	vehicle  models.Vehicle
+	capacity VehicleCapacity
+	shift    Shift
+	start    int // Depot node the route is loaded at
+	origin   int // Node the vehicle leaves from, its start depot unless it is on the road
+	end      int // Depot node the route returns to, or endAtNearestDepot
+}
+
+// routeCursor tracks the position of a vehicle while a route is simulated
+type routeCursor struct {
+	node     int
+	time     time.Time
+	distance float64
+	load     Load // Loaded at the depot for the stops visited so far
+	onboard  Load // Carried right now, including depot load for stops still ahead
+	peak     Load // Most carried at any point
+	stops    int
+	driving  time.Duration // Driving time since the last rest break
+	breaks   int
+}
+
+// visitResult is the simulated timing of driving to a single node
+type visitResult struct {
+	distance     float64
+	travel       time.Duration
+	rest         time.Duration // break taken before departing for this node
+	arrival      time.Time     // when the vehicle reaches the location
+	serviceStart time.Time     // arrival plus any wait for the window to open
+	departure    time.Time
+	wait         time.Duration
+	late         time.Duration
+}
+
+// routeEval is the simulated outcome of driving a vehicle through a sequence of nodes
+type routeEval struct {
+	feasible bool
+	reason   string
+	stops    []RouteStop
+	distance float64
+	duration time.Duration
+	waiting  time.Duration
+	lateness time.Duration
+	breaks   int
+	overtime time.Duration
+	end      time.Time
+	endDepot int  // Depot node the route finishes at
+	load     Load // Loaded at the depot
+	peak     Load // Most carried at once, pickups included
+	costs    RouteCost
+	cost     float64 // total of costs in currency
+	penalty  float64 // waiting and lateness penalties in currency
+}
+
+// objective returns the value the solver minimizes for a route
+func (e routeEval) objective() float64 {
+	return e.cost + e.penalty
+}
+
+// IsZero reports whether the window places no restriction on arrival
+func (w TimeWindow) IsZero() bool {
+	return w.Start.IsZero() && w.End.IsZero()
+}
+
+// newVehicleContext resolves capacity and working shift for a vehicle
+func (s *VRPSolver) newVehicleContext(vehicle models.Vehicle) vehicleContext {
+	start := s.startDepot(vehicle)
+	origin := start
+	if node, ok := s.origins[vehicle.ID.String()]; ok {
+		origin = node
+	}
+	return vehicleContext{
+		vehicle:  vehicle,
+		capacity: vehicleCapacity(vehicle),
+		shift:    s.shiftFor(vehicle),
+		start:    start,
+		origin:   origin,
+		end:      s.endDepot(vehicle, start),
+	}
+}
+
+// startCursor places a vehicle at its origin at the start of its shift, loaded for the given stops
+func (s *VRPSolver) startCursor(ctx vehicleContext, nodes []int) routeCursor {
+	var loaded Load
+	for _, node := range nodes {
+		loaded = loaded.Add(s.nodes[node].depotLoad())
+	}
+	return routeCursor{node: ctx.origin, time: ctx.shift.Start, onboard: loaded, peak: loaded}
+}
+
+// needsBreak reports whether driving a further leg would exceed the continuous driving limit
+func (s *VRPSolver) needsBreak(cursor routeCursor, travel time.Duration) bool {
+	return cursor.driving > 0 && cursor.driving+travel > s.constraints.BreakAfterDriving
+}
+
+// visit simulates driving from the cursor position to a node and serving it
+func (s *VRPSolver) visit(cursor routeCursor, to int) visitResult {
+	node := s.nodes[to]
+	v := visitResult{
+		distance: s.matrix.Distance(cursor.node, to),
+		travel:   s.matrix.Duration(cursor.node, to),
+	}
+
+	// Rest before the leg if it would push continuous driving over the limit
+	departure := cursor.time
+	if s.needsBreak(cursor, v.travel) {
+		v.rest = s.constraints.BreakDuration
+		departure = departure.Add(v.rest)
+	}
+
+	v.arrival = departure.Add(v.travel)
+	v.serviceStart = v.arrival
+	if !node.window.Start.IsZero() && v.arrival.Before(node.window.Start) {
+		v.wait = node.window.Start.Sub(v.arrival)
+		v.serviceStart = node.window.Start
+	}
+	if !node.window.End.IsZero() && v.serviceStart.After(node.window.End) {
+		v.late = v.serviceStart.Sub(node.window.End)
+	}
+	v.departure = v.serviceStart.Add(node.service)
+
+	return v
+}
+
+// advance moves the cursor past a visited node
+func (s *VRPSolver) advance(cursor routeCursor, to int, v visitResult) routeCursor {
+	node := s.nodes[to]
+	next := routeCursor{
+		node:     to,
+		time:     v.departure,
+		distance: cursor.distance + v.distance,
+		load:     cursor.load.Add(node.depotLoad()),
+		onboard:  cursor.onboard.Sub(node.load),
+		peak:     cursor.peak,
+		stops:    cursor.stops + 1,
+		driving:  cursor.driving + v.travel,
+		breaks:   cursor.breaks,
+	}
+	if node.isPickup() {
+		next.onboard = cursor.onboard.Add(node.load)
+		next.peak = cursor.peak.Max(next.onboard)
+	}
+
+	if v.rest > 0 {
+		next.driving = v.travel
+		next.breaks++
+	}
+
+	// A long enough wait for a window to open counts as the rest break
+	if v.wait >= s.constraints.BreakDuration {
+		next.driving = 0
+	}
+
+	return next
+}
+
+// returnToDepot simulates the final leg to the vehicle's end depot, resting first if required
+func (s *VRPSolver) returnToDepot(ctx vehicleContext, cursor routeCursor) routeCursor {
+	if cursor.stops == 0 {
+		return cursor
+	}
+
+	end := s.routeEnd(ctx, cursor.node)
+	travel := s.matrix.Duration(cursor.node, end)
+	if s.needsBreak(cursor, travel) {
+		cursor.time = cursor.time.Add(s.constraints.BreakDuration)
+		cursor.driving = 0
+		cursor.breaks++
+	}
+
+	cursor.distance += s.matrix.Distance(cursor.node, end)
+	cursor.time = cursor.time.Add(travel)
+	cursor.driving += travel
+	cursor.node = end
+	return cursor
+}
+
+// overtime returns how far a route ending at the given time runs past the shift end
+func overtime(shift Shift, end time.Time) time.Duration {
+	if shift.End.IsZero() || !end.After(shift.End) {
+		return 0
+	}
+	return end.Sub(shift.End)
+}
+
+// violation returns why appending a visit to the cursor breaks a hard constraint, or ""
+func (s *VRPSolver) violation(ctx vehicleContext, cursor routeCursor, to int, v visitResult) string {
+	node := s.nodes[to]
+
+	if !node.allowsVehicle(ctx.vehicle) {
+		return fmt.Sprintf("requires vehicle type %s", strings.Join(node.vehicleTypes, " or "))
+	}
+	// Depot load only grows along the route, pickups add to whatever is still on board
+	carried := cursor.load.Add(node.depotLoad())
+	if node.isPickup() {
+		carried = cursor.onboard.Add(node.load)
+	}
+	if exceeded := ctx.capacity.Exceeded(carried); len(exceeded) > 0 {
+		return fmt.Sprintf("exceeds vehicle %s", exceeded[0])
+	}
+	if s.constraints.HardTimeWindows && v.late > 0 {
+		return fmt.Sprintf("arrives %s after time window closes at %s", v.late.Round(time.Minute), node.window.End.Format("15:04"))
+	}
+	if s.constraints.MaxStopsPerRoute > 0 && cursor.stops+1 > s.constraints.MaxStopsPerRoute {
+		return fmt.Sprintf("exceeds max %d stops per route", s.constraints.MaxStopsPerRoute)
+	}
+	if s.constraints.MaxDistancePerRoute > 0 {
+		total := cursor.distance + v.distance + s.matrix.Distance(to, s.routeEnd(ctx, to))
+		if total > s.constraints.MaxDistancePerRoute {
+			return fmt.Sprintf("exceeds max distance %.1f km per route", s.constraints.MaxDistancePerRoute)
+		}
+	}
+
+	// The vehicle must still be able to get home within shift end plus allowed overtime
+	end := s.returnToDepot(ctx, s.advance(cursor, to, v)).time
+	if overtime(ctx.shift, end) > max(s.constraints.MaxOvertime, 0) {
+		return fmt.Sprintf("cannot return before driver shift ends at %s", ctx.shift.End.Format("15:04"))
+	}
+
+	return ""
+}
+
+// visitPenalty returns the waiting and lateness penalty of a visit
+func (s *VRPSolver) visitPenalty(v visitResult) float64 {
+	return v.wait.Hours()*s.constraints.WaitingCostPerHour + v.late.Hours()*s.constraints.LatenessPenaltyPerHour
+}
+
+// evaluateRoute simulates a vehicle driving from its depot through the nodes and back
+func (s *VRPSolver) evaluateRoute(vehicle models.Vehicle, nodes []int) routeEval {
+	ctx := s.newVehicleContext(vehicle)
+	cursor := s.startCursor(ctx, nodes)
+	eval := routeEval{feasible: true, stops: make([]RouteStop, 0, len(nodes))}
+	picked := make(map[int]bool)
+
+	for _, to := range nodes {
+		v := s.visit(cursor, to)
+		reason := s.precedenceViolation(to, picked)
+		if reason == "" {
+			reason = s.violation(ctx, cursor, to, v)
+		}
+		if reason != "" && eval.feasible {
+			eval.feasible = false
+			eval.reason = reason
+		}
+
+		node := s.nodes[to]
+		order := node.order
+		location := order.DeliveryAddress
+		if node.isPickup() {
+			location = order.PickupAddress
+			picked[to] = true
+		} else if node.paired() {
+			delete(picked, node.pair)
+		}
+		eval.stops = append(eval.stops, RouteStop{
+			OrderID:       order.ID.String(),
+			CustomerID:    order.CustomerID.String(),
+			StopType:      node.stopType,
+			Location:      location,
+			Latitude:      s.nodes[to].point.Lat,
+			Longitude:     s.nodes[to].point.Lng,
+			ArrivalTime:   v.serviceStart,
+			DepartureTime: v.departure,
+			ServiceTime:   s.nodes[to].service,
+			Distance:      v.distance,
+			TravelTime:    v.travel,
+			WaitTime:      v.wait,
+			LateBy:        v.late,
+			BreakBefore:   v.rest,
+		})
+		eval.waiting += v.wait
+		eval.lateness += v.late
+		eval.penalty += s.visitPenalty(v)
+
+		cursor = s.advance(cursor, to, v)
+	}
+
+	// Every pickup must be delivered by the same vehicle
+	for _, to := range nodes {
+		if picked[to] && eval.feasible {
+			eval.feasible = false
+			eval.reason = "pickup of " + orderLabel(*s.nodes[to].order) + " without its delivery"
+		}
+	}
+
+	// Return to depot
+	start := ctx.shift.Start
+	cursor = s.returnToDepot(ctx, cursor)
+
+	eval.distance = cursor.distance
+	eval.duration = cursor.time.Sub(start)
+	eval.end = cursor.time
+	eval.endDepot = cursor.node
+	eval.breaks = cursor.breaks
+	eval.load = cursor.load
+	eval.peak = cursor.peak
+	eval.overtime = overtime(ctx.shift, cursor.time)
+	eval.costs = s.routeCost(vehicle, eval)
+	eval.cost = eval.costs.Total()
+
+	return eval
+}
+
+// shiftFor returns the working shift of the driver assigned to a vehicle on the plan day,
+// starting no earlier than VRPConstraints.NotBefore
+func (s *VRPSolver) shiftFor(vehicle models.Vehicle) Shift {
+	return s.notBefore(s.driverShift(vehicle))
+}
+
+// driverShift returns the shift the driver assigned to a vehicle works on the plan day
+// Explicit constraint shifts (keyed by vehicle or driver ID) win over the driver's profile
+func (s *VRPSolver) driverShift(vehicle models.Vehicle) Shift {
+	if shift, ok := s.constraints.DriverShifts[vehicle.ID.String()]; ok {
+		return shift
+	}
+	if vehicle.CurrentDriverID != nil {
+		if shift, ok := s.constraints.DriverShifts[vehicle.CurrentDriverID.String()]; ok {
+			return shift
+		}
+	}
+
+	shift := Shift{Start: s.planStart, End: s.planStart.Add(defaultShiftLength)}
+	if vehicle.CurrentDriver != nil {
+		if start, err := clockOnDay(s.planStart, vehicle.CurrentDriver.ShiftStart); err == nil {
+			shift.Start = start
+		}
+		if end, err := clockOnDay(s.planStart, vehicle.CurrentDriver.ShiftEnd); err == nil {
+			shift.End = end
+		}
+		// Overnight shifts end on the following day
+		if !shift.End.After(shift.Start) {
+			shift.End = shift.End.Add(24 * time.Hour)
+		}
+	}
+
+	return shift
+}
+
+// timeWindowFor returns the delivery window of an order on the plan day
+// Explicit constraint windows win, then the customer window, tightened by RequiredBy
+func (s *VRPSolver) timeWindowFor(order models.Order) TimeWindow {
+	if window, ok := s.constraints.TimeWindows[order.ID.String()]; ok {
+		return window
+	}
+
+	window := TimeWindow{}
+	if order.Customer != nil {
+		if start, err := clockOnDay(s.planStart, order.Customer.TimeWindowStart); err == nil {
+			window.Start = start
+		}
+		if end, err := clockOnDay(s.planStart, order.Customer.TimeWindowEnd); err == nil {
+			window.End = end
+		}
+	}
+
+	if order.RequiredBy != nil && (window.End.IsZero() || order.RequiredBy.Before(window.End)) {
+		window.End = *order.RequiredBy
+	}
+
+	return window
+}
+
+// serviceTimeFor returns how long a vehicle spends at an order's location
+func serviceTimeFor(order models.Order) time.Duration {
+	if order.Customer != nil && order.Customer.AvgServiceTimeMinutes > 0 {
+		return time.Duration(order.Customer.AvgServiceTimeMinutes) * time.Minute
+	}
+	return defaultServiceTime
+}
+
+// clockOnDay parses an "HH:MM" clock time and places it on the given day
+func clockOnDay(day time.Time, clock string) (time.Time, error) {
+	if clock == "" {
+		return time.Time{}, fmt.Errorf("empty clock time")
+	}
+
+	var parsed time.Time
+	var err error
+	for _, layout := range []string{"15:04", "15:04:05"} {
+		if parsed, err = time.Parse(layout, clock); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid clock time %q: %w", clock, err)
+	}
+
+	return time.Date(day.Year(), day.Month(), day.Day(),
+		parsed.Hour(), parsed.Minute(), parsed.Second(), 0, day.Location()), nil
+}
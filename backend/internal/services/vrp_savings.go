@@ -0,0 +1,255 @@
+package services
+
+import (
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/ai-tms/backend/internal/models"
+)
+
+// saving is the distance saved by serving two nodes on one route instead of two
+type saving struct {
+	from  int
+	to    int
+	value float64
+}
+
+// constructSavings builds routes with the Clarke-Wright parallel savings heuristic
+// Routes are merged while some vehicle can still serve them, then matched to vehicles
+// With several depots each order is seeded at its closest staffed depot and only routes
+// of the same depot are merged. Pickup-and-delivery orders are seeded as pickup then delivery
+func (s *VRPSolver) constructSavings() []routePlan {
+	profiles := s.vehicleProfiles()
+	home := s.homeDepots()
+
+	// Start with one out-and-back route per order that a vehicle can serve alone
+	pallets := s.newPalletBudget()
+	routes := make([][]int, 0, len(s.orders))
+	routeOf := make(map[int]int)
+	for _, node := range s.orderNodes() {
+		if !pallets.fits(home[node], s.nodes[node].depotLoad().Pallets) {
+			continue
+		}
+		seed := s.withPickup(node)
+		if !s.servableByAny(profiles, seed) {
+			continue
+		}
+		pallets.take(home[node], s.nodes[node].depotLoad().Pallets)
+		for _, n := range seed {
+			routeOf[n] = len(routes)
+		}
+		routes = append(routes, seed)
+	}
+
+	// Merge the route ending at from with the route starting at to, best savings first
+	for _, sv := range s.savingsList(routeOf, home) {
+		a, b := routeOf[sv.from], routeOf[sv.to]
+		if a == b {
+			continue
+		}
+		routeA, routeB := routes[a], routes[b]
+		if routeA[len(routeA)-1] != sv.from || routeB[0] != sv.to {
+			continue
+		}
+
+		merged := append(append(make([]int, 0, len(routeA)+len(routeB)), routeA...), routeB...)
+		if !s.servableByAny(profiles, merged) {
+			continue
+		}
+
+		routes[a] = merged
+		routes[b] = nil
+		for _, node := range routeB {
+			routeOf[node] = a
+		}
+	}
+
+	return s.assignRoutesToVehicles(routes)
+}
+
+// savingsList returns the savings of every ordered pair of seeded nodes sharing a home depot,
+// largest first
+func (s *VRPSolver) savingsList(routeOf map[int]int, home map[int]int) []saving {
+	nodes := make([]int, 0, len(routeOf))
+	for _, node := range s.stopNodes() {
+		if _, ok := routeOf[node]; ok {
+			nodes = append(nodes, node)
+		}
+	}
+
+	savings := make([]saving, 0, len(nodes)*len(nodes))
+	for _, i := range nodes {
+		for _, j := range nodes {
+			if i == j || home[i] != home[j] {
+				continue
+			}
+			depot := home[i]
+			value := s.matrix.Distance(i, depot) + s.matrix.Distance(depot, j) - s.matrix.Distance(i, j)
+			if value > 0 {
+				savings = append(savings, saving{from: i, to: j, value: value})
+			}
+		}
+	}
+
+	// Stable sort keeps ties in node order so runs are repeatable
+	sort.SliceStable(savings, func(x, y int) bool {
+		return savings[x].value > savings[y].value
+	})
+	return savings
+}
+
+// vehicleProfiles returns one vehicle per distinct capacity, shift, type and depot combination
+// Feasibility of a sequence only depends on these, so checking one vehicle of each suffices
+func (s *VRPSolver) vehicleProfiles() []models.Vehicle {
+	type profileKey struct {
+		capacity    VehicleCapacity
+		shift       Shift
+		vehicleType string
+		start, end  int
+		origin      int
+	}
+
+	seen := make(map[profileKey]bool)
+	profiles := make([]models.Vehicle, 0)
+	for _, vehicle := range s.vehicles {
+		ctx := s.newVehicleContext(vehicle)
+		key := profileKey{
+			capacity:    ctx.capacity,
+			shift:       ctx.shift,
+			vehicleType: strings.ToLower(vehicleType(vehicle)),
+			start:       ctx.start,
+			end:         ctx.end,
+			origin:      ctx.origin,
+		}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		profiles = append(profiles, vehicle)
+	}
+	return profiles
+}
+
+// servableByAny reports whether at least one of the vehicles can drive the sequence
+func (s *VRPSolver) servableByAny(vehicles []models.Vehicle, nodes []int) bool {
+	for _, vehicle := range vehicles {
+		if s.evaluateRoute(vehicle, nodes).feasible {
+			return true
+		}
+	}
+	return false
+}
+
+// assignRoutesToVehicles gives each route, heaviest first, the cheapest free vehicle able to drive it
+// Routes left without a vehicle have their stops inserted into the assigned routes where possible
+func (s *VRPSolver) assignRoutesToVehicles(routes [][]int) []routePlan {
+	pallets := s.newPalletBudget()
+	pending := make([][]int, 0, len(routes))
+	for _, route := range routes {
+		if len(route) > 0 {
+			pending = append(pending, route)
+		}
+	}
+	sort.SliceStable(pending, func(i, j int) bool {
+		return s.sequenceLoad(pending[i]).WeightKg > s.sequenceLoad(pending[j]).WeightKg
+	})
+
+	plans := make([]routePlan, len(s.vehicles))
+	used := make([]bool, len(s.vehicles))
+	leftover := make([]int, 0)
+	for _, route := range pending {
+		best := -1
+		var bestPlan routePlan
+		for v, vehicle := range s.vehicles {
+			if used[v] || !pallets.fits(s.startDepot(vehicle), s.sequenceLoad(route).Pallets) {
+				continue
+			}
+			plan := s.newRoutePlan(vehicle, route)
+			if plan.eval.feasible && (best < 0 || plan.eval.objective() < bestPlan.eval.objective()) {
+				best, bestPlan = v, plan
+			}
+		}
+		if best < 0 {
+			leftover = append(leftover, route...)
+			continue
+		}
+		used[best] = true
+		plans[best] = bestPlan
+		pallets.take(s.startDepot(bestPlan.vehicle), bestPlan.eval.load.Pallets)
+	}
+
+	for v, vehicle := range s.vehicles {
+		if !used[v] {
+			plans[v] = s.newRoutePlan(vehicle, []int{})
+		}
+	}
+
+	sort.Ints(leftover)
+	for _, node := range leftover {
+		if !s.nodes[node].isPickup() {
+			s.insertCheapest(plans, node, pallets)
+		}
+	}
+
+	return plans
+}
+
+// insertCheapest adds an order node at the feasible position with the lowest extra objective
+// A paired delivery is inserted together with its pickup, anywhere before it on the same route
+// It reports whether any route could take the order within its depot's pallet budget
+func (s *VRPSolver) insertCheapest(plans []routePlan, node int, pallets palletBudget) bool {
+	pallet := s.nodes[node].depotLoad().Pallets
+	bestRoute := -1
+	bestDelta := math.MaxFloat64
+	var bestPlan routePlan
+	for r := range plans {
+		if !pallets.fits(s.startDepot(plans[r].vehicle), pallet) {
+			continue
+		}
+		for _, nodes := range s.insertions(plans[r].nodes, node) {
+			candidate := s.newRoutePlan(plans[r].vehicle, nodes)
+			if !candidate.eval.feasible {
+				continue
+			}
+			if delta := candidate.eval.objective() - plans[r].eval.objective(); delta < bestDelta {
+				bestRoute, bestDelta, bestPlan = r, delta, candidate
+			}
+		}
+	}
+
+	if bestRoute < 0 {
+		return false
+	}
+	plans[bestRoute] = bestPlan
+	pallets.take(s.startDepot(bestPlan.vehicle), pallet)
+	return true
+}
+
+// insertions returns every sequence obtained by inserting an order node, with its pickup if paired
+func (s *VRPSolver) insertions(nodes []int, node int) [][]int {
+	sequences := make([][]int, 0)
+	if !s.nodes[node].paired() {
+		for k := 0; k <= len(nodes); k++ {
+			sequences = append(sequences, insertSegment(nodes, k, []int{node}))
+		}
+		return sequences
+	}
+
+	pickup := s.nodes[node].pair
+	for i := 0; i <= len(nodes); i++ {
+		for j := i; j <= len(nodes); j++ {
+			sequences = append(sequences, insertPair(nodes, i, j, pickup, node))
+		}
+	}
+	return sequences
+}
+
+// sequenceLoad sums the depot load of the nodes in a sequence
+func (s *VRPSolver) sequenceLoad(nodes []int) Load {
+	var load Load
+	for _, node := range nodes {
+		load = load.Add(s.nodes[node].depotLoad())
+	}
+	return load
+}
@@ -1,39 +1,102 @@
 package services
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
 	"math"
+	"strings"
 	"time"
 
+	"github.com/ai-tms/backend/internal/maps"
 	"github.com/ai-tms/backend/internal/models"
 )
 
+var (
+	// ErrMissingCoordinates is returned when an order or depot cannot be placed on the map
+	ErrMissingCoordinates = errors.New("missing coordinates")
+
+	// ErrUnassignedOrders is returned alongside a partial plan when some orders could not be placed
+	ErrUnassignedOrders = errors.New("could not assign all orders")
+)
+
+// DefaultPlanStartClock is when the planning day starts if no start time is given
+const DefaultPlanStartClock = "08:00"
+
+// PlanStart places a "HH:MM" start time on a plan date, DefaultPlanStartClock when empty
+func PlanStart(date time.Time, clock string) (time.Time, error) {
+	if clock == "" {
+		clock = DefaultPlanStartClock
+	}
+	return clockOnDay(date, clock)
+}
+
 // VRPSolver handles vehicle routing problem optimization
 type VRPSolver struct {
-	orders   []models.Order
-	vehicles []models.Vehicle
-	depot    models.Depot
+	orders      []models.Order
+	vehicles    []models.Vehicle
+	depots      []models.Depot // Vehicles leave from their own depot, the first one by default
+	mapsProxy   *maps.MapsProxyService
+	constraints VRPConstraints
+	strategy    string
+	external    *ExternalSolverClient
+	progress    ProgressFunc
+
+	// presetMatrix replaces coordinate lookup and matrix building when set
+	presetMatrix *DistanceMatrix
+
+	// Working state of the current solve
+	planStart  time.Time
+	nodes      []vrpNode
+	origins    map[string]int // Vehicle ID to the node of its start position
+	matrix     *DistanceMatrix
+	unassigned []UnassignedOrder
+	report     SolverReport
 }
 
 // RouteResult represents the result of route optimization
 type RouteResult struct {
 	VehicleID     string
+	StartDepotID  string
+	EndDepotID    string
 	Stops         []RouteStop
 	TotalDistance float64
 	TotalDuration time.Duration
 	TotalCost     float64
 	Utilization   float64
+	Load          Load
+	WaitingTime   time.Duration // Total idle time waiting for windows to open
+	LateTime      time.Duration // Total time past soft window ends
+	Penalty       float64       // Waiting and lateness penalties added to the objective
+	ShiftStart    time.Time
+	ShiftEnd      time.Time
+	EndTime       time.Time     // Time the vehicle is back at the depot
+	Breaks        int           // Rest breaks inserted for continuous driving
+	Overtime      time.Duration // Time past the driver's shift end
+	FixedCost     float64       // TotalCost is FixedCost + DistanceCost + TimeCost + OvertimeCost
+	DistanceCost  float64
+	TimeCost      float64
+	OvertimeCost  float64
+	Violation     string // Hard constraint a fixed route breaks, empty when feasible
 }
 
 // RouteStop represents a stop in the route
 type RouteStop struct {
 	OrderID       string
 	CustomerID    string
+	StopType      string // StopTypePickup or StopTypeDelivery
 	Location      string
-	ArrivalTime   time.Time
+	Latitude      float64
+	Longitude     float64
+	ArrivalTime   time.Time // Service start, after any wait for the window to open
 	DepartureTime time.Time
 	ServiceTime   time.Duration
 	Distance      float64
+	TravelTime    time.Duration
+	WaitTime      time.Duration
+	LateBy        time.Duration
+	BreakBefore   time.Duration // Rest break taken before driving to this stop
 }
 
 // NewVRPSolver creates a new VRP solver instance
@@ -41,198 +104,505 @@ func NewVRPSolver(orders []models.Order, vehicles []models.Vehicle, depot models
 	return &VRPSolver{
 		orders:   orders,
 		vehicles: vehicles,
-		depot:    depot,
+		depots:   []models.Depot{depot},
 	}
 }
 
-// Solve performs route optimization using a greedy nearest neighbor algorithm
-// In production, this should use OR-Tools for optimal solutions
+// SetMapsProxy enables geocoding of unlocated orders and OSRM distance lookups
+func (s *VRPSolver) SetMapsProxy(proxy *maps.MapsProxyService) *VRPSolver {
+	s.mapsProxy = proxy
+	return s
+}
+
+// SetStrategy selects how routes are built and improved, see the Strategy constants
+func (s *VRPSolver) SetStrategy(strategy string) *VRPSolver {
+	s.strategy = strategy
+	return s
+}
+
+// SetExternalSolver sets the client used by the external strategy
+func (s *VRPSolver) SetExternalSolver(client *ExternalSolverClient) *VRPSolver {
+	s.external = client
+	return s
+}
+
+// SetDistanceMatrix supplies a precomputed matrix over the depots followed by the orders
+// Orders then need no coordinates, which suits benchmark instances on a plane
+func (s *VRPSolver) SetDistanceMatrix(matrix *DistanceMatrix) *VRPSolver {
+	s.presetMatrix = matrix
+	return s
+}
+
+// SetPlanStart sets the time vehicles leave the depot when no driver shift is known
+func (s *VRPSolver) SetPlanStart(start time.Time) *VRPSolver {
+	s.planStart = start
+	return s
+}
+
+// SetProgress registers a callback that follows the best cost found during a solve
+func (s *VRPSolver) SetProgress(progress ProgressFunc) *VRPSolver {
+	s.progress = progress
+	return s
+}
+
+// notify passes solver progress to the registered callback
+func (s *VRPSolver) notify(progress SolverProgress) {
+	if s.progress != nil {
+		s.progress(progress)
+	}
+}
+
+// Report returns how the last Solve improved on the constructed plan
+func (s *VRPSolver) Report() SolverReport {
+	return s.report
+}
+
+// Unassigned returns the orders the last Solve could not place, with a reason for each
+func (s *VRPSolver) Unassigned() []UnassignedOrder {
+	return s.unassigned
+}
+
+// Solve builds routes with the configured strategy, by default a greedy nearest neighbor pass
+// improved with local search
 func (s *VRPSolver) Solve() ([]RouteResult, error) {
+	return s.SolveContext(context.Background())
+}
+
+// SolveContext is Solve bounded by a context
+// Once the context's deadline passes local search stops and the best plan so far is returned;
+// a cancelled context aborts the run with the context's error. With the same input, plan start
+// and seed, a run that finishes within its limits always produces the same plan
+func (s *VRPSolver) SolveContext(ctx context.Context) ([]RouteResult, error) {
 	if len(s.orders) == 0 {
 		return nil, fmt.Errorf("no orders to optimize")
 	}
 
-	if len(s.vehicles) == 0 {
-		return nil, fmt.Errorf("no vehicles available")
+	// Locate depot and orders and build the distance matrix
+	if err := s.prepare(); err != nil {
+		return nil, err
 	}
 
-	// Build distance matrix
-	distanceMatrix := s.buildDistanceMatrix()
+	// Build the initial routes, one plan per vehicle
+	var plans []routePlan
+	switch s.strategy {
+	case StrategySavings:
+		plans = s.constructSavings()
+	case StrategyExternal:
+		external, err := s.constructExternal(ctx)
+		if err != nil {
+			return nil, err
+		}
+		plans = external
+	default:
+		plans = s.constructGreedy()
+	}
 
-	// Initialize routes for each vehicle
-	routes := make([]RouteResult, 0, len(s.vehicles))
-	remainingOrders := make(map[string]models.Order)
-	for _, order := range s.orders {
-		remainingOrders[order.ID.String()] = order
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return nil, ctx.Err()
 	}
 
-	// Assign orders to vehicles using greedy algorithm
-	for _, vehicle := range s.vehicles {
-		if len(remainingOrders) == 0 {
-			break
-		}
+	// Improve the constructed routes within the time budget
+	plans = s.improve(ctx, plans)
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return nil, ctx.Err()
+	}
 
-		route := s.buildRouteForVehicle(vehicle, remainingOrders, distanceMatrix)
-		if len(route.Stops) > 0 {
-			routes = append(routes, route)
+	routes := make([]RouteResult, 0, len(plans))
+	assigned := make(map[int]bool)
+	for _, plan := range plans {
+		if len(plan.nodes) == 0 {
+			continue
+		}
+		routes = append(routes, s.routeResult(plan))
+		for _, node := range plan.nodes {
+			assigned[node] = true
 		}
 	}
 
 	// Check if all orders were assigned
-	if len(remainingOrders) > 0 {
-		return routes, fmt.Errorf("could not assign %d orders (insufficient capacity or vehicles)", len(remainingOrders))
+	pallets := s.remaining(plans)
+	for _, node := range s.orderNodes() {
+		if assigned[node] {
+			continue
+		}
+		order := s.nodes[node].order
+		s.unassigned = append(s.unassigned, UnassignedOrder{
+			OrderID:     order.ID.String(),
+			OrderNumber: order.OrderNumber,
+			Reason:      s.unassignedReason(node, pallets),
+		})
+	}
+
+	if len(s.unassigned) > 0 {
+		return routes, fmt.Errorf("%w: %d orders left (insufficient capacity or vehicles)", ErrUnassignedOrders, len(s.unassigned))
 	}
 
 	return routes, nil
 }
 
-// buildRouteForVehicle creates a route for a single vehicle
-func (s *VRPSolver) buildRouteForVehicle(vehicle models.Vehicle, remainingOrders map[string]models.Order, distanceMatrix map[string]map[string]float64) RouteResult {
-	route := RouteResult{
-		VehicleID: vehicle.ID.String(),
-		Stops:     make([]RouteStop, 0),
+// prepare resets the working state of a solve and locates every node
+func (s *VRPSolver) prepare() error {
+	if len(s.vehicles) == 0 {
+		return fmt.Errorf("no vehicles available")
+	}
+
+	s.constraints = s.constraints.withDefaults()
+	if s.planStart.IsZero() {
+		s.planStart, _ = PlanStart(time.Now(), DefaultPlanStartClock)
+	}
+	s.unassigned = make([]UnassignedOrder, 0)
+	return s.prepareNodes()
+}
+
+// constructGreedy fills vehicles one after another with nearest neighbor routes
+// Each vehicle first serves orders whose closest staffed depot is its own; orders left over
+// and pickup-and-delivery orders are then inserted wherever they are cheapest, across depots
+func (s *VRPSolver) constructGreedy() []routePlan {
+	pallets := s.newPalletBudget()
+	home := s.homeDepots()
+	plans := make([]routePlan, 0, len(s.vehicles))
+	remainingOrders := make(map[int]bool)
+	for _, node := range s.orderNodes() {
+		remainingOrders[node] = true
 	}
 
-	currentLocation := s.depot.Location
-	currentTime := time.Now().Add(8 * time.Hour) // Start at 8 AM
-	currentCapacity := 0.0
+	for _, vehicle := range s.vehicles {
+		depot := s.startDepot(vehicle)
+		candidates := make([]int, 0)
+		for _, node := range s.orderNodes() {
+			// Pickup-and-delivery orders are inserted as pairs afterwards
+			if remainingOrders[node] && home[node] == depot && !s.nodes[node].paired() {
+				candidates = append(candidates, node)
+			}
+		}
+
+		sequence := make([]int, 0)
+		if len(candidates) > 0 {
+			sequence = s.buildRouteForVehicle(vehicle, candidates, pallets)
+			for _, node := range sequence {
+				delete(remainingOrders, node)
+			}
+		}
+		// Idle vehicles stay in the plan so local search can move stops onto them
+		plans = append(plans, s.newRoutePlan(vehicle, sequence))
+	}
+
+	for _, node := range s.orderNodes() {
+		if remainingOrders[node] {
+			s.insertCheapest(plans, node, pallets)
+		}
+	}
+
+	return plans
+}
+
+// buildRouteForVehicle greedily builds the visiting sequence for a single vehicle
+// Candidates are scanned in order and ties go to the earlier one, so equal input gives equal routes
+func (s *VRPSolver) buildRouteForVehicle(vehicle models.Vehicle, candidates []int, pallets palletBudget) []int {
+	remainingOrders := append([]int(nil), candidates...)
+	sequence := make([]int, 0)
+	ctx := s.newVehicleContext(vehicle)
+	cursor := s.startCursor(ctx, nil)
+
+	// Distance and driver time are weighted by the vehicle's rates so penalties are comparable
+	costPerKm := math.Max(vehicle.CostPerKm, 1)
 
 	// Greedy nearest neighbor algorithm
 	for len(remainingOrders) > 0 {
-		var nearestOrder *models.Order
-		var nearestDistance float64 = math.MaxFloat64
-		var nearestOrderID string
-
-		// Find nearest order that fits capacity
-		for id, order := range remainingOrders {
-			// Check capacity constraint
-			orderWeight := s.getOrderWeight(order)
-			if currentCapacity+orderWeight > float64(vehicle.CapacityKg) {
+		nearest := -1
+		nearestNode := -1
+		nearestScore := math.MaxFloat64
+		var nearestVisit visitResult
+
+		// Find nearest order that satisfies every hard constraint
+		for i, node := range remainingOrders {
+			if !pallets.fits(ctx.start, s.nodes[node].depotLoad().Pallets) {
+				continue
+			}
+
+			v := s.visit(cursor, node)
+			if s.violation(ctx, cursor, node, v) != "" {
 				continue
 			}
 
-			// Calculate distance
-			distance := s.getDistance(currentLocation, order.DeliveryAddress, distanceMatrix)
-			if distance < nearestDistance {
-				nearestDistance = distance
-				nearestOrder = &order
-				nearestOrderID = id
+			score := v.distance*costPerKm + (v.rest+v.travel+v.wait).Hours()*vehicle.CostPerHour + s.visitPenalty(v)
+			if score < nearestScore {
+				nearest = i
+				nearestScore = score
+				nearestNode = node
+				nearestVisit = v
 			}
 		}
 
 		// No more orders fit in this vehicle
-		if nearestOrder == nil {
+		if nearestNode < 0 {
 			break
 		}
 
 		// Add stop to route
-		serviceTime := 15 * time.Minute                                                      // Default service time
-		arrivalTime := currentTime.Add(time.Duration(nearestDistance/40.0*60) * time.Minute) // 40 km/h average
-		departureTime := arrivalTime.Add(serviceTime)
-
-		stop := RouteStop{
-			OrderID:       nearestOrder.ID.String(),
-			CustomerID:    nearestOrder.CustomerID.String(),
-			Location:      nearestOrder.DeliveryAddress,
-			ArrivalTime:   arrivalTime,
-			DepartureTime: departureTime,
-			ServiceTime:   serviceTime,
-			Distance:      nearestDistance,
-		}
+		sequence = append(sequence, nearestNode)
+		cursor = s.advance(cursor, nearestNode, nearestVisit)
+		pallets.take(ctx.start, s.nodes[nearestNode].depotLoad().Pallets)
 
-		route.Stops = append(route.Stops, stop)
-		route.TotalDistance += nearestDistance
-		route.TotalDuration += time.Duration(nearestDistance/40.0*60)*time.Minute + serviceTime
+		// Remove assigned order
+		remainingOrders = removeSegment(remainingOrders, nearest, 1)
+	}
 
-		// Update state
-		currentLocation = nearestOrder.DeliveryAddress
-		currentTime = departureTime
-		currentCapacity += s.getOrderWeight(*nearestOrder)
+	return sequence
+}
 
-		// Remove assigned order
-		delete(remainingOrders, nearestOrderID)
+// routeResult converts an evaluated route plan into a fully timed route
+func (s *VRPSolver) routeResult(plan routePlan) RouteResult {
+	vehicle, eval := plan.vehicle, plan.eval
+	shift := s.shiftFor(vehicle)
+
+	return RouteResult{
+		VehicleID:     vehicle.ID.String(),
+		StartDepotID:  s.depots[s.startDepot(vehicle)].ID.String(),
+		EndDepotID:    s.depots[eval.endDepot].ID.String(),
+		Stops:         eval.stops,
+		TotalDistance: eval.distance,
+		TotalDuration: eval.duration,
+		TotalCost:     eval.cost,
+		Utilization:   vehicleCapacity(vehicle).Utilization(eval.peak),
+		Load:          eval.load,
+		WaitingTime:   eval.waiting,
+		LateTime:      eval.lateness,
+		Penalty:       eval.penalty,
+		ShiftStart:    shift.Start,
+		ShiftEnd:      shift.End,
+		EndTime:       eval.end,
+		Breaks:        eval.breaks,
+		Overtime:      eval.overtime,
+		FixedCost:     eval.costs.Fixed,
+		DistanceCost:  eval.costs.Distance,
+		TimeCost:      eval.costs.Time,
+		OvertimeCost:  eval.costs.Overtime,
 	}
+}
 
-	// Return to depot
-	if len(route.Stops) > 0 {
-		returnDistance := s.getDistance(currentLocation, s.depot.Location, distanceMatrix)
-		route.TotalDistance += returnDistance
-		route.TotalDuration += time.Duration(returnDistance/40.0*60) * time.Minute
+// unassignedReason explains why an order was left out of every route
+func (s *VRPSolver) unassignedReason(node int, pallets palletBudget) string {
+	if reason := s.vehicleTypeReason(node); reason != "" {
+		return reason
+	}
+	palletLimited := pallets.limited() && !s.nodes[node].paired()
+	if reason := capacityReason(*s.nodes[node].order, s.vehicles, pallets.mostLeft(), palletLimited); reason != "" {
+		return reason
 	}
 
-	// Calculate cost and utilization
-	route.TotalCost = route.TotalDistance * vehicle.CostPerKm
-	route.Utilization = (currentCapacity / float64(vehicle.CapacityKg)) * 100
+	// Check whether any vehicle could serve the order on its own
+	reasons := map[string]int{}
+	firstReason := ""
+	for _, vehicle := range s.vehicles {
+		eval := s.evaluateRoute(vehicle, s.withPickup(node))
+		if eval.feasible {
+			return "no vehicle has remaining capacity or time"
+		}
+		if firstReason == "" {
+			firstReason = eval.reason
+		}
+		reasons[eval.reason]++
+	}
 
-	return route
+	if reasons[firstReason] == len(s.vehicles) {
+		return firstReason + " on every vehicle"
+	}
+	return firstReason
 }
 
-// buildDistanceMatrix creates a distance matrix between all locations
-func (s *VRPSolver) buildDistanceMatrix() map[string]map[string]float64 {
-	matrix := make(map[string]map[string]float64)
+// prepareNodes locates the depots and orders and builds the distance matrix
+// Depots take the first nodes and orders[i] is node orderNode(i); pickups of
+// pickup-and-delivery orders follow, in order, and then the start positions of vehicles
+func (s *VRPSolver) prepareNodes() error {
+	pickups := s.pickupOrders()
+	s.origins = s.originNodes(s.orderNode(len(s.orders) + len(pickups)))
+
+	var points []LatLng
+	if s.presetMatrix != nil {
+		want := s.depotCount() + len(s.orders) + len(pickups) + len(s.origins)
+		if len(s.presetMatrix.Distances) != want || len(s.presetMatrix.Durations) != want {
+			return fmt.Errorf("distance matrix has %d nodes, want %d", len(s.presetMatrix.Distances), want)
+		}
+		points = s.knownLocations(pickups)
+	} else {
+		resolved, err := s.resolveLocations(pickups)
+		if err != nil {
+			return err
+		}
+		points = resolved
+	}
+	points = append(points, s.originPoints()...)
 
-	// Add depot
-	locations := []string{s.depot.Location}
+	s.nodes = make([]vrpNode, len(points))
+	for i := range s.depots {
+		s.nodes[i] = vrpNode{point: points[i]}
+	}
+	for i := range s.orders {
+		order := &s.orders[i]
+		s.nodes[s.orderNode(i)] = vrpNode{
+			order:    order,
+			point:    points[s.orderNode(i)],
+			load:     orderLoad(*order),
+			service:  serviceTimeFor(*order),
+			window:   s.timeWindowFor(*order),
+			stopType: StopTypeDelivery,
+
+			vehicleTypes: s.vehicleTypesFor(*order),
+		}
+	}
+	for k, i := range pickups {
+		order := &s.orders[i]
+		pickup, delivery := s.orderNode(len(s.orders)+k), s.orderNode(i)
+		s.nodes[pickup] = vrpNode{
+			order:    order,
+			point:    points[pickup],
+			load:     orderLoad(*order),
+			service:  defaultServiceTime,
+			window:   s.pickupWindow(*order),
+			stopType: StopTypePickup,
+			pair:     delivery,
+
+			vehicleTypes: s.nodes[delivery].vehicleTypes,
+		}
+		s.nodes[delivery].pair = pickup
+	}
+	for i := s.stopEnd(); i < len(s.nodes); i++ {
+		s.nodes[i] = vrpNode{point: points[i]}
+	}
 
-	// Add all order locations
+	if s.presetMatrix != nil {
+		s.matrix = s.presetMatrix
+	} else {
+		s.matrix = s.buildDistanceMatrix(points)
+	}
+	s.matrix = s.congested(s.matrix, points)
+	return nil
+}
+
+// knownLocations returns whatever coordinates the depots, orders and pickups carry, without geocoding
+func (s *VRPSolver) knownLocations(pickups []int) []LatLng {
+	points := make([]LatLng, 0, s.depotCount()+len(s.orders)+len(pickups))
+	for _, depot := range s.depots {
+		points = append(points, LatLng{Lat: depot.Latitude, Lng: depot.Longitude})
+	}
 	for _, order := range s.orders {
-		locations = append(locations, order.DeliveryAddress)
-	}
-
-	// Calculate distances (using Haversine formula for now)
-	// In production, use Google Maps Distance Matrix API
-	for _, loc1 := range locations {
-		matrix[loc1] = make(map[string]float64)
-		for _, loc2 := range locations {
-			if loc1 == loc2 {
-				matrix[loc1][loc2] = 0
-			} else {
-				// Simple distance calculation (should use real geocoding)
-				matrix[loc1][loc2] = s.calculateDistance(loc1, loc2)
+		point := LatLng{}
+		if order.Customer != nil {
+			point = LatLng{Lat: order.Customer.Latitude, Lng: order.Customer.Longitude}
+		}
+		points = append(points, point)
+	}
+	for _, i := range pickups {
+		points = append(points, LatLng{Lat: s.orders[i].PickupLatitude, Lng: s.orders[i].PickupLongitude})
+	}
+	return points
+}
+
+// resolveLocations returns the depot coordinates followed by each order's delivery coordinates
+// and the pickup coordinates of the given orders
+func (s *VRPSolver) resolveLocations(pickups []int) ([]LatLng, error) {
+	points := make([]LatLng, 0, s.depotCount()+len(s.orders)+len(pickups))
+
+	for _, depot := range s.depots {
+		depotPoint := LatLng{Lat: depot.Latitude, Lng: depot.Longitude}
+		if !hasCoordinates(depotPoint) {
+			located, err := s.geocode(depot.Address)
+			if err != nil {
+				return nil, fmt.Errorf("depot %s: %w", depot.Name, err)
 			}
+			depotPoint = located
+		}
+		points = append(points, depotPoint)
+	}
+
+	var unlocated []string
+	for _, order := range s.orders {
+		point, err := s.orderLocation(order)
+		if err != nil {
+			unlocated = append(unlocated, fmt.Sprintf("order %s: %v", orderLabel(order), err))
+			continue
+		}
+		points = append(points, point)
+	}
+	for _, i := range pickups {
+		point, err := s.pickupLocation(s.orders[i])
+		if err != nil {
+			unlocated = append(unlocated, fmt.Sprintf("pickup of order %s: %v", orderLabel(s.orders[i]), err))
+			continue
 		}
+		points = append(points, point)
 	}
 
-	return matrix
+	if len(unlocated) > 0 {
+		return nil, fmt.Errorf("%w for %d orders: %s", ErrMissingCoordinates, len(unlocated), strings.Join(unlocated, "; "))
+	}
+
+	return points, nil
 }
 
-// getDistance retrieves distance from matrix
-func (s *VRPSolver) getDistance(from, to string, matrix map[string]map[string]float64) float64 {
-	if from == to {
-		return 0
+// orderLocation returns the delivery coordinates of an order, geocoding the address if needed
+func (s *VRPSolver) orderLocation(order models.Order) (LatLng, error) {
+	if order.Customer != nil {
+		point := LatLng{Lat: order.Customer.Latitude, Lng: order.Customer.Longitude}
+		if hasCoordinates(point) {
+			return point, nil
+		}
 	}
-	if dist, ok := matrix[from][to]; ok {
-		return dist
+
+	address := order.DeliveryAddress
+	if address == "" && order.Customer != nil {
+		address = order.Customer.Address
 	}
-	return 10.0 // Default distance
+	return s.geocode(address)
 }
 
-// calculateDistance calculates approximate distance between two addresses
-// This is a placeholder - in production, use geocoding + distance calculation
-func (s *VRPSolver) calculateDistance(addr1, addr2 string) float64 {
-	// Simple hash-based distance for demo
-	// In production, geocode addresses and use Haversine formula
-	hash1 := 0
-	hash2 := 0
-	for _, c := range addr1 {
-		hash1 += int(c)
+// geocode resolves an address to coordinates through the maps proxy
+func (s *VRPSolver) geocode(address string) (LatLng, error) {
+	if address == "" {
+		return LatLng{}, fmt.Errorf("no address to geocode")
+	}
+	if s.mapsProxy == nil {
+		return LatLng{}, fmt.Errorf("no coordinates and no geocoder configured for %q", address)
+	}
+
+	resp, err := s.mapsProxy.Geocode(address)
+	if err != nil {
+		return LatLng{}, fmt.Errorf("failed to geocode %q: %w", address, err)
+	}
+
+	point := LatLng{Lat: resp.Lat, Lng: resp.Lng}
+	if !hasCoordinates(point) {
+		return LatLng{}, fmt.Errorf("geocoder returned no coordinates for %q", address)
 	}
-	for _, c := range addr2 {
-		hash2 += int(c)
+	return point, nil
+}
+
+// buildDistanceMatrix creates a distance matrix between all nodes
+// OSRM road distances are used when a maps proxy is configured, haversine otherwise
+func (s *VRPSolver) buildDistanceMatrix(points []LatLng) *DistanceMatrix {
+	if s.mapsProxy != nil {
+		matrix, err := NewOSRMMatrix(s.mapsProxy, points)
+		if err == nil {
+			return matrix
+		}
+		log.Printf("⚠️ OSRM table lookup failed, falling back to haversine: %v", err)
 	}
-	return math.Abs(float64(hash1-hash2)) / 100.0
+
+	return NewHaversineMatrix(points)
 }
 
-// getOrderWeight returns the weight/volume of an order
-func (s *VRPSolver) getOrderWeight(order models.Order) float64 {
-	// In production, this should come from order details
-	return 1.0 // Default weight
+// orderLabel returns a human readable identifier for an order
+func orderLabel(order models.Order) string {
+	if order.OrderNumber != "" {
+		return order.OrderNumber
+	}
+	return order.ID.String()
 }
 
 // OptimizeWithConstraints performs optimization with additional constraints
 func (s *VRPSolver) OptimizeWithConstraints(constraints VRPConstraints) ([]RouteResult, error) {
-	// This is where OR-Tools would be integrated for optimal solutions
-	// For now, use the greedy algorithm
+	s.constraints = constraints
 	return s.Solve()
 }
 
@@ -240,9 +610,65 @@ func (s *VRPSolver) OptimizeWithConstraints(constraints VRPConstraints) ([]Route
 type VRPConstraints struct {
 	MaxStopsPerRoute    int
 	MaxDistancePerRoute float64
-	TimeWindows         map[string]TimeWindow
-	DriverShifts        map[string]Shift
-	VehicleTypes        map[string]string
+	TimeWindows         map[string]TimeWindow // Keyed by order ID, overrides customer windows
+	DriverShifts        map[string]Shift      // Keyed by vehicle or driver ID, overrides driver profiles
+	VehicleTypes        map[string]string     // Order or customer ID to allowed vehicle types, comma separated
+	EndDepots           map[string]string     // Vehicle ID to the depot ID its route must end at
+	EndAtNearestDepot   bool                  // Other routes may end at the depot closest to their last stop
+	StartPositions      map[string]LatLng     // Vehicle ID to where a vehicle on the road is now, it starts there
+
+	// NotBefore is the earliest time any route starts, for plans made during the day
+	NotBefore time.Time
+	// Congestion slows travel in the given areas
+	Congestion []Congestion
+
+	// HardTimeWindows rejects late arrivals instead of penalizing them
+	HardTimeWindows bool
+	// WaitingCostPerHour is charged while a vehicle idles until a window opens
+	WaitingCostPerHour float64
+	// LatenessPenaltyPerHour is charged for arriving after a soft window closes
+	LatenessPenaltyPerHour float64
+
+	// MaxOvertime is how far past shift end a route may run (negative forbids overtime)
+	MaxOvertime time.Duration
+	// OvertimeCostPerHour is added to route cost for time past shift end
+	OvertimeCostPerHour float64
+	// BreakAfterDriving is the continuous driving limit before a rest break
+	BreakAfterDriving time.Duration
+	// BreakDuration is the length of each inserted rest break
+	BreakDuration time.Duration
+
+	// ImprovementTimeLimit bounds the local search phase (negative skips it)
+	ImprovementTimeLimit time.Duration
+	// Seed shuffles the order in which local search scans routes, 0 keeps input order
+	// Different seeds give different but reproducible plans
+	Seed int64
+}
+
+// withDefaults fills unset penalty rates with the solver defaults
+func (c VRPConstraints) withDefaults() VRPConstraints {
+	if c.WaitingCostPerHour == 0 {
+		c.WaitingCostPerHour = defaultWaitingCostPerHour
+	}
+	if c.LatenessPenaltyPerHour == 0 {
+		c.LatenessPenaltyPerHour = defaultLatenessPenaltyPerHour
+	}
+	if c.MaxOvertime == 0 {
+		c.MaxOvertime = defaultMaxOvertime
+	}
+	if c.OvertimeCostPerHour == 0 {
+		c.OvertimeCostPerHour = defaultOvertimeCostPerHour
+	}
+	if c.BreakAfterDriving == 0 {
+		c.BreakAfterDriving = defaultBreakAfterDriving
+	}
+	if c.BreakDuration == 0 {
+		c.BreakDuration = defaultBreakDuration
+	}
+	if c.ImprovementTimeLimit == 0 {
+		c.ImprovementTimeLimit = defaultImprovementTimeLimit
+	}
+	return c
 }
 
 // TimeWindow represents a delivery time window